@@ -1,42 +1,23 @@
 package interpreter
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
 
+// Node is a node of a program's syntax tree. The values the program computes are Values.
 type Node struct {
 	Type NodeType
 	Val  interface{}
-	Func
-	L, R  *Node
-	Scope *Environment
-	Line  int
-}
-
-type Func func(*Environment, ...*Node) (*Node, error)
+	L, R *Node
+	Line int
 
-type List []*Node
-type Set map[Value]bool
-type Object map[Value]*Node
-
-type Value struct {
-	DataType
-	Val interface{}
+	res   *resolution  // set by Resolve
+	proto atomic.Value // the compiled body of a lambda, as a *Chunk, set when it's first called on the VM
 }
 
-type DataType uint8
-
-const (
-	IntDT DataType = iota
-	FloatDT
-	BoolDT
-	StringDT
-	ResultDT
-
-	LambdaDT
-	ListDT
-	SetDT
-	ObjectDT
-)
-
 type NodeType uint8
 
 const (
@@ -57,6 +38,10 @@ const (
 	ExprNT
 	IfNT
 	ThenBranchNT
+	TypeNT
+	MatchNT
+	MatchCaseNT
+	MatchArmNT
 	AssignmentNT
 	AugAssignNT
 	LambdaNT
@@ -82,6 +67,8 @@ const (
 	FindNT
 	FoldNT
 	BindNT
+	PMapNT
+	PWhereNT
 	InNT
 	PowerNT
 	UnderscoreNT
@@ -96,16 +83,21 @@ const (
 	CardinalityNT
 	MaybeNT
 	SplatNT
+	SpawnNT
+	AwaitNT
 
 	ListNT
 	SetNT
 	ObjectNT
+	TupleNT
+	ComprehensionNT
+	ClauseNT
+	GeneratorNT
 
 	SuccessNT
 	FailNT
 
 	ImportNT
-	ModuleNT
 
 	CallNT
 	RangeNT
@@ -117,87 +109,35 @@ const (
 	IntNT
 	BoolNT
 	StringNT
+	TemplateNT
 	CharNT
 	NullNT
 	EOFNT
 )
 
+// Environment is a scope. Tasks may share scopes, so after it is created its maps should only be
+// accessed through its methods, which lock it.
 type Environment struct {
 	Parent *Environment
-	Vars   map[string]*Node
-	Consts map[string]*Node
-}
+	Vars   map[string]Value
+	Consts map[string]Value
+	Types  map[string]*Type // annotated types of variables, enforced on assignment
 
-func (n *Node) toValue() Value {
-	switch n.Type {
-	case IntNT:
-		return Value{
-			DataType: IntDT,
-			Val:      n.Val.(int64),
-		}
-	case FloatNT:
-		return Value{
-			DataType: FloatDT,
-			Val:      n.Val.(float64),
-		}
-	case StringNT, IdentifierNT:
-		return Value{
-			DataType: StringDT,
-			Val:      n.Val.(string),
-		}
-	case BoolNT:
-		return Value{
-			DataType: BoolDT,
-			Val:      n.Val.(bool),
-		}
-	case SuccessNT:
-		return Value{
-			DataType: ResultDT,
-			Val:      true,
-		}
-	case FailNT:
-		return Value{
-			DataType: ResultDT,
-			Val:      false,
-		}
-	default:
-		return Value{
-			DataType: ResultDT,
-			Val:      false,
-		}
-	}
-}
+	sandbox  *sandbox // limits of the program running in this scope, if any
+	depth    int      // number of function calls this scope is nested in
+	maxDepth int      // most function calls that may be nested, or DefaultMaxDepth if 0
 
-func (v Value) toNode() *Node {
-	switch v.DataType {
-	case IntDT:
-		return &Node{
-			Type: IntNT,
-			Val:  v.Val.(int64),
-		}
-	case FloatDT:
-		return &Node{
-			Type: FloatNT,
-			Val:  v.Val.(float64),
-		}
-	case StringDT:
-		return &Node{
-			Type: StringNT,
-			Val:  v.Val.(string),
-		}
-	case BoolDT:
-		return &Node{
-			Type: BoolNT,
-			Val:  v.Val.(bool),
-		}
-	case ResultDT:
-		if v.Val.(bool) {
-			return &Node{Type: SuccessNT}
-		}
-		return &Node{Type: FailNT}
-	default:
-		return &Node{Type: FailNT}
-	}
+	// the frame of a function call, spawned task or program keeps the locals of its blocks in
+	// slots rather than maps, as laid out by Resolve. Functions called from a scope run by the VM
+	// are run by the VM too.
+	slots  []Value
+	layout *layout
+	vm     bool
+	// owned is set while a frame run by the VM can only be reached by the goroutine running it, so
+	// its slots aren't locked. It's cleared before a closure or task is given the frame.
+	owned bool
+
+	mu sync.RWMutex
 }
 
 var nodeTypeMap map[NodeType]string = map[NodeType]string{
@@ -236,6 +176,7 @@ var nodeTypeMap map[NodeType]string = map[NodeType]string{
 	ListNT:          "list",
 	SetNT:           "set",
 	ObjectNT:        "obj",
+	TupleNT:         "tuple",
 	SuccessNT:       "success",
 	FailNT:          "fail",
 	CallNT:          "call",
@@ -247,6 +188,7 @@ var nodeTypeMap map[NodeType]string = map[NodeType]string{
 	IntNT:           "INT",
 	BoolNT:          "BOOL",
 	StringNT:        "STRING",
+	TemplateNT:      "template",
 	CharNT:          "CHAR",
 	NullNT:          "null",
 	EOFNT:           "",
@@ -254,6 +196,8 @@ var nodeTypeMap map[NodeType]string = map[NodeType]string{
 	MaybeNT:         "?",
 	MapNT:           "map",
 	WhereNT:         "where",
+	PMapNT:          "pmap",
+	PWhereNT:        "pwhere",
 	InNT:            "in",
 	PowerNT:         "^",
 	PipeNT:          "|>",
@@ -265,11 +209,20 @@ var nodeTypeMap map[NodeType]string = map[NodeType]string{
 	KVPairNT:        ":",
 	SetItemNT:       "set-item",
 	ImportNT:        "import",
-	ModuleNT:        "module",
 	SplatNT:         "...",
 	ObjectItemNT:    "object-item",
 	FindNT:          "find",
 	FoldNT:          "fold",
+	BindNT:          "bind",
+	MatchNT:         "match",
+	MatchCaseNT:     "case",
+	MatchArmNT:      "arm",
+	TypeNT:          "type",
+	ComprehensionNT: "comprehension",
+	ClauseNT:        "clause",
+	GeneratorNT:     "<-",
+	SpawnNT:         "spawn",
+	AwaitNT:         "await",
 }
 
 func (nt NodeType) ToString() string {
@@ -300,71 +253,35 @@ func linked2String(n *Node) string {
 	return fmt.Sprintf("(%s %s %s)", n.Type.ToString(), n.L.ToString(), n.R.ToString())
 }
 
-func Display(n *Node) string {
-	if n == nil {
-		return "NIL_PTR"
-	}
-	switch n.Type {
-	case FloatNT, IntNT, CharNT, BoolNT, IdentifierNT, StringNT, ListNT, ObjectNT, SetNT, NullNT, UnderscoreNT, FailNT, SuccessNT:
-		return n.ToString()
-	case LambdaNT:
-		return "<lambda>"
-	default:
-		return "success"
-	}
-}
-
 func (n *Node) ToString() string {
 	if n == nil {
 		return "NIL_PTR"
 	}
 	switch n.Type {
 	// atoms
-	case FloatNT, IntNT, CharNT, BoolNT, IdentifierNT:
+	case IdentifierNT:
+		if n.L != nil && n.L.Type == TypeNT {
+			return fmt.Sprintf("%v: %s", n.Val, n.L.ToString())
+		}
+		return fmt.Sprintf("%v", n.Val)
+	case FloatNT, IntNT, CharNT, BoolNT:
 		return fmt.Sprintf("%v", n.Val)
 	case StringNT:
 		return fmt.Sprintf("\"%v\"", n.Val)
 	case ListNT:
-		list := n.Val.(List)
-		res := "["
-		for i, m := range list {
-			if i > 0 {
-				res += ", "
-			}
-			res += fmt.Sprintf(m.ToString())
+		items := []string{}
+		for _, m := range n.Val.([]*Node) {
+			items = append(items, m.ToString())
 		}
-		res += "]"
-		return res
-	case ObjectNT:
-		if n.Val == nil {
-			return "{}"
-		}
-		obj := n.Val.(Object)
-		res := "{"
-		for k, v := range obj {
-			if len(res) > 1 {
-				res += ", "
-			}
-			res += k.toNode().ToString()
-			res += ": "
-			res += v.ToString()
-		}
-		res += "}"
-		return res
-	case SetNT:
-		set := n.Val.(Set)
-		res := "{"
-		for k := range set {
-			if !set[k] {
-				continue
-			}
-			if len(res) > 1 {
-				res += ", "
-			}
-			res += k.toNode().ToString()
+		return "[" + strings.Join(items, ", ") + "]"
+	case TupleNT:
+		items := []string{}
+		for _, m := range n.Val.([]*Node) {
+			items = append(items, m.ToString())
 		}
-		res += "}"
-		return res
+		return "(" + strings.Join(items, ", ") + ")"
+	case ObjectNT:
+		return "{}"
 	case NullNT:
 		return "null"
 	case UnderscoreNT:
@@ -375,10 +292,10 @@ func (n *Node) ToString() string {
 		return "success"
 	case IndexNT:
 		return "index"
+	case TypeNT:
+		return n.Val.(*Type).String()
 	case BreakNT, ContinueNT:
 		return n.Type.ToString()
-	case ModuleNT:
-		return fmt.Sprintf("(module %s)", n.Val.(string))
 	case ImportNT:
 		if n.R != nil {
 			return fmt.Sprintf("(import %s %s)\n", n.Val.(string), n.L.Val.(string))
@@ -390,12 +307,19 @@ func (n *Node) ToString() string {
 		}
 		return fmt.Sprintf("\n%s", n.L.ToString())
 	// unary
-	case UnaryNegNT, LogicNotNT, CardinalityNT, MaybeNT, ReturnStmtNT, SplatNT:
+	case UnaryNegNT, LogicNotNT, CardinalityNT, MaybeNT, ReturnStmtNT, SplatNT, SpawnNT, AwaitNT:
 		return unOp2String(n)
 	// binary
-	case MultNT, DivNT, AddNT, SubtNT, ModuloNT, NotEqualNT, EqualNT, GreaterNT, GreaterEqualNT, LessNT, LessEqualNT, FallbackNT, LogicOrNT, LogicAndNT, MapNT, WhereNT, InNT, PowerNT, IfNT, ThenBranchNT, LambdaNT, PipeNT, AssignmentNT, VarDeclNT, ConstDeclNT, WhileStmtNT, ForStmtNT, CallNT, BracketAccessNT, ListSliceNT, FieldAccessNT, RangeNT, SliceNT, KVPairNT, FindNT, FoldNT:
+	case ComprehensionNT:
+		return fmt.Sprintf("(%s-comprehension %s %s)", n.Val.(NodeType).ToString(), n.L.ToString(), n.R.ToString())
+	case FoldNT:
+		if n.Val != nil {
+			return fmt.Sprintf("(fold %s %s %s)", n.L.ToString(), n.R.ToString(), n.Val.(*Node).ToString())
+		}
+		return binOp2String(n)
+	case MultNT, DivNT, AddNT, SubtNT, ModuloNT, NotEqualNT, EqualNT, GreaterNT, GreaterEqualNT, LessNT, LessEqualNT, FallbackNT, LogicOrNT, LogicAndNT, MapNT, WhereNT, PMapNT, PWhereNT, InNT, PowerNT, IfNT, ThenBranchNT, LambdaNT, PipeNT, AssignmentNT, VarDeclNT, ConstDeclNT, WhileStmtNT, ForStmtNT, CallNT, BracketAccessNT, ListSliceNT, FieldAccessNT, RangeNT, SliceNT, KVPairNT, FindNT, BindNT, MatchNT, MatchArmNT, GeneratorNT:
 		return binOp2String(n)
-	case ParamNT, ArgNT, SetItemNT, ObjectItemNT:
+	case ParamNT, ArgNT, SetItemNT, ObjectItemNT, MatchCaseNT, TemplateNT, ClauseNT:
 		return linked2String(n)
 
 	default:
@@ -0,0 +1,223 @@
+package interpreter
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// task is the result of a spawned expression, which is evaluated in its own goroutine
+type task struct {
+	done chan struct{}
+	res  Value
+	err  error
+}
+
+// channel passes values between tasks. A Go channel can't be sent on or closed once it has been
+// closed, so instead of closing items, close signals closed to any blocked senders and receivers.
+type channel struct {
+	items  chan Value
+	closed chan struct{}
+	once   sync.Once
+}
+
+func newChannel(capacity int) Value {
+	return Value{
+		Type: ChannelDT,
+		ref: &channel{
+			items:  make(chan Value, capacity),
+			closed: make(chan struct{}),
+		},
+	}
+}
+
+// send blocks until a value is received (or buffered), reporting false if the channel is closed.
+// It stops waiting with a *CancelledError once the sandbox's context is done.
+func (c *channel) send(val Value, s *sandbox) (bool, error) {
+	select {
+	case <-c.closed:
+		return false, nil
+	default:
+	}
+
+	select {
+	case c.items <- val:
+		return true, nil
+	case <-c.closed:
+		return false, nil
+	case <-s.done():
+		return false, s.cancelled()
+	}
+}
+
+// receive blocks until a value is sent, reporting false once the channel is closed and any
+// buffered values have been received. It stops waiting with a *CancelledError once the sandbox's
+// context is done.
+func (c *channel) receive(s *sandbox) (Value, bool, error) {
+	select {
+	case val := <-c.items:
+		return val, true, nil
+	case <-c.closed:
+		select {
+		case val := <-c.items:
+			return val, true, nil
+		default:
+			return Value{}, false, nil
+		}
+	case <-s.done():
+		return Value{}, false, s.cancelled()
+	}
+}
+
+// close reports false if the channel was already closed
+func (c *channel) close() bool {
+	closed := false
+	c.once.Do(func() {
+		close(c.closed)
+		closed = true
+	})
+	return closed
+}
+
+// interpretSpawn starts evaluating an expression or block in a new goroutine, returning a task
+// that can be awaited for its result. The task has a frame of its own, inside the scope it was
+// spawned in.
+func interpretSpawn(n *Node, env *Environment) (res Value, err error) {
+	t := &task{done: make(chan struct{})}
+	scope := newFrame(env, env, layoutOf(n), 0)
+
+	go func() {
+		defer close(t.done)
+		if n.R.Type == StmtNT {
+			t.res, t.err = interpretFunctionBody(n.R, scope)
+			if t.err == nil && t.res.Type == tailCallDT {
+				t.res, t.err = t.res.tailCall().make()
+			}
+		} else {
+			t.res, t.err = Interpret(n.R, scope)
+		}
+		if t.err != nil {
+			t.err = locate(t.err, n.R)
+		}
+	}()
+
+	return Value{Type: TaskDT, ref: t}, nil
+}
+
+// interpretAwait waits for a task to finish, giving its result or runtime error. Awaiting
+// anything other than a task fails.
+func interpretAwait(n *Node, env *Environment) (res Value, err error) {
+	val, err := Interpret(n.R, env)
+	if err != nil {
+		return Value{}, err
+	}
+
+	if val.Type != TaskDT {
+		return FAIL, nil
+	}
+
+	t := val.task()
+	s := env.limits()
+	select {
+	case <-t.done:
+	case <-s.done():
+		return Value{}, s.cancelled()
+	}
+
+	var re *RuntimeError
+	if errors.As(t.err, &re) {
+		// a task may be awaited more than once, so each await adds the frames of its own caller to
+		// a copy of the error
+		cp := *re
+		cp.Stack = append([]Frame{}, re.Stack...)
+		return Value{}, pushFrame(&cp, "<task>", nodeLine(n))
+	}
+	return t.res, t.err
+}
+
+// interpretParallel evaluates the collection on the left of pmap or pwhere, then calls the lambda
+// on the right with each of its items on a pool of workers. Results are returned in the order of
+// the items. If the collection can't be iterated, lhs is fail.
+func interpretParallel(n *Node, env *Environment) (lhs Value, items, results []Value, err error) {
+	lhs, err = Interpret(n.L, env)
+	if err != nil {
+		return Value{}, nil, nil, err
+	}
+
+	if lhs.Type != ListDT && lhs.Type != SetDT {
+		return FAIL, nil, nil, nil
+	}
+
+	callee := n.R
+	var lambda Value
+	if callee.Type == IdentifierNT {
+		lambda, err = resolveIdentifier(callee, env)
+	} else {
+		lambda, err = Interpret(callee, env)
+	}
+
+	if err != nil {
+		return Value{}, nil, nil, err
+	}
+
+	if lambda.Type != LambdaDT {
+		return FAIL, nil, nil, nil
+	}
+
+	next := iterateCollection(lhs)
+	for item := next(); !item.IsZero(); item = next() {
+		items = append(items, item)
+	}
+
+	results, err = parallelCall(items, callee, lambda, env)
+	return lhs, items, results, err
+}
+
+// parallelCall calls a lambda with each item on up to GOMAXPROCS workers. Each worker declares
+// index in a scope of its own. Workers take items in order and stop once any call fails, so every
+// item before a failed one has been called, and the error returned is the one a sequential map
+// would have run into first.
+func parallelCall(items []Value, callee *Node, lambda Value, env *Environment) ([]Value, error) {
+	results := make([]Value, len(items))
+	errs := make([]error, len(items))
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(items) {
+		workers = len(items)
+	}
+
+	var next int64 = -1
+	var failed int32
+	wg := sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			scope := newScope(env)
+			for atomic.LoadInt32(&failed) == 0 {
+				i := int(atomic.AddInt64(&next, 1))
+				if i >= len(items) {
+					return
+				}
+
+				scope.setConst("index", NewInt(int64(i)))
+				var err error
+				results[i], err = callLambda(callee, lambda, scope, items[i])
+
+				if err != nil {
+					errs[i] = err
+					atomic.StoreInt32(&failed, 1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return results, nil
+}
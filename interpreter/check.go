@@ -0,0 +1,1290 @@
+package interpreter
+
+import (
+	"fmt"
+	"sort"
+)
+
+// TypeError is an error found by Check without running the program
+type TypeError struct {
+	Line int
+	Msg  string
+}
+
+func (e *TypeError) Error() string {
+	if e.Line != 0 {
+		return fmt.Sprintf("Line %d: %s", e.Line, e.Msg)
+	}
+	return e.Msg
+}
+
+type typeBinding struct {
+	t        *Type
+	mutable  bool
+	declared *Type // annotated type, if any
+}
+
+type typeScope struct {
+	parent *typeScope
+	names  map[string]*typeBinding
+}
+
+func newTypeScope(parent *typeScope) *typeScope {
+	return &typeScope{
+		parent: parent,
+		names:  map[string]*typeBinding{},
+	}
+}
+
+func (s *typeScope) lookup(name string) (*typeBinding, bool) {
+	for e := s; e != nil; e = e.parent {
+		if b, ok := e.names[name]; ok {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// builtinSig describes the arguments and result of a function in the StdLib
+type builtinSig struct {
+	params   []*Type
+	min, max int // max < 0 for any number of arguments
+	ret      func(args []*Type) *Type
+}
+
+func sig(params []*Type, ret *Type) *builtinSig {
+	return &builtinSig{
+		params: params,
+		min:    len(params),
+		max:    len(params),
+		ret:    func(_ []*Type) *Type { return ret },
+	}
+}
+
+func argOr(args []*Type, i int) *Type {
+	if i < len(args) {
+		return args[i]
+	}
+	return anyType
+}
+
+var builtinSigs map[string]*builtinSig = map[string]*builtinSig{
+	// I/O utils
+	"print":     {min: 0, max: -1, ret: func(_ []*Type) *Type { return successType }},
+	"readInput": sig([]*Type{stringType}, maybe(stringType)),
+	"readFile":  sig([]*Type{stringType}, maybe(stringType)),
+	// math utils
+	"sum":    {min: 1, max: -1, ret: func(_ []*Type) *Type { return maybe(numberType) }},
+	"max":    {min: 1, max: -1, ret: func(_ []*Type) *Type { return maybe(numberType) }},
+	"min":    {min: 1, max: -1, ret: func(_ []*Type) *Type { return maybe(numberType) }},
+	"random": sig([]*Type{}, floatType),
+	// string utils
+	"split":          sig([]*Type{stringType, stringType}, listOf(stringType)),
+	"join":           sig([]*Type{listOf(anyType), stringType}, maybe(stringType)),
+	"uppercase":      sig([]*Type{stringType}, stringType),
+	"lowercase":      sig([]*Type{stringType}, stringType),
+	"codepoints":     sig([]*Type{stringType}, listOf(intType)),
+	"fromCodepoints": sig([]*Type{listOf(intType)}, maybe(stringType)),
+	"graphemes":      sig([]*Type{stringType}, listOf(stringType)),
+	// type casts and utils
+	"typeof": sig([]*Type{anyType}, stringType),
+	"Int":    sig([]*Type{anyType}, maybe(intType)),
+	"Float":  sig([]*Type{anyType}, maybe(floatType)),
+	"String": sig([]*Type{anyType}, stringType),
+	"Set": {min: 1, max: 1, ret: func(args []*Type) *Type {
+		arg := argOr(args, 0)
+		switch arg.Kind {
+		case ListTK, SetTK:
+			return setOf(arg.elemType())
+		case AnyTK:
+			return maybe(setOf(anyType))
+		}
+		return setOf(arg)
+	}},
+	"List": {min: 1, max: -1, ret: func(args []*Type) *Type {
+		if len(args) > 1 {
+			return listOf(unionOf(args...))
+		}
+		arg := argOr(args, 0)
+		switch arg.Kind {
+		case ListTK, SetTK:
+			return listOf(arg.elemType())
+		case AnyTK:
+			return listOf(anyType)
+		}
+		return listOf(arg)
+	}},
+	// set utils
+	"union":        {params: []*Type{setOf(anyType), setOf(anyType)}, min: 2, max: 2, ret: setUnion},
+	"intersection": {params: []*Type{setOf(anyType), setOf(anyType)}, min: 2, max: 2, ret: setUnion},
+	"difference":   {params: []*Type{setOf(anyType), setOf(anyType)}, min: 2, max: 2, ret: setUnion},
+	"add": {params: []*Type{setOf(anyType), anyType}, min: 2, max: 2, ret: func(args []*Type) *Type {
+		return setOf(unionOf(argOr(args, 0).elemType(), argOr(args, 1)))
+	}},
+	"remove": {params: []*Type{setOf(anyType), anyType}, min: 2, max: 2, ret: func(args []*Type) *Type {
+		return setOf(argOr(args, 0).elemType())
+	}},
+	// object utils
+	"keys": {params: []*Type{objectOf(nil)}, min: 1, max: 1, ret: func(args []*Type) *Type {
+		return listOf(argOr(args, 0).elemType())
+	}},
+	"values": {params: []*Type{objectOf(nil)}, min: 1, max: 1, ret: func(args []*Type) *Type {
+		obj := argOr(args, 0)
+		if obj.Kind != ObjectTK || obj.Fields == nil {
+			return listOf(anyType)
+		}
+		vals := []*Type{}
+		for _, v := range obj.Fields {
+			vals = append(vals, v)
+		}
+		return listOf(unionOf(vals...))
+	}},
+	"pairs": {params: []*Type{objectOf(nil)}, min: 1, max: 1, ret: func(args []*Type) *Type {
+		obj := argOr(args, 0)
+		if obj.Kind != ObjectTK || obj.Fields == nil {
+			return listOf(listOf(anyType))
+		}
+		vals := []*Type{stringType}
+		for _, v := range obj.Fields {
+			vals = append(vals, v)
+		}
+		return listOf(listOf(unionOf(vals...)))
+	}},
+	// list utils
+	"flat": {params: []*Type{listOf(anyType)}, min: 1, max: 1, ret: func(args []*Type) *Type {
+		elems := []*Type{}
+		for _, m := range argOr(args, 0).elemType().members() {
+			if m.Kind == ListTK {
+				elems = append(elems, m.elemType())
+			} else {
+				elems = append(elems, m)
+			}
+		}
+		return listOf(unionOf(elems...))
+	}},
+	"find": {params: []*Type{listOf(anyType), {Kind: LambdaTK}}, min: 2, max: 2, ret: func(args []*Type) *Type {
+		return maybe(argOr(args, 0).elemType())
+	}},
+	"findIndex": sig([]*Type{listOf(anyType), {Kind: LambdaTK}}, maybe(intType)),
+	"fold":      sig([]*Type{anyType, anyType, {Kind: LambdaTK}}, anyType),
+	"reduce":    sig([]*Type{anyType, {Kind: LambdaTK}}, anyType),
+	"append": {params: []*Type{listOf(anyType), anyType}, min: 2, max: 2, ret: func(args []*Type) *Type {
+		return listOf(unionOf(argOr(args, 0).elemType(), argOr(args, 1)))
+	}},
+	"reverse": {params: []*Type{listOf(anyType)}, min: 1, max: 1, ret: func(args []*Type) *Type {
+		return listOf(argOr(args, 0).elemType())
+	}},
+	// tasks and channels
+	"Channel": {params: []*Type{intType}, min: 0, max: 1, ret: func(args []*Type) *Type {
+		if len(args) == 0 {
+			return channelType
+		}
+		return maybe(channelType)
+	}},
+	"send":    sig([]*Type{channelType, anyType}, resultType),
+	"receive": sig([]*Type{channelType}, maybe(anyType)),
+	"close":   sig([]*Type{channelType}, resultType),
+}
+
+func setUnion(args []*Type) *Type {
+	return setOf(unionOf(argOr(args, 0).elemType(), argOr(args, 1).elemType()))
+}
+
+// maxInstantiationDepth limits how deeply calls are followed when inferring the result of a lambda
+const maxInstantiationDepth = 16
+
+type checker struct {
+	errs []*TypeError
+	seen map[string]bool
+
+	pending []*Type        // lambdas whose bodies have not been checked yet
+	checked map[*Node]bool // lambda bodies that have been checked
+	active  map[*Node]int  // lambdas currently being checked against a call's arguments
+	depth   int            // depth of nested calls being checked
+	branch  int            // depth of conditional branches within the current call
+	calls   []int          // lines of the calls being checked
+
+	returns []*[]*Type // types returned by each function currently being checked
+}
+
+// Check infers the types of a parsed program without running it, returning any type errors found.
+// Lambda parameters are inferred from the arguments at each call and `T?` is treated as the union
+// of T and fail, so an expression is only reported when it can never succeed.
+func Check(root *Node) []*TypeError {
+	c := &checker{
+		seen:    map[string]bool{},
+		checked: map[*Node]bool{},
+		active:  map[*Node]int{},
+	}
+
+	global := newTypeScope(nil)
+	for name := range StdLib {
+		if s, ok := builtinSigs[name]; ok {
+			global.names[name] = &typeBinding{t: &Type{Kind: LambdaTK, Params: s.params, Variadic: s.max < 0, builtin: s}}
+		} else {
+			global.names[name] = &typeBinding{t: &Type{Kind: LambdaTK, Variadic: true, Return: anyType}}
+		}
+	}
+
+	if root != nil {
+		c.stmts(root, newTypeScope(global))
+	}
+
+	// lambda bodies are checked once every declaration they could refer to is known
+	for len(c.pending) > 0 {
+		lt := c.pending[0]
+		c.pending = c.pending[1:]
+		if c.checked[lt.lambda] {
+			continue
+		}
+		c.checked[lt.lambda] = true
+		c.body(lt, nil, nil)
+	}
+
+	sort.SliceStable(c.errs, func(i, j int) bool { return c.errs[i].Line < c.errs[j].Line })
+	return c.errs
+}
+
+func (c *checker) errorf(n *Node, format string, args ...interface{}) {
+	// a branch may only be taken for some of the argument types a lambda is called with
+	if c.depth > 0 && c.branch > 0 {
+		return
+	}
+
+	err := &TypeError{Line: nodeLine(n), Msg: fmt.Sprintf(format, args...)}
+	if c.depth > 0 && len(c.calls) > 0 {
+		err.Msg += fmt.Sprintf(" (in call on line %d)", c.calls[0])
+	}
+	if key := err.Error(); !c.seen[key] {
+		c.seen[key] = true
+		c.errs = append(c.errs, err)
+	}
+}
+
+// nodeLine finds the first line number recorded in a subtree
+func nodeLine(n *Node) int {
+	if n != nil && n.Line != 0 {
+		return n.Line
+	}
+	// the queue starts out on the stack, since this is done for every call of a lambda by map
+	var buf [16]*Node
+	q := append(buf[:0], n)
+	for i := 0; i < len(q); i++ {
+		m := q[i]
+		if m == nil {
+			continue
+		}
+		if m.Line != 0 {
+			return m.Line
+		}
+		q = append(q, m.L, m.R)
+		if list, ok := m.Val.([]*Node); ok {
+			q = append(q, list...)
+		}
+	}
+	return 0
+}
+
+// stmts checks a chain of statements, returning the type of the last one
+func (c *checker) stmts(root *Node, s *typeScope) *Type {
+	res := successType
+	for n := root; n != nil; n = n.R {
+		if n.L == nil {
+			continue
+		}
+		if n.L.Type == StmtNT {
+			res = c.stmts(n.L, newTypeScope(s))
+		} else {
+			res = c.expr(n.L, s)
+		}
+	}
+	return res
+}
+
+// block checks the body of a conditional, which shares the scope it appears in at runtime, but
+// only if that branch is taken
+func (c *checker) block(n *Node, s *typeScope) *Type {
+	c.branch++
+	defer func() { c.branch-- }()
+
+	inner := newTypeScope(s)
+	var res *Type
+	if n.Type == StmtNT {
+		res = c.stmts(n, inner)
+	} else {
+		res = c.expr(n, inner)
+	}
+	for name, b := range inner.names {
+		if _, exists := s.names[name]; !exists {
+			s.names[name] = b
+		}
+	}
+	return res
+}
+
+func (c *checker) expr(n *Node, s *typeScope) *Type {
+	if n == nil {
+		return anyType
+	}
+
+	switch n.Type {
+	case StmtNT:
+		return c.stmts(n, s)
+	case IntNT:
+		return intType
+	case FloatNT:
+		return floatType
+	case BoolNT:
+		return boolType
+	case StringNT:
+		return stringType
+	case TemplateNT:
+		for ; n != nil; n = n.R {
+			c.expr(n.L, s)
+		}
+		return stringType
+	case ComprehensionNT:
+		return c.comprehension(n, s)
+	case TupleNT:
+		items := []*Type{}
+		for _, m := range n.Val.([]*Node) {
+			items = append(items, c.expr(m, s))
+		}
+		return tupleOf(items...)
+	case NullNT:
+		return nullType
+	case SuccessNT:
+		return successType
+	case FailNT:
+		return failType
+	case IdentifierNT, UnderscoreNT:
+		name := n.Val.(string)
+		if b, ok := s.lookup(name); ok {
+			return b.t
+		}
+		// names used by lambdas may be declared after them, these are reported by the lambda's own check
+		if c.depth == 0 {
+			c.errorf(n, "\"%s\" is undefined", name)
+		}
+		return anyType
+	case IndexNT:
+		if b, ok := s.lookup("index"); ok {
+			return b.t
+		}
+		return anyType
+	case BreakNT, ContinueNT:
+		return successType
+
+	// operators
+	case AddNT, SubtNT, MultNT, DivNT, ModuloNT, PowerNT, LessNT, LessEqualNT, GreaterNT, GreaterEqualNT, EqualNT, NotEqualNT, InNT:
+		l, r := c.expr(n.L, s), c.expr(n.R, s)
+		return c.binary(n, l, r)
+	case LogicAndNT:
+		c.expr(n.L, s)
+		return unionOf(c.expr(n.R, s), boolType)
+	case LogicOrNT:
+		return unionOf(c.expr(n.L, s), c.expr(n.R, s))
+	case FallbackNT:
+		l, r := c.expr(n.L, s), c.expr(n.R, s)
+		if !l.mayBe(FailTK) {
+			return l
+		}
+		return unionOf(l.without(FailTK), r)
+	case LogicNotNT:
+		c.expr(n.R, s)
+		return boolType
+	case MaybeNT:
+		arg := c.expr(n.R, s)
+		if arg.Kind == FailTK {
+			return failType
+		}
+		if !arg.mayBe(FailTK) {
+			return successType
+		}
+		return resultType
+	case CardinalityNT, UnaryNegNT:
+		return c.unary(n, c.expr(n.R, s))
+
+	// collections
+	case ListNT:
+		elems := []*Type{}
+		for _, m := range n.Val.([]*Node) {
+			if m.Type == SplatNT {
+				elems = append(elems, c.expr(m.R, s).elemType())
+			} else if m.Type == RangeNT || m.Type == MapNT || m.Type == WhereNT || m.Type == PMapNT || m.Type == PWhereNT {
+				// collections produced inside a list literal are spread into it
+				t := c.expr(m, s)
+				if t.Kind == ListTK || t.Kind == SetTK {
+					elems = append(elems, t.elemType())
+				} else {
+					elems = append(elems, t)
+				}
+			} else {
+				elems = append(elems, c.expr(m, s))
+			}
+		}
+		if len(elems) == 0 {
+			return listOf(anyType)
+		}
+		return listOf(unionOf(elems...))
+	case SetItemNT:
+		elems := []*Type{}
+		for m := n; m != nil; m = m.R {
+			switch m.L.Type {
+			case SplatNT:
+				elems = append(elems, c.expr(m.L.R, s).elemType())
+			case RangeNT, MapNT, WhereNT, PMapNT, PWhereNT:
+				elems = append(elems, c.expr(m.L, s).elemType())
+			default:
+				elems = append(elems, c.expr(m.L, s))
+			}
+		}
+		return setOf(unionOf(elems...))
+	case ObjectNT:
+		return objectOf(map[string]*Type{})
+	case ObjectItemNT:
+		fields := map[string]*Type{}
+		for m := n; m != nil; m = m.R {
+			item := m.L
+			switch item.Type {
+			case KVPairNT:
+				val := c.expr(item.R, s)
+				switch item.L.Type {
+				case IdentifierNT, StringNT:
+					if fields != nil {
+						fields[item.L.Val.(string)] = val
+					}
+				default:
+					c.expr(item.L, s)
+					fields = nil
+				}
+			case SplatNT:
+				src := c.expr(item.R, s)
+				if src.Kind == ObjectTK && src.Fields != nil && fields != nil {
+					for k, v := range src.Fields {
+						fields[k] = v
+					}
+				} else {
+					fields = nil
+				}
+			}
+		}
+		return objectOf(fields)
+	case RangeNT:
+		if n.L != nil {
+			start := c.expr(n.L, s)
+			if !start.mayBe(IntTK) {
+				c.errorf(n, "Invalid start value for range: %s", start)
+			}
+		}
+		end := c.expr(n.R, s)
+		if !end.mayBe(IntTK) && !end.mayBe(FloatTK) {
+			c.errorf(n, "Invalid end value for range: %s", end)
+			return failType
+		}
+		return listOf(intType)
+
+	// access
+	case BracketAccessNT:
+		return c.bracketAccess(n, c.expr(n.L, s), c.expr(n.R, s))
+	case FieldAccessNT:
+		return c.fieldAccess(n, c.expr(n.L, s))
+	case ListSliceNT:
+		src := c.expr(n.L, s)
+		if n.R.L != nil {
+			c.expr(n.R.L, s)
+		}
+		if n.R.R != nil {
+			c.expr(n.R.R, s)
+		}
+		res := []*Type{}
+		for _, m := range src.members() {
+			switch m.Kind {
+			case AnyTK:
+				return anyType
+			case ListTK, StringTK:
+				res = append(res, m)
+			default:
+				res = append(res, failType)
+			}
+		}
+		return c.definite(n, unionOf(res...), "Cannot slice a value of type %s", src)
+
+	// conditionals
+	case IfNT:
+		c.expr(n.L, s)
+		if n.R.Type == ThenBranchNT {
+			return unionOf(c.block(n.R.L, s), c.block(n.R.R, s))
+		}
+		return maybe(c.block(n.R, s))
+	case MatchNT:
+		return c.match(n, s)
+
+	// functions
+	case LambdaNT:
+		lt := &Type{Kind: LambdaTK, lambda: n, scope: s, Return: annotation(n)}
+		for p := n.L; p != nil && (p.Val != nil || p.L != nil); p = p.R {
+			if t := annotation(p); t != nil && p.Val != nil {
+				lt.Params = append(lt.Params, t)
+			} else {
+				lt.Params = append(lt.Params, anyType)
+			}
+		}
+		c.pending = append(c.pending, lt)
+		return lt
+	case CallNT:
+		callee := c.expr(n.L, s)
+		args := []*Type{}
+		for a := n.R; a != nil && a.L != nil; a = a.R {
+			args = append(args, c.expr(a.L, s))
+		}
+		name := ""
+		if n.L.Type == IdentifierNT {
+			name = n.L.Val.(string)
+		}
+		return c.apply(n, callee, args, name, nil)
+	case ReturnStmtNT:
+		t := c.expr(n.R, s)
+		if len(c.returns) > 0 {
+			rets := c.returns[len(c.returns)-1]
+			*rets = append(*rets, t)
+		}
+		return t
+
+	// tasks
+	case SpawnNT:
+		inner := newTypeScope(s)
+		if n.R.Type != StmtNT {
+			return taskOf(c.expr(n.R, inner))
+		}
+		rets := []*Type{}
+		c.returns = append(c.returns, &rets)
+		last := c.stmts(n.R, inner)
+		c.returns = c.returns[:len(c.returns)-1]
+		return taskOf(unionOf(append(rets, last)...))
+	case AwaitNT:
+		src := c.expr(n.R, s)
+		res := []*Type{}
+		for _, m := range src.members() {
+			switch m.Kind {
+			case AnyTK:
+				return anyType
+			case TaskTK:
+				if m.Elem == nil {
+					return anyType
+				}
+				res = append(res, m.Elem)
+			default:
+				res = append(res, failType)
+			}
+		}
+		return c.definite(n, unionOf(res...), "Cannot await a value of type %s", src)
+
+	// compound expressions
+	case MapNT, WhereNT, FindNT, PMapNT, PWhereNT:
+		return c.iteration(n, c.expr(n.L, s), c.expr(n.R, s))
+	case FoldNT:
+		var init *Type
+		if n.Val != nil {
+			init = c.expr(n.Val.(*Node), s)
+		}
+		return c.fold(n, c.expr(n.L, s), c.expr(n.R, s), init)
+	case PipeNT, BindNT:
+		lhs := c.expr(n.L, s)
+		fn := c.expr(n.R, s)
+		if lhs.Kind == FailTK {
+			return failType
+		}
+		res := c.apply(n, fn, []*Type{lhs.without(FailTK)}, "", nil)
+		if lhs.mayBe(FailTK) {
+			return maybe(res)
+		}
+		return res
+
+	// statements
+	case ConstDeclNT, VarDeclNT:
+		c.declare(n.L, c.expr(n.R, s), n.Type == VarDeclNT, s)
+		return successType
+	case AssignmentNT:
+		c.assign(n, s)
+		return successType
+	case WhileStmtNT:
+		c.expr(n.L, s)
+		c.stmts(n.R, newTypeScope(s))
+		return anyType
+	case ForStmtNT:
+		src := c.expr(n.L.R, s)
+		ok := false
+		for _, m := range src.members() {
+			if m.Kind == AnyTK || m.Kind == ListTK || m.Kind == SetTK || m.Kind == ObjectTK {
+				ok = true
+			}
+		}
+		if !ok && src.Kind != FailTK {
+			c.errorf(n, "Cannot iterate over a value of type %s", src)
+		}
+		inner := newTypeScope(s)
+		c.declare(n.L.L, src.elemType(), false, inner)
+		inner.names["index"] = &typeBinding{t: intType}
+		c.stmts(n.R, inner)
+		return anyType
+	case ImportNT:
+		name := getModuleName(n.Val.(string))
+		if n.R != nil {
+			name = n.R.Val.(string)
+		}
+		top := s
+		for top.parent != nil {
+			top = top.parent
+		}
+		top.names[name] = &typeBinding{t: moduleType}
+		return successType
+	}
+
+	return anyType
+}
+
+// definite reports an error if a result can only ever be fail, unless fail was passed in
+func (c *checker) definite(n *Node, res *Type, format string, args ...interface{}) *Type {
+	if res.Kind == FailTK {
+		for _, arg := range args {
+			if t, ok := arg.(*Type); ok && t.mayBe(FailTK) {
+				return res
+			}
+		}
+		c.errorf(n, format, args...)
+	}
+	return res
+}
+
+// binary infers the result of a binary operator for every combination of its operands' types
+func (c *checker) binary(n *Node, l, r *Type) *Type {
+	if l.Kind == AnyTK || r.Kind == AnyTK {
+		switch n.Type {
+		case LessNT, LessEqualNT, GreaterNT, GreaterEqualNT, EqualNT, NotEqualNT, InNT:
+			return maybe(boolType)
+		}
+		return anyType
+	}
+
+	res := []*Type{}
+	valid, failed := false, false
+	for _, a := range l.members() {
+		for _, b := range r.members() {
+			if t := binaryResult(n.Type, a, b); t != nil {
+				valid = true
+				res = append(res, t)
+			} else {
+				res = append(res, failType)
+				if a.Kind != FailTK && b.Kind != FailTK {
+					failed = true
+				}
+			}
+		}
+	}
+
+	if !valid && failed {
+		c.errorf(n, "Operator \"%s\" cannot be applied to %s and %s", n.Type.ToString(), l, r)
+	}
+	return unionOf(res...)
+}
+
+func isNumber(t *Type) bool {
+	return t.Kind == IntTK || t.Kind == FloatTK
+}
+
+// binaryResult returns the result of an operator applied to two (non-union) types, or nil if the
+// operation always fails
+func binaryResult(op NodeType, a, b *Type) *Type {
+	ints := a.Kind == IntTK && b.Kind == IntTK
+	nums := isNumber(a) && isNumber(b)
+
+	switch op {
+	case AddNT:
+		switch {
+		case ints:
+			return intType
+		case nums:
+			return floatType
+		case a.Kind == StringTK && (b.Kind == StringTK || isNumber(b)):
+			return stringType
+		case a.Kind == ListTK && b.Kind == ListTK:
+			return listOf(unionOf(a.elemType(), b.elemType()))
+		}
+	case SubtNT, MultNT:
+		if ints {
+			return intType
+		}
+		if nums {
+			return floatType
+		}
+	case DivNT:
+		if nums {
+			return maybe(floatType)
+		}
+	case ModuloNT:
+		if ints {
+			return maybe(intType)
+		}
+	case PowerNT:
+		if b.Kind == IntTK {
+			if a.Kind == IntTK {
+				return numberType
+			}
+			if a.Kind == FloatTK {
+				return floatType
+			}
+		}
+	case LessNT, LessEqualNT, GreaterNT, GreaterEqualNT:
+		if nums {
+			return boolType
+		}
+	case EqualNT, NotEqualNT:
+		// sets and objects are equal by their contents, but lambdas and modules can't be compared
+		if nums || (a.Kind == b.Kind && a.Kind != LambdaTK && a.Kind != ModuleTK) {
+			return boolType
+		}
+	case InNT:
+		if b.Kind == ListTK || b.Kind == SetTK {
+			return boolType
+		}
+	}
+	return nil
+}
+
+func (c *checker) unary(n *Node, arg *Type) *Type {
+	if arg.Kind == AnyTK {
+		return anyType
+	}
+
+	res := []*Type{}
+	for _, m := range arg.members() {
+		switch {
+		case n.Type == CardinalityNT && (m.Kind == ListTK || m.Kind == StringTK || m.Kind == SetTK || m.Kind == ObjectTK || m.Kind == TupleTK):
+			res = append(res, intType)
+		case n.Type == UnaryNegNT && isNumber(m):
+			res = append(res, m)
+		default:
+			res = append(res, failType)
+		}
+	}
+	return c.definite(n, unionOf(res...), "Operator \"%s\" cannot be applied to %s", n.Type.ToString(), arg)
+}
+
+func (c *checker) bracketAccess(n *Node, src, key *Type) *Type {
+	res := []*Type{}
+	for _, m := range src.members() {
+		switch m.Kind {
+		case AnyTK:
+			return anyType
+		case ListTK:
+			res = append(res, maybe(m.elemType()))
+		case StringTK:
+			res = append(res, maybe(stringType))
+		case TupleTK:
+			if i, ok := intLiteral(n.R); ok && m.Items != nil {
+				if i < 0 {
+					i += len(m.Items)
+				}
+				res = append(res, m.itemType(i))
+				continue
+			}
+			res = append(res, maybe(unionOf(m.Items...)))
+		case ObjectTK:
+			if m.Fields != nil && n.R.Type == StringNT {
+				if field, ok := m.Fields[n.R.Val.(string)]; ok {
+					res = append(res, field)
+					continue
+				}
+				res = append(res, failType)
+				continue
+			}
+			res = append(res, anyType)
+		default:
+			res = append(res, failType)
+		}
+	}
+
+	if (src.mayBe(ListTK) || src.mayBe(StringTK) || src.mayBe(TupleTK)) && !src.mayBe(ObjectTK) && !key.mayBe(IntTK) && !key.mayBe(FloatTK) && key.Kind != FailTK {
+		c.errorf(n, "Cannot index %s with %s", src, key)
+	}
+	if src.mayBe(TupleTK) {
+		// like a missing field, an index past the end of a tuple is a soft error
+		return unionOf(res...)
+	}
+	return c.definite(n, unionOf(res...), "Cannot index a value of type %s", src)
+}
+
+// intLiteral returns the value of an Int literal, e.g. a tuple index
+func intLiteral(n *Node) (int, bool) {
+	switch {
+	case n.Type == IntNT:
+		return int(n.Val.(int64)), true
+	case n.Type == UnaryNegNT && n.R.Type == IntNT:
+		return -int(n.R.Val.(int64)), true
+	}
+	return 0, false
+}
+
+func (c *checker) fieldAccess(n *Node, src *Type) *Type {
+	res := []*Type{}
+	for _, m := range src.members() {
+		switch m.Kind {
+		case AnyTK, ModuleTK:
+			return anyType
+		case ObjectTK:
+			if m.Fields == nil {
+				return anyType
+			}
+			if field, ok := m.Fields[n.R.Val.(string)]; ok {
+				res = append(res, field)
+			} else {
+				// accessing a missing field is a soft error
+				res = append(res, failType)
+			}
+		default:
+			res = append(res, failType)
+		}
+	}
+	if !src.mayBe(ObjectTK) {
+		return c.definite(n, unionOf(res...), "Cannot access field \"%s\" of a value of type %s", n.R.Val, src)
+	}
+	return unionOf(res...)
+}
+
+// iteration infers the result of map, where and find
+func (c *checker) iteration(n *Node, src, fn *Type) *Type {
+	if src.Kind == FailTK {
+		return failType
+	}
+
+	extra := map[string]*Type{"index": intType}
+	res := []*Type{}
+	collection := false
+	for _, m := range src.members() {
+		switch m.Kind {
+		case AnyTK:
+			c.apply(n, fn, []*Type{anyType}, "", extra)
+			return anyType
+		case ListTK, SetTK:
+			collection = true
+			elem := m.elemType()
+			out := c.apply(n, fn, []*Type{elem}, "", extra)
+			switch n.Type {
+			case MapNT, PMapNT:
+				res = append(res, &Type{Kind: m.Kind, Elem: out})
+			case WhereNT, PWhereNT:
+				res = append(res, m)
+			case FindNT:
+				res = append(res, maybe(elem))
+			}
+		default:
+			res = append(res, failType)
+		}
+	}
+
+	if !collection && !src.mayBe(FailTK) {
+		c.errorf(n, "\"%s\" cannot be applied to a value of type %s", n.Type.ToString(), src)
+	}
+	return unionOf(res...)
+}
+
+// comprehension checks the clauses of a comprehension in nested scopes, then its item. The
+// result may be fail if a generator's source may not be a collection.
+func (c *checker) comprehension(n *Node, s *typeScope) *Type {
+	inner := newTypeScope(s)
+	fails := false
+	for clause := n.R; clause != nil; clause = clause.R {
+		gen := clause.L
+		if gen.Type != GeneratorNT {
+			c.expr(gen, inner)
+			continue
+		}
+
+		src := c.expr(gen.R, inner)
+		ok := false
+		for _, m := range src.members() {
+			switch m.Kind {
+			case AnyTK, ListTK, SetTK, ObjectTK:
+				ok = true
+			default:
+				fails = true
+			}
+		}
+		if !ok && src.Kind != FailTK {
+			c.errorf(gen, "Cannot iterate over a value of type %s", src)
+		}
+		inner = newTypeScope(inner)
+		c.bindPattern(gen.L, src.elemType(), inner)
+	}
+
+	var res *Type
+	switch n.Val.(NodeType) {
+	case ObjectNT:
+		c.expr(n.L.L, inner)
+		c.expr(n.L.R, inner)
+		res = objectOf(nil)
+	case SetNT:
+		res = setOf(c.expr(n.L, inner))
+	default:
+		res = listOf(c.expr(n.L, inner))
+	}
+
+	if fails {
+		return maybe(res)
+	}
+	return res
+}
+
+// fold infers the result of a fold, which may also be the starting value (or without one, the
+// first item) for collections with too few items to call the function
+func (c *checker) fold(n *Node, src, fn, init *Type) *Type {
+	if src.Kind == FailTK {
+		return failType
+	}
+
+	extra := map[string]*Type{"index": intType}
+	res := []*Type{}
+	collection := false
+	for _, m := range src.members() {
+		switch m.Kind {
+		case AnyTK:
+			c.apply(n, fn, []*Type{anyType, anyType}, "", extra)
+			return anyType
+		case ListTK, SetTK, ObjectTK:
+			collection = true
+			elem := m.elemType()
+			if init != nil {
+				res = append(res, c.apply(n, fn, []*Type{init, elem}, "", extra), init)
+			} else {
+				res = append(res, c.apply(n, fn, []*Type{elem, elem}, "", extra), maybe(elem))
+			}
+		default:
+			res = append(res, failType)
+		}
+	}
+
+	if !collection && !src.mayBe(FailTK) {
+		c.errorf(n, "\"fold\" cannot be applied to a value of type %s", src)
+	}
+	return unionOf(res...)
+}
+
+// apply infers the result of calling a function with arguments of the given types
+func (c *checker) apply(n *Node, fn *Type, args []*Type, name string, extra map[string]*Type) *Type {
+	if fn.Kind == AnyTK {
+		return anyType
+	}
+	if fn.Kind == UnionTK {
+		res := []*Type{}
+		for _, m := range fn.Members {
+			if m.Kind != FailTK {
+				res = append(res, c.apply(n, m, args, name, extra))
+			}
+		}
+		if fn.mayBe(FailTK) {
+			res = append(res, failType)
+		}
+		return unionOf(res...)
+	}
+	if fn.Kind != LambdaTK {
+		if fn.Kind != FailTK {
+			c.errorf(n, "Cannot call a value of type %s", fn)
+		}
+		return failType
+	}
+
+	function := "anonymous function"
+	if name != "" {
+		function = fmt.Sprintf("function \"%s\"", name)
+	}
+
+	// built-in functions
+	if fn.builtin != nil {
+		sig := fn.builtin
+		if len(args) < sig.min || (sig.max >= 0 && len(args) > sig.max) {
+			expected := fmt.Sprint(sig.min)
+			if sig.max < 0 {
+				expected += "+"
+			}
+			c.errorf(n, "Wrong number of arguments for %s. Expected %s, received %d.", function, expected, len(args))
+			return anyType
+		}
+		for i, p := range sig.params {
+			if i < len(args) && !args[i].mayBe(FailTK) && !args[i].assignable(p) && !p.assignable(args[i]) {
+				c.errorf(n, "Argument %d of %s must be %s, received %s", i+1, function, typeKindNames[p.Kind], args[i])
+				return failType
+			}
+		}
+		return sig.ret(args)
+	}
+
+	if fn.lambda == nil {
+		if fn.Return != nil {
+			return fn.Return
+		}
+		return anyType
+	}
+
+	ps := len(fn.Params)
+	if ps > len(args) {
+		c.errorf(n, "Too few arguments provided to %s. Expected %d, received %d.", function, ps, len(args))
+		return anyType
+	}
+	if ps < len(args) {
+		c.errorf(n, "Too many arguments provided to %s. Expected %d, received %d.", function, ps, len(args))
+		return anyType
+	}
+
+	annotated := true
+	i := 0
+	for p := fn.lambda.L; p != nil && (p.Val != nil || p.L != nil); p = p.R {
+		t := fn.Params[i]
+		if !args[i].compatible(t) {
+			c.errorf(n, "Type mismatch for argument \"%s\" of %s. Expected %s, received %s.", p.Val.(string), function, t, args[i])
+			return failType
+		}
+		annotated = annotated && t.Kind != AnyTK
+		i++
+	}
+
+	// with every parameter annotated, the body's own check covers every call
+	if annotated && fn.Return != nil {
+		return fn.Return
+	}
+
+	c.calls = append(c.calls, nodeLine(n))
+	defer func() { c.calls = c.calls[:len(c.calls)-1] }()
+	res := c.body(fn, args, extra)
+	if fn.Return != nil {
+		return fn.Return
+	}
+	return res
+}
+
+// body checks the body of a Rye lambda with its parameters bound to the given argument types (or
+// Any), returning the type of the value it returns
+func (c *checker) body(fn *Type, args []*Type, extra map[string]*Type) *Type {
+	n := fn.lambda
+	if c.active[n] > 0 || c.depth > maxInstantiationDepth {
+		// recursive call
+		return anyType
+	}
+	c.active[n]++
+	branch := c.branch
+	c.branch = 0
+	if args != nil {
+		c.depth++
+	}
+	defer func() {
+		c.active[n]--
+		c.branch = branch
+		if args != nil {
+			c.depth--
+		}
+	}()
+
+	scope := newTypeScope(fn.scope)
+	for k, v := range extra {
+		scope.names[k] = &typeBinding{t: v}
+	}
+	i := 0
+	for p := n.L; p != nil && (p.Val != nil || p.L != nil); p = p.R {
+		arg := argOr(args, i)
+		if t := annotation(p); t != nil && p.Val != nil {
+			scope.names[p.Val.(string)] = &typeBinding{t: t, mutable: true, declared: t}
+		} else if p.Val != nil {
+			scope.names[p.Val.(string)] = &typeBinding{t: arg, mutable: true}
+		} else {
+			c.declare(p.L, arg, true, scope)
+		}
+		i++
+	}
+
+	var res *Type
+	if n.R == nil || n.R.Type != StmtNT {
+		res = c.expr(n.R, scope)
+	} else {
+		rets := []*Type{}
+		c.returns = append(c.returns, &rets)
+		last := c.stmts(n.R, scope)
+		c.returns = c.returns[:len(c.returns)-1]
+		res = unionOf(append(rets, last)...)
+	}
+
+	if fn.Return != nil && args == nil && !res.compatible(fn.Return) {
+		c.errorf(n.Val.(*Node), "Type mismatch for return value. Expected %s, received %s.", fn.Return, res)
+	}
+	return res
+}
+
+// declare adds a declaration (or destructured declaration) to a scope
+func (c *checker) declare(target *Node, t *Type, mutable bool, s *typeScope) {
+	switch target.Type {
+	case IdentifierNT:
+		name := target.Val.(string)
+		if _, exists := s.names[name]; exists {
+			c.errorf(target, "\"%s\" is already defined", name)
+		}
+		ann := annotation(target)
+		if ann != nil {
+			if !t.compatible(ann) {
+				c.errorf(target, "Type mismatch for \"%s\". Expected %s, received %s.", name, ann, t)
+			}
+			t = ann
+		}
+		s.names[name] = &typeBinding{t: t, mutable: mutable, declared: ann}
+	case ListNT:
+		for i, m := range target.Val.([]*Node) {
+			c.declare(m, t.itemType(i), mutable, s)
+		}
+	case TupleNT:
+		for i, m := range target.Val.([]*Node) {
+			c.declare(m, t.itemType(i), mutable, s)
+		}
+	case ObjectItemNT:
+		for m := target; m != nil; m = m.R {
+			key, name := m.L, m.L
+			if m.L.Type == KVPairNT {
+				key, name = m.L.L, m.L.R
+			}
+			field := anyType
+			if t.Kind == ObjectTK && t.Fields != nil {
+				if f, ok := t.Fields[key.Val.(string)]; ok {
+					field = f
+				} else {
+					field = failType
+				}
+			}
+			c.declare(name, field, mutable, s)
+		}
+	}
+}
+
+func (c *checker) assign(n *Node, s *typeScope) {
+	val := c.expr(n.R, s)
+	target := n.L
+
+	if target.Type == IdentifierNT {
+		name := target.Val.(string)
+		b, ok := s.lookup(name)
+		if !ok {
+			c.errorf(target, "Cannot assign to undefined variable \"%s\"", name)
+			return
+		}
+		if !b.mutable {
+			c.errorf(target, "Cannot assign to constant variable \"%s\"", name)
+			return
+		}
+		if b.declared != nil {
+			if !val.compatible(b.declared) {
+				c.errorf(target, "Type mismatch for \"%s\". Expected %s, received %s.", name, b.declared, val)
+			}
+			return
+		}
+		if !val.assignable(b.t) {
+			b.t = unionOf(b.t, val)
+		}
+		return
+	}
+
+	container := c.expr(target.L, s)
+	for _, m := range container.members() {
+		if m.Kind != ObjectTK || m.Fields == nil {
+			continue
+		}
+		// fields added to an object are visible everywhere the object is
+		if target.Type == FieldAccessNT {
+			name := target.R.Val.(string)
+			m.Fields[name] = unionOf(m.Fields[name], val)
+		} else if target.R.Type == StringNT {
+			name := target.R.Val.(string)
+			m.Fields[name] = unionOf(m.Fields[name], val)
+		} else {
+			m.Fields = nil
+		}
+	}
+	if target.Type == BracketAccessNT {
+		c.expr(target.R, s)
+	}
+
+	if container.Kind == TupleTK {
+		c.errorf(n, "Cannot assign to an item of a tuple. Tuples can't be changed.")
+	} else if !container.mayBe(ListTK) && !container.mayBe(ObjectTK) {
+		c.errorf(n, "Invalid assignment target. Cannot assign to a field or index of %s", container)
+	}
+}
+
+func (c *checker) match(n *Node, s *typeScope) *Type {
+	subject := c.expr(n.L, s)
+	res := []*Type{}
+	exhaustive := false
+
+	c.branch++
+	defer func() { c.branch-- }()
+	for arm := n.R; arm != nil; arm = arm.R {
+		pattern, guard := arm.L.L, (*Node)(nil)
+		if pattern.Type == IfNT {
+			pattern, guard = pattern.R, pattern.L
+		}
+
+		scope := newTypeScope(s)
+		c.bindPattern(pattern, subject, scope)
+		if guard != nil {
+			c.expr(guard, scope)
+		} else if pattern.Type == UnderscoreNT || pattern.Type == IdentifierNT {
+			exhaustive = true
+		}
+
+		if arm.L.R.Type == StmtNT {
+			res = append(res, c.stmts(arm.L.R, scope))
+		} else {
+			res = append(res, c.expr(arm.L.R, scope))
+		}
+	}
+
+	if !exhaustive {
+		res = append(res, failType)
+	}
+	return unionOf(res...)
+}
+
+func (c *checker) bindPattern(pattern *Node, t *Type, s *typeScope) {
+	switch pattern.Type {
+	case IdentifierNT:
+		s.names[pattern.Val.(string)] = &typeBinding{t: t}
+	case ListNT:
+		for i, m := range pattern.Val.([]*Node) {
+			switch {
+			case m.Type == SplatNT:
+				c.bindPattern(m.R, listOf(t.elemType()), s)
+			case t.Kind == TupleTK:
+				c.bindPattern(m, t.itemType(i), s)
+			default:
+				c.bindPattern(m, t.elemType(), s)
+			}
+		}
+	case TupleNT:
+		for i, m := range pattern.Val.([]*Node) {
+			item := anyType
+			if t.Kind == TupleTK && t.Items != nil && i < len(t.Items) {
+				item = t.Items[i]
+			}
+			c.bindPattern(m, item, s)
+		}
+	case ObjectItemNT:
+		for m := pattern; m != nil; m = m.R {
+			key, sub := m.L, m.L
+			if m.L.Type == KVPairNT {
+				key, sub = m.L.L, m.L.R
+			}
+			field := anyType
+			if t.Kind == ObjectTK && t.Fields != nil {
+				if f, ok := t.Fields[key.Val.(string)]; ok {
+					field = f
+				}
+			}
+			c.bindPattern(sub, field, s)
+		}
+	}
+}
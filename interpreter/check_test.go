@@ -0,0 +1,175 @@
+package interpreter
+
+import (
+	"strings"
+	"testing"
+)
+
+type CheckTest struct {
+	input  string
+	errors []string // expected type errors, in order
+}
+
+func runCheckTest(test CheckTest, t *testing.T) {
+	ast, err := scanAndParse(test.input)
+
+	if err != nil {
+		t.Fatalf(`Failed to parse "%s": %s`, test.input, err.Error())
+	}
+
+	errs := Check(ast)
+	msgs := []string{}
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+
+	if strings.Join(msgs, "\n") != strings.Join(test.errors, "\n") {
+		t.Fatalf(`Checked "%s" incorrectly:
+			Expected: %v
+			Received: %v
+			`, test.input, test.errors, msgs)
+	}
+}
+
+func TestCheckExpr(t *testing.T) {
+	tests := []CheckTest{
+		{`1 + 2 * 3.5`, nil},
+		{`"a" + 1`, nil},
+		{`1 + "a"`, []string{`Line 1: Operator "+" cannot be applied to Int and String`}},
+		{`4 > "three"`, []string{`Line 1: Operator ">" cannot be applied to Int and String`}},
+		{`{1, 2} == {2, 1}`, nil},
+		{`{a: 1} == {a: 1}`, nil},
+		{`{a: 1} != {a: 2, b: "c"}`, nil},
+		{`{1} == {a: 1}`, []string{`Line 1: Operator "==" cannot be applied to Set[Int] and {a: Int}`}},
+		{`-"foo"`, []string{`Line 1: Operator "-" cannot be applied to String`}},
+		{`(1 / 0) + 1`, nil},
+		{`fail + 1`, nil},
+		{`x + 1`, []string{`Line 1: "x" is undefined`}},
+		{`uppercase(3)`, []string{`Line 1: Argument 1 of function "uppercase" must be String, received Int`}},
+		{`split("a b")`, []string{`Line 1: Wrong number of arguments for function "split". Expected 2, received 1.`}},
+		{`5 map _ + 1`, []string{`Line 1: "map" cannot be applied to a value of type Int`}},
+		{`5 fold (a, b) => a + b`, []string{`Line 1: "fold" cannot be applied to a value of type Int`}},
+		{`[1, 2] fold (acc, x) => acc + x from ""`, nil},
+		{`{a: 1}.b`, nil},
+		{`[x for x <- 5 if y]`, []string{`Line 1: Cannot iterate over a value of type Int`, `Line 1: "y" is undefined`}},
+		{`{k: v for k, v <- pairs({a: 1}) if v > 0}`, nil},
+		{`[-k for k, v <- [("a", 1)] if v > 0]`, []string{`Line 1: Operator "-" cannot be applied to String`}},
+		{`-(1, "a")[1]`, []string{`Line 1: Operator "-" cannot be applied to String`}},
+		{`(1, "a")[2]`, nil},
+		{`"total: ${1 + "a"} for ${y}"`, []string{`Line 1: Operator "+" cannot be applied to Int and String`, `Line 1: "y" is undefined`}},
+	}
+
+	for _, test := range tests {
+		runCheckTest(test, t)
+	}
+}
+
+func TestCheckStmt(t *testing.T) {
+	tests := []CheckTest{
+		{`
+			x := 1
+			x = 2
+		`, []string{`Line 3: Cannot assign to constant variable "x"`}},
+		{`
+			var x := 1
+			x = "one"
+			y := x + 1
+		`, nil},
+		{`
+			f := (a, b) => a + b
+			f(1)
+		`, []string{`Line 3: Too few arguments provided to function "f". Expected 2, received 1.`}},
+		{`
+			double := _ * 2
+			double("two")
+		`, []string{`Line 2: Operator "*" cannot be applied to String and Int (in call on line 3)`}},
+		{`
+			isEven := x => x % 2 == 0
+			ns := [1, 2, 3] where isEven map _ * 2 then sum
+		`, nil},
+		{`
+			// lambdas may refer to declarations after them
+			isEven := n => n == 0 or isOdd(n - 1)
+			isOdd := n => n != 0 and isEven(n - 1)
+			isEven(4)
+		`, nil},
+		{`
+			f := () => y
+		`, []string{`Line 2: "y" is undefined`}},
+		{`
+			shape := { kind: "circle", r: 2 }
+			area := match shape {
+				{ kind: "circle", r } => 3.14 * r ^ 2
+				{ kind: "square", s } => s ^ 2
+			}
+			area + 1
+		`, nil},
+		{`
+			var x: Int = 1
+			x = "one"
+			y: String = x
+		`, []string{
+			`Line 3: Type mismatch for "x". Expected Int, received String.`,
+			`Line 4: Type mismatch for "y". Expected String, received Int.`,
+		}},
+		{`
+			f := (a: Int, b: Int): Int => a * b
+			f(2, 1.5)
+			n: Int = f(2, 3)
+		`, []string{`Line 3: Type mismatch for argument "b" of function "f". Expected Int, received Float.`}},
+		{`
+			g := (s: String): Int => uppercase(s)
+		`, []string{`Line 2: Type mismatch for return value. Expected Int, received String.`}},
+		{`
+			p := (1, 2)
+			p[0] = 3
+			(a, b) := p
+			c := a + b
+		`, []string{`Line 3: Cannot assign to an item of a tuple. Tuples can't be changed.`}},
+		{`
+			ch := Channel()
+			t := spawn send(ch, 1)
+			await ch
+		`, []string{`Line 4: Cannot await a value of type Channel`}},
+	}
+
+	for _, test := range tests {
+		runCheckTest(test, t)
+	}
+}
+
+func TestCheckTypes(t *testing.T) {
+	tests := []struct {
+		input, typ string
+	}{
+		{`1 + 1`, `Int`},
+		{`1 / 2`, `Float?`},
+		{`[1, 2.5]`, `List[Int | Float]`},
+		{`{ a: "foo" }`, `{a: String}`},
+		{`..10 map _ * 1.5`, `List[Float]`},
+		{`"a" if true`, `String?`},
+		{`1 / 2 | 0`, `Float | Int`},
+		{`{1, 2} where _ > 1`, `Set[Int]`},
+		{`(1, ("a", 2.5))`, `(Int, (String, Float))`},
+		{`(1, "a")[-1]`, `String`},
+		{`(x, y) := (1, "a")
+		y`, `String`},
+		{`[x * 2 for x <- [1, 2] if x > 1]`, `List[Int]`},
+		{`spawn { return "a" }`, `Task[String]`},
+		{`await (spawn 1 + 2)`, `Int`},
+		{`{1, 2} pmap _ * 2.5`, `Set[Float]`},
+	}
+
+	for _, test := range tests {
+		ast, err := scanAndParse(test.input)
+		if err != nil {
+			t.Fatalf(`Failed to parse "%s": %s`, test.input, err.Error())
+		}
+
+		c := &checker{seen: map[string]bool{}, checked: map[*Node]bool{}, active: map[*Node]int{}}
+		typ := c.stmts(ast, newTypeScope(nil))
+		if typ.String() != test.typ {
+			t.Fatalf(`Inferred the type of "%s" incorrectly. Expected %s, received %s`, test.input, test.typ, typ)
+		}
+	}
+}
@@ -0,0 +1,660 @@
+package interpreter
+
+import (
+	"math/bits"
+	"sync/atomic"
+)
+
+// List is a persistent vector. Its items are kept in a tree of nodes with up to 32 children, whose
+// leaves hold 32 items each, followed by a tail holding the last few. Updates copy the path to the
+// item they change rather than the whole list, so they take O(log n) time, and appending to the tail
+// usually takes none at all. A slice of a list is a window of its tree, so it's made in O(1) time.
+type List struct {
+	root  *vnode
+	tail  []Value
+	claim *int32 // how much of the tail's array is in use by some version of the list
+	shift uint   // how far an index is shifted to pick the root's child
+	tree  int    // items in the tree
+	start int    // the window of items in the tree and tail that are part of the list
+	end   int
+}
+
+// vnode is a node of a List's tree: a leaf with items, or a branch with kids
+type vnode struct {
+	kids  []*vnode
+	items []Value
+}
+
+const (
+	listBits  = 5
+	listWidth = 1 << listBits
+	listMask  = listWidth - 1
+)
+
+// ListOf makes a list of items
+func ListOf(items ...Value) List {
+	return listFrom(append([]Value(nil), items...))
+}
+
+// listFrom makes a list of items, keeping the slice rather than copying it
+func listFrom(items []Value) List {
+	if len(items) == 0 {
+		return List{}
+	}
+
+	// the tail holds 1 to 32 items, and the full leaves before it are grouped into branches
+	tree := (len(items) - 1) &^ listMask
+	l := List{tail: items[tree:], claim: new(int32), tree: tree, end: len(items)}
+	*l.claim = int32(len(l.tail))
+	if tree == 0 {
+		return l
+	}
+
+	nodes := make([]*vnode, 0, tree/listWidth)
+	for i := 0; i < tree; i += listWidth {
+		nodes = append(nodes, &vnode{items: items[i : i+listWidth : i+listWidth]})
+	}
+	l.shift = listBits
+	for len(nodes) > listWidth {
+		parents := make([]*vnode, 0, (len(nodes)+listMask)/listWidth)
+		for i := 0; i < len(nodes); i += listWidth {
+			j := i + listWidth
+			if j > len(nodes) {
+				j = len(nodes)
+			}
+			parents = append(parents, &vnode{kids: nodes[i:j:j]})
+		}
+		nodes = parents
+		l.shift += listBits
+	}
+	l.root = &vnode{kids: nodes}
+	return l
+}
+
+// Len gives the number of items in a list
+func (l List) Len() int {
+	return l.end - l.start
+}
+
+// Get gives the item at index i, which must be in range
+func (l List) Get(i int) Value {
+	leaf, j := l.leaf(l.start + i)
+	return leaf[j]
+}
+
+// leaf gives the leaf or tail holding the item at position i of the tree and tail, and its index
+// there
+func (l List) leaf(i int) ([]Value, int) {
+	if i >= l.tree {
+		return l.tail, i - l.tree
+	}
+
+	n := l.root
+	for level := l.shift; level > 0; level -= listBits {
+		n = n.kids[(i>>level)&listMask]
+	}
+	return n.items, i & listMask
+}
+
+// Append gives a list with an item added to the end
+func (l List) Append(v Value) List {
+	if l.end < l.tree+len(l.tail) {
+		// a slice that ends before the items it was sliced from
+		l = l.set(l.end, v)
+		l.end++
+		return l
+	}
+
+	if len(l.tail) == listWidth {
+		// the tail is full, so it becomes a leaf of the tree
+		leaf := &vnode{items: l.tail}
+		switch {
+		case l.root == nil:
+			l.root, l.shift = &vnode{kids: []*vnode{leaf}}, listBits
+		case l.tree>>listBits >= 1<<l.shift:
+			// the root is full
+			l.root = &vnode{kids: []*vnode{l.root, newPath(l.shift, leaf)}}
+			l.shift += listBits
+		default:
+			l.root = pushLeaf(l.root, l.shift, l.tree, leaf)
+		}
+		l.tree += listWidth
+		l.tail, l.claim = nil, nil
+	}
+
+	// versions of a list share the array of their tail, and the first to append to it claims the
+	// next item of it. The rest copy it.
+	n := len(l.tail)
+	if n < cap(l.tail) && atomic.CompareAndSwapInt32(l.claim, int32(n), int32(n+1)) {
+		l.tail = l.tail[:n+1]
+	} else {
+		size := 2 * cap(l.tail)
+		if size < 4 {
+			size = 4
+		} else if size > listWidth {
+			size = listWidth
+		}
+		tail := make([]Value, n+1, size)
+		copy(tail, l.tail)
+		l.tail, l.claim = tail, new(int32)
+		*l.claim = int32(n + 1)
+	}
+	l.tail[n] = v
+	l.end++
+	return l
+}
+
+// newPath makes a branch for each level above a leaf, down to it
+func newPath(level uint, leaf *vnode) *vnode {
+	if level == 0 {
+		return leaf
+	}
+	return &vnode{kids: []*vnode{newPath(level-listBits, leaf)}}
+}
+
+// pushLeaf gives a copy of a branch with a leaf added after its last, whose first item is at i
+func pushLeaf(n *vnode, level uint, i int, leaf *vnode) *vnode {
+	kids := make([]*vnode, len(n.kids), len(n.kids)+1)
+	copy(kids, n.kids)
+
+	sub := (i >> level) & listMask
+	switch {
+	case level == listBits:
+		kids = append(kids, leaf)
+	case sub < len(kids):
+		kids[sub] = pushLeaf(kids[sub], level-listBits, i, leaf)
+	default:
+		kids = append(kids, newPath(level-listBits, leaf))
+	}
+	return &vnode{kids: kids}
+}
+
+// Update gives a list with the item at index i, which must be in range, replaced by v
+func (l List) Update(i int, v Value) List {
+	return l.set(l.start+i, v)
+}
+
+// set replaces the item at position i of the tree and tail
+func (l List) set(i int, v Value) List {
+	if i >= l.tree {
+		tail := make([]Value, len(l.tail), cap(l.tail))
+		copy(tail, l.tail)
+		tail[i-l.tree] = v
+		l.tail, l.claim = tail, new(int32)
+		*l.claim = int32(len(tail))
+		return l
+	}
+
+	l.root = setItem(l.root, l.shift, i, v)
+	return l
+}
+
+func setItem(n *vnode, level uint, i int, v Value) *vnode {
+	if level == 0 {
+		items := append([]Value(nil), n.items...)
+		items[i&listMask] = v
+		return &vnode{items: items}
+	}
+
+	kids := append([]*vnode(nil), n.kids...)
+	sub := (i >> level) & listMask
+	kids[sub] = setItem(kids[sub], level-listBits, i, v)
+	return &vnode{kids: kids}
+}
+
+// Slice gives the items from index i up to j, which must be in range
+func (l List) Slice(i, j int) List {
+	l.start, l.end = l.start+i, l.start+j
+	return l
+}
+
+// Concat gives a list with the items of r added to the end
+func (l List) Concat(r List) List {
+	r.each(func(_ int, v Value) bool {
+		l = l.Append(v)
+		return true
+	})
+	return l
+}
+
+// Items gives the items of a list, in a slice of their own
+func (l List) Items() []Value {
+	items := make([]Value, 0, l.Len())
+	l.each(func(_ int, v Value) bool {
+		items = append(items, v)
+		return true
+	})
+	return items
+}
+
+// each calls fn with the index and value of each item in order, until it returns false
+func (l List) each(fn func(int, Value) bool) {
+	for i := l.start; i < l.end; {
+		leaf, j := l.leaf(i)
+		for ; j < len(leaf) && i < l.end; i, j = i+1, j+1 {
+			if !fn(i-l.start, leaf[j]) {
+				return
+			}
+		}
+	}
+}
+
+// iterator gives a function giving the items of a list in order, then the zero Value
+func (l List) iterator() func() Value {
+	var leaf []Value
+	i, j := l.start, 0
+	return func() Value {
+		if i >= l.end {
+			return Value{}
+		}
+		if j >= len(leaf) {
+			leaf, j = l.leaf(i)
+		}
+		v := leaf[j]
+		i, j = i+1, j+1
+		return v
+	}
+}
+
+// hamt is a persistent hash array mapped trie, which maps the keys of values to entries. Each node
+// has up to 32 slots, picked by 5 bits of the hash of a key, holding either an entry or a node for
+// the entries whose hashes share those bits. Updates copy the path to the entry they change, so
+// they take O(log n) time. Keys whose hashes are the same share a node past the last bits.
+type hamt struct {
+	root *hnode
+	size int
+}
+
+type hnode struct {
+	datamap uint32 // the slots holding an entry
+	nodemap uint32 // the slots holding a node
+	entries []entry
+	kids    []*hnode
+	owner   *owner // the builder that may change the node in place
+}
+
+// entry is an entry of a hamt. It holds its field through a pointer, as copying a node copies all
+// of its entries.
+type entry struct {
+	hash uint64
+	val  *Field // the field of an object, or a set member as the Key
+}
+
+// is reports whether an entry is the one for k, whose hash is given. Keys are only compared when
+// the hashes are the same, as a collection's key must be encoded again.
+func (e *entry) is(hash uint64, k key) bool {
+	return e.hash == hash && keyOf(e.val.Key) == k
+}
+
+// owner identifies a builder, which may change the nodes it made until it's done building
+type owner struct {
+	_ int
+}
+
+const hashBits = 64
+
+// hashKey hashes a key with FNV-1a, mixing the result so that keys that differ in a few bits (like
+// consecutive Ints) are spread out across the slots of a node
+func hashKey(k key) uint64 {
+	h := uint64(14695981039346656037)
+	h = (h ^ uint64(k.Type)) * 1099511628211
+	for i := 0; i < 64; i += 8 {
+		h = (h ^ (k.bits>>i)&0xff) * 1099511628211
+	}
+	for i := 0; i < len(k.str); i++ {
+		h = (h ^ uint64(k.str[i])) * 1099511628211
+	}
+
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// slot gives the bit of a node's maps for the slot a hash is in, at a level of the trie
+func slot(hash uint64, shift uint) uint32 {
+	return 1 << ((hash >> shift) & 31)
+}
+
+// index gives the position in a node's entries or kids of the slot for bit
+func index(bitmap, bit uint32) int {
+	return bits.OnesCount32(bitmap & (bit - 1))
+}
+
+func (m hamt) get(k key) (Field, bool) {
+	h := hashKey(k)
+	n := m.root
+	for shift := uint(0); n != nil; shift += 5 {
+		if shift >= hashBits {
+			for i := range n.entries {
+				if n.entries[i].is(h, k) {
+					return *n.entries[i].val, true
+				}
+			}
+			return Field{}, false
+		}
+
+		bit := slot(h, shift)
+		if n.datamap&bit != 0 {
+			e := &n.entries[index(n.datamap, bit)]
+			if e.is(h, k) {
+				return *e.val, true
+			}
+			return Field{}, false
+		}
+		if n.nodemap&bit == 0 {
+			return Field{}, false
+		}
+		n = n.kids[index(n.nodemap, bit)]
+	}
+	return Field{}, false
+}
+
+// put gives a trie with the entry for k set to val. Nodes made by o are changed in place.
+func (m hamt) put(k key, val Field, o *owner) hamt {
+	root, added := m.root.put(entry{hash: hashKey(k), val: &val}, k, 0, o)
+	m.root = root
+	if added {
+		m.size++
+	}
+	return m
+}
+
+// remove gives a trie without the entry for k
+func (m hamt) remove(k key) hamt {
+	root, removed := m.root.remove(k, hashKey(k), 0)
+	if removed {
+		m.root = root
+		m.size--
+	}
+	return m
+}
+
+// each calls fn with each entry of a trie, until it returns false
+func (m hamt) each(fn func(*entry) bool) {
+	if m.root != nil {
+		m.root.each(fn)
+	}
+}
+
+func (n *hnode) each(fn func(*entry) bool) bool {
+	for i := range n.entries {
+		if !fn(&n.entries[i]) {
+			return false
+		}
+	}
+	for _, kid := range n.kids {
+		if !kid.each(fn) {
+			return false
+		}
+	}
+	return true
+}
+
+// editable gives a node that can be changed: the node itself if o made it, or else a copy of it
+// with room for another entry and kid
+func (n *hnode) editable(o *owner) *hnode {
+	if o != nil && n.owner == o {
+		return n
+	}
+	m := &hnode{datamap: n.datamap, nodemap: n.nodemap, owner: o}
+	m.entries = make([]entry, len(n.entries), len(n.entries)+1)
+	copy(m.entries, n.entries)
+	if len(n.kids) > 0 {
+		m.kids = make([]*hnode, len(n.kids), len(n.kids)+1)
+		copy(m.kids, n.kids)
+	}
+	return m
+}
+
+func (n *hnode) put(e entry, k key, shift uint, o *owner) (*hnode, bool) {
+	if n == nil {
+		return &hnode{datamap: slot(e.hash, shift), entries: []entry{e}, owner: o}, true
+	}
+
+	if shift >= hashBits {
+		// every entry here has the same hash
+		for i := range n.entries {
+			if n.entries[i].is(e.hash, k) {
+				m := n.editable(o)
+				m.entries[i] = e
+				return m, false
+			}
+		}
+		m := n.editable(o)
+		m.entries = append(m.entries, e)
+		return m, true
+	}
+
+	bit := slot(e.hash, shift)
+	switch {
+	case n.datamap&bit != 0:
+		i := index(n.datamap, bit)
+		old := n.entries[i]
+		m := n.editable(o)
+		if old.is(e.hash, k) {
+			m.entries[i] = e
+			return m, false
+		}
+
+		// the entries share a slot, so they move to a node of their own
+		m.entries = append(m.entries[:i], m.entries[i+1:]...)
+		m.datamap &^= bit
+		m.nodemap |= bit
+		j := index(m.nodemap, bit)
+		m.kids = append(m.kids, nil)
+		copy(m.kids[j+1:], m.kids[j:])
+		m.kids[j] = pair(old, e, shift+5, o)
+		return m, true
+	case n.nodemap&bit != 0:
+		i := index(n.nodemap, bit)
+		kid, added := n.kids[i].put(e, k, shift+5, o)
+		m := n.editable(o)
+		m.kids[i] = kid
+		return m, added
+	}
+
+	m := n.editable(o)
+	m.datamap |= bit
+	i := index(m.datamap, bit)
+	m.entries = append(m.entries, entry{})
+	copy(m.entries[i+1:], m.entries[i:])
+	m.entries[i] = e
+	return m, true
+}
+
+// pair makes a node for two entries whose hashes share the bits above shift
+func pair(a, b entry, shift uint, o *owner) *hnode {
+	if shift >= hashBits {
+		return &hnode{entries: []entry{a, b}, owner: o}
+	}
+
+	ba, bb := slot(a.hash, shift), slot(b.hash, shift)
+	if ba == bb {
+		return &hnode{nodemap: ba, kids: []*hnode{pair(a, b, shift+5, o)}, owner: o}
+	}
+	if ba > bb {
+		a, b = b, a
+	}
+	return &hnode{datamap: ba | bb, entries: []entry{a, b}, owner: o}
+}
+
+// remove gives a node without the entry for k, or nil if it was the node's last, along with
+// whether it was there at all
+func (n *hnode) remove(k key, hash uint64, shift uint) (*hnode, bool) {
+	if n == nil {
+		return nil, false
+	}
+
+	if shift >= hashBits {
+		for i := range n.entries {
+			if n.entries[i].is(hash, k) {
+				if len(n.entries) == 1 {
+					return nil, true
+				}
+				m := n.editable(nil)
+				m.entries = append(m.entries[:i], m.entries[i+1:]...)
+				return m, true
+			}
+		}
+		return n, false
+	}
+
+	bit := slot(hash, shift)
+	switch {
+	case n.datamap&bit != 0:
+		i := index(n.datamap, bit)
+		if !n.entries[i].is(hash, k) {
+			return n, false
+		}
+		if len(n.entries) == 1 && len(n.kids) == 0 {
+			return nil, true
+		}
+		m := n.editable(nil)
+		m.entries = append(m.entries[:i], m.entries[i+1:]...)
+		m.datamap &^= bit
+		return m, true
+	case n.nodemap&bit != 0:
+		i := index(n.nodemap, bit)
+		kid, removed := n.kids[i].remove(k, hash, shift+5)
+		if !removed {
+			return n, false
+		}
+
+		m := n.editable(nil)
+		switch {
+		case kid != nil && (len(kid.entries) > 1 || len(kid.kids) > 0):
+			m.kids[i] = kid
+			return m, true
+		case kid != nil:
+			// a node left with one entry is replaced by the entry
+			m.datamap |= bit
+			j := index(m.datamap, bit)
+			m.entries = append(m.entries, entry{})
+			copy(m.entries[j+1:], m.entries[j:])
+			m.entries[j] = kid.entries[0]
+		}
+		m.kids = append(m.kids[:i], m.kids[i+1:]...)
+		m.nodemap &^= bit
+		if len(m.entries) == 0 && len(m.kids) == 0 {
+			return nil, true
+		}
+		return m, true
+	}
+	return n, false
+}
+
+// Set is a persistent hash set, whose members are held under their keys in a hamt so that equal
+// values are the same member
+type Set struct {
+	m hamt
+}
+
+// Len gives the number of members in a set
+func (s Set) Len() int {
+	return s.m.size
+}
+
+// Has reports whether a set has a member equal to v
+func (s Set) Has(v Value) bool {
+	_, ok := s.m.get(keyOf(v))
+	return ok
+}
+
+// Add gives a set with v as a member
+func (s Set) Add(v Value) Set {
+	return Set{s.m.put(keyOf(v), Field{Key: v}, nil)}
+}
+
+// Remove gives a set without the member equal to v
+func (s Set) Remove(v Value) Set {
+	return Set{s.m.remove(keyOf(v))}
+}
+
+// Members gives the members of a set, in a slice of their own
+func (s Set) Members() []Value {
+	members := make([]Value, 0, s.Len())
+	s.m.each(func(e *entry) bool {
+		members = append(members, e.val.Key)
+		return true
+	})
+	return members
+}
+
+// setBuilder builds a set by adding to it in place. The set it starts with isn't changed.
+type setBuilder struct {
+	s     Set
+	owner *owner
+}
+
+func (b *setBuilder) add(v Value) {
+	if b.owner == nil {
+		b.owner = &owner{}
+	}
+	b.s.m = b.s.m.put(keyOf(v), Field{Key: v}, b.owner)
+}
+
+// set gives the set that was built, after which it's no longer changed in place
+func (b *setBuilder) set() Set {
+	b.owner = nil
+	return b.s
+}
+
+// Object is a persistent hash map of fields, held under the key of the field's name, which may be
+// any value
+type Object struct {
+	m hamt
+}
+
+// Len gives the number of fields of an object
+func (o Object) Len() int {
+	return o.m.size
+}
+
+// Get gives the field of an object named by k
+func (o Object) Get(k Value) (Value, bool) {
+	f, ok := o.m.get(keyOf(k))
+	return f.Val, ok
+}
+
+// Put gives an object with the field named by k set to v
+func (o Object) Put(k, v Value) Object {
+	return Object{o.m.put(keyOf(k), Field{Key: k, Val: v}, nil)}
+}
+
+// Remove gives an object without the field named by k
+func (o Object) Remove(k Value) Object {
+	return Object{o.m.remove(keyOf(k))}
+}
+
+// Fields gives the fields of an object, in a slice of their own
+func (o Object) Fields() []Field {
+	fields := make([]Field, 0, o.Len())
+	o.m.each(func(e *entry) bool {
+		fields = append(fields, *e.val)
+		return true
+	})
+	return fields
+}
+
+// objectBuilder builds an object by setting its fields in place. The object it starts with isn't
+// changed.
+type objectBuilder struct {
+	obj   Object
+	owner *owner
+}
+
+func (b *objectBuilder) put(k, v Value) {
+	if b.owner == nil {
+		b.owner = &owner{}
+	}
+	b.obj.m = b.obj.m.put(keyOf(k), Field{Key: k, Val: v}, b.owner)
+}
+
+// object gives the object that was built, after which it's no longer changed in place
+func (b *objectBuilder) object() Object {
+	b.owner = nil
+	return b.obj
+}
@@ -0,0 +1,169 @@
+package interpreter
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The slice and maps that List, Set and Object replaced, which were copied to be changed without
+// changing the original, kept as a reference for benchmarks.
+
+type copyList []Value
+
+func (l copyList) Append(v Value) copyList {
+	list := make(copyList, 0, len(l)+1)
+	return append(append(list, l...), v)
+}
+
+func (l copyList) Update(i int, v Value) copyList {
+	list := append(copyList(nil), l...)
+	list[i] = v
+	return list
+}
+
+type copyMap map[key]Field
+
+func (m copyMap) Put(k, v Value) copyMap {
+	cp := make(copyMap, len(m)+1)
+	for mk, f := range m {
+		cp[mk] = f
+	}
+	cp[keyOf(k)] = Field{Key: k, Val: v}
+	return cp
+}
+
+var collectionSizes = []int{100, 1_000, 10_000}
+
+func skipLarge(b *testing.B, size int) {
+	if size > 1_000 {
+		b.Skip("copying the whole collection for each item takes too long")
+	}
+}
+
+// each benchmark makes a new version of a collection of a given size once per item, as a loop like
+// `result += [item]` does
+func BenchmarkListAppend(b *testing.B) {
+	for _, size := range collectionSizes {
+		b.Run(fmt.Sprintf("copy/%d", size), func(b *testing.B) {
+			skipLarge(b, size)
+			for i := 0; i < b.N; i++ {
+				l := copyList{}
+				for j := 0; j < size; j++ {
+					l = l.Append(NewInt(int64(j)))
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("persistent/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				l := List{}
+				for j := 0; j < size; j++ {
+					l = l.Append(NewInt(int64(j)))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkListUpdate(b *testing.B) {
+	for _, size := range collectionSizes {
+		items := make([]Value, size)
+		for j := range items {
+			items[j] = NewInt(int64(j))
+		}
+
+		b.Run(fmt.Sprintf("copy/%d", size), func(b *testing.B) {
+			skipLarge(b, size)
+			for i := 0; i < b.N; i++ {
+				l := copyList(items)
+				for j := 0; j < size; j++ {
+					l = l.Update(j, NULL)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("persistent/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				l := ListOf(items...)
+				for j := 0; j < size; j++ {
+					l = l.Update(j, NULL)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSetAdd(b *testing.B) {
+	for _, size := range collectionSizes {
+		b.Run(fmt.Sprintf("copy/%d", size), func(b *testing.B) {
+			skipLarge(b, size)
+			for i := 0; i < b.N; i++ {
+				s := copyMap{}
+				for j := 0; j < size; j++ {
+					s = s.Put(NewInt(int64(j)), Value{})
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("persistent/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				s := Set{}
+				for j := 0; j < size; j++ {
+					s = s.Add(NewInt(int64(j)))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkObjectPut(b *testing.B) {
+	for _, size := range collectionSizes {
+		keys := make([]Value, size)
+		for j := range keys {
+			keys[j] = NewString(fmt.Sprintf("key%d", j))
+		}
+
+		b.Run(fmt.Sprintf("copy/%d", size), func(b *testing.B) {
+			skipLarge(b, size)
+			for i := 0; i < b.N; i++ {
+				o := copyMap{}
+				for j, k := range keys {
+					o = o.Put(k, NewInt(int64(j)))
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("persistent/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				o := Object{}
+				for j, k := range keys {
+					o = o.Put(k, NewInt(int64(j)))
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkObjectGet(b *testing.B) {
+	for _, size := range collectionSizes {
+		keys := make([]Value, size)
+		m := copyMap{}
+		o := Object{}
+		for j := range keys {
+			keys[j] = NewString(fmt.Sprintf("key%d", j))
+			m[keyOf(keys[j])] = Field{Key: keys[j], Val: NULL}
+			o = o.Put(keys[j], NULL)
+		}
+
+		b.Run(fmt.Sprintf("map/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, k := range keys {
+					_ = m[keyOf(k)]
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("persistent/%d", size), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				for _, k := range keys {
+					o.Get(k)
+				}
+			}
+		})
+	}
+}
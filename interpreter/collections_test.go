@@ -0,0 +1,187 @@
+package interpreter
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func checkList(t *testing.T, l List, want []Value, op string) {
+	t.Helper()
+	if l.Len() != len(want) {
+		t.Fatalf("After %s: expected %d items, received %d", op, len(want), l.Len())
+	}
+	items := l.Items()
+	next := l.iterator()
+	for i, w := range want {
+		if l.Get(i) != w || items[i] != w || next() != w {
+			t.Fatalf("After %s: expected %s at index %d, received %s", op, w.ToString(), i, l.Get(i).ToString())
+		}
+	}
+	if !next().IsZero() {
+		t.Fatalf("After %s: iterated past the end of the list", op)
+	}
+}
+
+func TestList(t *testing.T) {
+	// sizes around the boundaries of leaves and levels of the tree
+	for _, size := range []int{0, 1, 31, 32, 33, 64, 1024, 1056, 1057, 33 * 1024} {
+		items := make([]Value, size)
+		for i := range items {
+			items[i] = NewInt(int64(i))
+		}
+
+		built := List{}
+		for _, item := range items {
+			built = built.Append(item)
+		}
+		checkList(t, built, items, "appending")
+		checkList(t, ListOf(items...), items, "ListOf")
+
+		if size == 0 {
+			continue
+		}
+
+		// versions of a list are unchanged by appending to and updating each other
+		a := built.Append(NewString("a"))
+		b := built.Append(NewString("b"))
+		u := built.Update(size-1, NULL)
+		checkList(t, a, append(append([]Value{}, items...), NewString("a")), "appending a")
+		checkList(t, b, append(append([]Value{}, items...), NewString("b")), "appending b")
+		checkList(t, u, append(append([]Value{}, items[:size-1]...), NULL), "updating")
+		checkList(t, built, items, "appending to and updating copies")
+
+		lo, hi := size/3, 2*size/3
+		s := built.Slice(lo, hi)
+		checkList(t, s, items[lo:hi], "slicing")
+		checkList(t, s.Append(NULL), append(append([]Value{}, items[lo:hi]...), NULL), "appending to a slice")
+		checkList(t, built, items, "appending to a slice")
+		checkList(t, s.Concat(built), append(append([]Value{}, items[lo:hi]...), items...), "concatenating")
+	}
+}
+
+func TestListRandom(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// each step changes a random version of the list, and every version is checked against a
+	// slice that was copied instead
+	lists := []List{{}}
+	models := [][]Value{{}}
+	for step := 0; step < 3000; step++ {
+		i := rng.Intn(len(lists))
+		l, m := lists[i], models[i]
+		switch op := rng.Intn(4); {
+		case op == 0 || len(m) == 0:
+			for n := rng.Intn(40); n >= 0; n-- {
+				v := NewInt(int64(step))
+				l, m = l.Append(v), append(append([]Value{}, m...), v)
+			}
+		case op == 1:
+			j := rng.Intn(len(m))
+			m = append([]Value{}, m...)
+			m[j] = NewString("u")
+			l = l.Update(j, m[j])
+		case op == 2:
+			lo := rng.Intn(len(m))
+			hi := lo + rng.Intn(len(m)-lo+1)
+			l, m = l.Slice(lo, hi), m[lo:hi]
+		case op == 3:
+			j := rng.Intn(len(lists))
+			l, m = l.Concat(lists[j]), append(append([]Value{}, m...), models[j]...)
+		}
+		lists, models = append(lists, l), append(models, m)
+	}
+
+	for i := range lists {
+		checkList(t, lists[i], models[i], "random changes")
+	}
+}
+
+func TestHamt(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	// random changes to an object are checked against a map that was copied instead
+	o := Object{}
+	model := map[int64]int64{}
+	versions := []Object{}
+	models := []map[int64]int64{}
+	for step := 0; step < 5000; step++ {
+		k := rng.Int63n(2000)
+		if rng.Intn(3) == 0 {
+			o = o.Remove(NewInt(k))
+			delete(model, k)
+		} else {
+			o = o.Put(NewInt(k), NewInt(int64(step)))
+			model[k] = int64(step)
+		}
+
+		if step%500 == 0 {
+			cp := map[int64]int64{}
+			for mk, mv := range model {
+				cp[mk] = mv
+			}
+			versions, models = append(versions, o), append(models, cp)
+		}
+	}
+	versions, models = append(versions, o), append(models, model)
+
+	for i, v := range versions {
+		m := models[i]
+		if v.Len() != len(m) || len(v.Fields()) != len(m) {
+			t.Fatalf("Expected %d fields, received %d", len(m), v.Len())
+		}
+		for k := int64(0); k < 2000; k++ {
+			val, ok := v.Get(NewInt(k))
+			want, has := m[k]
+			if ok != has || (ok && val.Int() != want) {
+				t.Fatalf("Expected field %d to be %d (%v), received %s (%v)", k, want, has, val.ToString(), ok)
+			}
+		}
+	}
+}
+
+func TestHamtCollisions(t *testing.T) {
+	// keys whose hashes are the same share a node past the last bits of the hash
+	m := hamt{}
+	keys := []Value{NewInt(1), NewString("a"), NewList(ListOf(NewInt(1)))}
+	for i, k := range keys {
+		f := Field{Key: k, Val: NewInt(int64(i))}
+		root, _ := m.root.put(entry{hash: 42, val: &f}, keyOf(k), 0, nil)
+		m = hamt{root: root, size: m.size + 1}
+	}
+
+	get := func(m hamt, k Value) (Field, bool) {
+		for n := m.root; n != nil; {
+			if len(n.kids) == 0 {
+				for i := range n.entries {
+					if n.entries[i].is(42, keyOf(k)) {
+						return *n.entries[i].val, true
+					}
+				}
+				return Field{}, false
+			}
+			n = n.kids[0]
+		}
+		return Field{}, false
+	}
+
+	for i, k := range keys {
+		if f, ok := get(m, k); !ok || f.Val.Int() != int64(i) {
+			t.Fatalf("Expected %s to be %d, received %s", k.ToString(), i, f.Val.ToString())
+		}
+	}
+
+	root, removed := m.root.remove(keyOf(keys[1]), 42, 0)
+	if !removed {
+		t.Fatalf("Expected %s to be removed", keys[1].ToString())
+	}
+	less := hamt{root: root, size: 2}
+	if _, ok := get(less, keys[1]); ok {
+		t.Fatalf("Expected %s to be removed", keys[1].ToString())
+	}
+	if _, ok := get(less, keys[2]); !ok {
+		t.Fatalf("Expected %s to remain", keys[2].ToString())
+	}
+	if _, ok := get(m, keys[1]); !ok {
+		t.Fatalf("Expected %s to remain in the original", keys[1].ToString())
+	}
+}
@@ -158,9 +158,10 @@ var Star func(Parser, Nodify) Parser = func(p Parser, n Nodify) Parser {
 		}
 
 		return ParseRes{
-			ok:     true,
-			node:   prev.node,
-			tokens: prev.tokens,
+			ok:       true,
+			node:     prev.node,
+			tokens:   prev.tokens,
+			furthest: curr.furthest,
 		}
 	}
 }
@@ -237,8 +238,9 @@ var Peek func(Parser) Parser = func(p Parser) Parser {
 		resA := p(curr, nil)
 		if resA.ok {
 			return ParseRes{
-				ok:     true,
-				tokens: curr.tokens,
+				ok:       true,
+				tokens:   curr.tokens,
+				furthest: curr.furthest,
 			}
 		}
 
@@ -249,3 +251,35 @@ var Peek func(Parser) Parser = func(p Parser) Parser {
 		}
 	}
 }
+
+// Labeled describes what a parser expects in parse errors (e.g. "an expression") in place of the
+// individual tokens its sub-parsers expected at its first token
+var Labeled func(string, Parser) Parser = func(label string, p Parser) Parser {
+	return func(curr ParseRes, _ Nodify) ParseRes {
+		f := curr.furthest
+		if !curr.ok || f == nil {
+			return p(curr, nil)
+		}
+
+		tokens := curr.tokens
+		for len(tokens) > 0 && tokens[0].Type == NewLineTT {
+			tokens = tokens[1:]
+		}
+		if len(tokens) == 0 {
+			return p(curr, nil)
+		}
+
+		found, n := f.found, len(f.expected)
+		res := p(curr, nil)
+		if f.found == nil || f.found.Offset != tokens[0].Offset {
+			return res
+		}
+		if found == nil || found.Offset != tokens[0].Offset {
+			n = 0
+		}
+
+		f.expected = f.expected[:n]
+		curr.expect(tokens, label)
+		return res
+	}
+}
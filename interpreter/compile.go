@@ -0,0 +1,679 @@
+package interpreter
+
+// opcode is an instruction of the bytecode programs are compiled to. Each instruction has up to
+// two operands, a and b.
+type opcode uint8
+
+const (
+	opConst        opcode = iota // push consts[a]
+	opNil                        // push nil, the value of a loop that never ran
+	opLambda                     // push a closure of the lambda nodes[a]
+	opPop                        // pop a value
+	opGetLocal                   // push local a, or the identifier nodes[b] by name if a isn't declared
+	opGetName                    // push the identifier nodes[a], a local of an enclosing function or a name
+	opDeclareLocal               // pop a value, declaring local a with it. nodes[b] is the identifier.
+	opDestructure                // pop a value, declaring the locals of the destructuring target nodes[a]
+	opDeclareName                // pop a value, declaring the target nodes[a] by name. b is 1 for a constant.
+	opSetLocal                   // pop a value, assigning it to local a or to the identifier nodes[b]
+	opSetName                    // pop a value, assigning it to the identifier nodes[a], like opGetName
+	opLoadSlot                   // push local a, even if it's nil
+	opStoreSlot                  // pop a value into local a
+	opClear                      // clear the locals from a up to b, at the start of an iteration
+	opJump                       // jump to a
+	opJumpIfFalse                // pop a value, jumping to a if it's falsy
+	opLoop                       // jump back to a, at the end of an iteration of a loop
+	opBreak                      // jump to a, dropping the values above the first b
+	opGuard                      // replace the value on top with fail and jump to a, unless it passes guard b
+	opUnary                      // apply the unary operator a to the value on top
+	opBinary                     // pop two values, pushing the result of the binary operator a
+	opCall                       // call a function with a arguments. nodes[b] is the call.
+	opTailCall                   // return a call of a function with a arguments, for the caller to make. nodes[b] is the call.
+	opReturn                     // return the value on top, wrapped in a return statement if b is 1
+	opList                       // push an empty list
+	opAppend                     // pop a value, appending it to the list on top
+	opSpreadList                 // pop a collection, appending its items to the list on top
+	opSet                        // push an empty set
+	opAddSet                     // pop a value, adding it to the set on top
+	opSpreadSet                  // pop a collection, adding its items to the set on top
+	opObject                     // push an empty object
+	opAddField                   // pop a key and a value, adding them to the object on top
+	opSpreadObject               // pop an object, copying its fields to the object on top
+	opTuple                      // pop a values into a tuple
+	opTemplate                   // pop a values, joining them into a string
+	opIndex                      // pop an accessor and a value, pushing the item it accesses
+	opField                      // replace the value on top with its field nodes[a]
+	opSlice                      // slice a list or string, popping a start if a&1 and an end if a&2
+	opRange                      // pop the end of a range, and its start if a is 1
+	opCompound                   // pop a lambda and a value, applying the operator a (e.g. map) with the callee nodes[b]
+	opFold                       // pop a starting value if a is 1, a lambda and a value, folding it with the callee nodes[b]
+	opIter                       // pop a collection to iterate over with local a, or push fail and jump to b
+	opNext                       // push the next item of the iteration in local a, or jump to b once there are none
+	opIterIndex                  // push the index of the current item of the iteration in local a
+	opEval                       // interpret the node nodes[a], as described by the flags b
+)
+
+// guards for opGuard, which check the value on the left of a compound expression before its callee
+// is evaluated
+const (
+	guardCollection = iota // a list or set, for map and where
+	guardFoldable          // a list, set or object, for fold
+	guardSliceable         // a list or string, for slices
+	guardNotFail           // anything but fail, for pipe, bind and find
+)
+
+// flags of opEval
+const (
+	evalStmt = 1 << iota // a statement of a function, which may return
+	evalTail             // in tail position, so a call it ends with is returned as a tail call
+)
+
+// instr is an instruction of a Chunk. Line is the line of the node it was compiled from, given to
+// any error it raises.
+type instr struct {
+	op   opcode
+	a, b int32
+	line int32
+}
+
+// Chunk is a program or function body compiled to bytecode, to be run by the VM with Run
+type Chunk struct {
+	code     []instr
+	consts   []Value
+	nodes    []*Node // the nodes instructions refer to, like the calls they were compiled from
+	layout   *layout // the locals found by Resolve, followed by the hidden locals of loops
+	params   []*Node // the parameters of a function
+	maxStack int
+}
+
+// loopInfo is a loop being compiled
+type loopInfo struct {
+	last      int // local holding the value of the last iteration that wasn't stopped
+	sp        int // height of the stack in the body
+	breaks    []int
+	continues []int
+}
+
+type compiler struct {
+	ch    *Chunk
+	loops []*loopInfo
+	fn    bool // compiling a function, rather than a program
+	line  int32
+	sp    int // height of the stack
+}
+
+// Compile compiles a program prepared with Resolve to bytecode. Its top-level declarations are
+// made by name, in the scope it's run in, while those in nested blocks are kept in the slots Resolve
+// gave them. Anything the VM can't run itself (e.g. match expressions) is left to the tree-walking
+// interpreter.
+func Compile(root *Node) *Chunk {
+	if root == nil {
+		c := newCompiler(&layout{index: -1})
+		c.emit(opNil, 0, 0)
+		c.emit(opReturn, 0, 0)
+		return c.ch
+	}
+
+	c := newCompiler(layoutOf(root))
+	c.expr(root)
+	c.emit(opReturn, 0, 0)
+	return c.ch
+}
+
+// newCompiler creates a compiler for a frame with a layout, which is copied so that hidden locals
+// can be added to it
+func newCompiler(l *layout) *compiler {
+	return &compiler{
+		ch: &Chunk{layout: &layout{
+			names:  append([]string{}, l.names...),
+			consts: append([]bool{}, l.consts...),
+			types:  append([]*Type{}, l.types...),
+			index:  l.index,
+		}},
+	}
+}
+
+// compiledFunction gives the compiled body of a lambda, compiling it the first time it's called.
+// It's kept on the lambda's node, so that it's freed along with the program.
+func compiledFunction(lambda *Node) *Chunk {
+	if ch, ok := lambda.proto.Load().(*Chunk); ok {
+		return ch
+	}
+
+	c := newCompiler(layoutOf(lambda))
+	c.fn = true
+	for p := lambda.L; p != nil && (p.Val != nil || p.L != nil); p = p.R {
+		c.ch.params = append(c.ch.params, p)
+	}
+
+	c.expr(lambda.R)
+	c.emit(opReturn, 0, 0)
+	c.tailCalls()
+
+	// tasks may compile the same lambda at once, in which case the first to finish is kept
+	if lambda.proto.CompareAndSwap(nil, c.ch) {
+		return c.ch
+	}
+	return lambda.proto.Load().(*Chunk)
+}
+
+// tailCalls turns the calls of a function whose values are returned straight away, after any
+// jumps, into tail calls, and marks the nodes left to the interpreter whose values are
+func (c *compiler) tailCalls() {
+	code := c.ch.code
+	for i := range code {
+		if code[i].op != opCall && code[i].op != opEval {
+			continue
+		}
+
+		next := i + 1
+		for code[next].op == opJump {
+			next = int(code[next].a)
+		}
+		if code[next].op != opReturn {
+			continue
+		}
+
+		if code[i].op == opCall {
+			code[i].op = opTailCall
+		} else {
+			code[i].b |= evalTail
+		}
+	}
+}
+
+// emit adds an instruction, returning its position
+func (c *compiler) emit(op opcode, a, b int) int {
+	c.ch.code = append(c.ch.code, instr{op: op, a: int32(a), b: int32(b), line: c.line})
+
+	switch op {
+	case opConst, opNil, opLambda, opGetLocal, opGetName, opLoadSlot, opList, opSet, opObject,
+		opNext, opIterIndex, opEval:
+		c.sp++
+	case opPop, opDeclareLocal, opDestructure, opDeclareName, opSetLocal, opSetName, opStoreSlot,
+		opJumpIfFalse, opBinary, opReturn, opAppend, opSpreadList, opAddSet, opSpreadSet,
+		opSpreadObject, opIndex, opCompound, opIter:
+		c.sp--
+	case opAddField:
+		c.sp -= 2
+	case opCall, opTailCall:
+		c.sp -= a
+	case opTuple, opTemplate:
+		c.sp -= a - 1
+	case opSlice:
+		c.sp -= a&1 + a>>1
+	case opRange:
+		c.sp -= a
+	case opFold:
+		c.sp -= 1 + a
+	case opBreak:
+		c.sp = b
+	}
+	if c.sp > c.ch.maxStack {
+		c.ch.maxStack = c.sp
+	}
+
+	return len(c.ch.code) - 1
+}
+
+// patch points the jump at pos to the next instruction
+func (c *compiler) patch(pos int) {
+	in := &c.ch.code[pos]
+	if in.op == opIter || in.op == opNext {
+		in.b = int32(len(c.ch.code))
+	} else {
+		in.a = int32(len(c.ch.code))
+	}
+}
+
+func (c *compiler) addConst(v Value) int {
+	c.ch.consts = append(c.ch.consts, v)
+	return len(c.ch.consts) - 1
+}
+
+func (c *compiler) addNode(n *Node) int {
+	c.ch.nodes = append(c.ch.nodes, n)
+	return len(c.ch.nodes) - 1
+}
+
+func (c *compiler) constant(v Value) {
+	c.emit(opConst, c.addConst(v), 0)
+}
+
+// hidden adds a local that can't be looked up by name, for the state of a loop
+func (c *compiler) hidden() int {
+	l := c.ch.layout
+	l.names = append(l.names, "")
+	l.consts = append(l.consts, false)
+	l.types = append(l.types, nil)
+	return len(l.names) - 1
+}
+
+// stmts compiles a chain of statements, leaving the value of the last one
+func (c *compiler) stmts(root *Node) {
+	for n := root; n != nil; n = n.R {
+		last := n.R == nil
+		switch {
+		case n.L == nil:
+			if last {
+				c.emit(opNil, 0, 0)
+			}
+		case n.L.Type == StmtNT:
+			c.stmts(n.L)
+			if !last {
+				c.emit(opPop, 0, 0)
+			}
+		case c.interpreted(n.L):
+			c.fallback(n.L, true)
+			if !last {
+				c.emit(opPop, 0, 0)
+			}
+		case !last && (n.L.Type == ConstDeclNT || n.L.Type == VarDeclNT):
+			c.declaration(n.L)
+		case !last && n.L.Type == AssignmentNT:
+			c.assignment(n.L)
+		default:
+			c.expr(n.L)
+			if !last {
+				c.emit(opPop, 0, 0)
+			}
+		}
+	}
+}
+
+// interpreted reports whether a node is left to the tree-walking interpreter
+func (c *compiler) interpreted(n *Node) bool {
+	switch n.Type {
+	case MatchNT, ComprehensionNT, SpawnNT, AwaitNT, PMapNT, PWhereNT, ImportNT:
+		return true
+	case WhileStmtNT, ForStmtNT:
+		// loops whose body is a frame of its own each iteration
+		return n.res != nil && n.res.layout != nil
+	case AssignmentNT:
+		// assignments to list items and object fields
+		return n.L.Type != IdentifierNT || n.L.L != nil
+	case AddNT, SubtNT, DivNT, MultNT, ModuloNT, LogicAndNT, LogicOrNT, FallbackNT, EqualNT,
+		NotEqualNT, LessEqualNT, GreaterEqualNT, LessNT, GreaterNT:
+		// missing operands raise an error
+		return n.L == nil || n.R == nil
+	case LogicNotNT, MaybeNT, CardinalityNT, UnaryNegNT:
+		return n.R == nil
+	}
+	return false
+}
+
+// fallback compiles a node to be interpreted by the tree-walking interpreter
+func (c *compiler) fallback(n *Node, stmt bool) {
+	b := 0
+	if stmt && c.fn {
+		b = evalStmt
+	}
+	c.emit(opEval, c.addNode(n), b)
+}
+
+// expr compiles a node, leaving its value on the stack
+func (c *compiler) expr(n *Node) {
+	prevLine := c.line
+	c.line = int32(nodeLine(n))
+	defer func() { c.line = prevLine }()
+
+	if c.interpreted(n) {
+		c.fallback(n, false)
+		return
+	}
+
+	switch n.Type {
+	case StmtNT:
+		c.stmts(n)
+
+	// operators
+	case AddNT, SubtNT, DivNT, MultNT, ModuloNT, PowerNT, LogicAndNT, LogicOrNT, FallbackNT,
+		EqualNT, NotEqualNT, LessEqualNT, GreaterEqualNT, LessNT, GreaterNT, InNT:
+		c.expr(n.L)
+		c.expr(n.R)
+		c.emit(opBinary, int(n.Type), 0)
+	case LogicNotNT, MaybeNT, CardinalityNT, UnaryNegNT:
+		c.expr(n.R)
+		c.emit(opUnary, int(n.Type), 0)
+
+	// identifiers
+	case IdentifierNT, UnderscoreNT, IndexNT:
+		if n.res != nil && n.res.depth == 0 {
+			c.emit(opGetLocal, n.res.slot, c.addNode(n))
+		} else {
+			c.emit(opGetName, c.addNode(n), 0)
+		}
+
+	// literals
+	case IntNT, FloatNT, BoolNT, StringNT, FailNT, SuccessNT, NullNT:
+		c.constant(literal(n))
+	case LambdaNT:
+		c.emit(opLambda, c.addNode(n), 0)
+	case ObjectNT:
+		c.emit(opObject, 0, 0)
+	case ListNT:
+		c.emit(opList, 0, 0)
+		for _, m := range n.Val.([]*Node) {
+			if spread(m) {
+				c.spread(m)
+				c.emit(opSpreadList, 0, 0)
+			} else {
+				c.expr(m)
+				c.emit(opAppend, 0, 0)
+			}
+		}
+	case TupleNT:
+		for _, m := range n.Val.([]*Node) {
+			c.expr(m)
+		}
+		c.emit(opTuple, len(n.Val.([]*Node)), 0)
+	case SetItemNT:
+		c.emit(opSet, 0, 0)
+		for curr := n; curr != nil; curr = curr.R {
+			if spread(curr.L) {
+				c.spread(curr.L)
+				c.emit(opSpreadSet, 0, 0)
+			} else {
+				c.expr(curr.L)
+				c.emit(opAddSet, 0, 0)
+			}
+		}
+	case ObjectItemNT:
+		c.emit(opObject, 0, 0)
+		for curr := n; curr != nil; curr = curr.R {
+			switch node := curr.L; node.Type {
+			case KVPairNT:
+				if node.L.Type == IdentifierNT {
+					c.constant(nodeKey(node.L))
+				} else {
+					c.expr(node.L)
+				}
+				c.expr(node.R)
+				c.emit(opAddField, 0, 0)
+			case SplatNT:
+				c.expr(node.R)
+				c.emit(opSpreadObject, 0, 0)
+			}
+		}
+	case TemplateNT:
+		count := 0
+		for curr := n; curr != nil; curr = curr.R {
+			c.expr(curr.L)
+			count++
+		}
+		c.emit(opTemplate, count, 0)
+
+	// statements
+	case ConstDeclNT, VarDeclNT:
+		c.declaration(n)
+		c.constant(SUCCESS)
+	case AssignmentNT:
+		c.assignment(n)
+		c.constant(SUCCESS)
+	case IfNT:
+		c.ifExpr(n)
+	case WhileStmtNT:
+		c.while(n)
+	case ForStmtNT:
+		c.forLoop(n)
+	case BreakNT, ContinueNT:
+		c.jump(n)
+	case ReturnStmtNT:
+		sp := c.sp
+		c.expr(n.R)
+		wrap := 0
+		if !c.fn {
+			wrap = 1
+		}
+		c.emit(opReturn, 0, wrap)
+		// the rest of the block is unreachable, but is compiled as if return left a value
+		c.sp = sp + 1
+
+	// calls and compound expressions
+	case CallNT:
+		c.expr(n.L)
+		argc := 0
+		for arg := n.R; arg != nil && arg.L != nil; arg = arg.R {
+			c.expr(arg.L)
+			argc++
+		}
+		c.emit(opCall, argc, c.addNode(n))
+	case MapNT, WhereNT:
+		c.compound(n, guardCollection)
+	case PipeNT, BindNT, FindNT:
+		c.compound(n, guardNotFail)
+	case FoldNT:
+		c.expr(n.L)
+		guard := c.emit(opGuard, 0, guardFoldable)
+		c.expr(n.R)
+		hasAcc := 0
+		if n.Val != nil {
+			c.expr(n.Val.(*Node))
+			hasAcc = 1
+		}
+		c.emit(opFold, hasAcc, c.addNode(n.R))
+		c.patch(guard)
+
+	// access
+	case BracketAccessNT:
+		c.expr(n.L)
+		c.expr(n.R)
+		c.emit(opIndex, 0, 0)
+	case FieldAccessNT:
+		c.expr(n.L)
+		c.emit(opField, c.addNode(n.R), 0)
+	case ListSliceNT:
+		c.expr(n.L)
+		guard := c.emit(opGuard, 0, guardSliceable)
+		bounds := 0
+		if n.R.L != nil {
+			c.expr(n.R.L)
+			bounds |= 1
+		}
+		if n.R.R != nil {
+			c.expr(n.R.R)
+			bounds |= 2
+		}
+		c.emit(opSlice, bounds, 0)
+		c.patch(guard)
+	case RangeNT:
+		hasStart := 0
+		if n.L != nil {
+			c.expr(n.L)
+			hasStart = 1
+		}
+		c.expr(n.R)
+		c.emit(opRange, hasStart, 0)
+
+	default:
+		c.fallback(n, false)
+	}
+}
+
+// spread reports whether an item of a list or set literal adds all of its items
+func spread(n *Node) bool {
+	switch n.Type {
+	case SplatNT, RangeNT, MapNT, WhereNT, PMapNT, PWhereNT:
+		return true
+	}
+	return false
+}
+
+// spread compiles the collection spread into a list or set
+func (c *compiler) spread(n *Node) {
+	if n.Type == SplatNT {
+		c.expr(n.R)
+	} else {
+		c.expr(n)
+	}
+}
+
+// compound compiles an expression that calls the function on its right with the value on its
+// left, like map. The function isn't evaluated if the value doesn't pass the guard.
+func (c *compiler) compound(n *Node, guard int) {
+	c.expr(n.L)
+	pos := c.emit(opGuard, 0, guard)
+	c.expr(n.R)
+	c.emit(opCompound, int(n.Type), c.addNode(n.R))
+	c.patch(pos)
+}
+
+// declaration compiles a declaration, which leaves no value
+func (c *compiler) declaration(n *Node) {
+	c.expr(n.R)
+	c.declare(n.L, n.Type == ConstDeclNT)
+}
+
+// declare declares the target of a declaration with the value on top. Top-level declarations of a
+// program aren't locals, and are made by name.
+func (c *compiler) declare(target *Node, constant bool) {
+	idents := targetIdents(target)
+	switch {
+	case len(idents) == 0 || idents[0].res == nil:
+		// raises an error for an invalid target
+		flag := 0
+		if constant {
+			flag = 1
+		}
+		c.emit(opDeclareName, c.addNode(target), flag)
+	case target.Type == IdentifierNT:
+		c.emit(opDeclareLocal, target.res.slot, c.addNode(target))
+	default:
+		c.emit(opDestructure, c.addNode(target), 0)
+	}
+}
+
+// assignment compiles an assignment to an identifier, which leaves no value
+func (c *compiler) assignment(n *Node) {
+	c.expr(n.R)
+	if n.L.res != nil && n.L.res.depth == 0 {
+		c.emit(opSetLocal, n.L.res.slot, c.addNode(n.L))
+	} else {
+		c.emit(opSetName, c.addNode(n.L), 0)
+	}
+}
+
+// inline compiles the body of an if statement, which shares the enclosing block
+func (c *compiler) inline(n *Node) {
+	if n.Type == StmtNT {
+		c.stmts(n)
+	} else {
+		c.expr(n)
+	}
+}
+
+func (c *compiler) ifExpr(n *Node) {
+	c.expr(n.L)
+	skip := c.emit(opJumpIfFalse, 0, 0)
+
+	result := n.R
+	if result.Type == ThenBranchNT {
+		c.inline(result.L)
+		end := c.emit(opJump, 0, 0)
+		c.sp--
+		c.patch(skip)
+		c.inline(result.R)
+		c.patch(end)
+		return
+	}
+
+	// without an else branch, the if statement fails
+	c.inline(result)
+	end := c.emit(opJump, 0, 0)
+	c.sp--
+	c.patch(skip)
+	c.constant(FAIL)
+	c.patch(end)
+}
+
+// body compiles the body of a loop. Its value is the value of the last statement run, or of the
+// break or continue that ended the iteration.
+func (c *compiler) body(n *Node, l *loopInfo) {
+	c.stmts(n.R)
+	c.emit(opStoreSlot, l.last, 0)
+	for _, pos := range l.continues {
+		c.patch(pos)
+	}
+}
+
+// clear clears the locals of a loop's body, which is a block of its own each iteration
+func (c *compiler) clear(n *Node) {
+	if n.res.start < n.res.end {
+		c.emit(opClear, n.res.start, n.res.end)
+	}
+}
+
+func (c *compiler) while(n *Node) {
+	l := &loopInfo{last: c.hidden()}
+	c.emit(opClear, l.last, l.last+1)
+
+	top := len(c.ch.code)
+	c.expr(n.L)
+	exit := c.emit(opJumpIfFalse, 0, 0)
+	c.clear(n)
+
+	c.loops = append(c.loops, l)
+	l.sp = c.sp
+	c.body(n, l)
+	c.loops = c.loops[:len(c.loops)-1]
+	c.emit(opLoop, top, 0)
+
+	c.patch(exit)
+	c.end(l)
+}
+
+func (c *compiler) forLoop(n *Node) {
+	iterator, iteratee := n.L.L, n.L.R
+	c.expr(iteratee)
+
+	iter := c.hidden()
+	l := &loopInfo{last: c.hidden()}
+	c.emit(opClear, l.last, l.last+1)
+	notIterable := c.emit(opIter, iter, 0)
+
+	top := len(c.ch.code)
+	exit := c.emit(opNext, iter, 0)
+	c.clear(n)
+	// for x <- xs, for (k, v) <- pairs, for [a, b] <- rows, ...
+	c.declare(iterator, true)
+	c.emit(opIterIndex, iter, 0)
+	c.emit(opStoreSlot, n.res.slot, 0)
+
+	c.loops = append(c.loops, l)
+	l.sp = c.sp
+	c.body(n, l)
+	c.loops = c.loops[:len(c.loops)-1]
+	c.emit(opLoop, top, 0)
+
+	c.patch(exit)
+	c.end(l)
+	c.patch(notIterable)
+	c.emit(opClear, iter, iter+1)
+}
+
+// end finishes a loop once it's done or broken out of, leaving its value
+func (c *compiler) end(l *loopInfo) {
+	for _, pos := range l.breaks {
+		c.patch(pos)
+	}
+	c.emit(opLoadSlot, l.last, 0)
+}
+
+// jump compiles a break or continue. Outside of a loop, they're just values.
+func (c *compiler) jump(n *Node) {
+	sp := c.sp
+	c.constant(literal(n))
+	if len(c.loops) == 0 {
+		return
+	}
+
+	// the loop keeps the value of its last iteration that wasn't stopped
+	l := c.loops[len(c.loops)-1]
+	pos := c.emit(opBreak, 0, l.sp)
+	if n.Type == BreakNT {
+		l.breaks = append(l.breaks, pos)
+	} else {
+		l.continues = append(l.continues, pos)
+	}
+	// the rest of the block is unreachable, but is compiled as if the jump left a value
+	c.sp = sp + 1
+}
@@ -0,0 +1,264 @@
+package interpreter
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// DefaultMaxDepth is the most function calls that may be nested, unless an environment is given
+// another maximum with SetMaxDepth. Beyond it a program stops with a *StackOverflowError before it
+// can overflow the Go stack. Tail calls don't nest.
+const DefaultMaxDepth = 10000
+
+// StackOverflowError is raised when function calls are nested deeper than the maximum depth
+type StackOverflowError struct {
+	Depth int
+}
+
+func (e *StackOverflowError) Error() string {
+	return fmt.Sprintf("Stack overflow: more than %d nested function calls", e.Depth)
+}
+
+// ParseError is a syntax error at the furthest token the parser was able to reach
+type ParseError struct {
+	Line     int
+	Column   int
+	Offset   int
+	Expected []string // what could have come next, e.g. `")"` or "an expression"
+	Found    string   // the token found instead
+	Msg      string   // a more specific message, in place of "expected X, found Y"
+}
+
+func (e *ParseError) Error() string {
+	if e.Msg != "" {
+		return fmt.Sprintf("Line %d, column %d: %s", e.Line, e.Column, e.Msg)
+	}
+	return fmt.Sprintf("Line %d, column %d: Expected %s, found %s", e.Line, e.Column, orList(e.Expected), e.Found)
+}
+
+// Render shows the error with the line of source it occurred on and a caret under its column
+func (e *ParseError) Render(src string) string {
+	lines := strings.Split(src, "\n")
+	if e.Line < 1 || e.Line > len(lines) {
+		return e.Error()
+	}
+
+	line := strings.TrimRight(lines[e.Line-1], "\r")
+	if strings.TrimSpace(line) == "" && e.Line > 1 && e.Found == "end of file" {
+		// point just past the end of the last line, rather than at an empty one
+		prev := *e
+		prev.Line--
+		line = strings.TrimRight(lines[prev.Line-1], "\r")
+		prev.Column = len([]rune(line)) + 1
+		e = &prev
+	}
+
+	return e.Error() + showColumn(src, e.Line, e.Column)
+}
+
+// showColumn shows a line of source with a caret under one of its columns
+func showColumn(src string, line, column int) string {
+	lines := strings.Split(src, "\n")
+	if line < 1 || line > len(lines) {
+		return ""
+	}
+
+	text := strings.TrimRight(lines[line-1], "\r")
+	caret := []rune{}
+	for i, r := range []rune(text) {
+		if i >= column-1 {
+			break
+		}
+		// keep tabs so the caret lines up
+		if r == '\t' {
+			caret = append(caret, '\t')
+		} else {
+			caret = append(caret, ' ')
+		}
+	}
+
+	return fmt.Sprintf("\n    %s\n    %s^", text, string(caret))
+}
+
+// orList joins alternatives, e.g. `"a", "b" or "c"`
+func orList(items []string) string {
+	switch len(items) {
+	case 0:
+		return "nothing"
+	case 1:
+		return items[0]
+	}
+	return strings.Join(items[:len(items)-1], ", ") + " or " + items[len(items)-1]
+}
+
+// RuntimeError is an error raised while running a program. Errors from Go code called by the
+// interpreter (e.g. the StdLib) are wrapped, and can be retrieved with errors.Unwrap.
+type RuntimeError struct {
+	Line  int     // line of the node that failed, or 0 if unknown
+	Err   error   // the underlying error
+	Stack []Frame // calls in progress when the error occurred, innermost first
+}
+
+// Frame is a call to a Rye function in progress when a RuntimeError occurred
+type Frame struct {
+	Function string // name of the function, or "<lambda>" for anonymous functions
+	Line     int    // line the function was called from
+	// Elided stands in for tail calls whose frames are gone, the last of which was made by Function
+	Elided bool
+}
+
+func (e *RuntimeError) Error() string {
+	if e.Line != 0 {
+		return fmt.Sprintf("Line %d: %s", e.Line, e.Err.Error())
+	}
+	return e.Err.Error()
+}
+
+func (e *RuntimeError) Unwrap() error {
+	return e.Err
+}
+
+// Traceback formats the error with the calls leading to it, most recent call last
+func (e *RuntimeError) Traceback() string {
+	b := strings.Builder{}
+	b.WriteString("Traceback (most recent call last):\n")
+
+	fn, prev, repeated := "<main>", "", 0
+	line := func(s string) {
+		// collapse deep recursion
+		if s == prev {
+			repeated++
+			return
+		}
+		if repeated > 0 {
+			fmt.Fprintf(&b, "  [Previous line repeated %d more times]\n", repeated)
+		}
+		b.WriteString(s)
+		prev, repeated = s, 0
+	}
+
+	for i := len(e.Stack) - 1; i >= 0; i-- {
+		if e.Stack[i].Elided {
+			line("  [Tail calls elided]\n")
+		} else {
+			line(fmt.Sprintf("  Line %d, in %s\n", e.Stack[i].Line, fn))
+		}
+		fn = e.Stack[i].Function
+	}
+	if e.Line != 0 {
+		line(fmt.Sprintf("  Line %d, in %s\n", e.Line, fn))
+	} else {
+		line(fmt.Sprintf("  in %s\n", fn))
+	}
+	line("")
+
+	b.WriteString("Error: " + e.Err.Error())
+	return b.String()
+}
+
+func runtimeErrorf(line int, format string, args ...interface{}) error {
+	return &RuntimeError{
+		Line: line,
+		Err:  fmt.Errorf(format, args...),
+	}
+}
+
+// locate gives an error the line of the node that caused it, unless it already has one
+func locate(err error, n *Node) error {
+	var re *RuntimeError
+	if errors.As(err, &re) {
+		if re.Line == 0 && len(re.Stack) == 0 {
+			re.Line = nodeLine(n)
+		}
+		return err
+	}
+
+	return locateLine(err, nodeLine(n))
+}
+
+// locateLine gives an error a line, unless it already has one
+func locateLine(err error, line int) error {
+	var re *RuntimeError
+	if errors.As(err, &re) {
+		if re.Line == 0 && len(re.Stack) == 0 {
+			re.Line = line
+		}
+		return err
+	}
+
+	return &RuntimeError{
+		Line: line,
+		Err:  err,
+	}
+}
+
+// pushFrame adds a call to the stack of an error raised inside it
+func pushFrame(err error, function string, line int) error {
+	var re *RuntimeError
+	if !errors.As(err, &re) {
+		re = &RuntimeError{Err: err}
+		err = re
+	}
+
+	re.Stack = append(re.Stack, Frame{Function: function, Line: line})
+	return err
+}
+
+// pushElided adds a stand-in to the stack of an error for tail calls whose frames are gone, the
+// last of which was made by function
+func pushElided(err error, function string) error {
+	err = pushFrame(err, function, 0)
+	var re *RuntimeError
+	errors.As(err, &re)
+	re.Stack[len(re.Stack)-1].Elided = true
+	return err
+}
+
+// ScanError is an unexpected character or unterminated string found while scanning
+type ScanError struct {
+	Line         int
+	Column       int
+	Char         rune // the unexpected character
+	Unterminated bool // an unterminated string, starting at Line and Column
+}
+
+func (e *ScanError) Error() string {
+	if e.Unterminated {
+		return fmt.Sprintf("Line %d, column %d: Unterminated string", e.Line, e.Column)
+	}
+	return fmt.Sprintf("Line %d, column %d: Unexpected character %q", e.Line, e.Column, e.Char)
+}
+
+// Render shows the error with the line of source it occurred on and a caret under its column
+func (e *ScanError) Render(src string) string {
+	return e.Error() + showColumn(src, e.Line, e.Column)
+}
+
+// ScanErrors are all of the errors found while scanning a source
+type ScanErrors []*ScanError
+
+func (es ScanErrors) Error() string {
+	msgs := []string{}
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (es ScanErrors) Unwrap() []error {
+	errs := []error{}
+	for _, e := range es {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// Render shows each error with the line of source it occurred on
+func (es ScanErrors) Render(src string) string {
+	msgs := []string{}
+	for _, e := range es {
+		msgs = append(msgs, e.Render(src))
+	}
+	return strings.Join(msgs, "\n")
+}
@@ -2,12 +2,42 @@ package interpreter
 
 import (
 	"fmt"
+	"strings"
+	"unicode/utf8"
 )
 
-func Interpret(n *Node, env *Environment) (*Node, error) {
+// Interpret evaluates a node. A program must have been prepared with Resolve, and runs in a frame
+// of its own inside env, where its top-level declarations are made. Errors are returned as a
+// *RuntimeError, with the line of the innermost node that failed.
+func Interpret(n *Node, env *Environment) (Value, error) {
+	s := env.limits()
+	if s != nil {
+		if err := s.step(); err != nil {
+			return Value{}, locate(err, n)
+		}
+	}
+
+	res, err := interpret(n, env)
+	if err != nil {
+		return res, locate(err, n)
+	}
+
+	if s != nil {
+		if err := s.checkSize(res); err != nil {
+			return Value{}, locate(err, n)
+		}
+	}
+	return res, nil
+}
+
+func interpret(n *Node, env *Environment) (Value, error) {
 	// fmt.Printf("Interpret: \n%s\n\n", n.ToString())
 	switch n.Type {
 	case StmtNT:
+		if n.res != nil {
+			// a resolved program
+			return interpretStmt(n, newFrame(env, env, n.res.layout, 0))
+		}
 		return interpretStmt(n, env)
 	// binary operations
 	case AddNT, SubtNT, DivNT, MultNT, ModuloNT:
@@ -28,19 +58,26 @@ func Interpret(n *Node, env *Environment) (*Node, error) {
 	case IdentifierNT, UnderscoreNT, IndexNT:
 		return resolveIdentifier(n, env)
 	// literals
-	case IntNT, FloatNT, BoolNT, StringNT, FailNT, SuccessNT, NullNT, SetNT:
-		return copyNode(n), nil
+	case IntNT, FloatNT, BoolNT, StringNT, FailNT, SuccessNT, NullNT:
+		return literal(n), nil
 	case LambdaNT:
-		return copyNode(n), nil
+		// a closure of the scope it's created in
+		return newLambda(n, env), nil
 	case ObjectNT:
-		if n.Val == nil {
-			return newObject(Object{}), nil
-		}
-		return n, nil
-	case ModuleNT:
-		return n, nil
+		// an empty object
+		return NewObject(Object{}), nil
+	case SpawnNT:
+		return interpretSpawn(n, env)
+	case AwaitNT:
+		return interpretAwait(n, env)
 	case ListNT:
 		return interpretList(n, env)
+	case TupleNT:
+		return interpretTuple(n, env)
+	case TemplateNT:
+		return interpretTemplate(n, env)
+	case ComprehensionNT:
+		return interpretComprehension(n, env)
 	case ObjectItemNT:
 		return interpretObjectItem(n, env)
 	case SetItemNT:
@@ -51,19 +88,32 @@ func Interpret(n *Node, env *Environment) (*Node, error) {
 		return assignVar(n, env)
 	case IfNT:
 		return interpretIf(n, env)
+	case MatchNT:
+		return interpretMatch(n, env)
 	case CallNT:
 		return interpretCall(n, env)
 	case ReturnStmtNT:
 		returnVal, err := Interpret(n.R, env)
-		return &Node{Type: ReturnStmtNT, R: returnVal}, err
+		if err != nil {
+			return Value{}, err
+		}
+		return newReturn(returnVal), nil
 	case MapNT:
 		return interpretMap(n, env)
 	case WhereNT:
 		return interpretWhere(n, env)
+	case PMapNT:
+		return interpretPMap(n, env)
+	case PWhereNT:
+		return interpretPWhere(n, env)
 	case PipeNT:
 		return interpretPipe(n, env)
+	case BindNT:
+		return interpretBind(n, env)
 	case FindNT:
 		return interpretFind(n, env)
+	case FoldNT:
+		return interpretFold(n, env)
 	case BracketAccessNT:
 		return interpretBracketAccess(n, env)
 	case FieldAccessNT:
@@ -71,7 +121,7 @@ func Interpret(n *Node, env *Environment) (*Node, error) {
 	case ListSliceNT:
 		return interpretListSlice(n, env)
 	case BreakNT, ContinueNT:
-		return n, nil
+		return literal(n), nil
 	case WhileStmtNT:
 		return interpretWhile(n, env)
 	case ForStmtNT:
@@ -82,18 +132,12 @@ func Interpret(n *Node, env *Environment) (*Node, error) {
 		return importModule(n, env)
 	}
 
-	return nil, fmt.Errorf("Unknown node type")
+	return Value{}, fmt.Errorf("Unknown node type")
 }
 
-func interpretStmt(root *Node, env *Environment) (res *Node, err error) {
+func interpretStmt(root *Node, env *Environment) (res Value, err error) {
 	for n := root; n != nil; n = n.R {
-		if n.L != nil && n.L.Type == StmtNT {
-			res, err = Interpret(n.L, newScope(env))
-		} else {
-			res, err = Interpret(n.L, env)
-		}
-
-		if err != nil {
+		if res, err = Interpret(n.L, env); err != nil {
 			break
 		}
 	}
@@ -101,36 +145,41 @@ func interpretStmt(root *Node, env *Environment) (res *Node, err error) {
 	return res, err
 }
 
-func interpretMathOp(n *Node, env *Environment) (res *Node, err error) {
+func interpretMathOp(n *Node, env *Environment) (res Value, err error) {
 	if n.L == nil {
-		return nil, fmt.Errorf("Missing first argument for operation \"%s\"", nodeTypeMap[n.Type])
+		return Value{}, fmt.Errorf("Missing first argument for operation \"%s\"", nodeTypeMap[n.Type])
 	}
 	if n.R == nil {
-		return nil, fmt.Errorf("Missing second argument for operation \"%s\"", nodeTypeMap[n.Type])
+		return Value{}, fmt.Errorf("Missing second argument for operation \"%s\"", nodeTypeMap[n.Type])
 	}
 
 	lhs, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 	rhs, err := Interpret(n.R, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
+	return mathOp(n.Type, lhs, rhs)
+}
+
+// mathOp applies an arithmetic operator to two values
+func mathOp(op NodeType, lhs, rhs Value) (Value, error) {
 	l, r, t := maybeCastNumbers(lhs, rhs)
-	switch n.Type {
+	switch op {
 	case AddNT:
 		{
 			switch t {
-			case IntNT:
-				return newInt(l.Val.(int64) + r.Val.(int64)), nil
-			case FloatNT:
-				return newFloat(l.Val.(float64) + r.Val.(float64)), nil
-			case StringNT:
-				return newString(l.Val.(string) + r.Val.(string)), nil
-			case ListNT:
-				return newList(append(l.Val.(List), r.Val.(List)...)), nil
+			case IntDT:
+				return NewInt(l.Int() + r.Int()), nil
+			case FloatDT:
+				return NewFloat(l.Float() + r.Float()), nil
+			case StringDT:
+				return NewString(l.Str() + r.Str()), nil
+			case ListDT:
+				return NewList(l.List().Concat(r.List())), nil
 			default:
 				return FAIL, nil
 			}
@@ -138,10 +187,10 @@ func interpretMathOp(n *Node, env *Environment) (res *Node, err error) {
 	case SubtNT:
 		{
 			switch t {
-			case IntNT:
-				return newInt(l.Val.(int64) - r.Val.(int64)), nil
-			case FloatNT:
-				return newFloat(l.Val.(float64) - r.Val.(float64)), nil
+			case IntDT:
+				return NewInt(l.Int() - r.Int()), nil
+			case FloatDT:
+				return NewFloat(l.Float() - r.Float()), nil
 			default:
 				return FAIL, nil
 			}
@@ -149,16 +198,16 @@ func interpretMathOp(n *Node, env *Environment) (res *Node, err error) {
 	case DivNT:
 		{
 			switch t {
-			case IntNT:
-				if r.Val.(int64) == 0 {
+			case IntDT:
+				if r.Int() == 0 {
 					return FAIL, nil
 				}
-				return newFloat(float64(l.Val.(int64)) / float64(r.Val.(int64))), nil
-			case FloatNT:
-				if r.Val.(float64) == 0 {
+				return NewFloat(float64(l.Int()) / float64(r.Int())), nil
+			case FloatDT:
+				if r.Float() == 0 {
 					return FAIL, nil
 				}
-				return newFloat(l.Val.(float64) / r.Val.(float64)), nil
+				return NewFloat(l.Float() / r.Float()), nil
 			default:
 				return FAIL, nil
 			}
@@ -166,10 +215,10 @@ func interpretMathOp(n *Node, env *Environment) (res *Node, err error) {
 	case MultNT:
 		{
 			switch t {
-			case IntNT:
-				return newInt(l.Val.(int64) * r.Val.(int64)), nil
-			case FloatNT:
-				return newFloat(l.Val.(float64) * r.Val.(float64)), nil
+			case IntDT:
+				return NewInt(l.Int() * r.Int()), nil
+			case FloatDT:
+				return NewFloat(l.Float() * r.Float()), nil
 			default:
 				return FAIL, nil
 			}
@@ -177,84 +226,94 @@ func interpretMathOp(n *Node, env *Environment) (res *Node, err error) {
 	case ModuloNT:
 		{
 			switch t {
-			case IntNT:
-				if r.Val.(int64) == 0 {
+			case IntDT:
+				if r.Int() == 0 {
 					return FAIL, nil
 				}
-				return newInt(l.Val.(int64) % r.Val.(int64)), nil
+				return NewInt(l.Int() % r.Int()), nil
 			default:
 				return FAIL, nil
 			}
 		}
 	}
 
-	return nil, fmt.Errorf("Unknown binary operator")
+	return Value{}, fmt.Errorf("Unknown binary operator")
 }
 
-func interpretPower(n *Node, env *Environment) (res *Node, err error) {
+func interpretPower(n *Node, env *Environment) (res Value, err error) {
 	lhs, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 	rhs, err := Interpret(n.R, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	if rhs.Type != IntNT {
-		return FAIL, nil
+	return power(lhs, rhs), nil
+}
+
+// power raises a number to an integer power
+func power(lhs, rhs Value) Value {
+	if rhs.Type != IntDT {
+		return FAIL
 	}
 
-	if lhs.Type == FloatNT {
+	if lhs.Type == FloatDT {
 		var total float64 = 1
 		var i int64 = 0
-		x := rhs.Val.(int64)
-		if rhs.Val.(int64) < 0 {
+		x := rhs.Int()
+		if rhs.Int() < 0 {
 			x = -x
 		}
 		for ; i < x; i++ {
-			total *= lhs.Val.(float64)
+			total *= lhs.Float()
 		}
 
-		return newFloat(total), nil
-	} else if lhs.Type == IntNT {
+		return NewFloat(total)
+	} else if lhs.Type == IntDT {
 		var total int64 = 1
 		var i int64 = 0
-		x := rhs.Val.(int64)
-		if rhs.Val.(int64) < 0 {
+		x := rhs.Int()
+		if rhs.Int() < 0 {
 			x = -x
 		}
 		for ; i < x; i++ {
-			total *= lhs.Val.(int64)
+			total *= lhs.Int()
 		}
 
-		if rhs.Val.(int64) < 0 {
-			return newFloat(1 / float64(total)), nil
+		if rhs.Int() < 0 {
+			return NewFloat(1 / float64(total))
 		}
-		return newInt(total), nil
+		return NewInt(total)
 	}
 
-	return FAIL, nil
+	return FAIL
 }
 
-func interpretLogicOp(n *Node, env *Environment) (res *Node, err error) {
+func interpretLogicOp(n *Node, env *Environment) (res Value, err error) {
 	if n.L == nil {
-		return nil, fmt.Errorf("Missing first argument for operation \"%s\"", nodeTypeMap[n.Type])
+		return Value{}, fmt.Errorf("Missing first argument for operation \"%s\"", nodeTypeMap[n.Type])
 	}
 	if n.R == nil {
-		return nil, fmt.Errorf("Missing second argument for operation \"%s\"", nodeTypeMap[n.Type])
+		return Value{}, fmt.Errorf("Missing second argument for operation \"%s\"", nodeTypeMap[n.Type])
 	}
 
 	lhs, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 	rhs, err := Interpret(n.R, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	switch n.Type {
+	return logicOp(n.Type, lhs, rhs)
+}
+
+// logicOp applies a logical operator to two values. Both sides are always evaluated.
+func logicOp(op NodeType, lhs, rhs Value) (Value, error) {
+	switch op {
 	case LogicAndNT:
 		// should and/or always return bool?
 		if isTruthy(lhs) {
@@ -267,127 +326,140 @@ func interpretLogicOp(n *Node, env *Environment) (res *Node, err error) {
 		}
 		return rhs, nil
 	case FallbackNT:
-		if lhs.Type == FailNT {
+		if lhs.Type == FailDT {
 			return rhs, nil
 		}
 		return lhs, nil
 	}
 
-	return nil, fmt.Errorf("Unknown logical operator")
+	return Value{}, fmt.Errorf("Unknown logical operator")
 }
 
-func interpretComparison(n *Node, env *Environment) (res *Node, err error) {
+func interpretComparison(n *Node, env *Environment) (res Value, err error) {
 	if n.L == nil {
-		return nil, fmt.Errorf("Missing first argument for operation \"%s\"", nodeTypeMap[n.Type])
+		return Value{}, fmt.Errorf("Missing first argument for operation \"%s\"", nodeTypeMap[n.Type])
 	}
 	if n.R == nil {
-		return nil, fmt.Errorf("Missing second argument for operation \"%s\"", nodeTypeMap[n.Type])
+		return Value{}, fmt.Errorf("Missing second argument for operation \"%s\"", nodeTypeMap[n.Type])
 	}
 
 	lhs, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 	rhs, err := Interpret(n.R, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
+	return compare(n.Type, lhs, rhs), nil
+}
+
+// compare applies a comparison operator to two values, failing if they can't be compared
+func compare(op NodeType, lhs, rhs Value) Value {
 	// ==, !=
-	switch n.Type {
+	switch op {
 	case EqualNT:
 		equal, err := evalEquality(lhs, rhs)
 		if err != nil {
-			return FAIL, nil
+			return FAIL
 		}
-		return newBool(equal), nil
+		return NewBool(equal)
 	case NotEqualNT:
 		equal, err := evalEquality(lhs, rhs)
 		if err != nil {
-			return FAIL, nil
+			return FAIL
 		}
-		return newBool(!equal), nil
+		return NewBool(!equal)
 	}
 
 	// <, >, <=, >=
 	l, r, t := maybeCastNumbers(lhs, rhs)
-	switch n.Type {
+	switch op {
 	case LessEqualNT:
 		switch t {
-		case IntNT:
-			return newBool(l.Val.(int64) <= r.Val.(int64)), nil
-		case FloatNT:
-			return newBool(l.Val.(float64) <= r.Val.(float64)), nil
+		case IntDT:
+			return NewBool(l.Int() <= r.Int())
+		case FloatDT:
+			return NewBool(l.Float() <= r.Float())
 		}
 	case GreaterEqualNT:
 		switch t {
-		case IntNT:
-			return newBool(l.Val.(int64) >= r.Val.(int64)), nil
-		case FloatNT:
-			return newBool(l.Val.(float64) >= r.Val.(float64)), nil
+		case IntDT:
+			return NewBool(l.Int() >= r.Int())
+		case FloatDT:
+			return NewBool(l.Float() >= r.Float())
 		}
 	case LessNT:
 		switch t {
-		case IntNT:
-			return newBool(l.Val.(int64) < r.Val.(int64)), nil
-		case FloatNT:
-			return newBool(l.Val.(float64) < r.Val.(float64)), nil
+		case IntDT:
+			return NewBool(l.Int() < r.Int())
+		case FloatDT:
+			return NewBool(l.Float() < r.Float())
 		}
 	case GreaterNT:
 		switch t {
-		case IntNT:
-			return newBool(l.Val.(int64) > r.Val.(int64)), nil
-		case FloatNT:
-			return newBool(l.Val.(float64) > r.Val.(float64)), nil
+		case IntDT:
+			return NewBool(l.Int() > r.Int())
+		case FloatDT:
+			return NewBool(l.Float() > r.Float())
 		}
 	}
 
-	return FAIL, nil
+	return FAIL
 }
 
-func interpretIn(n *Node, env *Environment) (res *Node, err error) {
+func interpretIn(n *Node, env *Environment) (res Value, err error) {
 	item, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 	container, err := Interpret(n.R, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	switch container.Type {
-	case ListNT:
-		for i := 0; i < len(container.Val.(List)); i++ {
-			equal, _ := evalEquality(item, container.Val.(List)[i])
-			if equal {
-				return TRUE, nil
-			}
-		}
+	return contains(container, item), nil
+}
 
-		return FALSE, nil
-	case SetNT:
-		set := container.Val.(Set)
-		return newBool(set[item.toValue()]), nil
+// contains reports whether a list or set contains an item
+func contains(container, item Value) Value {
+	switch container.Type {
+	case ListDT:
+		found := false
+		container.List().each(func(_ int, m Value) bool {
+			found, _ = evalEquality(item, m)
+			return !found
+		})
+		return NewBool(found)
+	case SetDT:
+		return NewBool(container.Set().Has(item))
 
 	default:
-		return FAIL, nil
+		return FAIL
 	}
 }
 
-func interpretUnOp(n *Node, env *Environment) (res *Node, err error) {
+func interpretUnOp(n *Node, env *Environment) (res Value, err error) {
 	if n.R == nil {
-		return nil, fmt.Errorf("Missing argument for unary operation \"%s\"", nodeTypeMap[n.Type])
+		return Value{}, fmt.Errorf("Missing argument for unary operation \"%s\"", nodeTypeMap[n.Type])
 	}
 
 	arg, err := Interpret(n.R, env)
 	if err != nil {
 		return arg, err
 	}
-	switch n.Type {
+
+	return unaryOp(n.Type, arg)
+}
+
+// unaryOp applies a unary operator to a value
+func unaryOp(op NodeType, arg Value) (Value, error) {
+	switch op {
 	case LogicNotNT:
-		return newBool(!isTruthy(arg)), nil
+		return NewBool(!isTruthy(arg)), nil
 	case MaybeNT:
-		if arg.Type == FailNT {
+		if arg.Type == FailDT {
 			return arg, nil
 		}
 		return SUCCESS, nil
@@ -395,36 +467,48 @@ func interpretUnOp(n *Node, env *Environment) (res *Node, err error) {
 		{
 			var cardinality int
 			switch arg.Type {
-			case ListNT:
-				cardinality = len(arg.Val.(List))
-			case StringNT:
-				cardinality = len(arg.Val.(string))
-			case SetNT:
-				cardinality = len(arg.Val.(Set))
-			case ObjectNT:
-				cardinality = len(arg.Val.(Object))
+			case ListDT:
+				cardinality = arg.List().Len()
+			case StringDT:
+				cardinality = utf8.RuneCountInString(arg.Str())
+			case SetDT:
+				cardinality = arg.Set().Len()
+			case ObjectDT:
+				cardinality = arg.Object().Len()
+			case TupleDT:
+				cardinality = len(arg.Tuple())
 			default:
 				return FAIL, nil
 			}
-			return newInt(int64(cardinality)), nil
+			return NewInt(int64(cardinality)), nil
 		}
 	case UnaryNegNT:
 		{
 			switch arg.Type {
-			case IntNT:
-				return newInt(-arg.Val.(int64)), nil
-			case FloatNT:
-				return newFloat(-arg.Val.(float64)), nil
+			case IntDT:
+				return NewInt(-arg.Int()), nil
+			case FloatDT:
+				return NewFloat(-arg.Float()), nil
 			default:
 				return FAIL, nil
 			}
 		}
 	}
 
-	return nil, fmt.Errorf("Unknown unary operator")
+	return Value{}, fmt.Errorf("Unknown unary operator")
+}
+
+func interpretIf(n *Node, env *Environment) (res Value, err error) {
+	branch, err := ifBranch(n, env)
+	if err != nil || branch == nil {
+		return FAIL, err
+	}
+	return Interpret(branch, env)
 }
 
-func interpretIf(n *Node, env *Environment) (res *Node, err error) {
+// ifBranch gives the branch of an if statement taken, or nil if its condition is false and it has
+// no else branch
+func ifBranch(n *Node, env *Environment) (*Node, error) {
 	cond, result := n.L, n.R
 	condRes, err := Interpret(cond, env)
 	if err != nil {
@@ -434,23 +518,79 @@ func interpretIf(n *Node, env *Environment) (res *Node, err error) {
 	if isTruthy(condRes) {
 		if result.Type == ThenBranchNT {
 			// expr has an else branch
-			return Interpret(result.L, env)
+			return result.L, nil
 		} else {
 			// expr does not have an else branch
-			return Interpret(result, env)
+			return result, nil
 		}
 	} else {
 		if result.Type == ThenBranchNT {
-			return Interpret(result.R, env)
+			return result.R, nil
 		} else {
-			return FAIL, nil
+			return nil, nil
 		}
 	}
 }
 
-func interpretCall(n *Node, env *Environment) (res *Node, err error) {
+func interpretMatch(n *Node, env *Environment) (res Value, err error) {
+	body, err := matchArm(n, env)
+	if err != nil || body == nil {
+		return FAIL, err
+	}
+	return Interpret(body, env)
+}
+
+// matchArm gives the body of the first arm of a match expression that matches, or nil if none do
+func matchArm(n *Node, env *Environment) (*Node, error) {
+	subject, err := Interpret(n.L, env)
+	if err != nil {
+		return nil, err
+	}
+
+	for c := n.R; c != nil; c = c.R {
+		arm := c.L
+		pattern, guard := arm.L, (*Node)(nil)
+		if pattern.Type == IfNT {
+			// guarded arm: (if guard pattern)
+			pattern, guard = pattern.R, pattern.L
+		}
+
+		matched, err := matchPattern(pattern, subject, env)
+		if err != nil {
+			return nil, err
+		}
+		if !matched {
+			continue
+		}
+
+		if guard != nil {
+			cond, err := Interpret(guard, env)
+			if err != nil {
+				return nil, err
+			}
+			if !isTruthy(cond) {
+				continue
+			}
+		}
+
+		return arm.R, nil
+	}
+
+	// no arm matched
+	return nil, nil
+}
+
+func interpretCall(n *Node, env *Environment) (res Value, err error) {
+	lambda, args, line, err := callArgs(n, env)
+	if err != nil {
+		return Value{}, err
+	}
+	return call(lambda, args, env, n.L, line)
+}
+
+// callArgs evaluates the function and arguments of a call, giving the line it's made on
+func callArgs(n *Node, env *Environment) (lambda Value, args []Value, line int, err error) {
 	callee := n.L
-	var lambda *Node
 	if callee.Type == IdentifierNT {
 		lambda, err = resolveIdentifier(callee, env)
 	} else {
@@ -458,610 +598,1091 @@ func interpretCall(n *Node, env *Environment) (res *Node, err error) {
 	}
 
 	if err != nil {
-		return nil, err
+		return Value{}, nil, 0, err
 	}
 
-	// built-in functions
-	if lambda.Func != nil {
-		args := []*Node{}
-		for arg := n.R; arg != nil && arg.L != nil; arg = arg.R {
-			val, err := Interpret(arg.L, env)
-			if err != nil {
-				return nil, err
-			}
-			args = append(args, val)
+	line = n.Line
+	if line == 0 {
+		line = nodeLine(n)
+	}
+
+	// the arguments of a Rye function are counted before they're evaluated
+	if lambda.Type == LambdaDT && lambda.fn().builtin == nil && !env.vm {
+		ps, as := countArgs(lambda.fn().node.L, n.R)
+		if err := checkArity(callee, ps, as); err != nil {
+			return Value{}, nil, 0, err
 		}
-		return lambda.Func(env, args...)
 	}
 
-	parent := env
-	if lambda.Scope != nil {
-		parent = lambda.Scope
+	args = []Value{}
+	for arg := n.R; arg != nil && arg.L != nil; arg = arg.R {
+		val, err := Interpret(arg.L, env)
+		if err != nil {
+			return Value{}, nil, 0, err
+		}
+		args = append(args, val)
 	}
 
-	scope := newScope(parent)
+	return lambda, args, line, nil
+}
 
-	ps, as := countArgs(lambda.L, n.R)
-	if ps > as {
-		if callee.Type == IdentifierNT {
-			return nil, fmt.Errorf("Too few arguments provided to function \"%s\". Expected %d, received %d.", callee.Val.(string), ps, as)
+// tailCall is a call of a Rye function in tail position, whose value is the value of the function
+// making it. Rather than being made on top of that function's frame, it's returned for the caller
+// of the function to make once the frame is done, so that recursion in tail position runs in
+// constant space.
+type tailCall struct {
+	lambda Value
+	args   []Value
+	caller *Environment
+	callee *Node
+	line   int
+}
+
+// newTailCall gives a call in tail position, which is made straight away unless it's of a Rye
+// function
+func newTailCall(lambda Value, args []Value, caller *Environment, callee *Node, line int) (Value, error) {
+	if lambda.Type != LambdaDT || lambda.fn().builtin != nil {
+		return call(lambda, args, caller, callee, line)
+	}
+	return Value{Type: tailCallDT, ref: &tailCall{lambda, args, caller, callee, line}}, nil
+}
+
+// make makes a tail call that was returned outside of a function, e.g. by the body of a task
+func (tc *tailCall) make() (Value, error) {
+	return call(tc.lambda, tc.args, tc.caller, tc.callee, tc.line)
+}
+
+// pendingReturn is a function that ended with a tail call, whose return type is checked once the
+// call returns
+type pendingReturn struct {
+	f      *function
+	callee *Node
+}
+
+// call calls a function with arguments. The caller is the scope it's called from, and callee and
+// line are where it's called, for error messages. The callee is nil for functions that aren't
+// called by an expression, e.g. those called by built-in functions. The tail calls the function
+// ends with are made here in turn, each in a frame as deep as the first.
+func call(lambda Value, args []Value, caller *Environment, callee *Node, line int) (Value, error) {
+	depth := caller.depth + 1
+	outer, outerLine := callee, line
+	var from *Node // the callee of the function that made the last tail call
+	var pending []pendingReturn
+	for tails := 0; ; tails++ {
+		res, f, err := callOnce(lambda, args, caller, callee, line, depth)
+		if err == nil && res.Type != tailCallDT {
+			if f != nil {
+				// the return value is checked once the function's frame is done, so the error is
+				// given its frame here
+				if res, err = returnValue(res, f, callee); err != nil {
+					err = pushFrame(err, frameName(callee), line)
+				}
+			}
+			for i := len(pending) - 1; i >= 0 && err == nil; i-- {
+				res, err = returnValue(res, pending[i].f, pending[i].callee)
+			}
+		}
+		if err != nil {
+			if tails > 0 {
+				// the frames of the functions that made the tail calls are gone. The error is
+				// given the line of the last tail call and the frame of the outer call, with a
+				// stand-in for any frames in between.
+				err = locateLine(err, line)
+				if tails > 1 {
+					err = pushElided(err, frameName(from))
+				}
+				err = pushFrame(err, frameName(outer), outerLine)
+			}
+			return Value{}, err
+		}
+
+		if res.Type != tailCallDT {
+			return res, nil
 		}
-		return nil, fmt.Errorf("Too few arguments provided to anonymous function. Expected %d, received %d.", ps, as)
+
+		// return types are checked in reverse order once the tail calls are done
+		if annotation(f.node) != nil && (len(pending) == 0 || pending[len(pending)-1].f != f) {
+			pending = append(pending, pendingReturn{f, callee})
+		}
+
+		tc := res.tailCall()
+		from = callee
+		lambda, args, caller, callee, line = tc.lambda, tc.args, tc.caller, tc.callee, tc.line
 	}
+}
 
-	if ps < as {
-		if callee.Type == IdentifierNT {
-			return nil, fmt.Errorf("Too many arguments provided to function \"%s\". Expected %d, received %d.", callee.Val.(string), ps, as)
+// callOnce makes a call in a frame of the given depth, giving the function called, or nil for
+// built-in functions. Any tail call the function ends with is returned rather than made.
+func callOnce(lambda Value, args []Value, caller *Environment, callee *Node, line, depth int) (Value, *function, error) {
+	if err := caller.limits().cancelled(); err != nil {
+		return Value{}, nil, err
+	}
+
+	if lambda.Type != LambdaDT {
+		return Value{}, nil, fmt.Errorf("Cannot call a value of type %s", typeName(lambda))
+	}
+
+	// built-in functions
+	f := lambda.fn()
+	if f.builtin != nil {
+		if caller.vm {
+			// the arguments are part of the caller's stack
+			args = append([]Value{}, args...)
 		}
-		return nil, fmt.Errorf("Too many arguments provided to anonymous function. Expected %d, received %d.", ps, as)
+		res, err := f.builtin(caller, args...)
+		return res, nil, err
+	}
+
+	// compiled functions
+	if caller.vm {
+		res, err := callCompiled(f, args, caller, callee, line, depth)
+		return res, f, err
+	}
+
+	scope := callFrame(f, layoutOf(f.node), caller, depth, 0)
+	if err := scope.checkDepth(); err != nil {
+		return Value{}, nil, err
+	}
+
+	ps, _ := countArgs(f.node.L, nil)
+	if err := checkArity(callee, ps, len(args)); err != nil {
+		return Value{}, nil, err
 	}
 
-	param, arg := lambda.L, n.R
 	// assign arguments to function scope
-	for param != nil && (param.Val != nil || param.L != nil) && arg != nil && arg.L != nil {
-		val, err := Interpret(arg.L, env)
-		if err != nil {
-			return nil, err
+	param := f.node.L
+	for _, val := range args {
+		if t := annotation(param); t != nil {
+			var ok bool
+			if val, ok = conform(val, t); !ok {
+				return Value{}, nil, runtimeErrorf(param.Line, "Type mismatch for argument \"%s\" of %s. Expected %s, received %s.", param.Val.(string), describeFunction(callee), t, typeName(val))
+			}
 		}
 
-		assignArg(val, param, scope)
-		param, arg = param.R, arg.R
+		bindParam(scope, param, val)
+		param = param.R
 	}
 
-	if lambda.R.Type == StmtNT {
-		res, err = interpretFunctionBody(lambda.R, scope)
+	var res Value
+	var err error
+	if f.node.R.Type == StmtNT {
+		res, err = interpretFunctionBody(f.node.R, scope)
 	} else {
-		res, err = Interpret(lambda.R, scope)
+		res, err = interpretTail(f.node.R, scope, true)
 	}
 
 	if err != nil {
-		return res, err
+		return Value{}, nil, pushFrame(err, frameName(callee), line)
+	}
+	return res, f, nil
+}
+
+// Call calls a function with arguments from Go, in the scope env, as if it were called by name
+// there. The name may be empty for functions that aren't named. Errors are returned as a
+// *RuntimeError.
+func Call(fn Value, args []Value, env *Environment, name string) (Value, error) {
+	return callLambda(calleeNamed(name), fn, env, args...)
+}
+
+// calleeNamed gives the identifier a function is called by from Go, or nil
+func calleeNamed(name string) *Node {
+	if name == "" {
+		return nil
+	}
+	return &Node{Type: IdentifierNT, Val: name}
+}
+
+// checkArity raises an error if a function is called with the wrong number of arguments
+func checkArity(callee *Node, ps, as int) error {
+	if ps > as {
+		return fmt.Errorf("Too few arguments provided to %s. Expected %d, received %d.", describeFunction(callee), ps, as)
 	}
 
-	if res.Type == LambdaNT {
-		res.Scope = scope
+	if ps < as {
+		return fmt.Errorf("Too many arguments provided to %s. Expected %d, received %d.", describeFunction(callee), ps, as)
 	}
-	return res, err
+	return nil
 }
 
-func interpretFunctionBody(start *Node, env *Environment) (res *Node, err error) {
-	for n := start; n != nil; n = n.R {
-		if n.L != nil && n.L.Type == StmtNT {
-			res, err = Interpret(n.L, newScope(env))
-		} else {
-			res, err = Interpret(n.L, env)
+// calleeName gives the name of the function called by a callee, which is the field it's in for
+// calls like m.hello(), or "" for anonymous functions
+func calleeName(callee *Node) string {
+	switch {
+	case callee == nil:
+	case callee.Type == IdentifierNT:
+		return callee.Val.(string)
+	case callee.Type == FieldAccessNT && callee.R != nil && callee.R.Type == IdentifierNT:
+		return callee.R.Val.(string)
+	}
+	return ""
+}
+
+// describeFunction names the function called by a callee in error messages
+func describeFunction(callee *Node) string {
+	if name := calleeName(callee); name != "" {
+		return fmt.Sprintf("function \"%s\"", name)
+	}
+	return "anonymous function"
+}
+
+// frameName names the function called by a callee in stack traces
+func frameName(callee *Node) string {
+	if name := calleeName(callee); name != "" {
+		return name
+	}
+	return "<lambda>"
+}
+
+// returnValue checks the value returned by a call against the lambda's return type
+func returnValue(res Value, f *function, callee *Node) (Value, error) {
+	if t := annotation(f.node); t != nil && !res.IsZero() {
+		var ok bool
+		if res, ok = conform(res, t); !ok {
+			return Value{}, runtimeErrorf(f.node.Val.(*Node).Line, "Type mismatch for return value of %s. Expected %s, received %s.", describeFunction(callee), t, typeName(res))
 		}
+	}
+	return res, nil
+}
 
-		if err != nil {
+// interpretFunctionBody runs the statements of a function body until one returns. A call its value
+// ends with is returned as a tail call, rather than made.
+func interpretFunctionBody(start *Node, env *Environment) (res Value, err error) {
+	for n := start; n != nil; n = n.R {
+		if res, err = interpretTail(n.L, env, n.R == nil); err != nil {
 			return res, err
 		}
 
-		if res.Type == ReturnStmtNT {
-			return res.R, nil
+		if res.Type == ReturnDT {
+			return res.unwrap(), nil
+		}
+	}
+
+	return res, err
+}
+
+// interpretTail evaluates a statement of a function body, which is in tail position if its value is
+// the value of the function. A call whose value is returned, by a return statement or by being in
+// tail position, is given as a tail call.
+func interpretTail(n *Node, env *Environment, tail bool) (Value, error) {
+	switch {
+	case n.Type == CallNT && tail, n.Type == ReturnStmtNT, n.Type == IfNT, n.Type == MatchNT:
+	case n.Type == StmtNT && n.res == nil:
+		// a block
+	default:
+		return Interpret(n, env)
+	}
+
+	if s := env.limits(); s != nil {
+		if err := s.step(); err != nil {
+			return Value{}, locate(err, n)
+		}
+	}
+
+	res, err := tailOf(n, env, tail)
+	if err != nil {
+		return res, locate(err, n)
+	}
+	return res, nil
+}
+
+// tailOf evaluates the nodes interpretTail looks into
+func tailOf(n *Node, env *Environment, tail bool) (Value, error) {
+	switch n.Type {
+	case CallNT:
+		lambda, args, line, err := callArgs(n, env)
+		if err != nil {
+			return Value{}, err
+		}
+		return newTailCall(lambda, args, env, n.L, line)
+	case ReturnStmtNT:
+		res, err := interpretTail(n.R, env, true)
+		if err != nil {
+			return Value{}, err
+		}
+		return newReturn(res), nil
+	case IfNT:
+		branch, err := ifBranch(n, env)
+		if err != nil || branch == nil {
+			return FAIL, err
 		}
+		return interpretTail(branch, env, tail)
+	case MatchNT:
+		body, err := matchArm(n, env)
+		if err != nil || body == nil {
+			return FAIL, err
+		}
+		return interpretTail(body, env, tail)
 	}
 
+	// the statements of a block, of which only the last can return
+	var res Value
+	var err error
+	for stmt := n; stmt != nil; stmt = stmt.R {
+		if stmt.R != nil {
+			res, err = Interpret(stmt.L, env)
+		} else {
+			res, err = interpretTail(stmt.L, env, tail)
+		}
+		if err != nil {
+			break
+		}
+	}
 	return res, err
 }
 
-func interpretMap(n *Node, env *Environment) (res *Node, err error) {
+func interpretMap(n *Node, env *Environment) (res Value, err error) {
 	lhs, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	if lhs.Type == FailNT || (lhs.Type != ListNT && lhs.Type != SetNT) {
+	if lhs.Type != ListDT && lhs.Type != SetDT {
 		return FAIL, nil
 	}
 
-	callee := n.R
-	var lambda *Node
-	if callee.Type == IdentifierNT {
-		lambda, err = resolveIdentifier(callee, env)
-	} else {
-		lambda, err = Interpret(callee, env)
+	lambda, err := resolveCallee(n.R, env)
+	if err != nil {
+		return Value{}, err
 	}
 
-	if err != nil {
-		return nil, err
+	return mapItems(lhs, n.R, lambda, env)
+}
+
+// resolveCallee evaluates the function on the right of a compound expression like map
+func resolveCallee(callee *Node, env *Environment) (Value, error) {
+	if callee.Type == IdentifierNT {
+		return resolveIdentifier(callee, env)
 	}
+	return Interpret(callee, env)
+}
 
-	if lambda.Type != LambdaNT {
+// mapItems calls a lambda with each item of a list or set, collecting the results
+func mapItems(lhs Value, callee *Node, lambda Value, env *Environment) (res Value, err error) {
+	if (lhs.Type != ListDT && lhs.Type != SetDT) || lambda.Type != LambdaDT {
 		return FAIL, nil
 	}
 
-	resList := List{}
-	resSet := Set{}
+	resList := []Value{}
+	resSet := setBuilder{}
 
+	// index is declared in a scope of its own, rather than the caller's
+	scope := newScope(env)
 	next := iterateCollection(lhs)
-	for item, i := next(), 0; item != nil; item, i = next(), i+1 {
-		old, err := Interpret(item, env)
-		if err != nil {
-			return nil, err
+	for item, i := next(), 0; !item.IsZero(); item, i = next(), i+1 {
+		if err := env.limits().cancelled(); err != nil {
+			return Value{}, err
 		}
 
-		env.Consts["index"] = newInt(int64(i))
-
-		var new *Node
-		if lambda.Func != nil {
-			new, err = lambda.Func(env, item)
-		} else {
-			call := &Node{
-				Type: CallNT,
-				L:    lambda,
-				R: &Node{
-					Type: ArgNT,
-					L:    old,
-				},
-			}
-			new, err = Interpret(call, env)
+		scope.setConst("index", NewInt(int64(i)))
+		new, err := callLambda(callee, lambda, scope, item)
+		if err != nil {
+			return Value{}, err
 		}
 
-		if lhs.Type == ListNT {
+		if lhs.Type == ListDT {
 			resList = append(resList, new)
 		}
-		if lhs.Type == SetNT {
-			resSet[new.toValue()] = true
+		if lhs.Type == SetDT {
+			resSet.add(new)
 		}
 	}
-	env.Consts["_"] = nil
-	env.Consts["index"] = nil
 
-	if lhs.Type == SetNT {
-		return newSet(resSet), nil
+	if lhs.Type == SetDT {
+		return NewSet(resSet.set()), nil
 	}
 
-	return newList(resList), nil
+	return NewList(listFrom(resList)), nil
 }
 
-func interpretWhere(n *Node, env *Environment) (res *Node, err error) {
+func interpretWhere(n *Node, env *Environment) (res Value, err error) {
 	lhs, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	if lhs.Type == FailNT || (lhs.Type != ListNT && lhs.Type != SetNT) {
+	if lhs.Type != ListDT && lhs.Type != SetDT {
 		return FAIL, nil
 	}
 
-	callee := n.R
-	var lambda *Node
-	if callee.Type == IdentifierNT {
-		lambda, err = resolveIdentifier(callee, env)
-	} else {
-		lambda, err = Interpret(callee, env)
-	}
-
+	lambda, err := resolveCallee(n.R, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	if lambda.Type != LambdaNT {
+	return whereItems(lhs, n.R, lambda, env)
+}
+
+// whereItems keeps the items of a list or set for which a lambda returns a truthy value
+func whereItems(lhs Value, callee *Node, lambda Value, env *Environment) (res Value, err error) {
+	if (lhs.Type != ListDT && lhs.Type != SetDT) || lambda.Type != LambdaDT {
 		return FAIL, nil
 	}
 
-	resList := List{}
-	resSet := Set{}
+	resList := []Value{}
+	resSet := setBuilder{}
 
+	scope := newScope(env)
 	next := iterateCollection(lhs)
-	for item, i := next(), 0; item != nil; item, i = next(), i+1 {
-		val, err := Interpret(item, env)
-		if err != nil {
-			return nil, err
-		}
-
-		env.Consts["index"] = newInt(int64(i))
-		var result *Node
-		if lambda.Func != nil {
-			result, err = lambda.Func(env, item)
-		} else {
-			call := &Node{
-				Type: CallNT,
-				L:    lambda,
-				R: &Node{
-					Type: ArgNT,
-					L:    val,
-				},
-			}
-			result, err = Interpret(call, env)
+	for item, i := next(), 0; !item.IsZero(); item, i = next(), i+1 {
+		if err := env.limits().cancelled(); err != nil {
+			return Value{}, err
 		}
 
+		scope.setConst("index", NewInt(int64(i)))
+		result, err := callLambda(callee, lambda, scope, item)
 		if err != nil {
-			return nil, err
+			return Value{}, err
 		}
 
 		if isTruthy(result) {
-			if lhs.Type == ListNT {
-				resList = append(resList, val)
+			if lhs.Type == ListDT {
+				resList = append(resList, item)
 			}
-			if lhs.Type == SetNT {
-				resSet[val.toValue()] = true
+			if lhs.Type == SetDT {
+				resSet.add(item)
 			}
 		}
 	}
-	env.Consts["_"] = nil
-	env.Consts["index"] = nil
 
-	if lhs.Type == SetNT {
-		return newSet(resSet), nil
+	if lhs.Type == SetDT {
+		return NewSet(resSet.set()), nil
 	}
 
-	return newList(resList), nil
+	return NewList(listFrom(resList)), nil
 }
 
-func interpretPipe(n *Node, env *Environment) (res *Node, err error) {
+func interpretPMap(n *Node, env *Environment) (res Value, err error) {
+	lhs, _, results, err := interpretParallel(n, env)
+	if err != nil || lhs.Type == FailDT {
+		return lhs, err
+	}
+
+	if lhs.Type == SetDT {
+		resSet := setBuilder{}
+		for _, r := range results {
+			resSet.add(r)
+		}
+		return NewSet(resSet.set()), nil
+	}
+
+	return NewList(listFrom(results)), nil
+}
+
+func interpretPWhere(n *Node, env *Environment) (res Value, err error) {
+	lhs, items, results, err := interpretParallel(n, env)
+	if err != nil || lhs.Type == FailDT {
+		return lhs, err
+	}
+
+	resList := []Value{}
+	resSet := setBuilder{}
+	for i, r := range results {
+		if !isTruthy(r) {
+			continue
+		}
+		if lhs.Type == ListDT {
+			resList = append(resList, items[i])
+		}
+		if lhs.Type == SetDT {
+			resSet.add(items[i])
+		}
+	}
+
+	if lhs.Type == SetDT {
+		return NewSet(resSet.set()), nil
+	}
+
+	return NewList(listFrom(resList)), nil
+}
+
+func interpretPipe(n *Node, env *Environment) (res Value, err error) {
 	lhs, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	if lhs.Type == FailNT {
+	if lhs.Type == FailDT {
 		return lhs, nil
 	}
 
-	callee := n.R
-	var lambda *Node
-	if callee.Type == IdentifierNT {
-		lambda, err = resolveIdentifier(callee, env)
-	} else {
-		lambda, err = Interpret(callee, env)
+	lambda, err := resolveCallee(n.R, env)
+	if err != nil {
+		return Value{}, err
+	}
+
+	return pipeTo(lhs, n.R, lambda, env)
+}
+
+// pipeTo calls a lambda with a value, unless the value is fail
+func pipeTo(lhs Value, callee *Node, lambda Value, env *Environment) (res Value, err error) {
+	if lhs.Type == FailDT {
+		return lhs, nil
 	}
 
+	return callLambda(callee, lambda, env, lhs)
+}
+
+// interpretBind calls a function with a value unless the value is fail, in which case the
+// rest of a chain of binds is skipped. A lambda on the right names the value for the steps after
+// it: parse(s) bind n => lookup(n) bind v => v * 2
+func interpretBind(n *Node, env *Environment) (res Value, err error) {
+	lhs, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	// built-in functions
-	if lambda.Func != nil {
-		return lambda.Func(env, lhs)
+	if lhs.Type == FailDT {
+		return FAIL, nil
+	}
+
+	lambda, err := resolveCallee(n.R, env)
+	if err != nil {
+		return Value{}, err
 	}
 
-	call := &Node{
-		Type: CallNT,
-		L:    lambda,
-		R: &Node{
-			Type: ArgNT,
-			L:    lhs,
-		},
+	return bindTo(lhs, n.R, lambda, env)
+}
+
+// bindTo calls a lambda with a value, unless the value is fail or the lambda isn't a function
+func bindTo(lhs Value, callee *Node, lambda Value, env *Environment) (res Value, err error) {
+	if lhs.Type == FailDT || lambda.Type != LambdaDT {
+		return FAIL, nil
 	}
 
-	return Interpret(call, env)
+	return callLambda(callee, lambda, env, lhs)
 }
 
-func interpretFind(n *Node, env *Environment) (res *Node, err error) {
+func interpretFind(n *Node, env *Environment) (res Value, err error) {
 	lhs, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	if lhs.Type == FailNT {
+	if lhs.Type == FailDT {
 		return lhs, nil
 	}
 
-	callee := n.R
-	var lambda *Node
-	if callee.Type == IdentifierNT {
-		lambda, err = resolveIdentifier(callee, env)
-	} else {
-		lambda, err = Interpret(callee, env)
+	lambda, err := resolveCallee(n.R, env)
+	if err != nil {
+		return Value{}, err
 	}
 
-	if err != nil {
-		return nil, err
+	return findItem(lhs, n.R, lambda, env)
+}
+
+// findItem gives the first item of a collection for which a lambda returns a truthy value
+func findItem(lhs Value, callee *Node, lambda Value, env *Environment) (res Value, err error) {
+	if lhs.Type == FailDT {
+		return lhs, nil
 	}
 
 	// built-in functions
-	if lambda.Func != nil {
-		return lambda.Func(env, lhs)
+	if lambda.Type == LambdaDT && lambda.fn().builtin != nil {
+		return lambda.fn().builtin(env, lhs)
 	}
 
+	scope := newScope(env)
 	next := iterateCollection(lhs)
-	for item, i := next(), 0; item != nil; item, i = next(), i+1 {
-		val, err := Interpret(item, env)
+	for item, i := next(), 0; !item.IsZero(); item, i = next(), i+1 {
+		scope.setConst("index", NewInt(int64(i)))
+		result, err := callLambda(callee, lambda, scope, item)
 		if err != nil {
-			return nil, err
+			return Value{}, err
 		}
 
-		env.Consts["index"] = newInt(int64(i))
-		var result *Node
-		if lambda.Func != nil {
-			result, err = lambda.Func(env, item)
-		} else {
-			call := &Node{
-				Type: CallNT,
-				L:    lambda,
-				R: &Node{
-					Type: ArgNT,
-					L:    val,
-				},
-			}
-			result, err = Interpret(call, env)
+		if isTruthy(result) {
+			return item, nil
 		}
+	}
+
+	return FAIL, nil
+}
+
+// interpretFold folds a collection with a function of the accumulated value and the current item.
+// Without a starting value, the first item is used.
+func interpretFold(n *Node, env *Environment) (res Value, err error) {
+	lhs, err := Interpret(n.L, env)
+	if err != nil {
+		return Value{}, err
+	}
+
+	if lhs.Type != ListDT && lhs.Type != SetDT && lhs.Type != ObjectDT {
+		return FAIL, nil
+	}
 
+	lambda, err := resolveCallee(n.R, env)
+	if err != nil {
+		return Value{}, err
+	}
+
+	if lambda.Type != LambdaDT {
+		return FAIL, nil
+	}
+
+	var acc Value
+	if n.Val != nil {
+		acc, err = Interpret(n.Val.(*Node), env)
 		if err != nil {
-			return nil, err
+			return Value{}, err
 		}
+	}
+	return foldItems(lhs, acc, n.R, lambda, env)
+}
 
-		if isTruthy(result) {
-			env.Consts["_"] = nil
-			env.Consts["index"] = nil
-			return item, nil
-		}
+// foldItems folds a collection starting from acc, or from its first item if acc is the zero Value
+func foldItems(lhs, acc Value, callee *Node, lambda Value, env *Environment) (res Value, err error) {
+	if (lhs.Type != ListDT && lhs.Type != SetDT && lhs.Type != ObjectDT) || lambda.Type != LambdaDT {
+		return FAIL, nil
 	}
 
-	env.Consts["_"] = nil
-	env.Consts["index"] = nil
+	next := iterateCollection(lhs)
+	if !acc.IsZero() {
+		return fold(next, acc, callee, lambda, 0, env)
+	}
 
-	return FAIL, nil
+	first := next()
+	if first.IsZero() {
+		return FAIL, nil
+	}
+	return fold(next, first, callee, lambda, 1, env)
 }
 
-func interpretBracketAccess(n *Node, env *Environment) (res *Node, err error) {
+func interpretBracketAccess(n *Node, env *Environment) (res Value, err error) {
 	src, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
 	accessor, err := Interpret(n.R, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	if src.Type == ListNT || src.Type == StringNT {
+	return bracketAccess(src, accessor)
+}
+
+// bracketAccess gets an item of a list, string or tuple by index, or a field of an object by key
+func bracketAccess(src, accessor Value) (res Value, err error) {
+	if src.Type == ListDT || src.Type == StringDT || src.Type == TupleDT {
 		return getByIndex(src, accessor)
 	}
 
-	if src.Type == ObjectNT {
+	if src.Type == ObjectDT {
 		return getByName(src, accessor)
 	}
 
 	return FAIL, nil
 }
 
-func interpretListSlice(n *Node, env *Environment) (res *Node, err error) {
+// interpretComprehension builds a list, set or object with an item for every combination of
+// items from its generators (nested from left to right) that passes its filters. Items that don't
+// match a generator's pattern are skipped, and the comprehension fails if a generator's source is
+// not a collection.
+func interpretComprehension(n *Node, env *Environment) (Value, error) {
+	list, set, obj := []Value{}, setBuilder{}, objectBuilder{}
+	ok, err := comprehend(n.R, env, func(scope *Environment) error {
+		if n.Val.(NodeType) == ObjectNT {
+			k, err := Interpret(n.L.L, scope)
+			if err != nil {
+				return err
+			}
+			v, err := Interpret(n.L.R, scope)
+			if err != nil {
+				return err
+			}
+			obj.put(k, v)
+			return nil
+		}
+
+		item, err := Interpret(n.L, scope)
+		if err != nil {
+			return err
+		}
+		if n.Val.(NodeType) == SetNT {
+			set.add(item)
+		} else {
+			list = append(list, item)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return Value{}, err
+	}
+	if !ok {
+		return FAIL, nil
+	}
+
+	switch n.Val.(NodeType) {
+	case ObjectNT:
+		return NewObject(obj.object()), nil
+	case SetNT:
+		return NewSet(set.set()), nil
+	}
+	return NewList(listFrom(list)), nil
+}
+
+// comprehend runs the clauses of a comprehension, calling emit with the scope of each
+// combination of items that passes all of them
+func comprehend(clause *Node, scope *Environment, emit func(*Environment) error) (bool, error) {
+	if clause == nil {
+		return true, emit(scope)
+	}
+
+	// filter
+	gen := clause.L
+	if gen.Type != GeneratorNT {
+		cond, err := Interpret(gen, scope)
+		if err != nil || !isTruthy(cond) {
+			return true, err
+		}
+		return comprehend(clause.R, scope, emit)
+	}
+
+	src, err := Interpret(gen.R, scope)
+	if err != nil {
+		return false, err
+	}
+	if src.Type != ListDT && src.Type != SetDT && src.Type != ObjectDT {
+		return false, nil
+	}
+
+	next := iterateCollection(src)
+	for item := next(); !item.IsZero(); item = next() {
+		matched, err := matchPattern(gen.L, item, scope)
+		if err != nil {
+			return false, err
+		}
+		if !matched {
+			continue
+		}
+
+		ok, err := comprehend(clause.R, scope, emit)
+		if !ok || err != nil {
+			return ok, err
+		}
+	}
+	return true, nil
+}
+
+// interpretTemplate builds an interpolated string, formatting each interpolated value as it is
+// displayed by print
+func interpretTemplate(n *Node, env *Environment) (Value, error) {
+	var sb strings.Builder
+	for ; n != nil; n = n.R {
+		val, err := Interpret(n.L, env)
+		if err != nil {
+			return Value{}, err
+		}
+		sb.WriteString(interpolate(val))
+	}
+	return NewString(sb.String()), nil
+}
+
+// interpolate formats a value inserted into a string: strings as they are, and anything else as
+// print would display it
+func interpolate(val Value) string {
+	if val.Type == StringDT {
+		return val.Str()
+	}
+	return Display(val)
+}
+
+func interpretListSlice(n *Node, env *Environment) (res Value, err error) {
 	src, err := Interpret(n.L, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	if src.Type != ListNT && src.Type != StringNT {
+	if src.Type != ListDT && src.Type != StringDT {
 		return FAIL, nil
 		// return nil, fmt.Errorf("Value is not a list and cannot be sliced")
 	}
 
 	startNode := n.R.L
 	endNode := n.R.R
-	if startNode == nil && endNode == nil {
-		return Interpret(src, env)
+
+	var startVal, endVal Value
+	if startNode != nil {
+		startVal, err = Interpret(startNode, env)
+		if err != nil {
+			return Value{}, err
+		}
+	}
+	if endNode != nil {
+		endVal, err = Interpret(endNode, env)
+		if err != nil {
+			return Value{}, err
+		}
 	}
 
+	return slice(src, startVal, endVal), nil
+}
+
+// slice gets part of a list or string, from start up to end. Either may be the zero Value, to
+// slice from the beginning or to the end. Slicing a list always gives a new list.
+func slice(src, startVal, endVal Value) Value {
 	var start int64
 	var end int64
+	var runes []rune
 	switch src.Type {
-	case ListNT:
-		end = int64(len(src.Val.(List)))
-	case StringNT:
-		end = int64(len(src.Val.(string)))
-
+	case ListDT:
+		end = int64(src.List().Len())
+	case StringDT:
+		runes = []rune(src.Str())
+		end = int64(len(runes))
 	}
-	if startNode != nil {
-		startVal, err := Interpret(startNode, env)
-		if err != nil {
-			return nil, err
-		}
-
+	if !startVal.IsZero() {
 		switch startVal.Type {
-		case IntNT:
-			start = startVal.Val.(int64)
-		case FloatNT:
-			start = int64(startVal.Val.(float64))
+		case IntDT:
+			start = startVal.Int()
+		case FloatDT:
+			start = int64(startVal.Float())
 		default:
-			return FAIL, nil
+			return FAIL
 		}
 	}
 
-	if endNode != nil {
-		endVal, err := Interpret(endNode, env)
-		if err != nil {
-			return nil, err
-		}
-
+	if !endVal.IsZero() {
 		switch endVal.Type {
-		case IntNT:
-			end = endVal.Val.(int64)
-		case FloatNT:
-			end = int64(endVal.Val.(float64))
+		case IntDT:
+			end = endVal.Int()
+		case FloatDT:
+			end = int64(endVal.Float())
 		default:
-			return FAIL, nil
+			return FAIL
 		}
 	}
 
-	if src.Type == StringNT {
-		if end > int64(len(src.Val.(string))) {
-			end = int64(len(src.Val.(string)))
+	if src.Type == StringDT {
+		if end > int64(len(runes)) {
+			end = int64(len(runes))
+		}
+		if end < 0 {
+			end = 0
+		}
+		if start < 0 {
+			start = 0
 		}
 		if start > end {
 			start = end
 		}
-		return newString(src.Val.(string)[int(start):int(end)]), nil
+		return NewString(string(runes[start:end]))
 	}
 
-	list := List{}
-	for i := int(start); i < int(end) && i < len(src.Val.(List)); i++ {
-		list = append(list, src.Val.(List)[i])
+	list := src.List()
+	if end > int64(list.Len()) {
+		end = int64(list.Len())
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > end {
+		start = end
+	}
+	return NewList(list.Slice(int(start), int(end)))
+}
+
+// iterationScope gives the scope an iteration of a loop runs in, clearing the locals of its body
+// from the last iteration. If closures are made in the body, it's a frame of its own, so that they
+// keep the locals of the iteration they're made in.
+func iterationScope(stmt *Node, env, frame *Environment) *Environment {
+	switch {
+	case stmt.res == nil:
+		return env
+	case stmt.res.layout != nil:
+		return newFrame(env, env, stmt.res.layout, 0)
+	}
+	frame.clear(stmt.res.start, stmt.res.end)
+	return env
+}
+
+// interpretLoopBody runs an iteration of a loop, giving the value of the last statement of its body,
+// or the break, continue or return that stopped it. The value of the loop is the value of its last
+// iteration that wasn't stopped, and a return is passed on to the function it's in.
+func interpretLoopBody(stmt *Node, env *Environment) (res Value, err error) {
+	for n := stmt.R; n != nil; n = n.R {
+		if n.Type == StmtNT {
+			res, err = Interpret(n.L, env)
+		} else {
+			res, err = Interpret(n, env)
+		}
+
+		if err != nil {
+			return Value{}, err
+		}
+
+		switch res.Type {
+		case BreakDT, ContinueDT, ReturnDT:
+			return res, nil
+		}
 	}
 
-	return newList(list), nil
+	return res, nil
 }
 
-func interpretWhile(stmt *Node, env *Environment) (res *Node, err error) {
+func interpretWhile(stmt *Node, env *Environment) (res Value, err error) {
+	frame := env.frame(0)
 	for {
+		if err := env.limits().cancelled(); err != nil {
+			return Value{}, err
+		}
+
 		cond, err := Interpret(stmt.L, env)
 		if err != nil {
-			return nil, err
+			return Value{}, err
 		}
 		if !isTruthy(cond) {
 			break
 		}
-		stop := false
-
-		scope := newScope(env)
-
-		for n := stmt.R; n != nil; n = n.R {
-			if n.Type == StmtNT {
-				res, err = Interpret(n.L, scope)
-			} else {
-				res, err = Interpret(n, scope)
-			}
-
-			if err != nil {
-				return nil, err
-			}
-
-			if res.Type == BreakNT {
-				stop = true
-				break
-			}
-
-			if res.Type == ContinueNT {
-				break
-			}
 
-			if res.Type == ReturnStmtNT {
-				stop = true
-				return res.R, nil
-			}
+		// each iteration has a block of its own
+		val, err := interpretLoopBody(stmt, iterationScope(stmt, env, frame))
+		if err != nil {
+			return Value{}, err
 		}
 
-		if stop {
-			break
+		switch val.Type {
+		case BreakDT:
+			return res, nil
+		case ReturnDT:
+			return val, nil
+		case ContinueDT:
+		default:
+			res = val
 		}
 	}
 
 	return res, err
 }
 
-func interpretFor(stmt *Node, env *Environment) (res *Node, err error) {
+func interpretFor(stmt *Node, env *Environment) (res Value, err error) {
 	iterator, iteratee := stmt.L.L, stmt.L.R
 	src, err := Interpret(iteratee, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
-	if src.Type != ListNT && src.Type != ObjectNT && src.Type != SetNT {
+	if src.Type != ListDT && src.Type != ObjectDT && src.Type != SetDT {
 		return FAIL, nil
 	}
 
-	iter := iterator.Val.(string)
-
 	// for each iteration
+	frame := env.frame(0)
 	next := iterateCollection(src)
-	for item, i := next(), 0; item != nil; item, i = next(), i+1 {
-		scope := newScope(env)
-
-		scope.Consts[iter] = item
-		scope.Consts["index"] = newInt(int64(i))
-		stop := false
-
-		// for each statement in body
-		for n := stmt.R; n != nil; n = n.R {
-			if n.Type == StmtNT {
-				res, err = Interpret(n.L, scope)
-			} else {
-				res, err = Interpret(n, scope)
-			}
+	for item, i := next(), 0; !item.IsZero(); item, i = next(), i+1 {
+		if err := env.limits().cancelled(); err != nil {
+			return Value{}, err
+		}
 
-			if err != nil {
-				return res, err
-			}
+		// each iteration has a block of its own
+		scope := iterationScope(stmt, env, frame)
 
-			break_, continue_ := false, false
-			switch res.Type {
-			case BreakNT:
-				stop = true
-				break_ = true
-			case ContinueNT:
-				continue_ = true
-			case ReturnStmtNT:
-				stop = true
-				return res.R, nil
-			}
+		// for x <- xs, for (k, v) <- pairs, for [a, b] <- rows, ...
+		if err := declareValue(iterator, item, scope, true); err != nil {
+			return Value{}, err
+		}
+		if stmt.res != nil {
+			scope.frame(0).setSlot(stmt.res.slot, NewInt(int64(i)))
+		}
 
-			if break_ {
-				break
-			}
-			if continue_ {
-				continue
-			}
+		val, err := interpretLoopBody(stmt, scope)
+		if err != nil {
+			return Value{}, err
 		}
 
-		if stop {
-			break
+		switch val.Type {
+		case BreakDT:
+			return res, nil
+		case ReturnDT:
+			return val, nil
+		case ContinueDT:
+		default:
+			res = val
 		}
 	}
-	env.Consts["index"] = nil
 
 	return res, err
 }
 
-func interpretRange(n *Node, env *Environment) (res *Node, err error) {
-	var start *Node
+func interpretRange(n *Node, env *Environment) (res Value, err error) {
+	var start Value
 	if n.L != nil {
 		start, err = Interpret(n.L, env)
 	}
 
 	if err != nil {
-		return nil, err
-	}
-
-	if start != nil && start.Type != IntNT {
-		return nil, fmt.Errorf("Invalid start value for range")
+		return Value{}, err
 	}
 
 	end, err := Interpret(n.R, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
+	}
+
+	return makeRange(start, end, env)
+}
+
+// makeRange lists the integers from start (or 0, if it's the zero Value) up to end
+func makeRange(start, end Value, env *Environment) (res Value, err error) {
+	if !start.IsZero() && start.Type != IntDT {
+		return Value{}, fmt.Errorf("Invalid start value for range")
 	}
 
-	rng := List{}
 	var i int64
-	if start != nil {
-		switch start.Type {
-		case IntNT:
-			i = start.Val.(int64)
-		case FloatNT:
-			i = int64(start.Val.(float64))
-		default:
-			return FAIL, nil
-		}
+	if !start.IsZero() {
+		i = start.Int()
 	}
 
 	var endVal int64
 	switch end.Type {
-	case IntNT:
-		endVal = end.Val.(int64)
-	case FloatNT:
-		endVal = int64(end.Val.(float64))
+	case IntDT:
+		endVal = end.Int()
+	case FloatDT:
+		endVal = int64(end.Float())
 	default:
 		return FAIL, nil
 	}
 
 	if i >= endVal {
 
-		return newList(List{}), nil
+		return NewList(List{}), nil
 	}
+	// check the size before building a range that's too large
+	if s := env.limits(); s != nil {
+		if err := s.checkItems(int(endVal - i)); err != nil {
+			return Value{}, err
+		}
+	}
+	rng := make([]Value, 0, endVal-i)
 	for ; i < endVal; i++ {
-		rng = append(rng, newInt(i))
+		rng = append(rng, NewInt(i))
+	}
+
+	return NewList(listFrom(rng)), nil
+}
+
+// interpretTuple evaluates the items of a tuple literal. Unlike lists, tuples can't be changed
+// once they're created.
+func interpretTuple(n *Node, env *Environment) (res Value, err error) {
+	tuple := Tuple{}
+	for _, m := range n.Val.([]*Node) {
+		item, err := Interpret(m, env)
+		if err != nil {
+			return Value{}, err
+		}
+		tuple = append(tuple, item)
 	}
 
-	return newList(rng), nil
+	return NewTuple(tuple), nil
 }
 
-func interpretList(n *Node, env *Environment) (res *Node, err error) {
+func interpretList(n *Node, env *Environment) (res Value, err error) {
 	list := List{}
 
-	for _, m := range n.Val.(List) {
+	for _, m := range n.Val.([]*Node) {
 		switch m.Type {
-		case SplatNT, RangeNT, MapNT, WhereNT:
-			var arg *Node
+		case SplatNT, RangeNT, MapNT, WhereNT, PMapNT, PWhereNT:
+			var arg Value
 			var err error
 			if m.Type == SplatNT {
 				arg, err = Interpret(m.R, env)
@@ -1069,36 +1690,42 @@ func interpretList(n *Node, env *Environment) (res *Node, err error) {
 				arg, err = Interpret(m, env)
 			}
 			if err != nil {
-				return nil, err
+				return Value{}, err
 			}
 
-			switch arg.Type {
-			case ListNT:
-				list = append(list, arg.Val.(List)...)
-			case SetNT:
-				set := arg.Val.(Set)
-				for k := range set {
-					if set[k] {
-						list = append(list, k.toNode())
-					}
-				}
-			default:
-				list = append(list, FAIL)
-			}
+			list = spreadList(list, arg)
 			continue
 		default:
 			val, err := Interpret(m, env)
 			if err != nil {
-				return nil, err
+				return Value{}, err
 			}
-			list = append(list, val)
+			list = list.Append(val)
 		}
 	}
 
-	return newList(list), nil
+	return NewList(list), nil
 }
 
-func interpretObjectItem(n *Node, env *Environment) (res *Node, err error) {
+// spreadList adds the items of a spread list or set to a list, or fail if it isn't a collection
+func spreadList(list List, arg Value) List {
+	switch arg.Type {
+	case ListDT:
+		if list.Len() == 0 {
+			return arg.List()
+		}
+		list = list.Concat(arg.List())
+	case SetDT:
+		for _, m := range arg.Set().Members() {
+			list = list.Append(m)
+		}
+	default:
+		list = list.Append(FAIL)
+	}
+	return list
+}
+
+func interpretObjectItem(n *Node, env *Environment) (res Value, err error) {
 	obj := Object{}
 
 	curr := n
@@ -1107,78 +1734,95 @@ func interpretObjectItem(n *Node, env *Environment) (res *Node, err error) {
 
 		switch node.Type {
 		case KVPairNT:
-			key := node.L
+			key := nodeKey(node.L)
 			if node.L.Type != IdentifierNT {
 				var err error
 				key, err = Interpret(node.L, env)
 				if err != nil {
-					return nil, err
+					return Value{}, err
 				}
 			}
 
 			val, err := Interpret(node.R, env)
 			if err != nil {
-				return nil, err
+				return Value{}, err
 			}
 
-			obj[key.toValue()] = val
+			obj = obj.Put(key, val)
 		case SplatNT:
 			arg, err := Interpret(node.R, env)
 			if err != nil {
-				return nil, err
+				return Value{}, err
 			}
 
-			if arg.Type == ObjectNT {
-				for k, v := range arg.Val.(Object) {
-					obj[k] = v
-				}
-			}
+			obj = spreadObject(obj, arg)
 		}
 
 		curr = curr.R
 	}
 
-	return newObject(obj), nil
+	return NewObject(obj), nil
 }
 
-func interpretFieldAccess(n *Node, env *Environment) (res *Node, err error) {
+// spreadObject adds the fields of a spread object to another
+func spreadObject(obj Object, arg Value) Object {
+	if arg.Type != ObjectDT {
+		return obj
+	}
+	if obj.Len() == 0 {
+		return arg.Object()
+	}
+
+	b := objectBuilder{obj: obj}
+	for _, f := range arg.Object().Fields() {
+		b.put(f.Key, f.Val)
+	}
+	return b.object()
+}
+
+func interpretFieldAccess(n *Node, env *Environment) (res Value, err error) {
 	lhs, rhs := n.L, n.R
 
 	obj, err := Interpret(lhs, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	if obj.Type == ObjectNT {
-		val, ok := obj.Val.(Object)[rhs.toValue()]
+	return fieldAccess(obj, rhs), nil
+}
+
+// fieldAccess gets a field of an object or a declaration of a module
+func fieldAccess(obj Value, rhs *Node) Value {
+	if obj.Type == ObjectDT {
+		val, ok := obj.Object().Get(nodeKey(rhs))
 		if !ok {
-			return FAIL, nil
+			return FAIL
 		}
 
-		return Interpret(val, env)
+		return val
 	}
 
-	if obj.Type == ModuleNT {
-		val, ok := obj.Scope.Consts[rhs.Val.(string)]
+	if obj.Type == ModuleDT {
+		val, ok := obj.module().scope.get(rhs.Val.(string))
 		if !ok {
-			return FAIL, nil
+			return FAIL
 		}
 
-		return Interpret(val, env)
+		return val
 	}
 
-	return FAIL, nil
+	return FAIL
 }
 
-func interpretSetItem(n *Node, env *Environment) (res *Node, err error) {
+func interpretSetItem(n *Node, env *Environment) (res Value, err error) {
 	set := Set{}
 
 	curr := n
 	for curr != nil {
 		// handle spread
 		switch curr.L.Type {
-		case SplatNT, RangeNT, MapNT, WhereNT:
-			var arg *Node
+		case SplatNT, RangeNT, MapNT, WhereNT, PMapNT, PWhereNT:
+			var arg Value
 			var err error
 			if curr.L.Type == SplatNT {
 				arg, err = Interpret(curr.L.R, env)
@@ -1186,25 +1830,10 @@ func interpretSetItem(n *Node, env *Environment) (res *Node, err error) {
 				arg, err = Interpret(curr.L, env)
 			}
 			if err != nil {
-				return nil, err
-			}
-
-			switch arg.Type {
-			case ListNT:
-				for _, m := range arg.Val.(List) {
-					set[m.toValue()] = true
-				}
-			case SetNT:
-				s := arg.Val.(Set)
-				for m := range s {
-					if s[m] {
-						set[m] = true
-					}
-				}
-			default:
-				set[(FAIL).toValue()] = true
+				return Value{}, err
 			}
 
+			set = spreadSet(set, arg)
 			curr = curr.R
 			continue
 		}
@@ -1212,12 +1841,34 @@ func interpretSetItem(n *Node, env *Environment) (res *Node, err error) {
 		// all other set items
 		val, err := Interpret(curr.L, env)
 		if err != nil {
-			return nil, err
+			return Value{}, err
 		}
 
-		set[val.toValue()] = true
+		set = set.Add(val)
 		curr = curr.R
 	}
 
-	return newSet(set), nil
+	return NewSet(set), nil
+}
+
+// spreadSet adds the items of a spread list or set to a set, or fail if it isn't a collection
+func spreadSet(set Set, arg Value) Set {
+	b := setBuilder{s: set}
+	switch arg.Type {
+	case ListDT:
+		arg.List().each(func(_ int, m Value) bool {
+			b.add(m)
+			return true
+		})
+	case SetDT:
+		if set.Len() == 0 {
+			return arg.Set()
+		}
+		for _, m := range arg.Set().Members() {
+			b.add(m)
+		}
+	default:
+		b.add(FAIL)
+	}
+	return b.set()
 }
@@ -7,58 +7,71 @@ import (
 	"strings"
 )
 
-var FAIL = &Node{Type: FailNT}
-var SUCCESS = &Node{Type: SuccessNT}
-var TRUE = &Node{Type: BoolNT, Val: true}
-var FALSE = &Node{Type: BoolNT, Val: false}
-
-func isTruthy(n *Node) bool {
-	if n == nil {
+func isTruthy(v Value) bool {
+	switch v.Type {
+	case InvalidDT:
 		return false
-	}
-	switch n.Type {
-	case SuccessNT:
+	case SuccessDT:
 		return true
-	case FailNT:
+	case FailDT:
 		return false
-	case FloatNT:
-		return n.Val.(float64) != 0
-	case IntNT:
-		return n.Val.(int64) != 0
-	case BoolNT:
-		return n.Val.(bool)
-	case StringNT:
-		return len(n.Val.(string)) != 0
-	case NullNT:
+	case FloatDT:
+		return v.Float() != 0
+	case IntDT:
+		return v.Int() != 0
+	case BoolDT:
+		return v.Bool()
+	case StringDT:
+		return len(v.Str()) != 0
+	case NullDT:
 		return false
 	default:
 		return true
 	}
 }
 
-func evalEquality(a, b *Node) (bool, error) {
+func evalEquality(a, b Value) (bool, error) {
 	l, r, t := maybeCastNumbers(a, b)
 	switch t {
-	case IntNT:
-		return l.Val.(int64) == r.Val.(int64), nil
-	case FloatNT:
-		return l.Val.(float64) == r.Val.(float64), nil
-	case StringNT:
-		return l.Val.(string) == r.Val.(string), nil
-	case ListNT:
-		if len(l.Val.(List)) != len(r.Val.(List)) {
+	case IntDT:
+		return l.Int() == r.Int(), nil
+	case FloatDT:
+		return l.Float() == r.Float(), nil
+	case StringDT:
+		return l.Str() == r.Str(), nil
+	case ListDT:
+		a, b := l.List(), r.List()
+		if a.Len() != b.Len() {
+			return false, nil
+		}
+		next := b.iterator()
+		for item := a.iterator(); ; {
+			n := item()
+			if n.IsZero() {
+				return true, nil
+			}
+			equal, err := evalEquality(n, next())
+			if !equal || err != nil {
+				return false, err
+			}
+		}
+	case TupleDT:
+		if len(l.Tuple()) != len(r.Tuple()) {
 			return false, nil
 		}
-		for i, n := range a.Val.(List) {
-			equal, err := evalEquality(n, r.Val.(List)[i])
+		for i, n := range l.Tuple() {
+			equal, err := evalEquality(n, r.Tuple()[i])
 			if !equal || err != nil {
 				return false, err
 			}
 		}
 		return true, nil
-	case BoolNT:
-		return l.Val.(bool) == r.Val.(bool), nil
-	case SuccessNT, FailNT, NullNT:
+	case SetDT, ObjectDT:
+		// sets and objects are equal if they have the same members or fields
+		return keyOf(l) == keyOf(r), nil
+	case BoolDT:
+		return l.Bool() == r.Bool(), nil
+	case SuccessDT, FailDT, NullDT:
 		return true, nil
 	default:
 		return false, fmt.Errorf("Cannot compare types")
@@ -66,97 +79,133 @@ func evalEquality(a, b *Node) (bool, error) {
 }
 
 // maybeCastNumbers casts numbers to floats if one is a float
-func maybeCastNumbers(a, b *Node) (*Node, *Node, NodeType) {
-	if a == nil || b == nil {
-		return nil, nil, ErrorNT
-	}
-
+func maybeCastNumbers(a, b Value) (Value, Value, DataType) {
 	if a.Type == b.Type {
 		return a, b, a.Type
 	}
 
 	switch a.Type {
-	case IntNT:
-		if b.Type == FloatNT {
-			return newFloat(float64(a.Val.(int64))), b, FloatNT
+	case IntDT:
+		if b.Type == FloatDT {
+			return NewFloat(float64(a.Int())), b, FloatDT
 		} else {
-			return a, b, ErrorNT
+			return a, b, InvalidDT
 		}
-	case FloatNT:
-		if b.Type == IntNT {
-			return a, newFloat(float64(b.Val.(int64))), FloatNT
+	case FloatDT:
+		if b.Type == IntDT {
+			return a, NewFloat(float64(b.Int())), FloatDT
 		} else {
-			return a, b, ErrorNT
+			return a, b, InvalidDT
 		}
-	case StringNT:
+	case StringDT:
 		switch b.Type {
-		case IntNT, FloatNT:
-			return a, newString(b.ToString()), StringNT
+		case IntDT, FloatDT:
+			return a, NewString(b.ToString()), StringDT
 		default:
-			return a, b, ErrorNT
+			return a, b, InvalidDT
 		}
-	case ListNT, BoolNT, SuccessNT, FailNT, NullNT:
-		return a, b, ErrorNT
 	default:
-		return a, b, ErrorNT
+		return a, b, InvalidDT
 	}
 }
 
-func resolveIdentifier(n *Node, env *Environment) (res *Node, err error) {
+// resolveIdentifier gives the value of an identifier: the local Resolve found it refers to, or
+// else the value of its name. A local declared in a branch that wasn't taken is looked up by name.
+func resolveIdentifier(n *Node, env *Environment) (res Value, err error) {
+	if r := n.res; r != nil {
+		if frame := env.frame(r.depth); frame != nil {
+			if val := frame.slot(r.slot); !val.IsZero() {
+				return val, nil
+			}
+		}
+	}
+
 	ident := n.Val.(string)
 	for e := env; e != nil; e = e.Parent {
-		if val, ok := e.Consts[ident]; ok {
-			return val, nil
-		}
-		if val, ok := e.Vars[ident]; ok {
+		if val, ok := e.get(ident); ok {
 			return val, nil
 		}
 	}
 
 	if n.Line != 0 {
-		return nil, fmt.Errorf("Line %d: \"%s\" is undefined", n.Line, ident)
+		return Value{}, runtimeErrorf(n.Line, "\"%s\" is undefined", ident)
 	}
-	return nil, fmt.Errorf("\"%s\" is undefined", ident)
+	return Value{}, fmt.Errorf("\"%s\" is undefined", ident)
 }
 
-func declareVar(n *Node, env *Environment) (res *Node, err error) {
+func declareVar(n *Node, env *Environment) (res Value, err error) {
 	val, err := Interpret(n.R, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
-	ident := n.L.Val.(string)
-	if _, exists := env.Consts[ident]; exists {
-		return nil, fmt.Errorf("\"%s\" is already defined", ident)
+	if err := declareValue(n.L, val, env, n.Type == ConstDeclNT); err != nil {
+		return Value{}, err
 	}
-	if _, exists := env.Vars[ident]; exists {
-		return nil, fmt.Errorf("\"%s\" is already defined", ident)
+	return SUCCESS, nil
+}
+
+// declareValue declares an identifier, or the identifiers in a destructuring target, with a value
+func declareValue(target *Node, val Value, env *Environment, constant bool) error {
+	// [a, b] := val, (a, b) := val, {a, b} := val
+	if target.Type != IdentifierNT {
+		assign, err := getDestructuredAssign(target, env, constant)
+		if err != nil {
+			return err
+		}
+		return assign(val)
 	}
 
-	// assign(val)
-	if n.Type == VarDeclNT {
-		env.Vars[ident] = val
-	} else {
-		env.Consts[ident] = val
+	ident := target.Val.(string)
+
+	// x: T = val
+	t := annotation(target)
+	if t != nil {
+		var err error
+		if val, err = conformVar(ident, t, val, target.Line); err != nil {
+			return err
+		}
 	}
 
-	return SUCCESS, nil
+	if target.res != nil {
+		env.frame(0).setSlot(target.res.slot, val)
+		return nil
+	}
+
+	env = env.globals()
+	if err := env.declare(ident, val, constant); err != nil {
+		return err
+	}
+	if t != nil {
+		env.declareType(ident, t)
+	}
+	return nil
 }
 
-func assignVar(n *Node, env *Environment) (res *Node, err error) {
+// conformVar checks a value declared or assigned to a variable against the variable's annotated
+// type
+func conformVar(ident string, t *Type, val Value, line int) (Value, error) {
+	res, ok := conform(val, t)
+	if !ok {
+		return Value{}, runtimeErrorf(line, "Type mismatch for \"%s\". Expected %s, received %s.", ident, t, typeName(val))
+	}
+	return res, nil
+}
+
+func assignVar(n *Node, env *Environment) (res Value, err error) {
 	assign, err := getAssignmentTarget(n.L, env, false)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
 	val, err := Interpret(n.R, env)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
 	err = assign(val)
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
 	return SUCCESS, nil
@@ -164,20 +213,38 @@ func assignVar(n *Node, env *Environment) (res *Node, err error) {
 
 // getAssignmentTarget - returns a function that will assign its argument to the desired place.
 // lhs refers to a potentially nested assignment target, like a list index, object field, or some combination
-func getAssignmentTarget(lhs *Node, env *Environment, constant bool) (assignFunc func(*Node) error, err error) {
+func getAssignmentTarget(lhs *Node, env *Environment, constant bool) (assignFunc func(Value) error, err error) {
 	// basic identifiers
 	if lhs.L == nil && lhs.Type == IdentifierNT {
 		ident := lhs.Val.(string)
+		if r := lhs.res; r != nil {
+			if frame := env.frame(r.depth); frame != nil && !frame.slot(r.slot).IsZero() {
+				if frame.layout.consts[r.slot] {
+					return nil, fmt.Errorf("Cannot assign to constant variable \"%s\"", ident)
+				}
+				return func(n Value) error {
+					return frame.assign(r.slot, lhs, n)
+				}, nil
+			}
+		}
+
 		for e := env; e != nil; e = e.Parent {
-			if _, exists := e.Consts[ident]; exists {
+			isConst, isVar := e.defines(ident)
+			if isConst {
 				return nil, fmt.Errorf("Cannot assign to constant variable \"%s\"", ident)
 			}
-			if _, exists := e.Vars[ident]; exists {
-				return func(n *Node) error {
+			if isVar {
+				return func(n Value) error {
+					if t, ok := e.typeOf(ident); ok {
+						var err error
+						if n, err = conformVar(ident, t, n, lhs.Line); err != nil {
+							return err
+						}
+					}
 					if constant {
-						e.Consts[ident] = n
+						e.setConst(ident, n)
 					} else {
-						e.Vars[ident] = n
+						e.setVar(ident, n)
 					}
 					return nil
 				}, nil
@@ -189,34 +256,182 @@ func getAssignmentTarget(lhs *Node, env *Environment, constant bool) (assignFunc
 	return getNestedAssign(lhs, env)
 }
 
-func getDestructuredAssign(assignee *Node, env *Environment) (assignFunc func(*Node) error, err error) {
+// getDestructuredAssign returns a function that declares the identifiers in a list, tuple or
+// object destructuring target. List and tuple targets take the items of a list or tuple in order.
+// Any part missing from the value (or all of them, if the value has the wrong shape) is fail.
+func getDestructuredAssign(assignee *Node, env *Environment, constant bool) (assignFunc func(Value) error, err error) {
+	if assignee.Type != ListNT && assignee.Type != TupleNT && assignee.Type != ObjectItemNT {
+		return nil, fmt.Errorf("Invalid assignment target")
+	}
+
+	idents := targetIdents(assignee)
+	return func(n Value) error {
+		for i, val := range destructure(assignee, n) {
+			if idents[i].res != nil {
+				env.frame(0).setSlot(idents[i].res.slot, val)
+			} else if err := env.globals().declare(idents[i].Val.(string), val, constant); err != nil {
+				return err
+			}
+		}
+		return nil
+	}, nil
+}
+
+// targetIdents lists the identifiers declared by a declaration's target, which may be a list,
+// tuple or object destructuring target
+func targetIdents(assignee *Node) []*Node {
+	idents := []*Node{}
 	switch assignee.Type {
-	case ListNT:
-		return func(n *Node) error {
-			if n.Type != ListNT {
-				for _, m := range assignee.Val.(List) {
-					env.Consts[m.Val.(string)] = FAIL
-				}
-				return nil
+	case IdentifierNT:
+		idents = append(idents, assignee)
+	case ListNT, TupleNT:
+		idents = append(idents, assignee.Val.([]*Node)...)
+	case ObjectItemNT:
+		for p := assignee; p != nil; p = p.R {
+			ident := p.L
+			if p.L.Type == KVPairNT {
+				ident = p.L.R
 			}
+			idents = append(idents, ident)
+		}
+	}
+	return idents
+}
 
-			for i, m := range assignee.Val.(List) {
-				if i < len(n.Val.(List)) {
-					env.Consts[m.Val.(string)] = n.Val.(List)[i]
-				} else {
-					env.Consts[m.Val.(string)] = FAIL
+// destructure gives the parts of a value taken by each identifier of a destructuring target, in
+// the order of targetIdents
+func destructure(assignee *Node, n Value) []Value {
+	vals := []Value{}
+	switch assignee.Type {
+	case ListNT, TupleNT:
+		idents := assignee.Val.([]*Node)
+		items, ok := sequenceItems(n)
+		for i := range idents {
+			val := FAIL
+			if ok && i < len(items) {
+				val = items[i]
+			}
+			vals = append(vals, val)
+		}
+	case ObjectItemNT:
+		for p := assignee; p != nil; p = p.R {
+			key := p.L
+			if p.L.Type == KVPairNT {
+				key = p.L.L
+			}
+
+			val := FAIL
+			if n.Type == ObjectDT {
+				if field, ok := n.Object().Get(nodeKey(key)); ok {
+					val = field
 				}
 			}
+			vals = append(vals, val)
+		}
+	}
+	return vals
+}
+
+// matchPattern checks whether val has the shape described by a match arm's pattern, binding any
+// identifiers in the pattern to the corresponding parts of val in scope
+func matchPattern(pattern *Node, val Value, scope *Environment) (bool, error) {
+	switch pattern.Type {
+	case UnderscoreNT:
+		return true, nil
+	case IdentifierNT:
+		if pattern.res != nil {
+			scope.frame(0).setSlot(pattern.res.slot, val)
+		} else {
+			scope.setConst(pattern.Val.(string), val)
+		}
+		return true, nil
+	case IntNT, FloatNT, StringNT, BoolNT, NullNT, SuccessNT, FailNT, UnaryNegNT:
+		lit, err := Interpret(pattern, scope)
+		if err != nil {
+			return false, err
+		}
+		equal, err := evalEquality(lit, val)
+		return equal && err == nil, nil
+	case ListNT:
+		// like declarations, list patterns destructure tuples too, so k, v <- pairs works on both
+		var items List
+		switch val.Type {
+		case ListDT:
+			items = val.List()
+		case TupleDT:
+			items = ListOf(val.Tuple()...)
+		default:
+			return false, nil
+		}
+
+		patterns := pattern.Val.([]*Node)
+		rest := len(patterns) > 0 && patterns[len(patterns)-1].Type == SplatNT
+		if rest {
+			patterns = patterns[:len(patterns)-1]
+			if items.Len() < len(patterns) {
+				return false, nil
+			}
+		} else if items.Len() != len(patterns) {
+			return false, nil
+		}
+
+		for i, p := range patterns {
+			matched, err := matchPattern(p, items.Get(i), scope)
+			if !matched || err != nil {
+				return false, err
+			}
+		}
+
+		if rest {
+			splat := pattern.Val.([]*Node)[len(pattern.Val.([]*Node))-1]
+			tail := items.Slice(len(patterns), items.Len())
+			return matchPattern(splat.R, NewList(tail), scope)
+		}
+		return true, nil
+	case TupleNT:
+		if val.Type != TupleDT || len(val.Tuple()) != len(pattern.Val.([]*Node)) {
+			return false, nil
+		}
+
+		for i, p := range pattern.Val.([]*Node) {
+			matched, err := matchPattern(p, val.Tuple()[i], scope)
+			if !matched || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
+	case ObjectNT:
+		// empty object pattern: matches any object
+		return val.Type == ObjectDT, nil
+	case ObjectItemNT:
+		if val.Type != ObjectDT {
+			return false, nil
+		}
+
+		obj := val.Object()
+		for p := pattern; p != nil; p = p.R {
+			key, sub := p.L, p.L
+			if p.L.Type == KVPairNT {
+				key, sub = p.L.L, p.L.R
+			}
 
-			return nil
-		}, nil
+			field, ok := obj.Get(nodeKey(key))
+			if !ok {
+				return false, nil
+			}
 
+			matched, err := matchPattern(sub, field, scope)
+			if !matched || err != nil {
+				return false, err
+			}
+		}
+		return true, nil
 	}
 
-	return nil, fmt.Errorf("Invalid assignemnt target")
+	return false, fmt.Errorf("Invalid pattern in match expression")
 }
 
-func getNestedAssign(assignee *Node, env *Environment) (assignFunc func(*Node) error, err error) {
+func getNestedAssign(assignee *Node, env *Environment) (assignFunc func(Value) error, err error) {
 	// assignments to list indexes and object fields
 	container, err := Interpret(assignee.L, env)
 	if err != nil {
@@ -224,18 +439,18 @@ func getNestedAssign(assignee *Node, env *Environment) (assignFunc func(*Node) e
 	}
 
 	switch container.Type {
-	case ListNT:
+	case ListDT:
 		{
 			idxNode, err := Interpret(assignee.R, env)
-			length := len(container.Val.(List))
+			length := container.List().Len()
 			if err != nil {
 				return nil, err
 			}
 			var idx int
-			if idxNode.Type == IntNT {
-				idx = int(idxNode.Val.(int64))
-			} else if idxNode.Type == FloatNT {
-				idx = int(idxNode.Val.(float64))
+			if idxNode.Type == IntDT {
+				idx = int(idxNode.Int())
+			} else if idxNode.Type == FloatDT {
+				idx = int(idxNode.Float())
 			} else {
 				return nil, fmt.Errorf("Cannot assign to list index. Invalid index.")
 			}
@@ -246,17 +461,17 @@ func getNestedAssign(assignee *Node, env *Environment) (assignFunc func(*Node) e
 			if idx >= length || idx < 0 {
 				return nil, fmt.Errorf("Cannot assign to list. Index out of range.")
 			}
-			return func(n *Node) error {
-				container.Val.(List)[idx] = n
+			return func(n Value) error {
+				container.setList(container.List().Update(idx, n))
 				return nil
 			}, nil
 		}
-	case ObjectNT:
+	case ObjectDT:
 		{
 			// field access
 			if assignee.Type == FieldAccessNT {
-				return func(n *Node) error {
-					container.Val.(Object)[assignee.R.toValue()] = n
+				return func(n Value) error {
+					container.setObject(container.Object().Put(nodeKey(assignee.R), n))
 					return nil
 				}, nil
 			}
@@ -267,16 +482,33 @@ func getNestedAssign(assignee *Node, env *Environment) (assignFunc func(*Node) e
 				return nil, err
 			}
 
-			return func(n *Node) error {
-				container.Val.(Object)[key.toValue()] = n
+			return func(n Value) error {
+				container.setObject(container.Object().Put(key, n))
 				return nil
 			}, nil
 		}
+	case TupleDT:
+		return nil, fmt.Errorf("Cannot assign to an item of a tuple. Tuples can't be changed.")
 	default:
 		return nil, fmt.Errorf("Invalid assignment target.")
 	}
 }
 
+// fold combines the items produced by next using a function of the accumulated value and the
+// current item. Items are numbered from i, which is available as index inside the function.
+func fold(next func() Value, acc Value, callee *Node, lambda Value, i int, env *Environment) (res Value, err error) {
+	scope := newScope(env)
+	for item := next(); !item.IsZero(); item, i = next(), i+1 {
+		scope.setConst("index", NewInt(int64(i)))
+		acc, err = callLambda(callee, lambda, scope, acc, item)
+		if err != nil {
+			return Value{}, err
+		}
+	}
+
+	return acc, nil
+}
+
 func countArgs(params, args *Node) (p, a int) {
 	for param := params; ; p++ {
 		if param == nil || (param.Val == nil && param.L == nil) {
@@ -295,154 +527,64 @@ func countArgs(params, args *Node) (p, a int) {
 	return p, a
 }
 
-func assignArg(arg, param *Node, scope *Environment) {
-	if param == nil || (param.L == nil && param.Val == nil) {
-		return
-	}
-
-	// parameter: the identifier defined in a function definition
-	// argument: the value assigned to a parameter at call time
-
-	// identifier: 	param{Val: string, R: nextParam}
-	// list:				param{L: List of idents, R: nextParam}
-	// object:			param{L: ObjectItem{L: KVPair{L: key, R: rename} | ident, R: nil | nextField}, R: nextParam}
-
-	// plain parameter
-	if param.Val != nil {
-		scope.Vars[param.Val.(string)] = arg
-		return
-	}
-
-	// destructured param
-	switch param.L.Type {
-	case ListNT:
-		{
-			if arg.Type != ListNT {
-				for _, p := range param.L.Val.(List) {
-					scope.Vars[p.Val.(string)] = FAIL
-				}
-				return
-			}
-			as := arg.Val.(List)
-			for i, p := range param.L.Val.(List) {
-				if i >= len(as) {
-					scope.Vars[p.Val.(string)] = FAIL
-				}
-				scope.Vars[p.Val.(string)] = as[i]
-			}
-			return
+// iterateCollection returns a function giving the items of a list, the members of a set or the
+// keys of an object one at a time, and then the zero Value
+func iterateCollection(v Value) func() Value {
+	var items []Value
+	switch v.Type {
+	case ListDT:
+		return v.List().iterator()
+	case ObjectDT:
+		for _, f := range v.Object().Fields() {
+			items = append(items, f.Key)
 		}
-	case ObjectItemNT:
-		{
-			if arg.Type != ObjectNT {
-				// The arg is not an object
-				for p := param.L; p != nil; p = p.R {
-					if p.L != nil {
-						assignArg(FAIL, p.L, scope)
-					} else {
-						scope.Vars[p.Val.(string)] = FAIL
-					}
-				}
-				return
-			}
-
-			obj := arg.Val.(Object)
-			for p := param.L; p != nil; p = p.R {
-				destrucItem := p.L
-
-				var originalName *Node
-				var newName string
-				if destrucItem.Type == KVPairNT {
-					// key-value pair, rename
-					originalName = destrucItem.L
-					newName = destrucItem.R.Val.(string)
-				} else {
-					// plain identifier, do not rename
-					originalName = destrucItem
-					newName = originalName.Val.(string)
-				}
+	case SetDT:
+		items = v.Set().Members()
+	}
 
-				val, ok := obj[originalName.toValue()]
-				if ok {
-					// The field exists. Add to scope
-					scope.Vars[newName] = val
-				} else {
-					// The field does not exist on the arg
-					scope.Vars[newName] = FAIL
-				}
-			}
+	i := -1
+	return func() Value {
+		if i < len(items)-1 {
+			i++
+			return items[i]
 		}
-
+		return Value{}
 	}
 }
 
-func iterateCollection(n *Node) func() *Node {
-	switch n.Type {
-	case ListNT:
-		list := n.Val.(List)
-		i := -1
-		return func() *Node {
-			if i < len(list)-1 {
-				i++
-				return list[i]
-			}
-			return nil
-		}
-	case ObjectNT:
-		obj := n.Val.(Object)
-		keys := []*Node{}
-		for k := range obj {
-			keys = append(keys, k.toNode())
-		}
-		i := -1
-		return func() *Node {
-			if i < len(keys)-1 {
-				i++
-				return keys[i]
-			}
-			return nil
-		}
-	case SetNT:
-		set := n.Val.(Set)
-		items := []*Node{}
-		for k := range set {
-			if set[k] {
-				items = append(items, k.toNode())
-			}
-		}
-		i := -1
-		return func() *Node {
-			if i < len(items)-1 {
-				i++
-				return items[i]
-			}
-			return nil
-		}
-	default:
-		return func() *Node {
-			return nil
-		}
+// sequenceItems returns the items of a list or tuple
+func sequenceItems(v Value) ([]Value, bool) {
+	switch v.Type {
+	case ListDT:
+		return v.List().Items(), true
+	case TupleDT:
+		return v.Tuple(), true
 	}
+	return nil, false
 }
 
-func getByIndex(src, idxNode *Node) (res *Node, err error) {
+func getByIndex(src, idxNode Value) (res Value, err error) {
 	var idx int64
 	switch idxNode.Type {
-	case IntNT:
-		idx = idxNode.Val.(int64)
-	case FloatNT:
-		idx = int64(idxNode.Val.(float64))
+	case IntDT:
+		idx = idxNode.Int()
+	case FloatDT:
+		idx = int64(idxNode.Float())
 	default:
 		return FAIL, nil
 	}
 
 	var length int64
+	var runes []rune
 	switch src.Type {
-	case ListNT:
-		length = int64(len(src.Val.(List)))
-	case StringNT:
-		length = int64(len(src.Val.(string)))
-
+	case ListDT:
+		length = int64(src.List().Len())
+	case TupleDT:
+		length = int64(len(src.Tuple()))
+	case StringDT:
+		// strings are indexed by character rather than byte
+		runes = []rune(src.Str())
+		length = int64(len(runes))
 	}
 
 	if idx >= length {
@@ -454,19 +596,20 @@ func getByIndex(src, idxNode *Node) (res *Node, err error) {
 	}
 
 	if idx < 0 {
-		return src.Val.(List)[length+idx], nil
+		idx += length
 	}
 
-	if src.Type == StringNT {
-		return newString(string(src.Val.(string)[idx])), nil
+	if src.Type == StringDT {
+		return NewString(string(runes[idx])), nil
+	}
+	if src.Type == TupleDT {
+		return src.Tuple()[idx], nil
 	}
-	return src.Val.(List)[idx], nil
+	return src.List().Get(int(idx)), nil
 }
 
-func getByName(src, nameNode *Node) (res *Node, err error) {
-	obj := src.Val.(Object)
-
-	val, ok := obj[nameNode.toValue()]
+func getByName(src, nameNode Value) (res Value, err error) {
+	val, ok := src.Object().Get(nameNode)
 	if !ok {
 		return FAIL, nil
 	}
@@ -487,45 +630,69 @@ func getModuleName(path string) string {
 	return filenamePieces[0]
 }
 
-func importModule(n *Node, env *Environment) (res *Node, err error) {
+func importModule(n *Node, env *Environment) (res Value, err error) {
 	pwd, err := os.Getwd()
 	if err != nil {
-		return nil, err
+		return Value{}, err
 	}
 
+	// modules are declared in the scope just inside the built-in functions, which is the globals of
+	// the program importing them, or a scope of its own for a module
 	top := env
-	for top.Parent != nil {
+	for top.Parent != nil && top.Parent.Parent != nil {
 		top = top.Parent
 	}
 
 	pathVal := n.Val.(string)
 	pathElems := strings.Split(pathVal, "/")
 
-	path := pwd + "/"
+	rel := ""
 	for _, elem := range pathElems[:len(pathElems)-1] {
 		if elem == "." {
 			continue
 		}
-		path += elem + "/"
+		rel += elem + "/"
+	}
+	rel += pathElems[len(pathElems)-1]
+
+	path, err := env.limits().resolvePath(rel, pwd)
+	if err != nil {
+		return Value{}, err
 	}
-	path += pathElems[len(pathElems)-1]
 
 	file, err := ioutil.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to import from path \"%s\": %s", path, err.Error())
+		return Value{}, fmt.Errorf("Failed to import from path \"%s\": %s", path, err.Error())
 	}
 
-	ts := Scan(string(file))
+	ts, err := Scan(string(file))
+	if err != nil {
+		if se, ok := err.(ScanErrors); ok {
+			return Value{}, fmt.Errorf("Failed to scan module at path \"%s\":\n%s", path, se.Render(string(file)))
+		}
+		return Value{}, fmt.Errorf("Failed to scan module at path \"%s\": %s", path, err.Error())
+	}
 	modRoot, err := Parse(ts)
 	if err != nil {
-		return nil, fmt.Errorf("Failed to parse module at path \"%s\": %s", path, err.Error())
+		if pe, ok := err.(*ParseError); ok {
+			return Value{}, fmt.Errorf("Failed to parse module at path \"%s\": %s", path, pe.Render(string(file)))
+		}
+		return Value{}, fmt.Errorf("Failed to parse module at path \"%s\": %s", path, err.Error())
+	}
+	if err := Resolve(modRoot); err != nil {
+		return Value{}, fmt.Errorf("Failed to resolve module at path \"%s\":\n%s", path, err.(ResolveErrors).Render(string(file)))
 	}
 
-	modEnv := newScope(&Environment{Consts: map[string]*Node{}})
+	// the module sees the same built-in functions as the program importing it
+	modEnv := newScope(&Environment{Parent: top.Parent, Consts: map[string]Value{}, sandbox: env.limits(), depth: env.depth, maxDepth: env.maxDepth, vm: env.vm})
 
-	_, err = Interpret(modRoot, modEnv)
+	if env.vm {
+		_, err = Run(Compile(modRoot), modEnv)
+	} else {
+		_, err = Interpret(modRoot, modEnv)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("Encountered error while importing \"%s\": %s", path, err.Error())
+		return Value{}, fmt.Errorf("Encountered error while importing \"%s\": %s", path, err.Error())
 	}
 
 	var modName string
@@ -535,83 +702,415 @@ func importModule(n *Node, env *Environment) (res *Node, err error) {
 		modName = getModuleName(path)
 	}
 
-	module := &Node{
-		Type: ModuleNT,
-		Val:  modName,
-		Scope: &Environment{
-			Consts: modEnv.Consts,
-		},
+	// a module exports its constants
+	exports := map[string]Value{}
+	modEnv.mu.RLock()
+	for k, v := range modEnv.Consts {
+		exports[k] = v
 	}
+	modEnv.mu.RUnlock()
 
-	top.Consts[modName] = module
+	top.setConst(modName, newModule(modName, &Environment{Consts: exports}))
 
 	return SUCCESS, nil
 }
 
 func newScope(parent *Environment) *Environment {
 	return &Environment{
-		Parent: parent,
-		Consts: map[string]*Node{},
-		Vars:   map[string]*Node{},
+		Parent:   parent,
+		Consts:   map[string]Value{},
+		Vars:     map[string]Value{},
+		sandbox:  parent.limits(),
+		depth:    parent.depth,
+		maxDepth: parent.maxDepth,
+		vm:       parent.vm,
+	}
+}
+
+// newFrame creates the scope of a function call, spawned task or program, with a slot for each of
+// its locals. Its limits come from the scope it's run from. Frames run by the VM have room for its
+// stack after their slots.
+func newFrame(parent, caller *Environment, l *layout, stack int) *Environment {
+	return &Environment{
+		Parent:   parent,
+		sandbox:  caller.limits(),
+		depth:    caller.depth,
+		maxDepth: caller.maxDepth,
+		vm:       caller.vm,
+		slots:    make([]Value, len(l.names), len(l.names)+stack),
+		layout:   l,
+	}
+}
+
+// callFrame creates the scope of a call of a lambda, nested in depth calls. Its parent is where the
+// lambda was created, and it's given the index of the function calling it (like map), if it uses it.
+func callFrame(f *function, l *layout, caller *Environment, depth, stack int) *Environment {
+	parent := caller
+	if f.scope != nil {
+		parent = f.scope
+	}
+
+	frame := newFrame(parent, caller, l, stack)
+	frame.depth = depth
+	if l.index >= 0 {
+		frame.slots[l.index], _ = caller.get("index")
+	}
+	return frame
+}
+
+// SetMaxDepth changes the most function calls that may be nested in programs run in an environment,
+// and in any scopes created by them, from DefaultMaxDepth. It should be called before programs are
+// run.
+func (env *Environment) SetMaxDepth(depth int) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.maxDepth = depth
+}
+
+// checkDepth raises an error if a frame is nested in more calls than its maximum depth, which its
+// sandbox's Depth limit may lower
+func (env *Environment) checkDepth() error {
+	max := env.maxDepth
+	if max == 0 {
+		max = DefaultMaxDepth
+	}
+	if s := env.limits(); s != nil && s.Depth > 0 && s.Depth < max {
+		max = s.Depth
+	}
+	if env.depth > max {
+		return &StackOverflowError{Depth: max}
+	}
+	return nil
+}
+
+// layoutOf gives the layout of the frame of a lambda, spawn expression or program
+func layoutOf(n *Node) *layout {
+	if n.res == nil {
+		return &layout{index: -1}
+	}
+	return n.res.layout
+}
+
+// bindParam gives a parameter of a new call frame its argument
+func bindParam(frame *Environment, param *Node, arg Value) {
+	if param.Val != nil {
+		frame.bind(param, arg)
+		return
+	}
+
+	// destructured parameter
+	idents := targetIdents(param.L)
+	for i, val := range destructure(param.L, arg) {
+		frame.bind(idents[i], val)
+	}
+}
+
+// bind sets the local an identifier declares, or its name if it wasn't resolved, in a frame that
+// isn't shared yet
+func (env *Environment) bind(ident *Node, val Value) {
+	if ident.res != nil {
+		env.slots[ident.res.slot] = val
+		return
+	}
+	if env.Vars == nil {
+		env.Vars = map[string]Value{}
+	}
+	env.Vars[ident.Val.(string)] = val
+}
+
+// frame finds the frame of the function running in a scope, or of one depth functions up from it.
+// Scopes that aren't frames, like those map declares index in, are skipped.
+func (env *Environment) frame(depth int) *Environment {
+	for e := env; e != nil; e = e.Parent {
+		if e.layout == nil {
+			continue
+		}
+		if depth == 0 {
+			return e
+		}
+		depth--
+	}
+	return nil
+}
+
+// globals gives the scope a program's top-level declarations are made in, which is the scope it's
+// run in rather than its frame
+func (env *Environment) globals() *Environment {
+	if env.layout != nil {
+		return env.Parent
+	}
+	return env
+}
+
+// limits returns the sandbox of the program running in a scope, or nil
+func (env *Environment) limits() *sandbox {
+	if env == nil {
+		return nil
+	}
+	return env.sandbox
+}
+
+// Lookup finds the value of a name declared in this scope or any of its parents
+func (env *Environment) Lookup(name string) (Value, bool) {
+	for curr := env; curr != nil; curr = curr.Parent {
+		if val, ok := curr.get(name); ok {
+			return val, true
+		}
+	}
+	return Value{}, false
+}
+
+// Define declares a constant in this scope, replacing any constant or variable already declared
+// with the same name
+func (env *Environment) Define(name string, val Value) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	delete(env.Vars, name)
+	if env.Types != nil {
+		delete(env.Types, name)
+	}
+	if env.Consts == nil {
+		env.Consts = map[string]Value{}
+	}
+	env.Consts[name] = val
+}
+
+// get looks up an identifier declared in this scope, not including its parents
+func (env *Environment) get(ident string) (Value, bool) {
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+	if val, ok := env.Consts[ident]; ok {
+		return val, true
+	}
+	val, ok := env.Vars[ident]
+	return val, ok
+}
+
+// defines reports whether an identifier is declared in this scope as a constant or a variable
+func (env *Environment) defines(ident string) (isConst, isVar bool) {
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+	_, isConst = env.Consts[ident]
+	_, isVar = env.Vars[ident]
+	return isConst, isVar
+}
+
+// declare adds a constant or variable to this scope, unless it is already declared here
+func (env *Environment) declare(ident string, val Value, constant bool) error {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if _, exists := env.Consts[ident]; exists {
+		return fmt.Errorf("\"%s\" is already defined", ident)
+	}
+	if _, exists := env.Vars[ident]; exists {
+		return fmt.Errorf("\"%s\" is already defined", ident)
+	}
+	if constant {
+		if env.Consts == nil {
+			env.Consts = map[string]Value{}
+		}
+		env.Consts[ident] = val
+	} else {
+		if env.Vars == nil {
+			env.Vars = map[string]Value{}
+		}
+		env.Vars[ident] = val
+	}
+	return nil
+}
+
+func (env *Environment) setConst(ident string, val Value) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.Consts == nil {
+		env.Consts = map[string]Value{}
 	}
+	env.Consts[ident] = val
 }
 
-func copyNode(n *Node) *Node {
-	return &Node{
-		Type:  n.Type,
-		Val:   n.Val,
-		Func:  n.Func,
-		Scope: n.Scope,
-		L:     n.L,
-		R:     n.R,
+func (env *Environment) setVar(ident string, val Value) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.Vars == nil {
+		env.Vars = map[string]Value{}
 	}
+	env.Vars[ident] = val
 }
 
-func newInt(val int64) *Node {
-	return &Node{
-		Type: IntNT,
-		Val:  val,
+// share locks the slots of a frame from now on, before a closure or task is given it. Once it's
+// shared, tasks may be reading owned, so it isn't written again.
+func (env *Environment) share() {
+	if env.owned {
+		env.owned = false
 	}
 }
 
-func newFloat(val float64) *Node {
-	return &Node{
-		Type: FloatNT,
-		Val:  val,
+// slot gets the value of a local of a frame, which is the zero Value until it's declared
+func (env *Environment) slot(i int) Value {
+	if env.owned {
+		return env.slots[i]
 	}
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+	return env.slots[i]
 }
 
-func newBool(val bool) *Node {
-	return &Node{
-		Type: BoolNT,
-		Val:  val,
+func (env *Environment) setSlot(i int, val Value) {
+	if env.owned {
+		env.slots[i] = val
+		return
 	}
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.slots[i] = val
 }
 
-func newString(val string) *Node {
-	return &Node{
-		Type: StringNT,
-		Val:  val,
+// clear clears the locals from start up to end, at the start of an iteration of a loop
+func (env *Environment) clear(start, end int) {
+	if !env.owned {
+		env.mu.Lock()
+		defer env.mu.Unlock()
+	}
+	for i := start; i < end; i++ {
+		env.slots[i] = Value{}
+	}
+}
+
+// assign assigns a value to a local that's a variable, checking it against its annotated type
+func (env *Environment) assign(slot int, ident *Node, val Value) error {
+	l := env.layout
+	if l.consts[slot] {
+		return fmt.Errorf("Cannot assign to constant variable \"%s\"", l.names[slot])
+	}
+	if t := l.types[slot]; t != nil {
+		var err error
+		if val, err = conformVar(l.names[slot], t, val, ident.Line); err != nil {
+			return err
+		}
 	}
+	env.setSlot(slot, val)
+	return nil
 }
 
-func newSet(val Set) *Node {
-	return &Node{
-		Type: SetNT,
-		Val:  val,
+func (env *Environment) declareType(ident string, t *Type) {
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	if env.Types == nil {
+		env.Types = map[string]*Type{}
 	}
+	env.Types[ident] = t
+}
+
+// typeOf returns the annotated type of a variable declared in this scope
+func (env *Environment) typeOf(ident string) (*Type, bool) {
+	env.mu.RLock()
+	defer env.mu.RUnlock()
+	t, ok := env.Types[ident]
+	return t, ok
+}
+
+// annotation returns the annotated type of a declared identifier or parameter, or the return
+// type of a lambda
+func annotation(n *Node) *Type {
+	ann := n.L
+	if n.Type == LambdaNT {
+		ann, _ = n.Val.(*Node)
+	}
+	if ann == nil || ann.Type != TypeNT {
+		return nil
+	}
+	return ann.Val.(*Type)
+}
+
+var kindDataTypes map[TypeKind]DataType = map[TypeKind]DataType{
+	IntTK:     IntDT,
+	FloatTK:   FloatDT,
+	BoolTK:    BoolDT,
+	StringTK:  StringDT,
+	NullTK:    NullDT,
+	SuccessTK: SuccessDT,
+	FailTK:    FailDT,
+	ListTK:    ListDT,
+	SetTK:     SetDT,
+	ObjectTK:  ObjectDT,
+	TupleTK:   TupleDT,
+	LambdaTK:  LambdaDT,
+	ModuleTK:  ModuleDT,
+	TaskTK:    TaskDT,
+	ChannelTK: ChannelDT,
 }
 
-func newObject(val Object) *Node {
-	return &Node{
-		Type: ObjectNT,
-		Val:  val,
+// conform checks a value against an annotated type, converting Ints where a Float is expected
+func conform(v Value, t *Type) (Value, bool) {
+	switch t.Kind {
+	case AnyTK:
+		return v, true
+	case UnionTK:
+		// prefer a member the value matches exactly over converting it
+		for _, m := range t.Members {
+			if m.Kind != FloatTK || v.Type != IntDT {
+				if res, ok := conform(v, m); ok {
+					return res, true
+				}
+			}
+		}
+		for _, m := range t.Members {
+			if m.Kind == FloatTK {
+				return conform(v, m)
+			}
+		}
+		return v, false
+	case FloatTK:
+		if v.Type == IntDT {
+			return NewFloat(float64(v.Int())), true
+		}
+	case ListTK:
+		if v.Type != ListDT || t.Elem == nil {
+			break
+		}
+		// the list is copied, but only the items conform changes are replaced in the copy
+		items, ok := v.List(), true
+		v.List().each(func(i int, item Value) bool {
+			var conformed Value
+			conformed, ok = conform(item, t.Elem)
+			if ok && (conformed.Type != item.Type || item.Type == ListDT) {
+				items = items.Update(i, conformed)
+			}
+			return ok
+		})
+		if !ok {
+			return v, false
+		}
+		return NewList(items), true
+	case SetTK:
+		if v.Type != SetDT || t.Elem == nil {
+			break
+		}
+		for _, item := range v.Set().Members() {
+			if _, ok := conform(item, t.Elem); !ok {
+				return v, false
+			}
+		}
+		return v, true
 	}
+
+	return v, v.Type == kindDataTypes[t.Kind]
 }
 
-func newList(val List) *Node {
-	return &Node{
-		Type: ListNT,
-		Val:  val,
+// typeName describes the type of a value in error messages
+func typeName(v Value) string {
+	switch v.Type {
+	case SuccessDT:
+		return "Success"
+	case FailDT:
+		return "Fail"
+	case ModuleDT:
+		return "Module"
+	}
+	for k, dt := range kindDataTypes {
+		if v.Type == dt {
+			return typeKindNames[k]
+		}
 	}
+	return v.Type.ToString()
 }
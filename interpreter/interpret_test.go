@@ -1,78 +1,116 @@
 package interpreter
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
 
 type ExprTest struct {
 	input        string
-	resultType   NodeType
+	resultType   DataType
 	resultString string // S-expression representing AST
 }
 
+// engines run a program with the tree-walking interpreter, and compiled on the VM
+var engines = []struct {
+	name       string
+	run        func(*Node, *Environment) (Value, error)
+	runContext func(context.Context, *Node, *Environment) (Value, error)
+}{
+	{"interpreter", Interpret, InterpretContext},
+	{
+		"vm",
+		func(n *Node, env *Environment) (Value, error) { return Run(Compile(n), env) },
+		func(ctx context.Context, n *Node, env *Environment) (Value, error) {
+			return RunContext(ctx, Compile(n), env)
+		},
+	},
+}
+
 func runExprTest(test ExprTest, t *testing.T) {
-	tkns := Scan(test.input)
-	ast, err := Parse(tkns)
+	runExprTestWith(test, t, func(*Environment) {})
+}
+
+// runExprTestWith runs a test like runExprTest, preparing each environment it's run in with setup
+func runExprTestWith(test ExprTest, t *testing.T, setup func(*Environment)) {
+	ast, err := scanAndParse(test.input)
 
 	if err != nil {
 		t.Fatalf(`Failed to parse "%s": %s`, test.input, err.Error())
 	}
 
-	env := &Environment{
-		Parent: &Environment{
-			Consts: StdLib,
-		},
-		Consts: map[string]*Node{},
-		Vars:   map[string]*Node{},
-	}
+	for _, engine := range engines {
+		env := &Environment{
+			Parent: &Environment{
+				Consts: StdLib,
+			},
+			Consts: map[string]Value{},
+			Vars:   map[string]Value{},
+		}
+		setup(env)
 
-	res, err := Interpret(ast, env)
+		res, err := engine.run(ast, env)
 
-	if err != nil {
-		t.Fatalf(`Failed to evaluate "%s": %s`, test.input, err.Error())
-	}
+		if err != nil {
+			t.Fatalf(`Failed to evaluate "%s" (%s): %s`, test.input, engine.name, err.Error())
+		}
 
-	if res.Type != test.resultType || removeWhitespace(res.ToString()) != removeWhitespace(test.resultString) {
-		t.Fatalf(`Evaluated "%s" incorrectly:
-			Expected: %s (type %s)
-			Received: %s (type %s)
-			`,
-			test.input,
-			test.resultString,
-			test.resultType.ToString(),
-			res.ToString(),
-			res.Type.ToString(),
-		)
+		if res.Type != test.resultType || removeWhitespace(res.ToString()) != removeWhitespace(test.resultString) {
+			t.Fatalf(`Evaluated "%s" incorrectly (%s):
+				Expected: %s (type %s)
+				Received: %s (type %s)
+				`,
+				test.input,
+				engine.name,
+				test.resultString,
+				test.resultType.ToString(),
+				res.ToString(),
+				res.Type.ToString(),
+			)
+		}
 	}
 }
 
 func TestInterpretSimpleExpr(t *testing.T) {
 	tests := []ExprTest{
 		// arithmetic, logic, conditional
-		{`1`, IntNT, `1`},
-		{`2 + 2`, IntNT, `4`},
-		{`2 + 2 == 4`, BoolNT, `true`},
-		{`2.0 ^ 3 != 8`, BoolNT, `false`},
-		{`2.0 ^ -3 < .2`, BoolNT, `false`},
-		{`1 + 2 * (3 - 4) <= 5 / 6.7`, BoolNT, `true`},
-		{`false and true or true and !null`, BoolNT, `true`},
-		{`"foo" if false`, FailNT, `fail`},
-		{`"foo" if "bar"? else "baz"`, StringNT, `"foo"`},
+		{`1`, IntDT, `1`},
+		{`2 + 2`, IntDT, `4`},
+		{`2 + 2 == 4`, BoolDT, `true`},
+		{`2.0 ^ 3 != 8`, BoolDT, `false`},
+		{`2.0 ^ -3 < .2`, BoolDT, `false`},
+		{`1 + 2 * (3 - 4) <= 5 / 6.7`, BoolDT, `true`},
+		{`false and true or true and !null`, BoolDT, `true`},
+		{`"foo" if false`, FailDT, `fail`},
+		{`"foo" if "bar"? else "baz"`, StringDT, `"foo"`},
 		// collections, dot/bracket/slice access
-		{`[1, 2, 3]`, ListNT, `[1, 2, 3]`},
-		{`[1, 2, 3] + [4, 5, 6]`, ListNT, `[1, 2, 3, 4, 5, 6]`},
-		{`#[1, 2, 3]`, IntNT, `3`},
-		{`[1, 2, 3][5]`, FailNT, `fail`},
-		{`[1, 2, 3][-1]`, IntNT, `3`},
-		{`"cherry" in {"apple", "banana"}`, BoolNT, `false`},
-		{`{ a: true }.a`, BoolNT, `true`},
-		{`{ a: [{}, { "foo": {"bar"} }] }.a[1].foo`, SetNT, `{ "bar" }`},
-		{`10 in 2..20`, BoolNT, `true`},
-		{`(..10)[3..7]`, ListNT, `[3,4,5,6]`},
-		{`[3.14][1..]`, ListNT, `[]`},
-		{`"foobarbaz"[3..6]`, StringNT, `"bar"`},
+		{`[1, 2, 3]`, ListDT, `[1, 2, 3]`},
+		{`[1, 2, 3] + [4, 5, 6]`, ListDT, `[1, 2, 3, 4, 5, 6]`},
+		{`#[1, 2, 3]`, IntDT, `3`},
+		{`[1, 2, 3][5]`, FailDT, `fail`},
+		{`[1, 2, 3][-1]`, IntDT, `3`},
+		{`"cherry" in {"apple", "banana"}`, BoolDT, `false`},
+		{`{ a: true }.a`, BoolDT, `true`},
+		{`{ a: [{}, { "foo": {"bar"} }] }.a[1].foo`, SetDT, `{ "bar" }`},
+		{`10 in 2..20`, BoolDT, `true`},
+		{`(..10)[3..7]`, ListDT, `[3,4,5,6]`},
+		{`[3.14][1..]`, ListDT, `[]`},
+		{`"foobarbaz"[3..6]`, StringDT, `"bar"`},
 		// lambdas, calls
-		{`print("hello, world")`, SuccessNT, `success`},
-		{`x => x + 1`, LambdaNT, `(lambda (param) (+ x 1))`},
-		{`((a, b) => a if a > b else b)(-5, 7)`, IntNT, `7`},
+		{`print("hello, world")`, SuccessDT, `success`},
+		{`x => x + 1`, LambdaDT, `(lambda (param) (+ x 1))`},
+		{`((a, b) => a if a > b else b)(-5, 7)`, IntDT, `7`},
+		// match
+		{`match 2 { 1 => "one", 2 => "two" }`, StringDT, `"two"`},
+		{`match [1, 2, 3] { [a, b] => a + b, [h, ...t] => t }`, ListDT, `[2, 3]`},
+		{`match { kind: "circle", r: 2 } { { kind: "square", s } => s, { kind: "circle", r } => r }`, IntDT, `2`},
+		{`match 7 { n if n > 10 => "big", _ => "small" }`, StringDT, `"small"`},
+		{`match "x" { 1 => "one" }`, FailDT, `fail`},
+		{`match "x" { 1 => "one" } | "none"`, StringDT, `"none"`},
 	}
 
 	for _, test := range tests {
@@ -86,12 +124,12 @@ func TestInterpretStmt(t *testing.T) {
 		{`
 			x := 1
 			x
-		`, IntNT, `1`},
+		`, IntDT, `1`},
 		{`
 			var x := 1
 			x = "one"
 			x
-		`, StringNT, `"one"`},
+		`, StringDT, `"one"`},
 		{`
 			var x := 1
 			x += 2
@@ -99,17 +137,891 @@ func TestInterpretStmt(t *testing.T) {
 			x -= 4
 			x /= 2
 			x
-		`, FloatNT, `2.5`},
+		`, FloatDT, `2.5`},
 		{`
 			var foo := {}
 			foo.bar = [1,2,3]
 			foo.bar
 			// foo.bar[1] = { baz: false }
 		`, // known bug! updating a list inside an object (a Go slice inside a map) will require some workarounds: https://stackoverflow.com/questions/69475165/golang-does-not-update-array-in-a-map
-			ListNT, `[1,2,3]`},
+			ListDT, `[1,2,3]`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+}
+
+func TestInterpretLoops(t *testing.T) {
+	tests := []ExprTest{
+		// break and continue apply to the innermost loop
+		{`
+			var count := 0
+			for i in ..3 {
+				for j in ..3 {
+					if j == 1: break
+					count += 1
+				}
+			}
+			count
+		`, IntDT, `3`},
+		{`
+			var total := 0
+			for i in ..5 {
+				if i % 2 == 0: continue
+				total += i
+			}
+			total
+		`, IntDT, `4`},
+		// continue skips the rest of the body
+		{`
+			var out := []
+			for i <- ..5 {
+				if i == 2: continue
+				out += [i]
+			}
+			out
+		`, ListDT, `[0, 1, 3, 4]`},
+		{`
+			var out := []
+			var i := 0
+			while i < 5 {
+				i += 1
+				if i == 2: continue
+				out += [i]
+			}
+			out
+		`, ListDT, `[1, 3, 4, 5]`},
+		// the value of a loop is the value of its last iteration that wasn't stopped
+		{`
+			f := () => {
+				for i <- ..3 {
+					if i == 1: break
+					i * 10
+				}
+			}
+			var calls := 0
+			for j <- ..3 {
+				f()
+				calls += 1
+			}
+			[f(), calls]
+		`, ListDT, `[0, 3]`},
+		// return leaves the function straight away
+		{`
+			f := n => {
+				for i in ..10 {
+					if i == n: return i * 10
+				}
+				-1
+			}
+			g := () => {
+				var i := 0
+				while true {
+					i += 1
+					if i == 3: return i
+				}
+				-1
+			}
+			[f(3), f(20), g()]
+		`, ListDT, `[30, -1, 3]`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+}
+
+func TestInterpretTypeAnnotations(t *testing.T) {
+	tests := []ExprTest{
+		{`
+			x: Int = 4
+			x
+		`, IntDT, `4`},
+		{`
+			var x: Float = 1
+			x += 1
+			x
+		`, FloatDT, `2`},
+		{`
+			var s: String? = "foo"
+			s = fail
+			s
+		`, FailDT, `fail`},
+		{`
+			avg := (a: Float, b: Float): Float => (a + b) / 2 | 0.0
+			avg(1, 2)
+		`, FloatDT, `1.5`},
+		{`
+			f := (xs: List[Int]) => #xs
+			f([1, 2, 3])
+		`, IntDT, `3`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+
+	errTests := []struct {
+		input, err string
+	}{
+		{`x: Int = "four"`, `Line 1: Type mismatch for "x". Expected Int, received String.`},
+		{`
+			var x: Int = 1
+			x = 1.5
+		`, `Line 3: Type mismatch for "x". Expected Int, received Float.`},
+		{`
+			f := (a: Int) => a
+			f("a")
+		`, `Line 2: Type mismatch for argument "a" of function "f". Expected Int, received String.`},
+		{`
+			f := (): String => 1
+			f()
+		`, `Line 2: Type mismatch for return value of function "f". Expected String, received Int.`},
+		{`xs: List[Int] = [1, "2"]`, `Line 1: Type mismatch for "xs". Expected List[Int], received List.`},
+	}
+
+	for _, test := range errTests {
+		ast, err := scanAndParse(test.input)
+		if err != nil {
+			t.Fatalf(`Failed to parse "%s": %s`, test.input, err.Error())
+		}
+
+		for _, engine := range engines {
+			_, err = engine.run(ast, &Environment{
+				Parent: &Environment{Consts: StdLib},
+				Consts: map[string]Value{},
+				Vars:   map[string]Value{},
+			})
+			if err == nil || err.Error() != test.err {
+				t.Fatalf(`Evaluated "%s" incorrectly (%s):
+					Expected error: %s
+					Received: %v
+					`, test.input, engine.name, test.err, err)
+			}
+		}
+	}
+}
+
+func TestInterpretUnicode(t *testing.T) {
+	tests := []ExprTest{
+		{`#"héllo"`, IntDT, `5`},
+		{`"héllo"[1]`, StringDT, `"é"`},
+		{`"héllo"[-1]`, StringDT, `"o"`},
+		{`"日本語テキスト"[1..3]`, StringDT, `"本語"`},
+		{`"日本語"[5]`, FailDT, `fail`},
+		{`split("añb", "")`, ListDT, `["a", "ñ", "b"]`},
+		{`
+			größe := 3
+			名前 := "rye"
+			größe + #名前
+		`, IntDT, `6`},
+		{`codepoints("aé")`, ListDT, `[97, 233]`},
+		{`fromCodepoints([82, 121, 233])`, StringDT, `"Ryé"`},
+		{`fromCodepoints([-1])`, FailDT, `fail`},
+		{`fromCodepoints([4294967361])`, FailDT, `fail`},
+		{`fromCodepoints([1114111])`, StringDT, "\"\U0010FFFF\""},
+		{`#graphemes("e\u0301👍🏽🇯🇵👨\u200d👩\u200d👧")`, IntDT, `4`},
+		{`graphemes("ne\u0301e")[1]`, StringDT, "\"e\u0301\""},
 	}
 
 	for _, test := range tests {
 		runExprTest(test, t)
 	}
 }
+
+func TestInterpretStringInterpolation(t *testing.T) {
+	tests := []ExprTest{
+		{`
+			name := "Ada"
+			msgs := [1, 2, 3]
+			"Hello, ${name}! You have ${#msgs} messages"
+		`, StringDT, `"Hello, Ada! You have 3 messages"`},
+		{`"${[1, 2] map _ * 2} ${ {a: "x"} } ${fail} ${null}"`, StringDT, `"[2, 4] {"a": "x"} fail null"`},
+		{`"outer ${ "inner ${1 + 1}" }"`, StringDT, `"outer inner 2"`},
+		{`"costs \${5}"`, StringDT, `"costs ${5}"`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+}
+
+func TestInterpretFold(t *testing.T) {
+	tests := []ExprTest{
+		{`[1, 2, 3, 4] fold (acc, x) => acc + x`, IntDT, `10`},
+		{`[1, 2, 3, 4] fold (acc, x) => acc + x from 10`, IntDT, `20`},
+		{`["a", "b", "c"] fold (acc, x) => acc + x + index from ""`, StringDT, `"a0b1c2"`},
+		{`[3, 9, 4] fold max`, IntDT, `9`},
+		{`[] fold (acc, x) => acc + x`, FailDT, `fail`},
+		{`[] fold (acc, x) => acc + x from 0`, IntDT, `0`},
+		{`5 fold (acc, x) => acc + x from 0`, FailDT, `fail`},
+		{`{1, 2, 3} fold (acc, x) => acc + x from 0`, IntDT, `6`},
+		{`[1, 2, 3] map _ * 2 fold (a, b) => a + b from 0 then String`, StringDT, `"12"`},
+		{`fold([1, 2, 3], 1, (acc, x) => acc * x)`, IntDT, `6`},
+		{`reduce(["x", "y"], (acc, x) => acc + x)`, StringDT, `"xy"`},
+		{`reduce([], max)`, FailDT, `fail`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+}
+
+func TestInterpretBind(t *testing.T) {
+	tests := []ExprTest{
+		{`2 bind x => x + 1`, IntDT, `3`},
+		{`fail bind x => x + 1`, FailDT, `fail`},
+		{`"4" bind Int bind n => n * 2`, IntDT, `8`},
+		{`"four" bind Int bind n => n * 2`, FailDT, `fail`},
+		{`{a: 1} bind o => o.a bind a => [10, 20][a] bind v => v + a`, IntDT, `21`},
+		{`{a: 1} bind o => o.b bind b => b + 1`, FailDT, `fail`},
+		{`
+			dec := n => n - 1 if n > 0
+			3 bind dec bind dec bind dec
+		`, IntDT, `0`},
+		{`
+			dec := n => n - 1 if n > 0
+			2 bind dec bind dec bind dec
+		`, FailDT, `fail`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+}
+
+func TestInterpretComprehensions(t *testing.T) {
+	tests := []ExprTest{
+		{`[x * 2 for x <- [1, 2, 3]]`, ListDT, `[2, 4, 6]`},
+		{`[x for x <- ..10 if x % 3 == 0]`, ListDT, `[0, 3, 6, 9]`},
+		{`[[x, y] for x <- [1, 2], y <- [1, 2] if x != y]`, ListDT, `[[1, 2], [2, 1]]`},
+		{`[x + y for x <- [1, 2], y <- [x * 10]]`, ListDT, `[11, 22]`},
+		{`#{x % 2 for x <- [1, 2, 3, 4]}`, IntDT, `2`},
+		{`{k: v * 2 for k, v <- pairs({a: 1})}`, ObjectDT, `{"a": 2}`},
+		{`#{"${x}": x for x <- [1, 2]}`, IntDT, `2`},
+		{`[a for [a, 1] <- [[1, 1], [2, 2], [3, 1]]]`, ListDT, `[1, 3]`},
+		{`[x for x <- []]`, ListDT, `[]`},
+		{`[a + b for [a, b] <- [[1, 2], [3, 4]]]`, ListDT, `[3, 7]`},
+		// list patterns destructure tuples too
+		{`[k for k, v <- [("a", 1), ("b", 2)]]`, ListDT, `["a", "b"]`},
+		{`{k: v for k, v <- [("a", 1)]}`, ObjectDT, `{"a": 1}`},
+		{`[rest for [_, ...rest] <- [(1, 2, 3)]]`, ListDT, `[[2, 3]]`},
+		{`[x for x <- 5]`, FailDT, `fail`},
+		{`
+			var total := 0
+			for x <- [1, 2, 3] {
+				total = total + x
+			}
+			total
+		`, IntDT, `6`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+}
+
+func TestInterpretTuples(t *testing.T) {
+	tests := []ExprTest{
+		{`(1, "a", 2.5)`, TupleDT, `(1, "a", 2.5)`},
+		{`typeof((1, 2))`, StringDT, `"Tuple"`},
+		{`#(1, 2, 3)`, IntDT, `3`},
+		{`(1, "a")[1]`, StringDT, `"a"`},
+		{`(1, "a")[-2]`, IntDT, `1`},
+		{`(1, "a")[2]`, FailDT, `fail`},
+		{`(1, (2, "b")) == (1, (2, "b"))`, BoolDT, `true`},
+		{`(1, 2) == (2, 1)`, BoolDT, `false`},
+		{`(1, 2) == (1, 2, 3)`, BoolDT, `false`},
+		{`#{(1, 2), (1, 2), (2, 1), (1.5, "a")}`, IntDT, `3`},
+		{`(1, 2) in {(1, 2)}`, BoolDT, `true`},
+		{`
+			o := {}
+			o[(0, 0)] = "origin"
+			o[(0, 0)]
+		`, StringDT, `"origin"`},
+		{`
+			s := {((1, 2), -3.5, fail)}
+			[t for t <- s]
+		`, ListDT, `[((1, 2), -3.5, fail)]`},
+		{`
+			divmod := (a, b) => {
+				return (a - a % b) / b, a % b
+			}
+			(q, r) := divmod(7, 2)
+			[q, r]
+		`, ListDT, `[3, 1]`},
+		{`
+			(a, b, c) := (1, 2)
+			[a, b, c]
+		`, ListDT, `[1, 2, fail]`},
+		{`
+			[a, b] := (1, 2)
+			{x, y: z} := {x: 3, y: 4}
+			[a, b, x, z]
+		`, ListDT, `[1, 2, 3, 4]`},
+		{`
+			dist := ((x1, y1), (x2, y2)) => (x2 - x1) ^ 2 + (y2 - y1) ^ 2
+			dist((0, 0), (3, 4))
+		`, IntDT, `25`},
+		{`
+			var total := 0
+			for (n, times) <- [(2, 3), (5, 2)] {
+				total += n * times
+			}
+			total
+		`, IntDT, `16`},
+		{`[k for (k, "x") <- [(1, "x"), (2, "y"), [3, "x"]]]`, ListDT, `[1]`},
+		{`match (0, 5) { (0, y) => y, (x, 0) => x, _ => -1 }`, IntDT, `5`},
+		{`match ("a", 1) { (k, 2) => "tuple", [k, v] => k }`, StringDT, `"a"`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+}
+
+func TestInterpretCollectionKeys(t *testing.T) {
+	tests := []ExprTest{
+		{`#{[1, 2], [1, 2], [2, 1]}`, IntDT, `2`},
+		{`[1, 2] in {[1, 2], {3}}`, BoolDT, `true`},
+		{`{3} in {[1, 2], {3}}`, BoolDT, `true`},
+		{`{1, 2} == {2, 1}`, BoolDT, `true`},
+		{`{a: 1, b: [2]} == {b: [2], a: 1}`, BoolDT, `true`},
+		{`{a: 1} == {a: 2}`, BoolDT, `false`},
+		{`
+			o := {}
+			o[[1, 2]] = "list"
+			o[{x: 1}] = "object"
+			[o[[1, 2]], o[{x: 1}], o[[2, 1]]]
+		`, ListDT, `["list", "object", fail]`},
+		{`
+			s := {{1, 2}}
+			{2, 1} in s
+		`, BoolDT, `true`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+}
+
+func TestInterpretPersistentCollections(t *testing.T) {
+	tests := []ExprTest{
+		// new versions share items with the original, without changing it
+		{`
+			xs := [1, 2]
+			[xs + [3], xs + [4], append(xs, 5), xs]
+		`, ListDT, `[[1, 2, 3], [1, 2, 4], [1, 2, 5], [1, 2]]`},
+		{`
+			xs := [..10]
+			ys := xs[..3]
+			[ys + [0], xs[7..] + [0], xs]
+		`, ListDT, `[[0, 1, 2, 0], [7, 8, 9, 0], [0, 1, 2, 3, 4, 5, 6, 7, 8, 9]]`},
+		{`
+			xs := [..5]
+			var ys := xs[1..3]
+			ys[0] = 10
+			[xs, ys]
+		`, ListDT, `[[0, 1, 2, 3, 4], [10, 2]]`},
+		{`
+			s := {1, 2}
+			[add(s, 3) == {1, 2, 3}, remove(s, 1) == {2}, s == {1, 2}]
+		`, ListDT, `[true, true, true]`},
+		{`
+			o := {a: 1}
+			p := {...o, b: 2}
+			[#o, #p]
+		`, ListDT, `[1, 2]`},
+		{`
+			xs := [0]
+			ys := 0..100 pmap n => xs + [n]
+			sum(ys map _[1])
+		`, IntDT, `4950`},
+		// assigning to an item is seen through every name for the collection
+		{`
+			xs := [1, 2]
+			ys := xs
+			xs[0] = 5
+			ys
+		`, ListDT, `[5, 2]`},
+		{`
+			o := {inner: {}}
+			o.inner.a = 1
+			o.inner
+		`, ObjectDT, `{"a": 1}`},
+		// larger than a node of the tree
+		{`
+			var xs := []
+			for i <- ..5000 {
+				xs += [i]
+			}
+			xs[4000] = -1
+			[#xs, xs[0], xs[1023], xs[4000], xs[4999], sum(xs)]
+		`, ListDT, `[5000, 0, 1023, -1, 4999, 12493499]`},
+		{`
+			o := {}
+			for i <- ..5000 {
+				o[i] = i * 2
+			}
+			s := Set(..5000) then remove(_, 10)
+			[#o, o[4321], #s, 10 in s, 11 in s]
+		`, ListDT, `[5000, 8642, 4999, false, true]`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+}
+
+func TestInterpretTailCalls(t *testing.T) {
+	// tail calls don't nest, so they can recurse deeper than the maximum depth
+	shallow := func(env *Environment) { env.SetMaxDepth(100) }
+
+	tests := []ExprTest{
+		{`
+			count := (n, total) => total if n == 0 else count(n - 1, total + 1)
+			count(1000, 0)
+		`, IntDT, `1000`},
+		{`
+			loop := n => {
+				if n == 0 {
+					return "done"
+				}
+				return loop(n - 1)
+			}
+			loop(1000)
+		`, StringDT, `"done"`},
+		{`
+			even := n => true if n == 0 else odd(n - 1)
+			odd := n => false if n == 0 else even(n - 1)
+			even(1001)
+		`, BoolDT, `false`},
+		{`
+			f := n => match n { 0 => "zero", _ => f(n - 1) }
+			f(1000)
+		`, StringDT, `"zero"`},
+		{`
+			f := (n): Float => 1 if n == 0 else g(n - 1)
+			g := n => f(n)
+			typeof(f(1000))
+		`, StringDT, `"Float"`},
+		{`
+			count := (n, total) => total if n == 0 else count(n - 1, total + 1)
+			task := spawn {
+				start := 0
+				count(1000, start)
+			}
+			await task
+		`, IntDT, `1000`},
+	}
+
+	for _, test := range tests {
+		runExprTestWith(test, t, shallow)
+	}
+
+	errTests := []struct {
+		input, err string
+	}{
+		{`
+			f := n => 0 if n == 0 else 1 + f(n - 1)
+			f(1000)
+		`, `Line 2: Stack overflow: more than 100 nested function calls`},
+		{`
+			f := (n): String => g(n)
+			g := n => n
+			f(1)
+		`, `Line 2: Type mismatch for return value of function "f". Expected String, received Int.`},
+		{`
+			f := n => g(n, 1)
+			g := n => n
+			f(1)
+		`, `Line 2: Too many arguments provided to function "g". Expected 1, received 2.`},
+	}
+
+	for _, test := range errTests {
+		ast, err := scanAndParse(test.input)
+		if err != nil {
+			t.Fatalf(`Failed to parse "%s": %s`, test.input, err.Error())
+		}
+
+		for _, engine := range engines {
+			env := &Environment{
+				Parent: &Environment{Consts: StdLib},
+				Consts: map[string]Value{},
+				Vars:   map[string]Value{},
+			}
+			shallow(env)
+			_, err = engine.run(ast, env)
+			if err == nil || err.Error() != test.err {
+				t.Fatalf(`Expected "%s" to raise %s (%s), received %v`, test.input, test.err, engine.name, err)
+			}
+		}
+	}
+}
+
+func TestInterpretTasks(t *testing.T) {
+	tests := []ExprTest{
+		{`await (spawn 1 + 2)`, IntDT, `3`},
+		{`typeof(spawn 1)`, StringDT, `"Task"`},
+		{`await 3`, FailDT, `fail`},
+		{`
+			square := x => x * x
+			tasks := 1..5 map n => spawn square(n)
+			tasks map t => await t
+		`, ListDT, `[1, 4, 9, 16]`},
+		{`
+			t := spawn {
+				xs := [1, 2, 3] map _ * 2
+				return xs[-1]
+			}
+			[await t, await t]
+		`, ListDT, `[6, 6]`},
+		{`
+			ch := Channel()
+			producer := spawn {
+				for i <- 1..5 {
+					send(ch, i)
+				}
+				close(ch)
+				return "done"
+			}
+			var total := 0
+			var n := receive(ch)
+			while n? {
+				total += n
+				n = receive(ch)
+			}
+			[total, await producer]
+		`, ListDT, `[10, "done"]`},
+		{`
+			ch := Channel(2)
+			send(ch, "a")
+			close(ch)
+			[receive(ch), receive(ch), send(ch, "b"), close(ch)]
+		`, ListDT, `["a", fail, fail, fail]`},
+		{`[Channel(-1), send(1, 2), receive("ch")]`, ListDT, `[fail, fail, fail]`},
+		// index is bound in a scope of its own, rather than the caller's
+		{`
+			[10, 20] map x => {
+				ys := [1, 2, 3] map _ * x
+				return index
+			}
+		`, ListDT, `[0, 1]`},
+		{`
+			tasks := 1..4 map n => spawn ([1, 2] map _ + index + n)
+			tasks map t => await t
+		`, ListDT, `[[2, 4], [3, 5], [4, 6]]`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+}
+
+func TestInterpretParallel(t *testing.T) {
+	tests := []ExprTest{
+		{`1..6 pmap _ * 2`, ListDT, `[2, 4, 6, 8, 10]`},
+		{`["a", "b", "c"] pmap _ + String(index)`, ListDT, `["a0", "b1", "c2"]`},
+		{`1..10 pwhere _ % 3 == 0`, ListDT, `[3, 6, 9]`},
+		{`#({1, 2, 3} pmap _ % 2)`, IntDT, `2`},
+		{`{1, 2, 3, 4} pwhere _ > 2 then #_`, IntDT, `2`},
+		{`[[1, 2], [3]] pmap #_`, ListDT, `[2, 1]`},
+		{`5 pmap _ * 2`, FailDT, `fail`},
+		{`[] pmap _ * 2`, ListDT, `[]`},
+		{`
+			collatz := n => match n {
+				1 => 0
+				n if n % 2 == 0 => 1 + collatz(Int(n / 2))
+				_ => 1 + collatz(3 * n + 1)
+			}
+			xs := 1..200
+			(xs pmap collatz) == (xs map collatz)
+		`, BoolDT, `true`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+
+	// the error of the earliest failing item is returned
+	ast, _ := scanAndParse(`1..100 pmap n => n + (undefinedThing if n > 40 else 0) + (otherThing if n > 50 else 0)`)
+	for _, engine := range engines {
+		_, err := engine.run(ast, &Environment{
+			Parent: &Environment{Consts: StdLib},
+			Consts: map[string]Value{},
+			Vars:   map[string]Value{},
+		})
+		if err == nil || err.Error() != `Line 1: "undefinedThing" is undefined` {
+			t.Fatalf(`Received the wrong error from pmap (%s): %v`, engine.name, err)
+		}
+	}
+}
+
+func TestInterpretRuntimeErrors(t *testing.T) {
+	src := `
+		divide := (a, b) => {
+			x := a + b
+			return x + undefinedThing
+		}
+		compute := n => 1 + divide(n, 2)
+		[1, 2] map compute
+	`
+	ast, err := scanAndParse(src)
+	if err != nil {
+		t.Fatalf(`Failed to parse "%s": %s`, src, err.Error())
+	}
+
+	for _, engine := range engines {
+		_, err = engine.run(ast, &Environment{
+			Parent: &Environment{Consts: StdLib},
+			Consts: map[string]Value{},
+			Vars:   map[string]Value{},
+		})
+
+		var re *RuntimeError
+		if !errors.As(err, &re) {
+			t.Fatalf(`Expected a RuntimeError from "%s" (%s), received %v`, src, engine.name, err)
+		}
+		if err.Error() != `Line 4: "undefinedThing" is undefined` {
+			t.Fatalf(`Received the wrong error from "%s" (%s): %s`, src, engine.name, err.Error())
+		}
+
+		expected := `Traceback (most recent call last):
+  Line 7, in <main>
+  Line 6, in compute
+  Line 4, in divide
+Error: "undefinedThing" is undefined`
+		if re.Traceback() != expected {
+			t.Fatalf("Received the wrong traceback (%s).\nExpected:\n%s\nReceived:\n%s", engine.name, expected, re.Traceback())
+		}
+
+		// errors from built-in functions are located at the call
+		builtinAst, _ := scanAndParse("x := 1\nuppercase(1, 2)")
+		_, err = engine.run(builtinAst, &Environment{
+			Parent: &Environment{Consts: StdLib},
+			Consts: map[string]Value{},
+			Vars:   map[string]Value{},
+		})
+		if !errors.As(err, &re) || re.Line != 2 || len(re.Stack) != 0 {
+			t.Fatalf(`Expected a RuntimeError on line 2 (%s), received %v`, engine.name, err)
+		}
+
+		// the frames replaced by tail calls keep the line of the outer call, and any between it
+		// and the last tail call are marked as elided
+		tailTests := []struct {
+			src, expected string
+		}{
+			{"f := n => n + undefinedThing\ng := z => f(z)\ng(1)", `Traceback (most recent call last):
+  Line 3, in <main>
+  Line 2, in g
+  Line 1, in f
+Error: "undefinedThing" is undefined`},
+			{"f := n => n + undefinedThing\nh := z => f(z)\ng := z => h(z)\ng(1)", `Traceback (most recent call last):
+  Line 4, in <main>
+  [Tail calls elided]
+  Line 2, in h
+  Line 1, in f
+Error: "undefinedThing" is undefined`},
+			{"f := n => n\ng := z => f(z, 2)\ng(1)", `Traceback (most recent call last):
+  Line 3, in <main>
+  Line 2, in g
+Error: Too many arguments provided to function "f". Expected 1, received 2.`},
+			// return values are checked in the frame of the call, and functions in fields are
+			// named after them
+			{"f := (a): Float => \"s\"\nx := 1 + f(1)", `Traceback (most recent call last):
+  Line 2, in <main>
+  Line 1, in f
+Error: Type mismatch for return value of function "f". Expected Float, received String.`},
+			{"f := (a): Float => \"s\"\ng := n => f(n)\nx := 1 + g(1)", `Traceback (most recent call last):
+  Line 3, in <main>
+  Line 2, in g
+  Line 1, in f
+Error: Type mismatch for return value of function "f". Expected Float, received String.`},
+			{"m := {hello: () => 1 + undefinedThing}\nx := 1 + m.hello()", `Traceback (most recent call last):
+  Line 2, in <main>
+  Line 1, in hello
+Error: "undefinedThing" is undefined`},
+		}
+		for _, test := range tailTests {
+			tailAst, _ := scanAndParse(test.src)
+			_, err = engine.run(tailAst, &Environment{
+				Parent: &Environment{Consts: StdLib},
+				Consts: map[string]Value{},
+				Vars:   map[string]Value{},
+			})
+			if !errors.As(err, &re) || re.Traceback() != test.expected {
+				t.Fatalf("Received the wrong traceback (%s).\nExpected:\n%s\nReceived:\n%v", engine.name, test.expected, err)
+			}
+		}
+	}
+}
+
+func TestInterpretSandbox(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "data.txt"), []byte("hi"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input  string
+		limits Limits
+		err    string
+	}{
+		{`var i := 0
+		while true { i = i + 1 }`, Limits{Steps: 1000}, `Line 2: Exceeded the step limit of 1000`},
+		{`f := n => 1 + f(n + 1)
+		f(0)`, Limits{Depth: 50}, `Line 1: Stack overflow: more than 50 nested function calls`},
+		{`1..1000000`, Limits{Items: 100}, `Line 1: Exceeded the item limit of 100`},
+		{`[1, 2, 3] + [4]`, Limits{Items: 3}, `Line 1: Exceeded the item limit of 3`},
+		{`"abc" + "def"`, Limits{StringSize: 5}, `Line 1: Exceeded the string size limit of 5`},
+		{`readFile("../data.txt")`, Limits{FSRoot: filepath.Join(dir, "root")}, `Line 1: Cannot access "` + filepath.Join(dir, "data.txt") + `", which is outside of the sandbox's root directory`},
+	}
+
+	for _, engine := range engines {
+		for _, test := range tests {
+			ast, err := scanAndParse(test.input)
+			if err != nil {
+				t.Fatalf(`Failed to parse "%s": %s`, test.input, err.Error())
+			}
+
+			env := &Environment{
+				Parent: &Environment{Consts: StdLib},
+				Consts: map[string]Value{},
+				Vars:   map[string]Value{},
+			}
+			env.Sandbox(context.Background(), test.limits)
+
+			_, err = engine.run(ast, env)
+			var le *LimitError
+			if err == nil || err.Error() != test.err {
+				t.Fatalf(`Expected "%s" to raise %s (%s), received %v`, test.input, test.err, engine.name, err)
+			}
+			// going over the depth limit is a stack overflow
+			if test.limits.FSRoot == "" && test.limits.Depth == 0 && !errors.As(err, &le) {
+				t.Fatalf(`Expected a LimitError from "%s" (%s), received %v`, test.input, engine.name, err)
+			}
+		}
+
+		// programs within the limits run as usual, with paths relative to the root
+		ast, _ := scanAndParse(`f := n => 0 if n == 0 else 1 + f(n - 1)
+		[f(10), readFile("data.txt"), #(1..10)]`)
+		env := &Environment{
+			Parent: &Environment{Consts: StdLib},
+			Consts: map[string]Value{},
+			Vars:   map[string]Value{},
+		}
+		env.Sandbox(context.Background(), Limits{Steps: 10000, Depth: 20, Items: 10, StringSize: 10, FSRoot: dir})
+		res, err := engine.run(ast, env)
+		if err != nil || res.ToString() != `[10, "hi", 9]` {
+			t.Fatalf(`Expected [10, "hi", 9] (%s), received %v (%v)`, engine.name, res, err)
+		}
+
+		// cancelling the context stops loops and blocked tasks
+		for _, src := range []string{`while true { x := 1 }`, `await (spawn receive(Channel()))`} {
+			ast, _ = scanAndParse(src)
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			env.Sandbox(ctx, Limits{})
+			_, err = engine.run(ast, env)
+			cancel()
+			if !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf(`Expected "%s" to time out (%s), received %v`, src, engine.name, err)
+			}
+		}
+	}
+}
+
+func TestInterpretImport(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"m.ry": "import \"./n.ry\"\ngreet := name => uppercase(name)\nshout := s => n.bang(greet(s))\nsecret := () => lowercase(\"A\")",
+		"n.ry": "bang := s => s + \"!\"",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, engine := range engines {
+		// functions of a module call the built-in functions of the program importing it, and its
+		// own imports aren't declared in the program
+		ast, _ := scanAndParse(`import "./m.ry"
+		[m.greet("hi"), m.shout("hey")]`)
+		env := &Environment{
+			Parent: &Environment{Consts: map[string]Value{"uppercase": StdLib["uppercase"], "lowercase": StdLib["lowercase"]}},
+			Consts: map[string]Value{},
+			Vars:   map[string]Value{},
+		}
+		env.Sandbox(context.Background(), Limits{FSRoot: dir})
+		res, err := engine.run(ast, env)
+		if err != nil || res.ToString() != `["HI", "HEY!"]` {
+			t.Fatalf(`Expected ["HI", "HEY!"] (%s), received %v (%v)`, engine.name, res, err)
+		}
+		if _, ok := env.Lookup("n"); ok {
+			t.Fatalf(`Expected the module's import to be undeclared in the program (%s)`, engine.name)
+		}
+
+		// and only those the program may call
+		ast, _ = scanAndParse(`import "./m.ry"
+		m.secret()`)
+		env = &Environment{
+			Parent: &Environment{Consts: map[string]Value{"uppercase": StdLib["uppercase"]}},
+			Consts: map[string]Value{},
+			Vars:   map[string]Value{},
+		}
+		env.Sandbox(context.Background(), Limits{FSRoot: dir})
+		if _, err = engine.run(ast, env); err == nil || err.Error() != `Line 4: "lowercase" is undefined` {
+			t.Fatalf(`Expected "lowercase" to be undefined (%s), received %v`, engine.name, err)
+		}
+	}
+}
+
+func TestInterpretContext(t *testing.T) {
+	env := &Environment{
+		Parent: &Environment{Consts: StdLib},
+		Consts: map[string]Value{},
+		Vars:   map[string]Value{},
+	}
+
+	tests := []string{
+		`var i := 0
+		while true { i = i + 1 }`,
+		`for x <- 1..100000 { for y <- 1..100000 { z := y } }`,
+		`f := n => f(n + 1)
+		f(0)`,
+		`1..100000 map n => #(1..100000 map _ * 2)`,
+		`1..100000 where n => #(1..100000 where _ < 0) > 0`,
+	}
+
+	for _, engine := range engines {
+		for _, src := range tests {
+			ast, err := scanAndParse(src)
+			if err != nil {
+				t.Fatalf(`Failed to parse "%s": %s`, src, err.Error())
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			time.AfterFunc(10*time.Millisecond, cancel)
+			_, err = engine.runContext(ctx, ast, newScope(env))
+
+			var ce *CancelledError
+			if !errors.As(err, &ce) || !errors.Is(err, context.Canceled) || ce.Error() != `Evaluation was cancelled` {
+				t.Fatalf(`Expected "%s" to be cancelled (%s), received %v`, src, engine.name, err)
+			}
+		}
+	}
+
+	// declarations are kept, and the environment can be used again afterwards
+	ast, _ := scanAndParse(`x := 1`)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if _, err := InterpretContext(ctx, ast, env); err != nil {
+		t.Fatal(err)
+	}
+	ast, _ = scanAndParse(`x + 1`)
+	res, err := Interpret(ast, env)
+	if err != nil || res.ToString() != `2` || env.sandbox != nil {
+		t.Fatalf(`Expected 2 without a sandbox, received %v (%v)`, res, err)
+	}
+}
@@ -113,7 +113,7 @@ func listify(p Parser) Parser {
 		if res.ok {
 			res.node = &Node{
 				Type: ListNT,
-				Val:  List{res.node},
+				Val:  []*Node{res.node},
 			}
 		}
 		return res
@@ -136,10 +136,12 @@ func maybeFunc(p Parser) Parser {
 		}
 
 		forbidden := map[NodeType]bool{
-			MapNT:   true,
-			WhereNT: true,
-			PipeNT:  true,
-			StmtNT:  true,
+			MapNT:    true,
+			WhereNT:  true,
+			PMapNT:   true,
+			PWhereNT: true,
+			PipeNT:   true,
+			StmtNT:   true,
 		}
 
 		underscore := false
@@ -154,6 +156,15 @@ func maybeFunc(p Parser) Parser {
 					underscore = true
 				}
 
+				// underscores in match patterns are wildcards, only check guards and bodies
+				if n.Type == MatchArmNT {
+					if n.L.Type == IfNT {
+						q2 = append(q2, n.L.L)
+					}
+					q2 = append(q2, n.R)
+					continue
+				}
+
 				if n.L != nil {
 					q2 = append(q2, n.L)
 				}
@@ -175,9 +186,10 @@ func maybeFunc(p Parser) Parser {
 			}
 
 			return ParseRes{
-				ok:     true,
-				node:   n,
-				tokens: res.tokens,
+				ok:       true,
+				node:     n,
+				tokens:   res.tokens,
+				furthest: res.furthest,
 			}
 		}
 		return res
@@ -233,6 +245,107 @@ var nParam Nodify = func(res ...ParseRes) *Node {
 	return &Node{
 		Type: ParamNT,
 		Val:  res1.parsed.Lexeme,
+		Line: res1.parsed.Line,
+	}
+}
+
+// Types
+// nAnnotate attaches a type annotation to an identifier or parameter, on its L
+var nAnnotate Nodify = func(res ...ParseRes) *Node {
+	target, ann, ok := get2Results(res)
+	if !ok {
+		fmt.Println("nAnnotate failed :(")
+		return nil
+	}
+
+	target.node.L = ann.node
+	return target.node
+}
+
+// nReturnAnnotation holds a lambda's params and return type until its body is parsed
+var nReturnAnnotation Nodify = func(res ...ParseRes) *Node {
+	params, ann, ok := get2Results(res)
+	if !ok {
+		fmt.Println("nReturnAnnotation failed :(")
+		return nil
+	}
+
+	return &Node{
+		Type: LambdaNT,
+		Val:  ann.node,
+		L:    params.node,
+	}
+}
+
+// nLambda creates a lambda from its params and body, with its return type (if any) as its Val
+var nLambda Nodify = func(res ...ParseRes) *Node {
+	params, rest, ok := get2Results(res)
+	if !ok {
+		fmt.Println("nLambda failed :(")
+		return nil
+	}
+
+	if params.node.Type == LambdaNT {
+		return &Node{
+			Type: LambdaNT,
+			Val:  params.node.Val,
+			L:    params.node.L,
+			R:    rest.node.R,
+		}
+	}
+
+	return &Node{
+		Type: LambdaNT,
+		L:    params.node,
+		R:    rest.node.R,
+	}
+}
+
+var nTypeElem Nodify = func(res ...ParseRes) *Node {
+	base, elem, ok := get2Results(res)
+	if !ok {
+		fmt.Println("nTypeElem failed :(")
+		return nil
+	}
+
+	t := base.node.Val.(*Type)
+	if t.Kind != ListTK && t.Kind != SetTK {
+		return base.node
+	}
+
+	return &Node{
+		Type: TypeNT,
+		Val:  &Type{Kind: t.Kind, Elem: elem.node.Val.(*Type)},
+		Line: base.node.Line,
+	}
+}
+
+var nTypeMaybe Nodify = func(res ...ParseRes) *Node {
+	t, _, ok := get2Results(res)
+	if !ok {
+		fmt.Println("nTypeMaybe failed :(")
+		return nil
+	}
+
+	return &Node{
+		Type: TypeNT,
+		Val:  maybe(t.node.Val.(*Type)),
+		Line: t.node.Line,
+	}
+}
+
+// nTypeUnion combines types separated by "|"
+var nTypeUnion Nodify = func(res ...ParseRes) *Node {
+	a, b, ok := get2Results(res)
+	if !ok {
+		fmt.Println("nTypeUnion failed :(")
+		return nil
+	}
+
+	return &Node{
+		Type: TypeNT,
+		Val:  unionOf(a.node.Val.(*Type), b.node.Val.(*Type)),
+		Line: a.node.Line,
 	}
 }
 
@@ -274,10 +387,86 @@ var nKVPair Nodify = func(res ...ParseRes) *Node {
 	}
 }
 
+var nTemplate Nodify = func(res ...ParseRes) *Node {
+	part, rest, ok := get2Results(res)
+	if !ok {
+		return nil
+	}
+
+	return &Node{
+		Type: TemplateNT,
+		L:    part.node,
+		R:    rest.node,
+		Line: part.node.Line,
+	}
+}
+
+// nPairPattern creates the list pattern [k, v] from k, v
+var nPairPattern Nodify = func(res ...ParseRes) *Node {
+	k, v, ok := get2Results(res)
+	if !ok {
+		return nil
+	}
+
+	return &Node{
+		Type: ListNT,
+		Val:  []*Node{k.node, v.node},
+		Line: k.node.Line,
+	}
+}
+
+var nGenerator Nodify = func(res ...ParseRes) *Node {
+	pattern, src, ok := get2Results(res)
+	if !ok {
+		return nil
+	}
+
+	return &Node{
+		Type: GeneratorNT,
+		L:    pattern.node,
+		R:    src.node,
+		Line: pattern.node.Line,
+	}
+}
+
+// nComprehension creates a comprehension building a collection of type nt, from the item (or
+// key-value pair) on the left and the clauses on the right
+var nComprehension func(NodeType) Nodify = func(nt NodeType) Nodify {
+	return func(res ...ParseRes) *Node {
+		item, clauses, ok := get2Results(res)
+		if !ok {
+			return nil
+		}
+
+		return &Node{
+			Type: ComprehensionNT,
+			Val:  nt,
+			L:    item.node,
+			R:    clauses.node,
+			Line: clauses.node.L.Line,
+		}
+	}
+}
+
 var nObject Nodify = func(res ...ParseRes) *Node {
 	return &Node{Type: ObjectNT}
 }
 
+var nMatch Nodify = func(res ...ParseRes) *Node {
+	subject, arms, ok := get2Results(res)
+	if !ok {
+		fmt.Println("nMatch failed :(")
+		return nil
+	}
+
+	return &Node{
+		Type: MatchNT,
+		L:    subject.node,
+		R:    arms.node,
+		Line: subject.node.Line,
+	}
+}
+
 var nImport Nodify = func(res ...ParseRes) *Node {
 	if !res[0].ok {
 		return nil
@@ -488,6 +677,34 @@ var nRightAssoc Nodify = func(res ...ParseRes) *Node {
 }
 
 // nLeftAssoc expects 2 result structs: the previous result and the rhs
+// nFold creates a fold node, with the function on the right and the starting value (if any) as Val
+var nFold Nodify = func(res ...ParseRes) *Node {
+	_, rhs, ok := get2Results(res)
+	if !ok {
+		return nil
+	}
+
+	// a fold with a starting value is already built by nFoldFrom. Its left side is filled in later
+	// by nEndLeftAssoc, which tells it apart from a (complete) fold used as the function.
+	if rhs.node.Type == FoldNT && rhs.node.L == nil {
+		return rhs.node
+	}
+	return &Node{Type: FoldNT, R: rhs.node}
+}
+
+var nFoldFrom Nodify = func(res ...ParseRes) *Node {
+	fn, init, ok := get2Results(res)
+	if !ok {
+		return nil
+	}
+
+	return &Node{
+		Type: FoldNT,
+		Val:  init.node,
+		R:    fn.node,
+	}
+}
+
 var nLeftAssoc Nodify = func(res ...ParseRes) *Node {
 
 	//		 O1					 O2							O2
@@ -568,10 +785,12 @@ var nUnaryNested Nodify = func(res ...ParseRes) *Node {
 var nEmptyList Nodify = func(res ...ParseRes) *Node {
 	return &Node{
 		Type: ListNT,
-		Val:  List{},
+		Val:  []*Node{},
 	}
 }
 
+// nListHead and nListTail join the items of a list, each of which has been wrapped in a list of
+// its own by listify so that items which are themselves lists aren't flattened into it
 var nListHead Nodify = func(res ...ParseRes) *Node {
 	head, tail, ok := get2Results(res)
 	if !ok {
@@ -579,20 +798,12 @@ var nListHead Nodify = func(res ...ParseRes) *Node {
 		return nil
 	}
 
-	if head.node.Type != ListNT {
+	if head.node.Type != ListNT || tail.node.Type != ListNT {
 		fmt.Println("nListHead failed :(")
 		return nil
 	}
 
-	if tail.node.Type != ListNT {
-		h := head.node.Val.(List)
-		return &Node{
-			Type: ListNT,
-			Val:  append(h, tail.node),
-		}
-	}
-
-	h, t := head.node.Val.(List), tail.node.Val.(List)
+	h, t := head.node.Val.([]*Node), tail.node.Val.([]*Node)
 
 	return &Node{
 		Type: ListNT,
@@ -607,18 +818,25 @@ var nListTail Nodify = func(res ...ParseRes) *Node {
 		return nil
 	}
 
-	if prev.node.Type == ListNT {
-		list := prev.node.Val.(List)
+	list := prev.node.Val.([]*Node)
 
-		return &Node{
-			Type: ListNT,
-			Val:  append(list, curr.node),
-		}
+	return &Node{
+		Type: ListNT,
+		Val:  append(list[:len(list):len(list)], curr.node.Val.([]*Node)...),
+	}
+}
+
+// nTuple creates a tuple from its first item and a list of the rest
+var nTuple Nodify = func(res ...ParseRes) *Node {
+	head, tail, ok := get2Results(res)
+	if !ok {
+		return nil
 	}
 
 	return &Node{
-		Type: ListNT,
-		Val:  List{prev.node, curr.node},
+		Type: TupleNT,
+		Val:  append([]*Node{head.node}, tail.node.Val.([]*Node)...),
+		Line: head.node.Line,
 	}
 }
 
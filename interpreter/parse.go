@@ -3,8 +3,10 @@ package interpreter
 import "fmt"
 
 // Primaries and atoms
-var pPrimary, pPrimaryRhs, pAtom, pCollection, pIdentifier, pCall, pGroup Parser
+var pPrimary, pPrimaryRhs, pAtom, pCollection, pIdentifier, pCall, pGroup, pTuple, pTupleRest Parser
 var pList, pListItem, pListItems, pSplatExpr, pEmptyList, pObject, pObjectItems, pObjectItem, pKVPair, pSet, pSetItem, pSetItems Parser
+var pTemplate, pTemplateRest Parser
+var pComprehensionFor, pClauses, pClauseRest, pGenerator, pListComprehension, pSetComprehension, pObjectComprehension Parser
 var pArgs, pCallRhs, pBracketAccess, pListSlice, pSlice, pFieldAccess Parser
 
 // Unary expressions (and power)
@@ -25,17 +27,23 @@ var pConjunction, pConjunctionRhs, pDisjunction, pDisjunctionRhs, pInExpr, pInEx
 var pCondExpr, pCondElseExpr, pCondRhs, pIfRhs, pUnlessRhs, pElseRhs Parser
 
 // Match
-// var pMatchExpr Parser
+var pMatchExpr, pMatchArms, pMatchArm, pMatchArmEnd, pPattern, pPatternItems, pListPattern, pObjPattern, pObjPairPattern, pTuplePattern Parser
+
+// Types
+var pType, pTypeAtom, pTypeMaybe, pTypeAnnotation Parser
 
 // Lambdas
 var pLambda, pLambdaRhs, pEmptyParams, pParams, pParam Parser
-var pListDestruc, pObjDestruc, pObjPairDestruc Parser
+
+// Tasks
+var pSpawn Parser
+var pListDestruc, pObjDestruc, pObjPairDestruc, pTupleDestruc Parser
 
 // Simple expressions
 var pExpr, pSimpleExpr Parser
 
 // Compound expressions
-var pCompoundExpr, pCompoundExprRhs, pMapExprRhs, pWhereExprRhs, pPipeExprRhs, pFindExprRhs, pCompoundExprArg Parser
+var pCompoundExpr, pCompoundExprRhs, pMapExprRhs, pWhereExprRhs, pPipeExprRhs, pFindExprRhs, pFoldExprRhs, pBindExprRhs, pPMapExprRhs, pPWhereExprRhs, pCompoundExprArg Parser
 
 // Statements
 var pCompoundStmt, pSimpleStmt, pStmtBody, pStmt, pStmts Parser
@@ -43,7 +51,7 @@ var pIfStmt, pUnlessStmt, pElseStmt, pCondStmt Parser
 var pWhileStmt, pUntilStmt, pForStmt, pForAssign, pLoopStmt Parser
 
 // Simple statements
-var pVarDecl, pConstDecl, pDeclTarget, pDeclRhs, pAssignment, pAssignTarget, pAssignRhs, pAssignOp, pDecl Parser
+var pVarDecl, pConstDecl, pDeclTarget, pDeclRhs, pAnnotatedDeclTarget, pAnnotatedDeclRhs, pAssignment, pAssignTarget, pAssignRhs, pAssignOp, pDecl Parser
 var pImportStmt, pReturnStmt Parser
 var pProgram Parser
 
@@ -54,6 +62,14 @@ func init() {
 	pIdentifier = pToken(IdentifierTT, nAtom(IdentifierNT))
 	// This nonsense deals with circular dependencies. Passing the Parser itself, before defining, will pass nil
 	pGroup = InParens(func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) })
+	// Tuples: (a, b, ...). Without a comma this is just a parenthesized expression.
+	pTupleRest = Plus(
+		listify(Then(
+			pToken(CommaTT, nil),
+			func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) },
+			takeSecond,
+		)), nListTail)
+	pTuple = InParens(ThenMaybe(func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) }, pTupleRest, nTuple))
 
 	// Collections
 	// Lists
@@ -62,11 +78,11 @@ func init() {
 	pListItems = ThenMaybe(
 		listify(pListItem),
 		Plus(
-			Then(
+			listify(Then(
 				pToken(CommaTT, nil),
 				(pListItem),
 				takeSecond,
-			), nListTail),
+			)), nListTail),
 		nListHead,
 	)
 	pEmptyList = Then(
@@ -74,9 +90,56 @@ func init() {
 		(pToken(RightBracketTT, nil)),
 		nEmptyList,
 	)
+	// Comprehensions: [x * y for x <- xs, y <- ys if x < y]
+	pGenerator = Then(
+		Choice(
+			// k, v <- pairs(obj) is short for [k, v] <- pairs(obj)
+			Then(pIdentifier, Then(pToken(CommaTT, nil), pIdentifier, takeSecond), nPairPattern),
+			func(r ParseRes, n Nodify) ParseRes { return pPattern(r, n) },
+		),
+		Then(
+			pToken(LeftArrowTT, nil),
+			func(r ParseRes, n Nodify) ParseRes { return pFallback(r, n) },
+			takeSecond,
+		),
+		nGenerator,
+	)
+	pClauseRest = ThenMaybe(
+		nestLeft(Choice(
+			Then(pToken(CommaTT, nil), pGenerator, takeSecond),
+			// filter
+			Then(pToken(IfTT, nil), func(r ParseRes, n Nodify) ParseRes { return pFallback(r, n) }, takeSecond),
+		), ClauseNT),
+		func(r ParseRes, n Nodify) ParseRes { return pClauseRest(r, n) },
+		nLinked,
+	)
+	pClauses = ThenMaybe(nestLeft(pGenerator, ClauseNT), pClauseRest, nLinked)
+	pComprehensionFor = Then(pToken(ForTT, nil), pClauses, takeSecond)
+	pListComprehension = InBrackets(Then(
+		func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) },
+		pComprehensionFor,
+		nComprehension(ListNT),
+	))
+	pSetComprehension = InBraces(Then(
+		func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) },
+		pComprehensionFor,
+		nComprehension(SetNT),
+	))
+	// keys are evaluated, unlike in object literals
+	pObjectComprehension = InBraces(Then(
+		Then(
+			func(r ParseRes, n Nodify) ParseRes { return pFallback(r, n) },
+			Then(pToken(ColonTT, nil), func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) }, takeSecond),
+			nKVPair,
+		),
+		pComprehensionFor,
+		nComprehension(ObjectNT),
+	))
+
 	pList = Choice(
 		pEmptyList,
 		InBrackets(pListItems),
+		pListComprehension,
 	)
 
 	// Objects
@@ -98,27 +161,58 @@ func init() {
 	pObject = Choice(
 		Then(pToken(LeftBraceTT, nil), (pToken(RightBraceTT, nil)), nObject),
 		InBraces(pObjectItems),
+		pObjectComprehension,
 	)
 
 	// Set
 	pSetItem = Choice(pSplatExpr, func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) })
 	pSetItems = CommaSeparated(nestLeft(pSetItem, SetItemNT))
-	pSet = InBraces(pSetItems)
+	pSet = Choice(InBraces(pSetItems), pSetComprehension)
+
+	// Interpolated strings: "a ${x} b ${y} c" is scanned as the tokens "a ${, x, } b ${, y and } c"
+	pTemplateRest = Choice(
+		nestLeft(pToken(TemplateEndTT, nAtom(StringNT)), TemplateNT),
+		Then(
+			pToken(TemplateMidTT, nAtom(StringNT)),
+			Then(
+				func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) },
+				func(r ParseRes, n Nodify) ParseRes { return pTemplateRest(r, n) },
+				nTemplate,
+			),
+			nTemplate,
+		),
+	)
+	pTemplate = Then(
+		pToken(TemplateStartTT, nAtom(StringNT)),
+		Then(
+			func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) },
+			pTemplateRest,
+			nTemplate,
+		),
+		nTemplate,
+	)
 
 	pAtom = Choice(
 		pIdentifier,
+		// built-in functions named after keywords can still be called, e.g. fold(xs, 0, f)
+		ThenPeek(
+			Choice(pToken(FindTT, nAtom(IdentifierNT)), pToken(FoldTT, nAtom(IdentifierNT))),
+			pToken(LeftParenTT, nil),
+			nil,
+		),
 		pToken(TrueTT, nAtom(BoolNT)),
 		pToken(FalseTT, nAtom(BoolNT)),
 		pToken(NullTT, nAtom(NullNT)),
 		pToken(FailTT, nAtom(FailNT)),
 		pToken(SuccessTT, nAtom(SuccessNT)),
 		pToken(StringTT, nAtom(StringNT)),
+		pTemplate,
 		pToken(IntTT, nAtom(IntNT)),
 		pToken(FloatTT, nAtom(FloatNT)),
 		pToken(UnderscoreTT, nAtom(UnderscoreNT)),
 		pToken(IndexTT, nAtom(IndexNT)),
-		// pTuple,
-		pGroup,
+		func(r ParseRes, n Nodify) ParseRes { return pMatchExpr(r, n) },
+		pTuple,
 	)
 
 	pCollection = Choice(
@@ -133,11 +227,11 @@ func init() {
 		pToken(RightParenTT, nil),
 		takeFirst,
 	)
-	pCallRhs = nestRight(Then(
+	pCallRhs = sameLine(nestRight(Then(
 		pToken(LeftParenTT, nil),
 		Choice(nestLeft(pToken(RightParenTT, nil), ArgNT), pArgs),
 		takeSecond,
-	), CallNT)
+	), CallNT))
 
 	pSlice = Choice(
 		Then(
@@ -183,8 +277,8 @@ func init() {
 			func(r ParseRes, n Nodify) ParseRes { return pUnaryPre(r, n) },
 			nRhs), nRightAssoc)
 	pPower = ThenMaybe(pUnaryPost, pPowerRhs, nBinary)
-	pUnPreOp = Choice(pOperatorUnary(BangTT), pOperatorUnary(MinusTT), pOperatorUnary(HashTT))
-	pUnaryPre = Choice(Then(Plus(pUnPreOp, nUnaryNested), pPower, nUnaryNested), pPower)
+	pUnPreOp = Choice(pOperatorUnary(BangTT), pOperatorUnary(MinusTT), pOperatorUnary(HashTT), pOperatorUnary(AwaitTT))
+	pUnaryPre = Labeled("an expression", Choice(Then(Plus(pUnPreOp, nUnaryNested), pPower, nUnaryNested), pPower))
 
 	// Binary expressions
 	// Range
@@ -243,16 +337,30 @@ func init() {
 	pCondExpr = ThenMaybe(pFallback, pCondRhs, nBinaryFlip)
 	pCondElseExpr = ThenMaybe(pCondExpr, pElseRhs, nElse)
 
+	// Types
+	pTypeAtom = ThenMaybe(
+		pTypeName(),
+		InBrackets(func(r ParseRes, n Nodify) ParseRes { return pType(r, n) }),
+		nTypeElem,
+	)
+	pTypeMaybe = ThenMaybe(pTypeAtom, pOperatorUnary(QuestionMarkTT), nTypeMaybe)
+	pType = ThenMaybe(
+		pTypeMaybe,
+		Plus(Then(pToken(BarTT, nil), pTypeMaybe, takeSecond), nTypeUnion),
+		nTypeUnion,
+	)
+	pTypeAnnotation = Then(pToken(ColonTT, nil), pType, takeSecond)
+
 	// Lambdas
 	pListDestruc = InBrackets(
 		ThenMaybe(
 			listify(pIdentifier),
 			Plus(
-				Then(
+				listify(Then(
 					pToken(CommaTT, nil),
 					pIdentifier,
 					takeSecond,
-				), nListTail),
+				)), nListTail),
 			nListHead,
 		))
 	pObjPairDestruc = nestLeft(ThenMaybe(
@@ -265,19 +373,39 @@ func init() {
 		nKVPair,
 	), ObjectItemNT)
 	pObjDestruc = InBraces(CommaSeparated(pObjPairDestruc))
-
-	pParam = Choice(pToken(IdentifierTT, nParam), nestLeft(pListDestruc, ParamNT), nestLeft(pObjDestruc, ParamNT))
+	pTupleDestruc = InParens(Then(
+		pIdentifier,
+		Plus(
+			listify(Then(
+				pToken(CommaTT, nil),
+				pIdentifier,
+				takeSecond,
+			)), nListTail),
+		nTuple,
+	))
+
+	pParam = Choice(
+		ThenMaybe(pToken(IdentifierTT, nParam), pTypeAnnotation, nAnnotate),
+		nestLeft(pListDestruc, ParamNT),
+		nestLeft(pObjDestruc, ParamNT),
+		nestLeft(pTupleDestruc, ParamNT))
 	pParams =
 		Choice(
 			// single identifier: x => ...
 			pToken(IdentifierTT, nParam),
-			// empty params: () => ...
-			Then(
-				pToken(LeftParenTT, nil),
-				pToken(RightParenTT, nil),
-				nAlways(ParamNT)),
-			// comma-separated params: (x,y) => ...
-			InParens(CommaSeparated(pParam)),
+			// empty params: () => ..., () : T => ...
+			ThenMaybe(
+				Then(
+					pToken(LeftParenTT, nil),
+					pToken(RightParenTT, nil),
+					nAlways(ParamNT)),
+				pTypeAnnotation,
+				nReturnAnnotation),
+			// comma-separated params: (x,y) => ..., (x: T, y: T): T => ...
+			ThenMaybe(
+				InParens(CommaSeparated(pParam)),
+				pTypeAnnotation,
+				nReturnAnnotation),
 		)
 	pLambdaRhs = Then((pOperator(ArrowTT)),
 		Choice(
@@ -285,9 +413,98 @@ func init() {
 			InBraces(func(r ParseRes, n Nodify) ParseRes { return pStmts(r, n) }),
 		),
 		nRhs)
-	pLambda = Then(pParams, pLambdaRhs, nBinary)
+	pLambda = Then(pParams, pLambdaRhs, nLambda)
+
+	// spawn expr, spawn { stmts }
+	pSpawn = Then(
+		pOperatorUnary(SpawnTT),
+		Choice(
+			func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) },
+			InBraces(func(r ParseRes, n Nodify) ParseRes { return pStmts(r, n) }),
+		),
+		nUnaryPre)
+
+	pSimpleExpr = Choice(pLambda, pSpawn, pCondElseExpr)
 
-	pSimpleExpr = Choice(pLambda, pCondElseExpr)
+	// Match
+	pPatternItems = ThenMaybe(
+		listify(Choice(pSplatExpr, func(r ParseRes, n Nodify) ParseRes { return pPattern(r, n) })),
+		Plus(
+			listify(Then(
+				pToken(CommaTT, nil),
+				Choice(pSplatExpr, func(r ParseRes, n Nodify) ParseRes { return pPattern(r, n) }),
+				takeSecond,
+			)), nListTail),
+		nListHead,
+	)
+	pListPattern = Choice(pEmptyList, InBrackets(pPatternItems))
+	pObjPairPattern = nestLeft(ThenMaybe(
+		pIdentifier,
+		Then(
+			pToken(ColonTT, nil),
+			func(r ParseRes, n Nodify) ParseRes { return pPattern(r, n) },
+			takeSecond,
+		),
+		nKVPair,
+	), ObjectItemNT)
+	pTuplePattern = InParens(Then(
+		func(r ParseRes, n Nodify) ParseRes { return pPattern(r, n) },
+		Plus(
+			listify(Then(
+				pToken(CommaTT, nil),
+				func(r ParseRes, n Nodify) ParseRes { return pPattern(r, n) },
+				takeSecond,
+			)), nListTail),
+		nTuple,
+	))
+	pObjPattern = Choice(
+		Then(pToken(LeftBraceTT, nil), pToken(RightBraceTT, nil), nObject),
+		InBraces(CommaSeparated(pObjPairPattern)),
+	)
+	pPattern = Choice(
+		pToken(UnderscoreTT, nAtom(UnderscoreNT)),
+		pIdentifier,
+		pToken(TrueTT, nAtom(BoolNT)),
+		pToken(FalseTT, nAtom(BoolNT)),
+		pToken(NullTT, nAtom(NullNT)),
+		pToken(FailTT, nAtom(FailNT)),
+		pToken(SuccessTT, nAtom(SuccessNT)),
+		pToken(StringTT, nAtom(StringNT)),
+		pToken(IntTT, nAtom(IntNT)),
+		pToken(FloatTT, nAtom(FloatNT)),
+		Then(pOperatorUnary(MinusTT), Choice(pToken(IntTT, nAtom(IntNT)), pToken(FloatTT, nAtom(FloatNT))), nUnaryPre),
+		pListPattern,
+		pObjPattern,
+		pTuplePattern,
+	)
+	pMatchArm = alterNodeType(Then(
+		// a guard is stored like a postfix conditional: (if guard pattern)
+		ThenMaybe(pPattern, pIfRhs, nBinaryFlip),
+		Then(
+			pOperator(ArrowTT),
+			Choice(
+				func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) },
+				InBraces(func(r ParseRes, n Nodify) ParseRes { return pStmts(r, n) }),
+			),
+			nRhs),
+		nBinary,
+	), MatchArmNT)
+	pMatchArmEnd = Choice(
+		pToken(CommaTT, nil),
+		pToken(NewLineTT, nil),
+		Peek(pToken(RightBraceTT, nil)))
+	pMatchArms = Plus(
+		nestLeft(Choice(
+			Then(pMatchArm, pMatchArmEnd, takeFirst),
+			// an arm's body may run onto the next arm's pattern, e.g. `"a"\n[x] => ...` parses as "a"[x]
+			Then(untilNewLine(pMatchArm), pMatchArmEnd, takeFirst),
+		), MatchCaseNT),
+		nLinked)
+	pMatchExpr = Then(
+		Then(pToken(MatchTT, nil), func(r ParseRes, n Nodify) ParseRes { return pExpr(r, n) }, takeSecond),
+		InBraces(pMatchArms),
+		nMatch,
+	)
 
 	// Compound expressions
 	pCompoundExprArg = Choice(pLambda, maybeFunc(pCondElseExpr))
@@ -295,10 +512,23 @@ func init() {
 	pWhereExprRhs = Then(pOperator(WhereTT), pCompoundExprArg, nRhs)
 	pMapExprRhs = Then(pOperator(MapTT), pCompoundExprArg, nRhs)
 	pFindExprRhs = Then(pOperator(FindTT), pCompoundExprArg, nRhs)
-	pCompoundExprRhs = Plus((Choice(pPipeExprRhs, pWhereExprRhs, pMapExprRhs, pFindExprRhs)), nLeftAssoc)
+	pPMapExprRhs = Then(pOperator(PMapTT), pCompoundExprArg, nRhs)
+	pPWhereExprRhs = Then(pOperator(PWhereTT), pCompoundExprArg, nRhs)
+	pBindExprRhs = Then(pOperator(BindTT), pCompoundExprArg, nRhs)
+	// xs fold (acc, x) => acc + x from 0
+	pFoldExprRhs = Then(
+		pOperator(FoldTT),
+		ThenMaybe(
+			pCompoundExprArg,
+			Then(pToken(FromTT, nil), pCondElseExpr, takeSecond),
+			nFoldFrom,
+		),
+		nFold,
+	)
+	pCompoundExprRhs = Plus((Choice(pPipeExprRhs, pBindExprRhs, pWhereExprRhs, pMapExprRhs, pPWhereExprRhs, pPMapExprRhs, pFindExprRhs, pFoldExprRhs)), nLeftAssoc)
 	pCompoundExpr = ThenMaybe(pSimpleExpr, pCompoundExprRhs, nEndLeftAssoc)
 
-	pExpr = Choice(pCompoundExpr)
+	pExpr = Labeled("an expression", pCompoundExpr)
 
 	// Statements
 
@@ -324,12 +554,20 @@ func init() {
 	pDeclTarget = Choice(
 		pListDestruc,
 		pIdentifier,
-		pObjDestruc)
-	pConstDecl = Then(pDeclTarget, pDeclRhs, nBinary)
-	pVarDecl = Then(Then(pToken(VarTT, nil), pDeclTarget, takeSecond), alterNodeType(pDeclRhs, VarDeclNT), nBinary)
+		pObjDestruc,
+		pTupleDestruc)
+	pAnnotatedDeclTarget = Then(pIdentifier, pTypeAnnotation, nAnnotate)
+	pAnnotatedDeclRhs = alterNodeType(Then(pOperator(EqualTT), maybeFunc(pExpr), nRhs), ConstDeclNT)
+	pConstDecl = Choice(
+		Then(pDeclTarget, pDeclRhs, nBinary),
+		// x: T = ...
+		Then(pAnnotatedDeclTarget, pAnnotatedDeclRhs, nBinary),
+	)
+	pVarDecl = Then(pToken(VarTT, nil), alterNodeType(pConstDecl, VarDeclNT), takeSecond)
 	pDecl = Choice(pVarDecl, pConstDecl)
 
-	pReturnStmt = nestRight(Then(pToken(ReturnTT, nil), pExpr, takeSecond), ReturnStmtNT)
+	// return a, b returns the tuple (a, b)
+	pReturnStmt = nestRight(Then(pToken(ReturnTT, nil), ThenMaybe(pExpr, pTupleRest, nTuple), takeSecond), ReturnStmtNT)
 	pImportStmt = ThenMaybe(
 		Then(pToken(ImportTT, nil), pToken(StringTT, nAtom(StringNT)), nImport),
 		Then(pToken(AsTT, nil), pIdentifier, takeSecond),
@@ -362,7 +600,7 @@ func init() {
 	// Loop statements
 	pWhileStmt = Then(Then(pOperator(WhileTT), pExpr, nLhs), pStmtBody, nRhs)
 	pUntilStmt = Then(Then(pOperator(UntilTT), pExpr, negateSecond(nLhs)), pStmtBody, nRhs)
-	pForAssign = alterNodeType(Then(pDeclTarget, Then(pOperator(InTT), pExpr, nRhs), nBinary), ConstDeclNT)
+	pForAssign = alterNodeType(Then(pDeclTarget, Then(Choice(pOperator(InTT), pOperator(LeftArrowTT)), pExpr, nRhs), nBinary), ConstDeclNT)
 	pForStmt = Then(Then(pOperator(ForTT), pForAssign, nLhs), pStmtBody, nRhs)
 	pLoopStmt = Choice(pWhileStmt, pUntilStmt, pForStmt)
 
@@ -370,7 +608,7 @@ func init() {
 
 	pStmt = nestLeft(
 		Then(
-			Choice(pImportStmt, pCompoundStmt, pSimpleStmt, pExpr),
+			Labeled("a statement", Choice(pImportStmt, pCompoundStmt, pSimpleStmt, pExpr)),
 			Choice(
 				Peek(pToken(NewLineTT, nil)),
 				Peek(pToken(RightBraceTT, nil)),
@@ -389,12 +627,16 @@ func init() {
 // Parse parses a slice of tokens
 func Parse(ts []Token) (*Node, error) {
 	start := ParseRes{
-		ok:     true,
-		tokens: ts,
+		ok:       true,
+		tokens:   ts,
+		furthest: &parseFailure{},
 	}
 	res := pProgram(start, nil)
 
 	if !res.ok {
+		if start.furthest.found != nil {
+			return nil, start.furthest.error()
+		}
 		return nil, fmt.Errorf(res.err)
 	}
 
@@ -4,11 +4,137 @@ import "fmt"
 
 // ParseRes holds the state of a parse: success or failure, remaining tokens, current node in the AST
 type ParseRes struct {
-	ok     bool
-	err    string
-	node   *Node
-	parsed *Token
-	tokens []Token
+	ok       bool
+	err      string
+	node     *Node
+	parsed   *Token
+	tokens   []Token
+	furthest *parseFailure // shared by every result in a parse
+}
+
+// parseFailure records the furthest token at which any parser failed, and what was expected there
+type parseFailure struct {
+	found    *Token
+	expected []string
+	msg      string // a more specific error than "expected X, found Y"
+}
+
+// expect records that the next of the remaining tokens is not what a parser expected
+func (r ParseRes) expect(tokens []Token, expected string) {
+	f := r.furthest
+	if f == nil || len(tokens) == 0 {
+		return
+	}
+
+	if f.found == nil || tokens[0].Offset > f.found.Offset {
+		f.found, f.expected, f.msg = &tokens[0], nil, ""
+	} else if tokens[0].Offset < f.found.Offset {
+		return
+	}
+
+	for _, e := range f.expected {
+		if e == expected {
+			return
+		}
+	}
+	f.expected = append(f.expected, expected)
+}
+
+// failAt records a specific error at the next of the remaining tokens
+func (r ParseRes) failAt(tokens []Token, msg string) {
+	f := r.furthest
+	if f == nil || len(tokens) == 0 {
+		return
+	}
+
+	if f.found == nil || tokens[0].Offset >= f.found.Offset {
+		f.found, f.msg = &tokens[0], msg
+	}
+}
+
+func (f *parseFailure) error() *ParseError {
+	return &ParseError{
+		Line:     f.found.Line,
+		Column:   f.found.Column,
+		Offset:   f.found.Offset,
+		Expected: f.expected,
+		Found:    describeToken(f.found),
+		Msg:      f.msg,
+	}
+}
+
+// describeToken describes a token found by the parser, e.g. identifier "foo"
+func describeToken(t *Token) string {
+	switch t.Type {
+	case EOFTT:
+		return "end of file"
+	case NewLineTT:
+		return "new line"
+	case IdentifierTT, IntTT, FloatTT:
+		return fmt.Sprintf("%s \"%s\"", t.Type.ToString(), t.Lexeme)
+	case StringTT, TemplateStartTT:
+		return fmt.Sprintf("string literal %q", t.Lexeme)
+	case TemplateMidTT, TemplateEndTT:
+		return "\"}\""
+	}
+	return fmt.Sprintf("\"%s\"", t.Lexeme)
+}
+
+// describeTokenType describes a token expected by the parser, e.g. an identifier
+func describeTokenType(tt TokenType) string {
+	switch tt {
+	case EOFTT:
+		return "end of file"
+	case NewLineTT:
+		return "new line"
+	case IdentifierTT, IntTT:
+		return "an " + tt.ToString()
+	case StringTT, FloatTT:
+		return "a " + tt.ToString()
+	}
+	if binaryOperators[tt] {
+		return "an operator"
+	}
+	return fmt.Sprintf("\"%s\"", tt.ToString())
+}
+
+// binaryOperators are described as "an operator" in parse errors, rather than listing all of them.
+// This includes the tokens that may follow an expression, since those at the start of one are
+// described as "an expression".
+var binaryOperators map[TokenType]bool = map[TokenType]bool{
+	LeftParenTT:    true,
+	LeftBracketTT:  true,
+	DotTT:          true,
+	QuestionMarkTT: true,
+	IfTT:           true,
+	UnlessTT:       true,
+	ElseTT:         true,
+	BangEqualTT:    true,
+	DotDotTT:       true,
+	EqualEqualTT:   true,
+	GreaterTT:      true,
+	GreaterEqualTT: true,
+	LessTT:         true,
+	LessEqualTT:    true,
+	BarTT:          true,
+	PlusTT:         true,
+	MinusTT:        true,
+	StarTT:         true,
+	SlashTT:        true,
+	ModuloTT:       true,
+	CaratTT:        true,
+	InTT:           true,
+	AndTT:          true,
+	OrTT:           true,
+	PipeTT:         true,
+	MapTT:          true,
+	WhereTT:        true,
+	FindTT:         true,
+	PMapTT:         true,
+	PWhereTT:       true,
+	FoldTT:         true,
+	FromTT:         true,
+	BindTT:         true,
 }
 
 // Parser is a function that takes a parse state (ParseRes) and Nodify function that transforms
@@ -37,6 +163,106 @@ func trim(p Parser) Parser {
 	}
 }
 
+// sameLine only attempts a parser if its first token is on the current line, so that a
+// parenthesized expression or tuple at the start of a line isn't parsed as a call
+func sameLine(p Parser) Parser {
+	return func(curr ParseRes, _ Nodify) ParseRes {
+		if curr.ok && len(curr.tokens) > 0 && curr.tokens[0].Type == NewLineTT {
+			return fail("No match")
+		}
+		return p(curr, nil)
+	}
+}
+
+// untilNewLine attempts a parser on only the tokens before the next new line (outside of any
+// brackets), for constructs that would otherwise greedily continue onto the following line
+func untilNewLine(p Parser) Parser {
+	return func(curr ParseRes, _ Nodify) ParseRes {
+		if !curr.ok {
+			return curr
+		}
+
+		tokens := curr.tokens
+		for len(tokens) > 0 && tokens[0].Type == NewLineTT {
+			tokens = tokens[1:]
+		}
+
+		depth, end := 0, 0
+	scan:
+		for ; end < len(tokens); end++ {
+			switch tokens[end].Type {
+			case LeftParenTT, LeftBracketTT, LeftBraceTT:
+				depth++
+			case RightParenTT, RightBracketTT, RightBraceTT:
+				depth--
+			case NewLineTT:
+				if depth <= 0 {
+					break scan
+				}
+			}
+		}
+
+		res := p(ParseRes{ok: true, tokens: tokens[:end], furthest: curr.furthest}, nil)
+		if !res.ok || len(res.tokens) > 0 {
+			return ParseRes{
+				ok:     false,
+				err:    res.err,
+				tokens: curr.tokens,
+			}
+		}
+
+		return ParseRes{
+			ok:       true,
+			node:     res.node,
+			tokens:   tokens[end:],
+			furthest: curr.furthest,
+		}
+	}
+}
+
+var typeNames map[string]*Type = map[string]*Type{
+	"Any":     anyType,
+	"Int":     intType,
+	"Float":   floatType,
+	"Bool":    boolType,
+	"String":  stringType,
+	"Null":    nullType,
+	"Result":  resultType,
+	"Success": successType,
+	"Fail":    failType,
+	"List":    {Kind: ListTK},
+	"Set":     {Kind: SetTK},
+	"Object":  objectOf(nil),
+	"Tuple":   {Kind: TupleTK},
+	"Lambda":  {Kind: LambdaTK},
+	"Module":  moduleType,
+	"Task":    {Kind: TaskTK},
+	"Channel": channelType,
+}
+
+// pTypeName parses the name of a type in a type annotation
+func pTypeName() Parser {
+	return func(curr ParseRes, _ Nodify) ParseRes {
+		res := pToken(IdentifierTT, nil)(curr, nil)
+		if !res.ok {
+			return res
+		}
+
+		t, ok := typeNames[res.parsed.Lexeme]
+		if !ok {
+			curr.failAt([]Token{*res.parsed}, fmt.Sprintf("Unknown type \"%s\"", res.parsed.Lexeme))
+			return ParseRes{
+				ok:     false,
+				err:    fmt.Sprintf("Line %d: Unknown type \"%s\"", res.parsed.Line, res.parsed.Lexeme),
+				tokens: curr.tokens,
+			}
+		}
+
+		res.node = &Node{Type: TypeNT, Val: t, Line: res.parsed.Line}
+		return res
+	}
+}
+
 // Atoms
 var operatorMap map[TokenType]NodeType = map[TokenType]NodeType{
 	BangEqualTT:    NotEqualNT,
@@ -61,10 +287,14 @@ var operatorMap map[TokenType]NodeType = map[TokenType]NodeType{
 	MapTT:          MapNT,
 	WhereTT:        WhereNT,
 	FindTT:         FindNT,
+	PMapTT:         PMapNT,
+	PWhereTT:       PWhereNT,
+	FoldTT:         FoldNT,
+	BindTT:         BindNT,
 	IfTT:           IfNT,
 	UnlessTT:       IfNT,
 	ArrowTT:        LambdaNT,
-	ColonEqualTT:   ConstDeclNT, // TODO: split colon and equal operators to allow types in between
+	ColonEqualTT:   ConstDeclNT,
 	LeftArrowTT:    ConstDeclNT,
 	WhileTT:        WhileStmtNT,
 	UntilTT:        WhileStmtNT,
@@ -98,11 +328,13 @@ func pOperator(tt TokenType) Parser {
 				return fail("Unknown operator")
 			}
 			return ParseRes{
-				ok:     true,
-				node:   &Node{Type: op},
-				tokens: tokens[1:],
+				ok:       true,
+				node:     &Node{Type: op},
+				tokens:   tokens[1:],
+				furthest: curr.furthest,
 			}
 		}
+		curr.expect(tokens, describeTokenType(tt))
 		return fail("No match")
 	}
 }
@@ -126,16 +358,20 @@ func pOperatorUnary(tt TokenType) Parser {
 				HashTT:         CardinalityNT,
 				QuestionMarkTT: MaybeNT,
 				DotDotDotTT:    SplatNT,
+				SpawnTT:        SpawnNT,
+				AwaitTT:        AwaitNT,
 			}[tt]
 			if !ok {
 				return fail("Unknown operator")
 			}
 			return ParseRes{
-				ok:     true,
-				node:   &Node{Type: op},
-				tokens: tokens[1:],
+				ok:       true,
+				node:     &Node{Type: op},
+				tokens:   tokens[1:],
+				furthest: curr.furthest,
 			}
 		}
+		curr.expect(tokens, describeTokenType(tt))
 		return fail("No match")
 	}
 }
@@ -159,9 +395,10 @@ func pToken(tt TokenType, n Nodify) Parser {
 		}
 		if tokens[0].Type == tt {
 			res := ParseRes{
-				ok:     true,
-				parsed: &tokens[0],
-				tokens: tokens[1:],
+				ok:       true,
+				parsed:   &tokens[0],
+				tokens:   tokens[1:],
+				furthest: curr.furthest,
 			}
 			if n != nil {
 				res.node = n(res)
@@ -169,6 +406,7 @@ func pToken(tt TokenType, n Nodify) Parser {
 
 			return res
 		}
+		curr.expect(tokens, describeTokenType(tt))
 		return ParseRes{
 			ok: false,
 			err: fmt.Sprintf(
@@ -209,9 +447,10 @@ func pAssignOperator(tt TokenType) Parser {
 		if tokens[0].Type == tt {
 			if tt == EqualTT {
 				return ParseRes{
-					ok:     true,
-					node:   &Node{Type: AssignmentNT},
-					tokens: tokens[1:],
+					ok:       true,
+					node:     &Node{Type: AssignmentNT},
+					tokens:   tokens[1:],
+					furthest: curr.furthest,
 				}
 			}
 
@@ -227,9 +466,11 @@ func pAssignOperator(tt TokenType) Parser {
 						Type: nt,
 					},
 				},
-				tokens: tokens[1:],
+				tokens:   tokens[1:],
+				furthest: curr.furthest,
 			}
 		}
+		curr.expect(tokens, describeTokenType(tt))
 		return fail("No match")
 	}
 }
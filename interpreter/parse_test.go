@@ -1,6 +1,7 @@
 package interpreter
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -12,6 +13,18 @@ func removeWhitespace(s string) string {
 	return res
 }
 
+func scanAndParse(src string) (*Node, error) {
+	tkns, err := Scan(src)
+	if err != nil {
+		return nil, err
+	}
+	root, err := Parse(tkns)
+	if err != nil {
+		return nil, err
+	}
+	return root, Resolve(root)
+}
+
 type SingleNodeTest struct {
 	input string
 	NodeType
@@ -19,8 +32,7 @@ type SingleNodeTest struct {
 }
 
 func runSingleNodeTest(test SingleNodeTest, t *testing.T) {
-	tkns := Scan(test.input)
-	ast, err := Parse(tkns)
+	ast, err := scanAndParse(test.input)
 
 	if err != nil {
 		t.Fatalf(`Failed to parse "%s": %s`, test.input, err.Error())
@@ -55,6 +67,9 @@ func TestParseAtom(t *testing.T) {
 		{`"foo"`, StringNT, `"foo"`},
 		{"3.14", FloatNT, "3.14"},
 		{"_", UnderscoreNT, "_"},
+		{`"a ${x} b"`, TemplateNT, `(template "a " (template x (template " b")))`},
+		{`"${x + 1}${y}"`, TemplateNT, `(template "" (template (+ x 1) (template "" (template y (template "")))))`},
+		{`"{a: ${ {a: 1}.a }}"`, TemplateNT, `(template "{a: " (template (field-access (object-item (: a 1)) a) (template "}")))`},
 	}
 
 	for _, test := range tests {
@@ -68,6 +83,8 @@ func TestParseCollection(t *testing.T) {
 		{"[]", ListNT, "[]"},
 		{"[1]", ListNT, "[1]"},
 		{"[1,2,3,4]", ListNT, "[1,2,3,4]"},
+		{"[[1, 2], [3, 4]]", ListNT, "[[1,2],[3,4]]"},
+		{"[1, [2]]", ListNT, "[1,[2]]"},
 		// sets
 		{`{"apple"}`, SetItemNT, `(set-item "apple")`},
 		{`{"apple", "banana"}`, SetItemNT, `(set-item "apple" (set-item "banana"))`},
@@ -82,6 +99,20 @@ func TestParseCollection(t *testing.T) {
 				(object-item (: d "foo"))
 			))))
 		`},
+		// tuples
+		{"(1, 2)", TupleNT, "(1, 2)"},
+		{`(x + 1, "a", (b, c))`, TupleNT, `((+ x 1), "a", (b, c))`},
+		{"(1)", IntNT, "1"},
+		// comprehensions
+		{"[x * 2 for x <- xs]", ComprehensionNT, "(list-comprehension (* x 2) (clause (<- x xs)))"},
+		{"{x for x <- xs if x > 1}", ComprehensionNT, "(set-comprehension x (clause (<- x xs) (clause (> x 1))))"},
+		{"{k: v for k, v <- pairs(o)}", ComprehensionNT, "(obj-comprehension (: k v) (clause (<- [k, v] (call pairs (arg o)))))"},
+		{"[[x, y] for x <- xs, y <- ys if x != y]", ComprehensionNT, `
+			(list-comprehension [x, y]
+				(clause (<- x xs)
+				(clause (<- y ys)
+				(clause (!= x y)))))
+		`},
 	}
 
 	for _, test := range tests {
@@ -95,6 +126,8 @@ func TestParsePrimary(t *testing.T) {
 		{`f()`, CallNT, `(call f (arg))`},
 		{`f(1)`, CallNT, `(call f (arg 1))`},
 		{`f(1, "two")`, CallNT, `(call f (arg 1 (arg "two")))`},
+		// a tuple on the next line isn't called
+		{"f\n(1, 2)", IdentifierNT, `f`},
 		// list slice
 		{`myList[1..]`, ListSliceNT, `(slice-access myList (slice 1 NIL_PTR))`},
 		{`myList[..5]`, ListSliceNT, `(slice-access myList (slice NIL_PTR 5))`},
@@ -147,6 +180,8 @@ func TestParseUnary(t *testing.T) {
 		{"#[]", CardinalityNT, "(# [])"},
 		{"result?", MaybeNT, "(? result)"},
 		{"[...xs]", ListNT, "[(... xs)]"},
+		{"await t", AwaitNT, "(await t)"},
+		{"spawn f(x)", SpawnNT, "(spawn (call f (arg x)))"},
 		// combined
 		{"!result?", LogicNotNT, "(! (? result))"},
 		{"-#foo", UnaryNegNT, "(- (# foo))"},
@@ -166,8 +201,7 @@ type BinaryTest struct {
 }
 
 func runBinaryTest(test BinaryTest, t *testing.T) {
-	tkns := Scan(test.input)
-	ast, err := Parse(tkns)
+	ast, err := scanAndParse(test.input)
 
 	if err != nil {
 		t.Fatalf(`Failed to parse "%s": %s`, test.input, err.Error())
@@ -263,6 +297,31 @@ func TestParseConditionalExpr(t *testing.T) {
 	}
 }
 
+// Match
+func TestParseMatch(t *testing.T) {
+	tests := []SingleNodeTest{
+		{`match x { 1 => "one" }`, MatchNT, `(match x (case (arm 1 "one")))`},
+		{`match x { [a, b] => a + b, _ => 0 }`, MatchNT, `
+			(match x
+				(case (arm [a, b] (+ a b))
+				(case (arm _ 0))))
+			`},
+		{`match x {
+				{name} if name != "" => name
+				-1 => fail
+			}`, MatchNT, `
+			(match x
+				(case (arm (if (!= name "") (object-item name)) name)
+				(case (arm (- 1) fail))))
+			`},
+		{`match x { [h, ...t] => t } | []`, FallbackNT, `(| (match x (case (arm [h, (... t)] t))) [])`},
+	}
+
+	for _, test := range tests {
+		runSingleNodeTest(test, t)
+	}
+}
+
 // Lambdas
 func TestParseLambda(t *testing.T) {
 	// param values are not shown since they do not have an AST node of their own, but are stored as the Val of the param node
@@ -286,6 +345,9 @@ func TestParseLambda(t *testing.T) {
 			)
 		`},
 		// destructured params...
+		// annotated params
+		{`(x: Int, y) => x`, LambdaNT, `(lambda (param Int (param)) x)`},
+		{`(x: Float): Float => x / 2`, LambdaNT, `(lambda (param Float) (/ x 2))`},
 	}
 
 	for _, test := range tests {
@@ -308,6 +370,11 @@ func TestParseCompoundExpr(t *testing.T) {
 					(lambda (param) (> _ 0))) 
 				(lambda (param) (# _))
 			)`},
+		{`xs fold (a, b) => a + b`, FoldNT, IdentifierNT, LambdaNT, `(fold xs (lambda (param (param)) (+ a b)))`},
+		{`xs fold add from 0 then print`, PipeNT, FoldNT, IdentifierNT, `(|> (fold xs add 0) print)`},
+		{`s bind parse bind n => n + 1`, BindNT, BindNT, LambdaNT, `(bind (bind s parse) (lambda (param) (+ n 1)))`},
+		{`fs fold (gs fold h)`, FoldNT, IdentifierNT, FoldNT, `(fold fs (fold gs h))`},
+		{`rows pmap parse pwhere _?`, PWhereNT, PMapNT, LambdaNT, `(pwhere (pmap rows parse) (lambda (param) (? _)))`},
 	}
 
 	for _, test := range tests {
@@ -323,6 +390,10 @@ func TestParseAssignment(t *testing.T) {
 		{`x = (x + y / x) / 2`, AssignmentNT, IdentifierNT, DivNT, `(= x (/ (+ x (/ y x)) 2))`},
 		{`y += 1`, AssignmentNT, IdentifierNT, AddNT, `(= y (+ y 1))`},
 		{`f := x => x + 1`, ConstDeclNT, IdentifierNT, LambdaNT, `(const f (lambda (param) (+ x 1)))`},
+		{`(q, r) := divmod(7, 2)`, ConstDeclNT, TupleNT, CallNT, `(const (q, r) (call divmod (arg 7 (arg 2))))`},
+		{`x: Int = 1`, ConstDeclNT, IdentifierNT, IntNT, `(const x: Int 1)`},
+		{`var y: Float? = 2`, VarDeclNT, IdentifierNT, IntNT, `(var y: Float? 2)`},
+		{`xs: List[Int | String] = []`, ConstDeclNT, IdentifierNT, ListNT, `(const xs: List[Int | String] [])`},
 		{`z.a = "foo"`, AssignmentNT, FieldAccessNT, StringNT, `(= (field-access z a) "foo")`},
 		{`z.a[3] = "bar"`, AssignmentNT, BracketAccessNT, StringNT, `(= (bracket-access (field-access z a) 3) "bar")`},
 	}
@@ -413,3 +484,111 @@ func TestParseLoop(t *testing.T) {
 		runSingleNodeTest(test, t)
 	}
 }
+
+// Errors
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input, err string
+	}{
+		{`foo(x y)`, `Line 1, column 7: Expected "=>", an operator, "," or ")", found identifier "y"`},
+		{`x := )`, `Line 1, column 6: Expected an expression, found ")"`},
+		{`z := 3 * * 2`, `Line 1, column 10: Expected an expression, found "*"`},
+		{"if x {\n\tprint(1\n}", `Line 3, column 1: Expected an operator, "," or ")", found "}"`},
+		{`x: Foo = 1`, `Line 1, column 4: Unknown type "Foo"`},
+		{`xs := [1, 2`, `Line 1, column 12: Expected an operator, "," or "]", found end of file`},
+	}
+
+	for _, test := range tests {
+		_, err := scanAndParse(test.input)
+		if err == nil || err.Error() != test.err {
+			t.Fatalf(`Parsed "%s" incorrectly.
+			Expected error: %s
+			Received: %v`,
+				test.input, test.err, err)
+		}
+	}
+
+	src := "x := 1\n\ty := [1, 2 3]"
+	_, err := scanAndParse(src)
+	pe, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf(`Expected a ParseError for "%s", received %v`, src, err)
+	}
+	expected := "Line 2, column 13: Expected an operator, \",\" or \"]\", found integer literal \"3\"\n" +
+		"    \ty := [1, 2 3]\n" +
+		"    \t           ^"
+	if pe.Render(src) != expected {
+		t.Fatalf("Rendered parse error incorrectly.\nExpected:\n%s\nReceived:\n%s", expected, pe.Render(src))
+	}
+}
+
+func TestScanStrings(t *testing.T) {
+	tests := []struct {
+		input, lexeme string
+		nextLine      int // line of the token after the string
+	}{
+		{`"tab\tquote\"" x`, "tab\tquote\"", 1},
+		{"\"two\nlines\" x", "two\nlines", 2},
+		{"`C:\\new\\${dir}` x", `C:\new\${dir}`, 1},
+		{"`a\n\\d+\n` x", "a\n\\d+\n", 3},
+		{"\"\"\"\n\tSELECT *\n\t  FROM \"users\"\n\n\tWHERE id = 1\\t\n\t\"\"\" x", "SELECT *\n  FROM \"users\"\n\nWHERE id = 1\t", 6},
+		{`"""one "line" """ x`, `one "line" `, 1},
+		// only the whitespace the lines share is stripped, so a tab isn't the same as spaces
+		{"\"\"\"\n\tfoo\n    bar\n\"\"\" x", "\tfoo\n    bar", 4},
+		{"\"\"\"\n\t  foo\n\t bar\n\"\"\" x", " foo\nbar", 4},
+		{`"""""" x`, "", 1},
+	}
+
+	for _, test := range tests {
+		tkns, err := Scan(test.input)
+		if err != nil {
+			t.Fatalf(`Failed to scan "%s": %s`, test.input, err.Error())
+		}
+		if tkns[0].Type != StringTT || tkns[0].Lexeme != test.lexeme {
+			t.Fatalf("Scanned %q incorrectly.\nExpected: %q\nReceived: %q", test.input, test.lexeme, tkns[0].Lexeme)
+		}
+		if tkns[1].Line != test.nextLine {
+			t.Fatalf("Scanned %q incorrectly. Expected the next token on line %d, received line %d", test.input, test.nextLine, tkns[1].Line)
+		}
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	tests := []struct {
+		input, err string
+	}{
+		{`x := 1 @ 2`, `Line 1, column 8: Unexpected character '@'`},
+		{"x := 1\ny := ~3 + $", "Line 2, column 6: Unexpected character '~'\nLine 2, column 11: Unexpected character '$'"},
+		{`s := "abc`, `Line 1, column 6: Unterminated string`},
+		{`x := 1 +`, ``},
+		{"s := \"a ${x} b\ny := 1", `Line 1, column 6: Unterminated string`},
+		{"s := `raw\ny := 1", `Line 1, column 6: Unterminated string`},
+		{"s := \"\"\"\nmulti\"\"\ny := 1", `Line 1, column 6: Unterminated string`},
+	}
+
+	for _, test := range tests {
+		_, err := Scan(test.input)
+		if test.err == "" {
+			if err != nil {
+				t.Fatalf(`Scanned "%s" incorrectly. Unexpected error: %v`, test.input, err)
+			}
+			continue
+		}
+		if err == nil || err.Error() != test.err {
+			t.Fatalf(`Scanned "%s" incorrectly.
+			Expected error: %s
+			Received: %v`,
+				test.input, test.err, err)
+		}
+	}
+
+	src := "a := 1\nb := a € 2"
+	_, err := Scan(src)
+	var se *ScanError
+	if !errors.As(err, &se) {
+		t.Fatalf(`Expected a ScanError for "%s", received %v`, src, err)
+	}
+	if se.Line != 2 || se.Column != 8 || se.Char != '€' {
+		t.Fatalf("Expected '€' at line 2, column 8, received %q at line %d, column %d", se.Char, se.Line, se.Column)
+	}
+}
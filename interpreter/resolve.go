@@ -0,0 +1,648 @@
+package interpreter
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ResolveError is a misused variable found by Resolve before a program runs
+type ResolveError struct {
+	Line int
+	Msg  string
+}
+
+func (e *ResolveError) Error() string {
+	return fmt.Sprintf("Line %d: %s", e.Line, e.Msg)
+}
+
+// Render shows the error with the line of source it occurred on
+func (e *ResolveError) Render(src string) string {
+	lines := strings.Split(src, "\n")
+	if e.Line < 1 || e.Line > len(lines) {
+		return e.Error()
+	}
+	return fmt.Sprintf("%s\n    %s", e.Error(), strings.TrimRight(lines[e.Line-1], "\r"))
+}
+
+// ResolveErrors are all of the errors found by Resolve
+type ResolveErrors []*ResolveError
+
+func (es ResolveErrors) Error() string {
+	msgs := []string{}
+	for _, e := range es {
+		msgs = append(msgs, e.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (es ResolveErrors) Unwrap() []error {
+	errs := []error{}
+	for _, e := range es {
+		errs = append(errs, e)
+	}
+	return errs
+}
+
+// Render shows each error with the line of source it occurred on
+func (es ResolveErrors) Render(src string) string {
+	msgs := []string{}
+	for _, e := range es {
+		msgs = append(msgs, e.Render(src))
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// resolution is what Resolve works out about a node before it runs
+type resolution struct {
+	// identifiers and parameters: the local they refer to is in slot of the frame depth functions
+	// up from where they're used. Loops: slot holds index.
+	depth, slot int
+	// loops: the locals of their body, from start up to end, which are cleared each iteration
+	start, end int
+	// lambdas, spawn expressions, programs and loops whose body is a frame of its own: the locals
+	// of their frame
+	layout *layout
+}
+
+// layout describes the locals of a frame, each of which has a slot
+type layout struct {
+	names  []string // "" for the hidden locals used by the VM
+	consts []bool
+	types  []*Type
+	index  int // the local holding the index given by the function calling a lambda, or -1
+	// captures is set if the function uses locals of the frames it's nested in
+	captures bool
+}
+
+// resolver works out the locals of each function, spawn expression and program
+type resolver struct {
+	fn   *fnScope
+	errs ResolveErrors
+	seen map[string]bool
+}
+
+// fnScope is a function, spawn expression or program being resolved, whose blocks share a frame
+type fnScope struct {
+	parent *fnScope
+	layout *layout
+	blocks []*blockScope // innermost last
+	loops  []int         // the index locals of the for loops being resolved, innermost last
+	lambda bool
+	loop   bool // the body of a loop that's a frame of its own each iteration
+}
+
+// blockScope holds the locals declared in a block. Those of a program's top-level block are
+// declared by name when it runs, so they aren't given slots.
+type blockScope struct {
+	locals map[string]*local
+	global bool
+}
+
+// local is a variable declared in a block
+type local struct {
+	slot     int
+	line     int
+	constant bool
+	declared bool // its declaration has been reached
+	branch   bool // declared in the body of a conditional
+}
+
+// Resolve prepares a program to run, working out where each of its variables is kept. A function
+// keeps the locals declared in its blocks in the slots of its frame, and identifiers referring to
+// them are annotated with the slot, and how many functions up it is. Top-level declarations,
+// built-in functions and modules are still looked up by name. Variables used before they're
+// declared, declared twice in a block, or declared again in a nested block of the same function
+// are reported as ResolveErrors.
+func Resolve(root *Node) error {
+	if root == nil {
+		return nil
+	}
+
+	r := &resolver{seen: map[string]bool{}}
+	l := r.enterFunction(false)
+	r.block().global = true
+	r.body(root)
+	r.exitFunction()
+	root.res = &resolution{layout: l}
+
+	if len(r.errs) > 0 {
+		sort.SliceStable(r.errs, func(i, j int) bool { return r.errs[i].Line < r.errs[j].Line })
+		return r.errs
+	}
+	return nil
+}
+
+func (r *resolver) errorf(line int, format string, args ...interface{}) {
+	e := &ResolveError{Line: line, Msg: fmt.Sprintf(format, args...)}
+	if !r.seen[e.Error()] {
+		r.seen[e.Error()] = true
+		r.errs = append(r.errs, e)
+	}
+}
+
+func (r *resolver) enterFunction(lambda bool) *layout {
+	l := &layout{index: -1}
+	r.fn = &fnScope{parent: r.fn, layout: l, lambda: lambda}
+	r.enterBlock()
+	return l
+}
+
+func (r *resolver) exitFunction() {
+	r.fn = r.fn.parent
+}
+
+func (r *resolver) enterBlock() {
+	r.fn.blocks = append(r.fn.blocks, &blockScope{locals: map[string]*local{}})
+}
+
+func (r *resolver) exitBlock() {
+	r.fn.blocks = r.fn.blocks[:len(r.fn.blocks)-1]
+}
+
+// block gives the innermost block being resolved
+func (r *resolver) block() *blockScope {
+	return r.fn.blocks[len(r.fn.blocks)-1]
+}
+
+// slot adds a local to the frame of a function
+func (r *resolver) slot(fn *fnScope, name string, constant bool, t *Type) int {
+	l := fn.layout
+	l.names = append(l.names, name)
+	l.consts = append(l.consts, constant)
+	l.types = append(l.types, t)
+	return len(l.names) - 1
+}
+
+// add adds a local to the innermost block, before its declaration is reached
+func (r *resolver) add(ident *Node, constant, branch bool) *local {
+	b := r.block()
+	name := ident.Val.(string)
+	if l, ok := b.locals[name]; ok {
+		// both branches of a conditional may declare the same variable
+		if !l.branch || !branch {
+			r.errorf(ident.Line, "\"%s\" is already defined", name)
+		} else if l.constant != constant {
+			r.errorf(ident.Line, "\"%s\" is declared as both a constant and a variable", name)
+		}
+		return l
+	}
+
+	l := &local{slot: -1, line: ident.Line, constant: constant, branch: branch}
+	if !b.global {
+		l.slot = r.slot(r.fn, name, constant, annotation(ident))
+	}
+	b.locals[name] = l
+	return l
+}
+
+// bind declares a local that's bound as soon as it's added, like a parameter or an identifier in
+// a pattern. These may shadow variables of enclosing blocks.
+func (r *resolver) bind(ident *Node, constant bool, t *Type) {
+	l := &local{slot: r.slot(r.fn, ident.Val.(string), constant, t), line: ident.Line, constant: constant, declared: true}
+	r.block().locals[ident.Val.(string)] = l
+	ident.res = &resolution{slot: l.slot}
+}
+
+// hoist adds the locals declared by a chain of statements to the innermost block before they're
+// resolved, so that lambdas in the block can refer to locals declared after them. The bodies of
+// conditionals share the block they're in.
+func (r *resolver) hoist(stmts *Node, branch bool) {
+	for n := stmts; n != nil && n.Type == StmtNT; n = n.R {
+		switch s := n.L; {
+		case s == nil:
+		case s.Type == ConstDeclNT || s.Type == VarDeclNT:
+			for _, ident := range targetIdents(s.L) {
+				if ident.Type == IdentifierNT {
+					r.add(ident, s.Type == ConstDeclNT, branch)
+				}
+			}
+		case s.Type == IfNT:
+			r.hoistBranch(s.R)
+		}
+	}
+}
+
+func (r *resolver) hoistBranch(n *Node) {
+	switch n.Type {
+	case ThenBranchNT:
+		r.hoistBranch(n.L)
+		r.hoistBranch(n.R)
+	case IfNT:
+		r.hoistBranch(n.R)
+	case StmtNT:
+		r.hoist(n, true)
+	}
+}
+
+// declare reaches the declaration of the identifiers in a target, which were added to the
+// innermost block by hoist
+func (r *resolver) declare(target *Node, constant bool) {
+	b := r.block()
+	for _, ident := range targetIdents(target) {
+		if ident.Type != IdentifierNT {
+			continue
+		}
+
+		name := ident.Val.(string)
+		l, ok := b.locals[name]
+		if !ok {
+			// declared somewhere hoist doesn't look, e.g. a match arm whose body is a declaration
+			l = r.add(ident, constant, false)
+		}
+
+		for _, b := range r.enclosing() {
+			if outer, ok := b.locals[name]; ok && outer.declared {
+				r.errorf(ident.Line, "\"%s\" shadows the variable declared on line %d", name, outer.line)
+				break
+			}
+		}
+
+		l.declared = true
+		if l.slot >= 0 {
+			ident.res = &resolution{slot: l.slot}
+		}
+	}
+}
+
+// enclosing gives the blocks the innermost block is nested in, innermost first, up to the start of
+// the function being resolved. The bodies of loops that are frames of their own are part of the
+// function they're in.
+func (r *resolver) enclosing() []*blockScope {
+	var blocks []*blockScope
+	skip := 1
+	for fn := r.fn; fn != nil; fn = fn.parent {
+		for i := len(fn.blocks) - 1 - skip; i >= 0; i-- {
+			blocks = append(blocks, fn.blocks[i])
+		}
+		skip = 0
+		if !fn.loop {
+			break
+		}
+	}
+	return blocks
+}
+
+// lookup finds the local an identifier refers to. Variables declared after a lambda may be used
+// inside it, but anything else must be declared first. Names that aren't locals are left to be
+// looked up at runtime.
+func (r *resolver) lookup(ident *Node) {
+	name := ident.Val.(string)
+	depth := 0
+	direct := true // whether only the bodies of loops are between the frame and where it's used
+	for fn := r.fn; fn != nil; fn, depth = fn.parent, depth+1 {
+		for i := len(fn.blocks) - 1; i >= 0; i-- {
+			l, ok := fn.blocks[i].locals[name]
+			if !ok {
+				continue
+			}
+			if !l.declared && direct {
+				r.errorf(ident.Line, "\"%s\" is used before it's declared", name)
+				return
+			}
+			if l.slot >= 0 {
+				ident.res = &resolution{depth: depth, slot: l.slot}
+				r.capture(depth)
+			}
+			return
+		}
+		direct = direct && fn.loop
+	}
+}
+
+// capture records that the functions being resolved, up to depth functions up, use a local of the
+// frame they're nested in
+func (r *resolver) capture(depth int) {
+	for fn := r.fn; depth > 0; fn, depth = fn.parent, depth-1 {
+		fn.layout.captures = true
+	}
+}
+
+// index finds the local holding index: the index of the innermost for loop, or else the one given
+// to a lambda by the function calling it. A spawn expression sees the index of where it's spawned.
+func (r *resolver) index(n *Node) {
+	depth := 0
+	for fn := r.fn; fn != nil; fn, depth = fn.parent, depth+1 {
+		if len(fn.loops) > 0 {
+			n.res = &resolution{depth: depth, slot: fn.loops[len(fn.loops)-1]}
+			r.capture(depth)
+			return
+		}
+		if fn.lambda {
+			if fn.layout.index < 0 {
+				fn.layout.index = r.slot(fn, "index", true, nil)
+			}
+			n.res = &resolution{depth: depth, slot: fn.layout.index}
+			return
+		}
+	}
+}
+
+// body resolves a chain of statements that's a block of its own, or an expression
+func (r *resolver) body(n *Node) {
+	if n.Type == StmtNT {
+		r.hoist(n, false)
+		r.stmts(n)
+	} else {
+		r.expr(n)
+	}
+}
+
+// stmts resolves a chain of statements in the innermost block. Statements that are blocks have
+// blocks of their own.
+func (r *resolver) stmts(root *Node) {
+	for n := root; n != nil; n = n.R {
+		switch {
+		case n.L == nil:
+		case n.L.Type == StmtNT:
+			r.enterBlock()
+			r.body(n.L)
+			r.exitBlock()
+		default:
+			r.expr(n.L)
+		}
+	}
+}
+
+// inline resolves the body of a conditional, which shares the block it's in
+func (r *resolver) inline(n *Node) {
+	switch n.Type {
+	case StmtNT:
+		r.stmts(n)
+	case ThenBranchNT:
+		r.inline(n.L)
+		r.inline(n.R)
+	default:
+		r.expr(n)
+	}
+}
+
+func (r *resolver) expr(n *Node) {
+	if n == nil {
+		return
+	}
+
+	switch n.Type {
+	case StmtNT:
+		r.stmts(n)
+	case IdentifierNT, UnderscoreNT:
+		r.lookup(n)
+	case IndexNT:
+		r.index(n)
+
+	// literals
+	case ListNT:
+		for _, m := range n.Val.([]*Node) {
+			r.expr(m)
+		}
+	case TupleNT:
+		for _, m := range n.Val.([]*Node) {
+			r.expr(m)
+		}
+	case SetItemNT, TemplateNT:
+		for curr := n; curr != nil; curr = curr.R {
+			r.expr(curr.L)
+		}
+	case ObjectItemNT:
+		for curr := n; curr != nil; curr = curr.R {
+			switch item := curr.L; item.Type {
+			case KVPairNT:
+				// { key: val } has a literal key
+				if item.L.Type != IdentifierNT {
+					r.expr(item.L)
+				}
+				r.expr(item.R)
+			case SplatNT:
+				r.expr(item.R)
+			}
+		}
+	case ComprehensionNT:
+		r.comprehension(n)
+	case IntNT, FloatNT, BoolNT, StringNT, FailNT, SuccessNT, NullNT, SetNT, ObjectNT, BreakNT, ContinueNT, ImportNT, TypeNT:
+
+	// access
+	case FieldAccessNT:
+		r.expr(n.L)
+	case ListSliceNT:
+		r.expr(n.L)
+		r.expr(n.R.L)
+		r.expr(n.R.R)
+
+	// statements
+	case ConstDeclNT, VarDeclNT:
+		r.expr(n.R)
+		r.declare(n.L, n.Type == ConstDeclNT)
+	case AssignmentNT:
+		r.expr(n.R)
+		switch n.L.Type {
+		case IdentifierNT:
+			r.lookup(n.L)
+		case FieldAccessNT:
+			r.expr(n.L.L)
+		default:
+			r.expr(n.L)
+		}
+	case IfNT:
+		r.expr(n.L)
+		r.inline(n.R)
+	case MatchNT:
+		r.match(n)
+	case WhileStmtNT:
+		r.expr(n.L)
+		n.res = r.loop(n.R, nil)
+	case ForStmtNT:
+		r.expr(n.L.R)
+		n.res = r.loop(n.R, n.L.L)
+
+	// functions
+	case LambdaNT:
+		r.lambda(n)
+	case SpawnNT:
+		l := r.enterFunction(false)
+		r.body(n.R)
+		r.exitFunction()
+		n.res = &resolution{layout: l}
+	case CallNT:
+		r.expr(n.L)
+		for arg := n.R; arg != nil && arg.L != nil; arg = arg.R {
+			r.expr(arg.L)
+		}
+	case FoldNT:
+		r.expr(n.L)
+		r.expr(n.R)
+		if init, ok := n.Val.(*Node); ok {
+			r.expr(init)
+		}
+
+	default:
+		r.expr(n.L)
+		r.expr(n.R)
+	}
+}
+
+func (r *resolver) lambda(n *Node) {
+	l := r.enterFunction(true)
+	for p := n.L; p != nil && (p.Val != nil || p.L != nil); p = p.R {
+		if p.Val != nil {
+			r.param(p, annotation(p))
+			continue
+		}
+
+		// destructured parameter
+		for _, ident := range targetIdents(p.L) {
+			if ident.Type == IdentifierNT {
+				r.param(ident, nil)
+			}
+		}
+	}
+	r.body(n.R)
+	r.exitFunction()
+	n.res = &resolution{layout: l}
+}
+
+// param declares a parameter of the lambda being resolved
+func (r *resolver) param(p *Node, t *Type) {
+	if l, ok := r.block().locals[p.Val.(string)]; ok && l.declared {
+		r.errorf(p.Line, "\"%s\" is already defined", p.Val.(string))
+		return
+	}
+	r.bind(p, false, t)
+}
+
+// loop resolves the body of a loop, which is a block of its own each iteration, with the
+// iterator of a for loop and index declared in it. Closures made in the body keep the locals of
+// the iteration they're made in, so if it makes any, the body is a frame of its own each iteration.
+func (r *resolver) loop(body, iterator *Node) *resolution {
+	var l *layout
+	if makesClosures(body) {
+		l = r.enterFunction(false)
+		r.fn.loop = true
+	} else {
+		r.enterBlock()
+	}
+	res := &resolution{slot: -1, start: len(r.fn.layout.names), layout: l}
+	if iterator != nil {
+		for _, ident := range targetIdents(iterator) {
+			if ident.Type == IdentifierNT {
+				r.add(ident, true, false)
+			}
+		}
+		r.declare(iterator, true)
+		res.slot = r.slot(r.fn, "index", true, nil)
+		r.fn.loops = append(r.fn.loops, res.slot)
+	}
+
+	if body != nil {
+		r.body(body)
+	}
+
+	if iterator != nil {
+		r.fn.loops = r.fn.loops[:len(r.fn.loops)-1]
+	}
+	res.end = len(r.fn.layout.names)
+	if l != nil {
+		r.exitFunction()
+	} else {
+		r.exitBlock()
+	}
+	return res
+}
+
+// makesClosures reports whether any lambdas or tasks are made in a subtree
+func makesClosures(n *Node) bool {
+	if n == nil {
+		return false
+	}
+	if n.Type == LambdaNT || n.Type == SpawnNT {
+		return true
+	}
+	switch val := n.Val.(type) {
+	case []*Node:
+		for _, m := range val {
+			if makesClosures(m) {
+				return true
+			}
+		}
+	case *Node:
+		if makesClosures(val) {
+			return true
+		}
+	}
+	return makesClosures(n.L) || makesClosures(n.R)
+}
+
+// match resolves a match expression. Each arm is a block, with the identifiers of its pattern
+// declared in it.
+func (r *resolver) match(n *Node) {
+	r.expr(n.L)
+	for c := n.R; c != nil; c = c.R {
+		arm := c.L
+		pattern, guard := arm.L, (*Node)(nil)
+		if pattern.Type == IfNT {
+			pattern, guard = pattern.R, pattern.L
+		}
+
+		r.enterBlock()
+		r.pattern(pattern)
+		r.expr(guard)
+		r.body(arm.R)
+		r.exitBlock()
+	}
+}
+
+// pattern declares the identifiers of a pattern in the innermost block
+func (r *resolver) pattern(p *Node) {
+	switch p.Type {
+	case IdentifierNT:
+		r.bind(p, true, nil)
+	case ListNT:
+		for _, m := range p.Val.([]*Node) {
+			if m.Type == SplatNT {
+				r.pattern(m.R)
+			} else {
+				r.pattern(m)
+			}
+		}
+	case TupleNT:
+		for _, m := range p.Val.([]*Node) {
+			r.pattern(m)
+		}
+	case ObjectItemNT:
+		for m := p; m != nil; m = m.R {
+			if m.L.Type == KVPairNT {
+				r.pattern(m.L.R)
+			} else {
+				r.pattern(m.L)
+			}
+		}
+	}
+}
+
+// comprehension resolves a comprehension, whose generators each bind their pattern in a block
+// nested in the one before
+func (r *resolver) comprehension(n *Node) {
+	blocks := 0
+	for clause := n.R; clause != nil; clause = clause.R {
+		gen := clause.L
+		if gen.Type != GeneratorNT {
+			r.expr(gen)
+			continue
+		}
+
+		r.expr(gen.R)
+		r.enterBlock()
+		blocks++
+		r.pattern(gen.L)
+	}
+
+	if n.Val.(NodeType) == ObjectNT {
+		r.expr(n.L.L)
+		r.expr(n.L.R)
+	} else {
+		r.expr(n.L)
+	}
+
+	for ; blocks > 0; blocks-- {
+		r.exitBlock()
+	}
+}
@@ -0,0 +1,158 @@
+package interpreter
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	tests := []ExprTest{
+		// lambdas are closures of the scope they're created in, rather than the one they're called from
+		{`
+			x := "global"
+			f := () => x
+			g := () => {
+				x := "local"
+				f()
+			}
+			g()
+		`, StringDT, `"global"`},
+		{`
+			adders := [1, 2, 3] map n => m => m + n
+			adders map a => a(100)
+		`, ListDT, `[101, 102, 103]`},
+		// lambdas may use variables declared after them
+		{`
+			f := () => {
+				g := () => y * 2
+				y := 21
+				g()
+			}
+			f()
+		`, IntDT, `42`},
+		// both branches of a conditional may declare a variable, which belongs to the enclosing block
+		{`
+			f := c => {
+				if c {
+					y := "yes"
+				} else {
+					y := "no"
+				}
+				y
+			}
+			[f(true), f(false)]
+		`, ListDT, `["yes", "no"]`},
+		// index is the for loop's, or else the one given by map (whose items are spread into the list)
+		{`
+			var seen := []
+			for x <- ["a", "b"] {
+				seen += [[10, 20] map _ + index, index]
+			}
+			seen
+		`, ListDT, `[10, 21, 0, 10, 21, 1]`},
+		// patterns and parameters may reuse names from enclosing blocks
+		{`
+			n := 5
+			f := n => match n { [n, m] => n + m, n => n }
+			[f([1, 2]), f(n)]
+		`, ListDT, `[3, 5]`},
+		// closures made in a loop keep the locals of the iteration they're made in
+		{`
+			var gs := []
+			for i <- ..3 {
+				x := i * 10
+				gs += [() => [i, x]]
+			}
+			gs map (g => g())
+		`, ListDT, `[[0, 0], [1, 10], [2, 20]]`},
+		{`
+			f := () => {
+				var total := 0
+				var gs := []
+				var n := 0
+				while n < 3 {
+					m := n
+					gs += [() => m + total]
+					total = total + 1
+					n = n + 1
+				}
+				gs map (g => g())
+			}
+			f()
+		`, ListDT, `[3, 4, 5]`},
+	}
+
+	for _, test := range tests {
+		runExprTest(test, t)
+	}
+
+	errTests := []struct {
+		input string
+		errs  []string
+	}{
+		{`
+			print(x)
+			x := 1
+		`, []string{`Line 2: "x" is used before it's declared`}},
+		{`
+			f := () => {
+				y := z + 1
+				z := 2
+			}
+		`, []string{`Line 3: "z" is used before it's declared`}},
+		{`
+			x := 1
+			x := 2
+		`, []string{`Line 3: "x" is already defined`}},
+		{`
+			f := n => {
+				n := 1
+				var m := 1
+				if n > 0: m := 2
+			}
+		`, []string{`Line 3: "n" is already defined`, `Line 5: "m" is already defined`}},
+		{`
+			x := 1
+			for i <- ..3 {
+				x := i
+			}
+		`, []string{`Line 4: "x" shadows the variable declared on line 2`}},
+		{`
+			f := xs => {
+				total := 0
+				for x <- xs {
+					for total <- xs: print(total)
+				}
+			}
+		`, []string{`Line 5: "total" shadows the variable declared on line 3`}},
+		{`
+			f := xs => {
+				total := 0
+				for x <- xs {
+					total := x
+					g := () => total
+				}
+			}
+		`, []string{`Line 5: "total" shadows the variable declared on line 3`}},
+		{`
+			if true {
+				a := 1
+			} else {
+				var a := 2
+			}
+		`, []string{`Line 5: "a" is declared as both a constant and a variable`}},
+	}
+
+	for _, test := range errTests {
+		_, err := scanAndParse(test.input)
+		var re ResolveErrors
+		if !errors.As(err, &re) || len(re) != len(test.errs) {
+			t.Fatalf(`Expected "%s" to raise %v, received %v`, test.input, test.errs, err)
+		}
+		for i, e := range re {
+			if e.Error() != test.errs[i] {
+				t.Fatalf(`Expected "%s" to raise %v, received %v`, test.input, test.errs, err)
+			}
+		}
+	}
+}
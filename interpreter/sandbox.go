@@ -0,0 +1,207 @@
+package interpreter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+)
+
+// Limits restrict the resources a program may use, e.g. when running code from users. Zero values
+// are unlimited.
+type Limits struct {
+	Steps      int64  // nodes evaluated
+	Depth      int    // nested function calls, lowering the maximum depth (see SetMaxDepth)
+	Items      int    // items in a list, set, object or tuple
+	StringSize int    // bytes in a string
+	FSRoot     string // the directory readFile and import are restricted to
+}
+
+// LimitError is raised when a program goes over one of its Limits. Going over the Depth limit is a
+// stack overflow instead, like going over the maximum depth it lowers.
+type LimitError struct {
+	Limit string // the name of the limit, e.g. "step"
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("Exceeded the %s limit of %d", e.Limit, e.Max)
+}
+
+// CancelledError is raised when a program is stopped because its context is done. It wraps the
+// context's error, so errors.Is can tell a timeout (context.DeadlineExceeded) from a cancellation
+// (context.Canceled).
+type CancelledError struct {
+	Err error
+}
+
+func (e *CancelledError) Error() string {
+	if errors.Is(e.Err, context.DeadlineExceeded) {
+		return "Evaluation timed out"
+	}
+	return "Evaluation was cancelled"
+}
+
+func (e *CancelledError) Unwrap() error {
+	return e.Err
+}
+
+// sandbox is shared by the scopes of a program run with Limits
+type sandbox struct {
+	Limits
+	ctx   context.Context
+	steps int64
+}
+
+// ctxCheckInterval is how many steps are taken between checking whether a sandbox's context is
+// done, as checking is slow compared to most steps
+const ctxCheckInterval = 256
+
+// Sandbox restricts programs run in an environment, and in any scopes created by them, to limits.
+// Once ctx is done, they stop with a *CancelledError. Each call resets the count of steps taken, so it
+// should be called before each program is run, rather than while one is running. Without limits or
+// a context that can be done, programs aren't sandboxed at all, which is faster.
+func (env *Environment) Sandbox(ctx context.Context, limits Limits) {
+	if limits == (Limits{}) && (ctx == nil || ctx.Done() == nil) {
+		env.mu.Lock()
+		defer env.mu.Unlock()
+		env.sandbox = nil
+		return
+	}
+
+	if limits.FSRoot != "" {
+		if root, err := filepath.Abs(limits.FSRoot); err == nil {
+			limits.FSRoot = root
+		}
+		if root, err := filepath.EvalSymlinks(limits.FSRoot); err == nil {
+			limits.FSRoot = root
+		}
+	}
+
+	env.mu.Lock()
+	defer env.mu.Unlock()
+	env.sandbox = &sandbox{Limits: limits, ctx: ctx}
+}
+
+// InterpretContext evaluates a node like Interpret, but stops with a *CancelledError once ctx is
+// done. Loops, function calls and the items of map and where check ctx as they go. Any limits env
+// is sandboxed with still apply, with a fresh step budget.
+func InterpretContext(ctx context.Context, n *Node, env *Environment) (Value, error) {
+	defer withContext(ctx, env)()
+	return Interpret(n, env)
+}
+
+// RunContext runs a compiled chunk like Run, but stops with a *CancelledError once ctx is done,
+// as InterpretContext does
+func RunContext(ctx context.Context, ch *Chunk, env *Environment) (Value, error) {
+	defer withContext(ctx, env)()
+	return Run(ch, env)
+}
+
+// withContext sandboxes env with ctx, keeping any limits it already has, and returns a function
+// that restores its previous sandbox
+func withContext(ctx context.Context, env *Environment) func() {
+	prev := env.limits()
+	var limits Limits
+	if prev != nil {
+		limits = prev.Limits
+	}
+
+	env.Sandbox(ctx, limits)
+	return func() {
+		env.mu.Lock()
+		defer env.mu.Unlock()
+		env.sandbox = prev
+	}
+}
+
+// done returns a channel that's closed once a sandbox's context is done, or nil (which blocks
+// forever) if it has none
+func (s *sandbox) done() <-chan struct{} {
+	if s == nil || s.ctx == nil {
+		return nil
+	}
+	return s.ctx.Done()
+}
+
+// step counts a step, raising an error once the step limit is exceeded or the context is done
+func (s *sandbox) step() error {
+	steps := atomic.AddInt64(&s.steps, 1)
+	if s.Steps > 0 && steps > s.Steps {
+		return &LimitError{Limit: "step", Max: s.Steps}
+	}
+	// the first step is checked too, so programs run with a context that's already done don't start
+	if steps%ctxCheckInterval == 1 {
+		return s.cancelled()
+	}
+	return nil
+}
+
+// cancelled raises an error once a sandbox's context is done
+func (s *sandbox) cancelled() error {
+	if s == nil || s.ctx == nil {
+		return nil
+	}
+	if err := s.ctx.Err(); err != nil {
+		return &CancelledError{Err: err}
+	}
+	return nil
+}
+
+// checkSize raises an error if a value is larger than the size limits allow
+func (s *sandbox) checkSize(v Value) error {
+	items := 0
+	switch v.Type {
+	case StringDT:
+		if s.StringSize > 0 && len(v.Str()) > s.StringSize {
+			return &LimitError{Limit: "string size", Max: int64(s.StringSize)}
+		}
+		return nil
+	case ListDT:
+		items = v.List().Len()
+	case TupleDT:
+		items = len(v.Tuple())
+	case SetDT:
+		items = v.Set().Len()
+	case ObjectDT:
+		items = v.Object().Len()
+	}
+	return s.checkItems(items)
+}
+
+// checkItems raises an error if a collection of a given size would be larger than the limit
+func (s *sandbox) checkItems(items int) error {
+	if s.Items > 0 && items > s.Items {
+		return &LimitError{Limit: "item", Max: int64(s.Items)}
+	}
+	return nil
+}
+
+// resolvePath finds the file a path refers to, relative to dir. In a sandbox with a filesystem
+// root, relative paths are instead found from the root, and paths outside of it raise an error.
+func (s *sandbox) resolvePath(path, dir string) (string, error) {
+	if s == nil || s.FSRoot == "" {
+		if filepath.IsAbs(path) {
+			return path, nil
+		}
+		return filepath.Join(dir, path), nil
+	}
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(s.FSRoot, path)
+	}
+	path = filepath.Clean(path)
+	// symlinks inside the root may point outside of it
+	if real, err := filepath.EvalSymlinks(path); err == nil {
+		path = real
+	}
+
+	rel, err := filepath.Rel(s.FSRoot, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(os.PathSeparator)) {
+		return "", fmt.Errorf("Cannot access \"%s\", which is outside of the sandbox's root directory", path)
+	}
+	return path, nil
+}
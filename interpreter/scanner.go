@@ -1,273 +1,584 @@
 package interpreter
 
 import (
-	"fmt"
+	"bufio"
+	"io"
 	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
-// Scan ...
-func Scan(src string) []Token {
-	tokens := make([]Token, 0)
-	return scan(tokens, src, 1)
+// Scan splits source code into tokens. Scanning continues past unexpected characters, so that
+// every one is reported in the returned ScanErrors.
+func Scan(src string) ([]Token, error) {
+	s := NewScanner(strings.NewReader(src))
+	tokens := make([]Token, 0, len(src)/4)
+	for {
+		t := s.Next()
+		tokens = append(tokens, t)
+		if t.Type == EOFTT {
+			return tokens, s.Err()
+		}
+	}
+}
+
+// Scanner reads tokens one at a time from a stream of source code. Once the stream is exhausted,
+// Next keeps returning EOF tokens.
+type Scanner struct {
+	r       *bufio.Reader
+	pos     position // position of the next unread character
+	queued  []Token  // tokens already scanned but not yet returned
+	errs    ScanErrors
+	readErr error
+	done    bool
+
+	// interpolations being scanned, innermost last. Each counts the braces opened inside it, so
+	// that the closing brace of the interpolation can be told apart.
+	templates []template
+}
+
+type template struct {
+	start  position // start of the string literal
+	braces int
+}
+
+// position is a location in the source, with lines and columns counted from 1
+type position struct {
+	line, column, offset int
 }
 
-func scan(scanned []Token, remaining string, line int) []Token {
-	if len(remaining) == 0 {
-		scanned = append(scanned, Token{NewLineTT, line, ""})
-		return append(scanned, Token{EOFTT, line, "\x00"})
+// NewScanner creates a Scanner reading source code from r
+func NewScanner(r io.Reader) *Scanner {
+	return &Scanner{
+		r:   bufio.NewReader(r),
+		pos: position{line: 1, column: 1},
 	}
+}
 
-	r := remaining[0]
-	switch r {
-	// whitespace
-	case '\n':
-		scanned = append(scanned, Token{NewLineTT, line, ""})
-		return scan(scanned, remaining[1:], line+1)
-	case '\t', '\r', ' ':
-		return scan(scanned, remaining[1:], line)
-
-	// 1 character
-	case '(', ')', '{', '}', '[', ']', ';', ',', '?', '^', '#', '_':
-		if tt, ok := scanOneRune(r); ok {
-			if tt == RightBraceTT {
-				scanned = append(scanned, Token{NewLineTT, line, ""}) // insert newline at end of block
+// Err returns the error from reading the stream if there was one, or else any scanning errors
+// found so far
+func (s *Scanner) Err() error {
+	if s.readErr != nil {
+		return s.readErr
+	}
+	if len(s.errs) > 0 {
+		return s.errs
+	}
+	return nil
+}
+
+// Next scans the next token
+func (s *Scanner) Next() Token {
+	if len(s.queued) > 0 {
+		t := s.queued[0]
+		s.queued = s.queued[1:]
+		return t
+	}
+
+	for {
+		start := s.pos
+		c, ok := s.peek(0)
+		if !ok {
+			if s.done {
+				return start.token(EOFTT, "\x00")
 			}
-			scanned = append(scanned, Token{tt, line, string(r)})
-			return scan(scanned, remaining[1:], line)
+			s.done = true
+			s.queued = append(s.queued, start.token(EOFTT, "\x00"))
+			return start.token(NewLineTT, "")
 		}
-		fmt.Printf("Scanning error on line %d: Unexpected character \"%s\"\n", line, string(r))
-		return nil
-
-	// 1-2 characters
-	case '!', '=', '>', '<', ':', '-', '+', '/', '*', '%', '|':
-		if tt, ok := scanTwoRune(r, remaining[1]); ok {
-			if tt == CommentTT {
-				remaining = scanComment(remaining)
-				return scan(scanned, remaining, line)
+
+		switch c {
+		// whitespace
+		case '\n':
+			s.advance()
+			return start.token(NewLineTT, "")
+		case '\t', '\r', ' ':
+			s.advance()
+			continue
+
+		// 1 character
+		case '(', ')', '{', '}', '[', ']', ';', ',', '?', '^', '#', '_':
+			if t := len(s.templates) - 1; t >= 0 {
+				if c == '{' {
+					s.templates[t].braces++
+				} else if c == '}' && s.templates[t].braces == 0 {
+					// end of an interpolation, so continue with the rest of the string
+					tmpl := s.templates[t]
+					s.templates = s.templates[:t]
+					s.advance()
+					if tkn, ok := s.scanString(tmpl.start, start, true); ok {
+						return tkn
+					}
+					continue
+				} else if c == '}' {
+					s.templates[t].braces--
+				}
 			}
-			scanned = append(scanned, Token{tt, line, string(r) + string(remaining[1])})
-			return scan(scanned, remaining[2:], line)
-		} else if tt, ok = scanOneRune(r); ok {
-			scanned = append(scanned, Token{tt, line, string(r)})
-			return scan(scanned, remaining[1:], line)
-		} else {
-			fmt.Printf("Scanning error on line %d: Unexpected character \"%s\"\n", line, string(r))
-			return nil
-		}
-	case '.':
-		if len(remaining) > 1 {
-			n := remaining[1]
+			s.advance()
+			tt, _ := scanOneRune(c)
+			if tt == RightBraceTT {
+				// insert newline at end of block
+				s.queued = append(s.queued, start.token(tt, "}"))
+				return start.token(NewLineTT, "")
+			}
+			return start.token(tt, string(c))
+
+		// 1-2 characters
+		case '!', '=', '>', '<', ':', '-', '+', '/', '*', '%', '|':
+			if n, ok := s.peek(1); ok {
+				lexeme := string([]byte{c, n})
+				if tt, ok := scanTwoRune(lexeme); ok {
+					if tt == CommentTT {
+						s.skipComment()
+						continue
+					}
+					s.advance()
+					s.advance()
+					return start.token(tt, lexeme)
+				}
+			}
+			s.advance()
+			tt, _ := scanOneRune(c)
+			return start.token(tt, string(c))
+
+		case '.':
+			s.advance()
+			n, _ := s.peek(0)
 			if n == '.' {
-				// ...
-				if len(remaining) > 2 && remaining[2] == '.' {
-					scanned = append(scanned, Token{DotDotDotTT, line, "..."})
-					return scan(scanned, remaining[3:], line)
+				s.advance()
+				if n, _ := s.peek(0); n == '.' {
+					s.advance()
+					return start.token(DotDotDotTT, "...")
 				}
-				// ..
-				scanned = append(scanned, Token{DotDotTT, line, string(r) + string(n)})
-				return scan(scanned, remaining[2:], line)
-			} else if isDigit(n) {
-				// float
-				ds, remaining := scanDigits(remaining[1:])
-				scanned = append(scanned, Token{FloatTT, line, "." + ds})
-				return scan(scanned, remaining, line)
-			} else {
-				// .
-				scanned = append(scanned, Token{DotTT, line, string(r)})
-				return scan(scanned, remaining[1:], line)
+				return start.token(DotDotTT, "..")
 			}
-		}
+			if isDigit(n) {
+				return start.token(FloatTT, "."+s.digits())
+			}
+			return start.token(DotTT, ".")
 
-	// string
-	case '"':
-		t, remaining, ln := scanString(remaining, line)
-		if ln == -1 {
-			fmt.Printf("Scanning error: Unterminated string starting on line %d\n", line)
-			return nil
-		}
-		scanned = append(scanned, t)
-		return scan(scanned, remaining[1:], ln)
-	default:
-		// numbers
-		if isDigit(r) {
-			n, remaining := scanDigits(remaining)
-			// check if float
-			if len(remaining) > 0 && remaining[0] == '.' {
-				// check range operator
-				if len(remaining) > 1 && remaining[1] == '.' {
-					scanned = append(scanned, Token{IntTT, line, n})
-					return scan(scanned, remaining, line)
+		// string
+		case '"':
+			if s.triple('"') {
+				if t, ok := s.scanMultilineString(start); ok {
+					return t
 				}
-				m, remaining := scanDigits(remaining[1:])
-				n += "." + m
-				scanned = append(scanned, Token{FloatTT, line, n})
-				return scan(scanned, remaining, line)
+				continue
 			}
-			scanned = append(scanned, Token{IntTT, line, n})
-			return scan(scanned, remaining, line)
-		}
-		// identifiers
-		if isAlpha(r) {
-			s, remaining := scanIdentifier(remaining)
-			if tt, ok := scanKeyword(s); ok {
-				scanned = append(scanned, Token{tt, line, s})
-				return scan(scanned, remaining, line)
+			s.advance()
+			if t, ok := s.scanString(start, start, false); ok {
+				return t
+			}
+			continue
+		case '`':
+			s.advance()
+			if t, ok := s.scanRawString(start); ok {
+				return t
+			}
+			continue
+
+		default:
+			if isDigit(c) {
+				return s.scanNumber(start)
+			}
+			if r := s.peekRune(); isIdentifierStart(r) {
+				return s.scanIdentifier(start)
 			}
-			scanned = append(scanned, Token{IdentifierTT, line, s})
-			return scan(scanned, remaining, line)
 		}
-		// error
-		fmt.Printf("Scanning error: Unexpected character \"%s\" on line %d\n", string(r), line)
-		return nil
+
+		s.unexpected()
 	}
+}
 
-	return nil
+// peek looks ahead n bytes without consuming anything
+func (s *Scanner) peek(n int) (byte, bool) {
+	b, err := s.r.Peek(n + 1)
+	if len(b) <= n {
+		if err != nil && err != io.EOF && s.readErr == nil {
+			s.readErr = err
+		}
+		return 0, false
+	}
+	return b[n], true
 }
 
-func scanTwoRune(a byte, b byte) (TokenType, bool) {
-	twoRunes := map[string]TokenType{
-		"=>":  ArrowTT,
-		"<-":  LeftArrowTT,
-		"!=":  BangEqualTT,
-		"==":  EqualEqualTT,
-		">=":  GreaterEqualTT,
-		"<=":  LessEqualTT,
-		":=":  ColonEqualTT,
-		"-=":  MinusEqualTT,
-		"+=":  PlusEqualTT,
-		"/=":  SlashEqualTT,
-		"*=":  StarEqualTT,
-		"%=":  ModuloEqualTT,
-		"..":  DotDotTT,
-		"...": DotDotDotTT,
-		"//":  CommentTT,
-		"|=":  BarEqualTT,
-		"|>":  PipeTT,
+// peekRune looks ahead at the next character without consuming it
+func (s *Scanner) peekRune() rune {
+	b, _ := s.r.Peek(utf8.UTFMax)
+	r, _ := utf8.DecodeRune(b)
+	return r
+}
+
+// advance consumes one byte, keeping track of lines and columns
+func (s *Scanner) advance() byte {
+	b, err := s.r.ReadByte()
+	if err != nil {
+		return 0
 	}
-	tt, ok := twoRunes[string(a)+string(b)]
-	return tt, ok
+	s.pos.offset++
+	if b == '\n' {
+		s.pos.line++
+		s.pos.column = 1
+	} else if utf8.RuneStart(b) {
+		s.pos.column++
+	}
+	return b
 }
 
-func scanOneRune(r byte) (TokenType, bool) {
-	oneRune := map[byte]TokenType{
-		'(': LeftParenTT,
-		')': RightParenTT,
-		'{': LeftBraceTT,
-		'}': RightBraceTT,
-		'[': LeftBracketTT,
-		']': RightBracketTT,
-		':': ColonTT,
-		',': CommaTT,
-		'.': DotTT,
-		'-': MinusTT,
-		'+': PlusTT,
-		';': SemicolonTT,
-		'/': SlashTT,
-		'*': StarTT,
-		'%': ModuloTT,
-		'!': BangTT,
-		'=': EqualTT,
-		'>': GreaterTT,
-		'<': LessTT,
-		'?': QuestionMarkTT,
-		'|': BarTT,
-		'#': HashTT,
-		'^': CaratTT,
-		'_': UnderscoreTT,
+// advanceRune consumes a whole character, however many bytes it takes
+func (s *Scanner) advanceRune() {
+	b, _ := s.r.Peek(utf8.UTFMax)
+	_, size := utf8.DecodeRune(b)
+	for i := 0; i < size; i++ {
+		s.advance()
 	}
-	tt, ok := oneRune[r]
-	return tt, ok
 }
 
-func scanDigits(rem string) (string, string) {
-	for i := 0; i < len(rem); i++ {
-		if !isDigit(rem[i]) {
-			return rem[:i], rem[i:]
+// unexpected records an unexpected character and skips over it
+func (s *Scanner) unexpected() {
+	start := s.pos
+	r := s.peekRune()
+	s.advanceRune()
+	s.errs = append(s.errs, &ScanError{Line: start.line, Column: start.column, Char: r})
+}
+
+func (s *Scanner) digits() string {
+	var sb strings.Builder
+	for c, ok := s.peek(0); ok && isDigit(c); c, ok = s.peek(0) {
+		sb.WriteByte(s.advance())
+	}
+	return sb.String()
+}
+
+func (s *Scanner) scanNumber(start position) Token {
+	n := s.digits()
+	// check if float
+	if c, _ := s.peek(0); c == '.' {
+		// check range operator
+		if c, _ := s.peek(1); c == '.' {
+			return start.token(IntTT, n)
 		}
+		s.advance()
+		return start.token(FloatTT, n+"."+s.digits())
+	}
+	return start.token(IntTT, n)
+}
+
+func (s *Scanner) scanIdentifier(start position) Token {
+	var sb strings.Builder
+	for r := s.peekRune(); isIdentifierChar(r); r = s.peekRune() {
+		sb.WriteRune(r)
+		s.advanceRune()
+	}
+	id := sb.String()
+	if tt, ok := scanKeyword(id); ok {
+		return start.token(tt, id)
+	}
+	return start.token(IdentifierTT, id)
+}
+
+func (s *Scanner) skipComment() {
+	for c, ok := s.peek(0); ok && c != '\n'; c, ok = s.peek(0) {
+		s.advance()
 	}
-	return rem, ""
 }
 
-func scanIdentifier(rem string) (string, string) {
-	for i := 0; true; i++ {
-		if !isAlphaNumeric(rem[i]) {
-			return rem[:i], rem[i:]
+// scanString scans a string literal from just after its opening quote, or the rest of one after
+// an interpolation, recording an error if the source ends before the string does. A string with
+// interpolations is split into template tokens, with the tokens of each interpolated expression
+// in between.
+func (s *Scanner) scanString(start, tokenStart position, continued bool) (Token, bool) {
+	var sb strings.Builder
+	for {
+		c, ok := s.peek(0)
+		if !ok {
+			s.errs = append(s.errs, &ScanError{Line: start.line, Column: start.column, Unterminated: true})
+			return Token{}, false
 		}
-		if i == len(rem)-1 {
-			return rem, ""
+		if c == '$' {
+			if n, _ := s.peek(1); n == '{' {
+				s.advance()
+				s.advance()
+				s.templates = append(s.templates, template{start: start})
+				val := unescape(sb.String())
+				if continued {
+					return tokenStart.token(TemplateMidTT, val), true
+				}
+				return tokenStart.token(TemplateStartTT, val), true
+			}
+		}
+		s.advance()
+		if c == '"' {
+			val := unescape(sb.String())
+			if continued {
+				return tokenStart.token(TemplateEndTT, val), true
+			}
+			return tokenStart.token(StringTT, val), true
+		}
+		if c == '\\' {
+			if n, _ := s.peek(0); n == '$' {
+				// \$ is a literal dollar sign
+				sb.WriteByte(s.advance())
+				continue
+			}
+		}
+		sb.WriteByte(c)
+		if c == '\\' {
+			if c, ok := s.peek(0); ok {
+				sb.WriteByte(c)
+				s.advance()
+			}
 		}
 	}
-	return "", ""
 }
 
-func scanKeyword(s string) (TokenType, bool) {
-	keywords := map[string]TokenType{
-		"and":      AndTT,
-		"break":    BreakTT,
-		"continue": ContinueTT,
-		"else":     ElseTT,
-		"false":    FalseTT,
-		"for":      ForTT,
-		"if":       IfTT,
-		"null":     NullTT,
-		"or":       OrTT,
-		"return":   ReturnTT,
-		"true":     TrueTT,
-		"while":    WhileTT,
-		"until":    UntilTT,
-		"unless":   UnlessTT,
-		"fail":     FailTT,
-		"success":  SuccessTT,
-		"map":      MapTT,
-		"where":    WhereTT,
-		"in":       InTT,
-		"var":      VarTT,
-		"_":        UnderscoreTT,
-		"index":    IndexTT,
-		"import":   ImportTT,
-		"as":       AsTT,
-		"then":     PipeTT,
-		"find":     FindTT,
-		"fold":     FoldTT,
-		"bind":     PipeTT, //BindTT,
-		"each":     MapTT,
+// scanRawString scans a string in backticks, which is taken exactly as written: there are no
+// escape sequences or interpolations
+func (s *Scanner) scanRawString(start position) (Token, bool) {
+	var sb strings.Builder
+	for {
+		c, ok := s.peek(0)
+		if !ok {
+			s.errs = append(s.errs, &ScanError{Line: start.line, Column: start.column, Unterminated: true})
+			return Token{}, false
+		}
+		s.advance()
+		if c == '`' {
+			return start.token(StringTT, sb.String()), true
+		}
+		sb.WriteByte(c)
+	}
+}
+
+// scanMultilineString scans a string in triple quotes. Escape sequences work as in other strings,
+// and the indentation common to its lines is stripped, along with a line break just after the
+// opening quotes and one just before the closing quotes.
+func (s *Scanner) scanMultilineString(start position) (Token, bool) {
+	var sb strings.Builder
+	for i := 0; i < 3; i++ {
+		s.advance()
+	}
+	for {
+		c, ok := s.peek(0)
+		if !ok {
+			s.errs = append(s.errs, &ScanError{Line: start.line, Column: start.column, Unterminated: true})
+			return Token{}, false
+		}
+		if s.triple('"') {
+			for i := 0; i < 3; i++ {
+				s.advance()
+			}
+			return start.token(StringTT, unescape(dedent(sb.String()))), true
+		}
+		sb.WriteByte(s.advance())
+		if c == '\\' {
+			if _, ok := s.peek(0); ok {
+				sb.WriteByte(s.advance())
+			}
+		}
 	}
-	tt, ok := keywords[s]
-	return tt, ok
 }
 
-func scanComment(rem string) string {
-	for i := 0; i < len(rem); i++ {
-		if rem[i] == '\n' {
-			return rem[i:]
+// triple checks whether the next three characters are all c
+func (s *Scanner) triple(c byte) bool {
+	for i := 0; i < 3; i++ {
+		if n, ok := s.peek(i); !ok || n != c {
+			return false
+		}
+	}
+	return true
+}
+
+// dedent strips the indentation common to all non-blank lines of a multi-line string, and drops
+// blank first and last lines
+func dedent(str string) string {
+	lines := strings.Split(str, "\n")
+	if len(lines) > 1 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	if len(lines) > 1 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	// the longest prefix of whitespace the lines share, so that a tab and spaces aren't the same
+	indent, found := "", false
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		lead := l[:len(l)-len(strings.TrimLeft(l, " \t"))]
+		if !found {
+			indent, found = lead, true
+			continue
+		}
+		n := 0
+		for n < len(indent) && n < len(lead) && indent[n] == lead[n] {
+			n++
+		}
+		indent = indent[:n]
+	}
+
+	for i, l := range lines {
+		if strings.HasPrefix(l, indent) {
+			lines[i] = l[len(indent):]
+		} else {
+			// a blank line with less indentation
+			lines[i] = ""
 		}
 	}
-	return ""
+	return strings.Join(lines, "\n")
 }
 
-func scanString(rem string, line int) (Token, string, int) {
-	for i := 1; i < len(rem); i++ {
-		if rem[i] == '\n' {
-			line++
+// unescape replaces the escape sequences in a string literal. Unlike strconv.Unquote, it keeps
+// line breaks and unescaped quotes, and leaves an invalid escape sequence as it was written.
+func unescape(str string) string {
+	var sb strings.Builder
+	for len(str) > 0 {
+		if str[0] == '"' {
+			sb.WriteByte('"')
+			str = str[1:]
+			continue
+		}
+		r, multibyte, tail, err := strconv.UnquoteChar(str, '"')
+		if err != nil {
+			sb.WriteByte(str[0])
+			str = str[1:]
+			continue
 		}
-		if rem[i] == '\\' {
-			i++
-		} else if rem[i] == '"' {
-			val, _ := strconv.Unquote(fmt.Sprintf(`"%s"`, rem[1:i]))
-			return Token{StringTT, line, val}, rem[i:], line
+		if r < utf8.RuneSelf || !multibyte {
+			sb.WriteByte(byte(r))
+		} else {
+			sb.WriteRune(r)
 		}
+		str = tail
 	}
-	return Token{}, "", -1
+	return sb.String()
+}
+
+// token creates a token starting at p
+func (p position) token(tt TokenType, lexeme string) Token {
+	return Token{
+		Type:   tt,
+		Line:   p.line,
+		Lexeme: lexeme,
+		Column: p.column,
+		Offset: p.offset,
+	}
+}
+
+func scanTwoRune(rem string) (TokenType, bool) {
+	if len(rem) < 2 {
+		return 0, false
+	}
+	tt, ok := twoRunes[rem[:2]]
+	return tt, ok
+}
+
+var twoRunes = map[string]TokenType{
+	"=>":  ArrowTT,
+	"<-":  LeftArrowTT,
+	"!=":  BangEqualTT,
+	"==":  EqualEqualTT,
+	">=":  GreaterEqualTT,
+	"<=":  LessEqualTT,
+	":=":  ColonEqualTT,
+	"-=":  MinusEqualTT,
+	"+=":  PlusEqualTT,
+	"/=":  SlashEqualTT,
+	"*=":  StarEqualTT,
+	"%=":  ModuloEqualTT,
+	"..":  DotDotTT,
+	"...": DotDotDotTT,
+	"//":  CommentTT,
+	"|=":  BarEqualTT,
+	"|>":  PipeTT,
+}
+
+func scanOneRune(r byte) (TokenType, bool) {
+	tt, ok := oneRune[r]
+	return tt, ok
 }
 
-func isAlpha(r byte) bool {
-	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_'
+var oneRune = map[byte]TokenType{
+	'(': LeftParenTT,
+	')': RightParenTT,
+	'{': LeftBraceTT,
+	'}': RightBraceTT,
+	'[': LeftBracketTT,
+	']': RightBracketTT,
+	':': ColonTT,
+	',': CommaTT,
+	'.': DotTT,
+	'-': MinusTT,
+	'+': PlusTT,
+	';': SemicolonTT,
+	'/': SlashTT,
+	'*': StarTT,
+	'%': ModuloTT,
+	'!': BangTT,
+	'=': EqualTT,
+	'>': GreaterTT,
+	'<': LessTT,
+	'?': QuestionMarkTT,
+	'|': BarTT,
+	'#': HashTT,
+	'^': CaratTT,
+	'_': UnderscoreTT,
+}
+
+func scanKeyword(s string) (TokenType, bool) {
+	tt, ok := keywords[s]
+	return tt, ok
+}
+
+var keywords = map[string]TokenType{
+	"and":      AndTT,
+	"break":    BreakTT,
+	"continue": ContinueTT,
+	"else":     ElseTT,
+	"false":    FalseTT,
+	"for":      ForTT,
+	"if":       IfTT,
+	"null":     NullTT,
+	"or":       OrTT,
+	"return":   ReturnTT,
+	"true":     TrueTT,
+	"while":    WhileTT,
+	"until":    UntilTT,
+	"unless":   UnlessTT,
+	"fail":     FailTT,
+	"success":  SuccessTT,
+	"map":      MapTT,
+	"where":    WhereTT,
+	"in":       InTT,
+	"var":      VarTT,
+	"_":        UnderscoreTT,
+	"index":    IndexTT,
+	"import":   ImportTT,
+	"as":       AsTT,
+	"then":     PipeTT,
+	"find":     FindTT,
+	"fold":     FoldTT,
+	"from":     FromTT,
+	"bind":     BindTT,
+	"spawn":    SpawnTT,
+	"await":    AwaitTT,
+	"pmap":     PMapTT,
+	"pwhere":   PWhereTT,
+	"each":     MapTT,
+	"match":    MatchTT,
 }
 
 func isDigit(r byte) bool {
 	return r >= '0' && r <= '9'
 }
 
-func isAlphaNumeric(r byte) bool {
-	return isAlpha(r) || isDigit(r)
+// identifiers can use letters from any language
+func isIdentifierStart(r rune) bool {
+	return r == '_' || unicode.IsLetter(r)
+}
+
+func isIdentifierChar(r rune) bool {
+	return isIdentifierStart(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r)
 }
@@ -0,0 +1,319 @@
+package interpreter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unicode/utf8"
+)
+
+// The recursive scanner that Scanner replaced, kept as a reference for benchmarks and to check
+// that both produce the same tokens.
+
+func scanRecursive(src string) ([]Token, error) {
+	tokens, errs := recursiveScan(src, make([]Token, 0), src, 1, nil)
+	if len(errs) > 0 {
+		return tokens, errs
+	}
+	return tokens, nil
+}
+
+func recursiveScan(src string, scanned []Token, remaining string, line int, errs ScanErrors) ([]Token, ScanErrors) {
+	pos := len(src) - len(remaining)
+	if len(remaining) == 0 {
+		scanned = append(scanned, recursiveToken(src, pos, NewLineTT, line, ""))
+		return append(scanned, recursiveToken(src, pos, EOFTT, line, "\x00")), errs
+	}
+
+	r := remaining[0]
+	switch r {
+	// whitespace
+	case '\n':
+		scanned = append(scanned, recursiveToken(src, pos, NewLineTT, line, ""))
+		return recursiveScan(src, scanned, remaining[1:], line+1, errs)
+	case '\t', '\r', ' ':
+		return recursiveScan(src, scanned, remaining[1:], line, errs)
+
+	// 1 character
+	case '(', ')', '{', '}', '[', ']', ';', ',', '?', '^', '#', '_':
+		if tt, ok := scanOneRune(r); ok {
+			if tt == RightBraceTT {
+				scanned = append(scanned, recursiveToken(src, pos, NewLineTT, line, "")) // insert newline at end of block
+			}
+			scanned = append(scanned, recursiveToken(src, pos, tt, line, string(r)))
+			return recursiveScan(src, scanned, remaining[1:], line, errs)
+		}
+		return recursiveUnexpected(src, scanned, remaining, line, errs)
+
+	// 1-2 characters
+	case '!', '=', '>', '<', ':', '-', '+', '/', '*', '%', '|':
+		if tt, ok := scanTwoRune(remaining); ok {
+			if tt == CommentTT {
+				remaining = scanComment(remaining)
+				return recursiveScan(src, scanned, remaining, line, errs)
+			}
+			scanned = append(scanned, recursiveToken(src, pos, tt, line, string(r)+string(remaining[1])))
+			return recursiveScan(src, scanned, remaining[2:], line, errs)
+		} else if tt, ok = scanOneRune(r); ok {
+			scanned = append(scanned, recursiveToken(src, pos, tt, line, string(r)))
+			return recursiveScan(src, scanned, remaining[1:], line, errs)
+		} else {
+			return recursiveUnexpected(src, scanned, remaining, line, errs)
+		}
+	case '.':
+		if len(remaining) > 1 {
+			n := remaining[1]
+			if n == '.' {
+				// ...
+				if len(remaining) > 2 && remaining[2] == '.' {
+					scanned = append(scanned, recursiveToken(src, pos, DotDotDotTT, line, "..."))
+					return recursiveScan(src, scanned, remaining[3:], line, errs)
+				}
+				// ..
+				scanned = append(scanned, recursiveToken(src, pos, DotDotTT, line, string(r)+string(n)))
+				return recursiveScan(src, scanned, remaining[2:], line, errs)
+			} else if isDigit(n) {
+				// float
+				ds, remaining := scanDigits(remaining[1:])
+				scanned = append(scanned, recursiveToken(src, pos, FloatTT, line, "."+ds))
+				return recursiveScan(src, scanned, remaining, line, errs)
+			} else {
+				// .
+				scanned = append(scanned, recursiveToken(src, pos, DotTT, line, string(r)))
+				return recursiveScan(src, scanned, remaining[1:], line, errs)
+			}
+		}
+		scanned = append(scanned, recursiveToken(src, pos, DotTT, line, string(r)))
+		return recursiveScan(src, scanned, remaining[1:], line, errs)
+
+	// string
+	case '"':
+		t, remaining, ln := scanString(remaining, line)
+		if ln == -1 {
+			// the rest of the source is inside the string
+			errs = append(errs, &ScanError{Line: line, Column: column(src, pos), Unterminated: true})
+			return recursiveScan(src, scanned, "", strings.Count(src, "\n")+1, errs)
+		}
+		scanned = append(scanned, recursiveToken(src, pos, t.Type, line, t.Lexeme))
+		return recursiveScan(src, scanned, remaining[1:], ln, errs)
+	default:
+		// numbers
+		if isDigit(r) {
+			n, remaining := scanDigits(remaining)
+			// check if float
+			if len(remaining) > 0 && remaining[0] == '.' {
+				// check range operator
+				if len(remaining) > 1 && remaining[1] == '.' {
+					scanned = append(scanned, recursiveToken(src, pos, IntTT, line, n))
+					return recursiveScan(src, scanned, remaining, line, errs)
+				}
+				m, remaining := scanDigits(remaining[1:])
+				n += "." + m
+				scanned = append(scanned, recursiveToken(src, pos, FloatTT, line, n))
+				return recursiveScan(src, scanned, remaining, line, errs)
+			}
+			scanned = append(scanned, recursiveToken(src, pos, IntTT, line, n))
+			return recursiveScan(src, scanned, remaining, line, errs)
+		}
+		// identifiers
+		if isAlpha(r) {
+			s, remaining := scanIdentifier(remaining)
+			if tt, ok := scanKeyword(s); ok {
+				scanned = append(scanned, recursiveToken(src, pos, tt, line, s))
+				return recursiveScan(src, scanned, remaining, line, errs)
+			}
+			scanned = append(scanned, recursiveToken(src, pos, IdentifierTT, line, s))
+			return recursiveScan(src, scanned, remaining, line, errs)
+		}
+	}
+
+	return recursiveUnexpected(src, scanned, remaining, line, errs)
+}
+
+// recursiveUnexpected records an unexpected character and continues scanning after it
+func recursiveUnexpected(src string, scanned []Token, remaining string, line int, errs ScanErrors) ([]Token, ScanErrors) {
+	r, size := utf8.DecodeRuneInString(remaining)
+	errs = append(errs, &ScanError{Line: line, Column: column(src, len(src)-len(remaining)), Char: r})
+	return recursiveScan(src, scanned, remaining[size:], line, errs)
+}
+
+// recursiveToken creates a token starting at pos in the source
+func recursiveToken(src string, pos int, tt TokenType, line int, lexeme string) Token {
+	return Token{
+		Type:   tt,
+		Line:   line,
+		Lexeme: lexeme,
+		Column: column(src, pos),
+		Offset: pos,
+	}
+}
+
+// column finds the column of a byte offset in the source, counting from 1
+func column(src string, pos int) int {
+	lineStart := strings.LastIndexByte(src[:pos], '\n') + 1
+	return utf8.RuneCountInString(src[lineStart:pos]) + 1
+}
+
+func scanDigits(rem string) (string, string) {
+	for i := 0; i < len(rem); i++ {
+		if !isDigit(rem[i]) {
+			return rem[:i], rem[i:]
+		}
+	}
+	return rem, ""
+}
+
+func scanIdentifier(rem string) (string, string) {
+	for i := 0; true; i++ {
+		if !isAlphaNumeric(rem[i]) {
+			return rem[:i], rem[i:]
+		}
+		if i == len(rem)-1 {
+			return rem, ""
+		}
+	}
+	return "", ""
+}
+
+func scanComment(rem string) string {
+	for i := 0; i < len(rem); i++ {
+		if rem[i] == '\n' {
+			return rem[i:]
+		}
+	}
+	return ""
+}
+
+func scanString(rem string, line int) (Token, string, int) {
+	for i := 1; i < len(rem); i++ {
+		if rem[i] == '\n' {
+			line++
+		}
+		if rem[i] == '\\' {
+			i++
+		} else if rem[i] == '"' {
+			val, _ := strconv.Unquote(fmt.Sprintf(`"%s"`, rem[1:i]))
+			return Token{Type: StringTT, Line: line, Lexeme: val}, rem[i:], line
+		}
+	}
+	return Token{}, "", -1
+}
+
+// generated inputs, each around the given size in bytes
+var scanInputs = []struct {
+	name string
+	gen  func(size int) string
+}{
+	{"code", genCode},
+	{"data", genData},
+	{"string", genLongString},
+}
+
+// genCode repeats a small program with a bit of everything in it
+func genCode(size int) string {
+	const chunk = `fib := (n: Int): Int => {
+	if n <= 1 { return n }
+	fib(n - 1) + fib(n - 2) // recursive
+}
+xs := [1..20] map fib(_) where _ % 2 == 0
+total := 0.0
+for x in xs { total += x / 3.5 }
+print("total: " + total, #xs, xs[2...])
+`
+	return strings.Repeat(chunk, size/len(chunk)+1)
+}
+
+// genData is a large module of config-style objects
+func genData(size int) string {
+	var sb strings.Builder
+	sb.WriteString("config := [\n")
+	for i := 0; sb.Len() < size; i++ {
+		fmt.Fprintf(&sb, "\t{id: %d, name: \"item-%d\", weight: %d.%d, tags: {\"a\", \"b\"}, active: %v},\n", i, i, i, i%10, i%2 == 0)
+	}
+	sb.WriteString("]\n")
+	return sb.String()
+}
+
+// genLongString is a single huge string literal
+func genLongString(size int) string {
+	return `s := "` + strings.Repeat(`lorem ipsum \"dolor\" sit amet\n`, size/32+1) + `"`
+}
+
+func TestScannerMatchesRecursiveScanner(t *testing.T) {
+	sources := []string{
+		"x := 1 @ 2\ny := \"a\\\"b\" € 3...4..5.6 .7 x.y\n}",
+		"s := \"open\nx := 1",
+		"a |> b // comment\n+",
+	}
+	for _, in := range scanInputs {
+		sources = append(sources, in.gen(10_000))
+	}
+
+	for _, src := range sources {
+		expected, expectedErr := scanRecursive(src)
+		received, receivedErr := Scan(src)
+		if fmt.Sprint(expectedErr) != fmt.Sprint(receivedErr) {
+			t.Fatalf("Scan errors differ for %q.\nExpected: %v\nReceived: %v", src, expectedErr, receivedErr)
+		}
+		if len(expected) != len(received) {
+			t.Fatalf("Scanned %q into %d tokens, expected %d", src, len(received), len(expected))
+		}
+		for i := range expected {
+			if expected[i] != received[i] {
+				t.Fatalf("Token %d differs for %q.\nExpected: %+v\nReceived: %+v", i, src, expected[i], received[i])
+			}
+		}
+	}
+}
+
+func TestScannerStreaming(t *testing.T) {
+	src := genData(2_000)
+	expected, _ := Scan(src)
+
+	s := NewScanner(iotest.OneByteReader(strings.NewReader(src)))
+	for i := range expected {
+		if tkn := s.Next(); tkn != expected[i] {
+			t.Fatalf("Token %d differs when streaming.\nExpected: %+v\nReceived: %+v", i, expected[i], tkn)
+		}
+	}
+	if s.Next().Type != EOFTT || s.Err() != nil {
+		t.Fatalf("Expected EOF without errors after the last token")
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	scanners := []struct {
+		name string
+		scan func(string) ([]Token, error)
+	}{
+		{"recursive", scanRecursive},
+		{"iterative", Scan},
+	}
+
+	for _, in := range scanInputs {
+		for _, size := range []int{1_000, 100_000, 1_000_000} {
+			src := in.gen(size)
+			for _, s := range scanners {
+				b.Run(fmt.Sprintf("%s/%s/%d", in.name, s.name, size), func(b *testing.B) {
+					if s.name == "recursive" && size > 100_000 {
+						b.Skip("recursing once per character overflows the stack")
+					}
+					b.SetBytes(int64(len(src)))
+					for i := 0; i < b.N; i++ {
+						s.scan(src)
+					}
+				})
+			}
+		}
+	}
+}
+
+func isAlpha(r byte) bool {
+	return (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || r == '_'
+}
+
+func isAlphaNumeric(r byte) bool {
+	return isAlpha(r) || isDigit(r)
+}
@@ -9,920 +9,837 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 var randSrc = rand.New(rand.NewSource(time.Now().UnixNano()))
 
-var StdLib map[string]*Node = map[string]*Node{
+var StdLib map[string]Value = map[string]Value{
 	// I/O utils
-	"print": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			strs := []any{}
-			for _, arg := range args {
-				if arg.Type == StringNT {
-					strs = append(strs, fmt.Sprintf("%s", arg.Val))
-				} else {
-					strs = append(strs, arg.ToString())
-				}
-			}
-			fmt.Println(strs...)
-			return &Node{Type: SuccessNT}, nil
-		},
-	},
-	"readInput": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"readInput\". Expected 1, received %d.", len(args))
-			}
-
-			prompt := args[0]
-			if prompt.Type != StringNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			reader := bufio.NewReader(os.Stdin)
-			fmt.Print(prompt.Val.(string))
-			inp, err := reader.ReadString('\n')
-			if err != nil {
-				return &Node{Type: FailNT}, nil
-			}
-
-			return &Node{
-				Type: StringNT,
-				Val:  inp,
-			}, nil
-		},
-	},
-	"readFile": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"input\". Expected 1, received %d.", len(args))
-			}
-
-			path := args[0]
-			if path.Type != StringNT {
-				fmt.Println("path is not a string: fail")
-				return &Node{Type: FailNT}, nil
-			}
-
-			file, err := ioutil.ReadFile(path.Val.(string))
-			if err != nil {
-				return &Node{Type: FailNT}, nil
-			}
-
-			return &Node{
-				Type: StringNT,
-				Val:  string(file),
-			}, nil
-		},
-	},
+	"print": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		strs := []any{}
+		for _, arg := range args {
+			if arg.Type == StringDT {
+				strs = append(strs, arg.Str())
+			} else {
+				strs = append(strs, arg.ToString())
+			}
+		}
+		fmt.Println(strs...)
+		return SUCCESS, nil
+	}),
+	"readInput": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"readInput\". Expected 1, received %d.", len(args))
+		}
+
+		prompt := args[0]
+		if prompt.Type != StringDT {
+			return FAIL, nil
+		}
+
+		reader := bufio.NewReader(os.Stdin)
+		fmt.Print(prompt.Str())
+		inp, err := reader.ReadString('\n')
+		if err != nil {
+			return FAIL, nil
+		}
+
+		return NewString(inp), nil
+	}),
+	"readFile": NewFunc(func(env *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"input\". Expected 1, received %d.", len(args))
+		}
+
+		path := args[0]
+		if path.Type != StringDT {
+			fmt.Println("path is not a string: fail")
+			return FAIL, nil
+		}
+
+		resolved, err := env.limits().resolvePath(path.Str(), "")
+		if err != nil {
+			return Value{}, err
+		}
+
+		file, err := ioutil.ReadFile(resolved)
+		if err != nil {
+			return FAIL, nil
+		}
+
+		return NewString(string(file)), nil
+	}),
 	// "readJson": {
 	// 	Type: LambdaNT,
 	// 	Func: func(_ *Environment, args ...*Node) (*Node, error) {
 	// 		if len(args) < 1 {
-	// 			return nil, fmt.Errorf("Wrong number of arguments for \"sum\". Expected 1+, received %d.", len(args))
+	// 			return Value{}, fmt.Errorf("Wrong number of arguments for \"sum\". Expected 1+, received %d.", len(args))
 	// 		}
 
 	// 	},
 	// },
 	// math utils
-	"sum": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) < 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"sum\". Expected 1+, received %d.", len(args))
-			}
-
-			if args[0].Type == ListNT {
-				args = args[0].Val.(List)
-			}
-
-			allInts := true
-			for _, n := range args {
-				if n.Type != IntNT {
-					allInts = false
-					break
-				}
-			}
-
-			if allInts {
-				var total int64
-				for _, n := range args {
-					val, err := castInt(n)
-					if err != nil {
-						return &Node{Type: FailNT}, nil
-					}
-					total += val
-				}
-				return &Node{Type: IntNT, Val: total}, nil
+	"sum": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) < 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"sum\". Expected 1+, received %d.", len(args))
+		}
+
+		if args[0].Type == ListDT {
+			args = args[0].List().Items()
+		}
+
+		allInts := true
+		for _, n := range args {
+			if n.Type != IntDT {
+				allInts = false
+				break
 			}
+		}
 
-			var total float64
+		if allInts {
+			var total int64
 			for _, n := range args {
-				val, err := castFloat(n)
+				val, err := castInt(n)
 				if err != nil {
-					return &Node{Type: FailNT}, nil
+					return FAIL, nil
 				}
 				total += val
 			}
+			return NewInt(total), nil
+		}
 
-			return &Node{Type: FloatNT, Val: total}, nil
-		},
-	},
-	"max": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) < 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"max\". Expected 1+, received %d.", len(args))
-			}
-
-			if len(args) == 1 {
-				if args[0].Type == ListNT {
-					args = args[0].Val.(List)
-				} else {
-					return &Node{Type: FailNT}, nil
+		var total float64
+		for _, n := range args {
+			val, err := castFloat(n)
+			if err != nil {
+				return FAIL, nil
+			}
+			total += val
+		}
+
+		return NewFloat(total), nil
+	}),
+	"max": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) < 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"max\". Expected 1+, received %d.", len(args))
+		}
+
+		if len(args) == 1 {
+			if args[0].Type == ListDT {
+				args = args[0].List().Items()
+			} else {
+				return FAIL, nil
+			}
+		}
+
+		allInts := true
+		var floatMax float64
+		var intMax int64
+		switch args[0].Type {
+		case FloatDT:
+			allInts = false
+			floatMax = args[0].Float()
+			intMax = int64(floatMax)
+		case IntDT:
+			intMax = args[0].Int()
+			floatMax = float64(intMax)
+		default:
+			return FAIL, nil
+		}
+
+		for _, n := range args[1:] {
+			switch n.Type {
+			case FloatDT:
+				if n.Float() > floatMax {
+					floatMax = n.Float()
+					intMax = int64(floatMax)
 				}
-			}
-
-			allInts := true
-			var floatMax float64
-			var intMax int64
-			switch args[0].Type {
-			case FloatNT:
 				allInts = false
-				floatMax = args[0].Val.(float64)
-				intMax = int64(floatMax)
-			case IntNT:
-				intMax = args[0].Val.(int64)
-				floatMax = float64(intMax)
-			default:
-				return &Node{Type: FailNT}, nil
-			}
-
-			for _, n := range args[1:] {
-				switch n.Type {
-				case FloatNT:
-					if n.Val.(float64) > floatMax {
-						floatMax = n.Val.(float64)
-						intMax = int64(floatMax)
-					}
-					allInts = false
-				case IntNT:
-					if n.Val.(int64) > intMax {
-						intMax = n.Val.(int64)
-						floatMax = float64(intMax)
-					}
-				default:
-					return &Node{Type: FailNT}, nil
+			case IntDT:
+				if n.Int() > intMax {
+					intMax = n.Int()
+					floatMax = float64(intMax)
 				}
-			}
-
-			if allInts {
-				return &Node{Type: FloatNT, Val: floatMax}, nil
-			}
-
-			return &Node{Type: IntNT, Val: intMax}, nil
-		},
-	},
-	"min": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) < 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"min\". Expected 1+, received %d.", len(args))
-			}
-
-			if len(args) == 1 {
-				if args[0].Type == ListNT {
-					args = args[0].Val.(List)
-				} else {
-					return &Node{Type: FailNT}, nil
+			default:
+				return FAIL, nil
+			}
+		}
+
+		if allInts {
+			return NewFloat(floatMax), nil
+		}
+
+		return NewInt(intMax), nil
+	}),
+	"min": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) < 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"min\". Expected 1+, received %d.", len(args))
+		}
+
+		if len(args) == 1 {
+			if args[0].Type == ListDT {
+				args = args[0].List().Items()
+			} else {
+				return FAIL, nil
+			}
+		}
+
+		allInts := true
+		var floatMax float64
+		var intMax int64
+		switch args[0].Type {
+		case FloatDT:
+			allInts = false
+			floatMax = args[0].Float()
+			intMax = int64(floatMax)
+		case IntDT:
+			intMax = args[0].Int()
+			floatMax = float64(intMax)
+		default:
+			return FAIL, nil
+		}
+
+		for _, n := range args[1:] {
+			switch n.Type {
+			case FloatDT:
+				if n.Float() < floatMax {
+					floatMax = n.Float()
+					intMax = int64(floatMax)
 				}
-			}
-
-			allInts := true
-			var floatMax float64
-			var intMax int64
-			switch args[0].Type {
-			case FloatNT:
 				allInts = false
-				floatMax = args[0].Val.(float64)
-				intMax = int64(floatMax)
-			case IntNT:
-				intMax = args[0].Val.(int64)
-				floatMax = float64(intMax)
-			default:
-				return &Node{Type: FailNT}, nil
-			}
-
-			for _, n := range args[1:] {
-				switch n.Type {
-				case FloatNT:
-					if n.Val.(float64) < floatMax {
-						floatMax = n.Val.(float64)
-						intMax = int64(floatMax)
-					}
-					allInts = false
-				case IntNT:
-					if n.Val.(int64) < intMax {
-						intMax = n.Val.(int64)
-						floatMax = float64(intMax)
-					}
-				default:
-					return &Node{Type: FailNT}, nil
+			case IntDT:
+				if n.Int() < intMax {
+					intMax = n.Int()
+					floatMax = float64(intMax)
 				}
+			default:
+				return FAIL, nil
 			}
+		}
 
-			if allInts {
-				return &Node{Type: FloatNT, Val: floatMax}, nil
-			}
+		if allInts {
+			return NewFloat(floatMax), nil
+		}
 
-			return &Node{Type: IntNT, Val: intMax}, nil
-		},
-	},
-	"random": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 0 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"random\". Expected 0, received %d.", len(args))
-			}
+		return NewInt(intMax), nil
+	}),
+	"random": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 0 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"random\". Expected 0, received %d.", len(args))
+		}
 
-			return &Node{
-				Type: FloatNT,
-				Val:  randSrc.Float64(),
-			}, nil
-		},
-	},
+		return NewFloat(randSrc.Float64()), nil
+	}),
 	// string utils
-	"split": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 2 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"split\". Expected 2, received %d.", len(args))
-			}
-
-			if args[0].Type != StringNT || args[1].Type != StringNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			strs := strings.Split(args[0].Val.(string), args[1].Val.(string))
-			ns := List{}
-			for _, s := range strs {
-				ns = append(ns, &Node{
-					Type: StringNT,
-					Val:  s,
-				})
-			}
-
-			return &Node{
-				Type: ListNT,
-				Val:  ns,
-			}, nil
-		},
-	},
-	"join": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 2 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"join\". Expected 2, received %d.", len(args))
-			}
-
-			if args[0].Type != ListNT || args[1].Type != StringNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			strs := []string{}
-			for _, n := range args[0].Val.(List) {
-				if n.Type != StringNT {
-					return &Node{Type: FailNT}, nil
-				}
-				strs = append(strs, n.Val.(string))
-			}
-
-			return &Node{
-				Type: StringNT,
-				Val:  strings.Join(strs, args[1].Val.(string)),
-			}, nil
-		},
-	},
-	"uppercase": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"uppercase\". Expected 1, received %d.", len(args))
-			}
-
-			if args[0].Type != StringNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			return &Node{
-				Type: StringNT,
-				Val:  strings.ToUpper(args[0].Val.(string)),
-			}, nil
-		},
-	},
-	"lowercase": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"lowercase\". Expected 1, received %d.", len(args))
-			}
-
-			if args[0].Type != StringNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			return &Node{
-				Type: StringNT,
-				Val:  strings.ToLower(args[0].Val.(string)),
-			}, nil
-		},
-	},
+	"split": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"split\". Expected 2, received %d.", len(args))
+		}
+
+		if args[0].Type != StringDT || args[1].Type != StringDT {
+			return FAIL, nil
+		}
+
+		strs := strings.Split(args[0].Str(), args[1].Str())
+		ns := []Value{}
+		for _, s := range strs {
+			ns = append(ns, NewString(s))
+		}
+
+		return NewList(listFrom(ns)), nil
+	}),
+	"join": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"join\". Expected 2, received %d.", len(args))
+		}
+
+		if args[0].Type != ListDT || args[1].Type != StringDT {
+			return FAIL, nil
+		}
+
+		strs := []string{}
+		for _, n := range args[0].List().Items() {
+			if n.Type != StringDT {
+				return FAIL, nil
+			}
+			strs = append(strs, n.Str())
+		}
+
+		return NewString(strings.Join(strs, args[1].Str())), nil
+	}),
+	"uppercase": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"uppercase\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != StringDT {
+			return FAIL, nil
+		}
+
+		return NewString(strings.ToUpper(args[0].Str())), nil
+	}),
+	"lowercase": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"lowercase\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != StringDT {
+			return FAIL, nil
+		}
+
+		return NewString(strings.ToLower(args[0].Str())), nil
+	}),
+	"codepoints": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"codepoints\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != StringDT {
+			return FAIL, nil
+		}
+
+		ns := []Value{}
+		for _, r := range args[0].Str() {
+			ns = append(ns, NewInt(int64(r)))
+		}
+		return NewList(listFrom(ns)), nil
+	}),
+	"fromCodepoints": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"fromCodepoints\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != ListDT {
+			return FAIL, nil
+		}
+
+		var sb strings.Builder
+		for _, n := range args[0].List().Items() {
+			// range-checked before the conversion to a rune, which would truncate it
+			if n.Type != IntDT || n.Int() < 0 || n.Int() > utf8.MaxRune || !utf8.ValidRune(rune(n.Int())) {
+				return FAIL, nil
+			}
+			sb.WriteRune(rune(n.Int()))
+		}
+		return NewString(sb.String()), nil
+	}),
+	"graphemes": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"graphemes\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != StringDT {
+			return FAIL, nil
+		}
+
+		ns := []Value{}
+		for _, g := range graphemes(args[0].Str()) {
+			ns = append(ns, NewString(g))
+		}
+		return NewList(listFrom(ns)), nil
+	}),
 	// type casts and utils
-	"typeof": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of values for \"typeof\". Expected 1, received %d.", len(args))
-			}
-
-			switch args[0].Type {
-			case LambdaNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "Lambda",
-				}, nil
-			case ListNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "List",
-				}, nil
-			case SetNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "Set",
-				}, nil
-			case ObjectNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "Object",
-				}, nil
-			case SuccessNT, FailNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "Result",
-				}, nil
-			case FloatNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "Float",
-				}, nil
-			case IntNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "Int",
-				}, nil
-			case BoolNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "Bool",
-				}, nil
-			case StringNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "String",
-				}, nil
-			case NullNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "Null",
-				}, nil
-			case ModuleNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "Module",
-				}, nil
-			default:
-				return &Node{Type: FailNT}, nil
-			}
-		},
-	},
-	"Int": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"Int\". Expected 1, received %d.", len(args))
-			}
-
-			switch args[0].Type {
-			case IntNT:
-				return args[0], nil
-			case FloatNT:
-				return &Node{
-					Type: IntNT,
-					Val:  int64(args[0].Val.(float64)),
-				}, nil
-			case StringNT:
-				val, err := strconv.ParseInt(args[0].Val.(string), 10, 64)
-				if err != nil {
-					return &Node{Type: FailNT}, nil
-				}
-				return &Node{
-					Type: IntNT,
-					Val:  val,
-				}, nil
-			default:
-				return &Node{Type: FailNT}, nil
-			}
-		},
-	},
-	"Float": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"Float\". Expected 1, received %d.", len(args))
-			}
-
-			switch args[0].Type {
-			case IntNT:
-				return &Node{
-					Type: FloatNT,
-					Val:  float64(args[0].Val.(int64)),
-				}, nil
-			case FloatNT:
-				return args[0], nil
-			case StringNT:
-				val, err := strconv.ParseFloat(args[0].Val.(string), 64)
-				if err != nil {
-					return &Node{Type: FailNT}, nil
-				}
-				return &Node{
-					Type: FloatNT,
-					Val:  val,
-				}, nil
-			default:
-				return &Node{Type: FailNT}, nil
-			}
-		},
-	},
-	"String": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"String\". Expected 1, received %d.", len(args))
-			}
-
-			switch args[0].Type {
-			case StringNT:
-				return args[0], nil
-			case LambdaNT:
-				return &Node{
-					Type: StringNT,
-					Val:  "<lambda>",
-				}, nil
-			default:
-				return &Node{
-					Type: StringNT,
-					Val:  args[0].ToString(),
-				}, nil
-			}
-		},
-	},
-	"Set": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"Set\". Expected 1, received %d.", len(args))
-			}
-
-			set := Set{}
-
-			switch args[0].Type {
-			case SetNT:
-				return args[0], nil
-			case ListNT:
-				for _, n := range args[0].Val.(List) {
-					set[n.toValue()] = true
-				}
-				return &Node{
-					Type: SetNT,
-					Val:  set,
-				}, nil
-			case IntNT, FloatNT, StringNT, BoolNT, SuccessNT, FailNT:
-				set[args[0].toValue()] = true
-				return &Node{
-					Type: SetNT,
-					Val:  set,
-				}, nil
-			default:
-				return &Node{Type: FailNT}, nil
-			}
-		},
-	},
-	"List": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) < 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"List\". Expected 1+, received %d.", len(args))
-			}
-
-			list := List{}
-			if len(args) > 1 {
-				list = append(list, args...)
-				return &Node{
-					Type: ListNT,
-					Val:  list,
-				}, nil
-			}
-
-			switch args[0].Type {
-			case ListNT:
-				return args[0], nil
-			case SetNT:
-				{
-					set := args[0].Val.(Set)
-					for v := range set {
-						if !set[v] {
-							continue
-						}
-						list = append(list, v.toNode())
-					}
-					return &Node{
-						Type: ListNT,
-						Val:  list,
-					}, nil
-				}
-			default:
-				list = append(list, args[0])
-				return &Node{
-					Type: ListNT,
-					Val:  list,
-				}, nil
-			}
-		},
-	},
+	"typeof": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of values for \"typeof\". Expected 1, received %d.", len(args))
+		}
+
+		kind := args[0].Kind()
+		if kind == "" {
+			return FAIL, nil
+		}
+		return NewString(kind), nil
+	}),
+	"Int": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"Int\". Expected 1, received %d.", len(args))
+		}
+
+		switch args[0].Type {
+		case IntDT:
+			return args[0], nil
+		case FloatDT:
+			return NewInt(int64(args[0].Float())), nil
+		case StringDT:
+			val, err := strconv.ParseInt(args[0].Str(), 10, 64)
+			if err != nil {
+				return FAIL, nil
+			}
+			return NewInt(val), nil
+		default:
+			return FAIL, nil
+		}
+	}),
+	"Float": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"Float\". Expected 1, received %d.", len(args))
+		}
+
+		switch args[0].Type {
+		case IntDT:
+			return NewFloat(float64(args[0].Int())), nil
+		case FloatDT:
+			return args[0], nil
+		case StringDT:
+			val, err := strconv.ParseFloat(args[0].Str(), 64)
+			if err != nil {
+				return FAIL, nil
+			}
+			return NewFloat(val), nil
+		default:
+			return FAIL, nil
+		}
+	}),
+	"String": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"String\". Expected 1, received %d.", len(args))
+		}
+
+		switch args[0].Type {
+		case StringDT:
+			return args[0], nil
+		case LambdaDT:
+			return NewString("<lambda>"), nil
+		default:
+			return NewString(args[0].ToString()), nil
+		}
+	}),
+	"Set": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"Set\". Expected 1, received %d.", len(args))
+		}
+
+		switch args[0].Type {
+		case SetDT:
+			return args[0], nil
+		case ListDT:
+			return NewSet(spreadSet(Set{}, args[0])), nil
+		case IntDT, FloatDT, StringDT, BoolDT, SuccessDT, FailDT:
+			return NewSet(Set{}.Add(args[0])), nil
+		default:
+			return FAIL, nil
+		}
+	}),
+	"List": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) < 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"List\". Expected 1+, received %d.", len(args))
+		}
+
+		if len(args) > 1 {
+			return NewList(ListOf(args...)), nil
+		}
+
+		switch args[0].Type {
+		case ListDT:
+			return args[0], nil
+		case SetDT:
+			return NewList(listFrom(args[0].Set().Members())), nil
+		default:
+			return NewList(ListOf(args[0])), nil
+		}
+	}),
 	// set utils
-	"union": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 2 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"union\". Expected 2, received %d.", len(args))
-			}
-
-			if args[0].Type != SetNT || args[1].Type != SetNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			union := Set{}
-			a, b := args[0].Val.(Set), args[1].Val.(Set)
-			for n := range a {
-				union[n] = true
-			}
-
-			for n := range b {
-				union[n] = true
-			}
-
-			return &Node{Type: SetNT, Val: union}, nil
-		},
-	},
-	"intersection": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 2 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"intersection\". Expected 2, received %d.", len(args))
-			}
-
-			if args[0].Type != SetNT || args[1].Type != SetNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			intersection := Set{}
-			a, b := args[0].Val.(Set), args[1].Val.(Set)
-			for n := range a {
-				if b[n] {
-					intersection[n] = true
-				}
-			}
-
-			return &Node{Type: SetNT, Val: intersection}, nil
-		},
-	},
-	"difference": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 2 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"difference\". Expected 2, received %d.", len(args))
-			}
-
-			if args[0].Type != SetNT || args[1].Type != SetNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			difference := Set{}
-			a, b := args[0].Val.(Set), args[1].Val.(Set)
-			for n := range a {
-				if !b[n] {
-					difference[n] = true
-				}
-			}
-
-			return &Node{Type: SetNT, Val: difference}, nil
-		},
-	},
-	"add": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 2 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"add\". Expected 2, received %d.", len(args))
-			}
-
-			if args[0].Type != SetNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			set := args[0].Val.(Set)
-			set[args[1].toValue()] = true
-
-			return &Node{
-				Type: SetNT,
-				Val:  set,
-			}, nil
-		},
-	},
-	"remove": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 2 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"remove\". Expected 2, received %d.", len(args))
-			}
-
-			if args[0].Type != SetNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			set := args[0].Val.(Set)
-			set[args[1].toValue()] = false
-
-			return &Node{
-				Type: SetNT,
-				Val:  set,
-			}, nil
-		},
-	},
+	"union": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"union\". Expected 2, received %d.", len(args))
+		}
+
+		if args[0].Type != SetDT || args[1].Type != SetDT {
+			return FAIL, nil
+		}
+
+		// the members of the smaller set are added to the larger
+		a, b := args[0], args[1]
+		if a.Set().Len() < b.Set().Len() {
+			a, b = b, a
+		}
+		return NewSet(spreadSet(a.Set(), b)), nil
+	}),
+	"intersection": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"intersection\". Expected 2, received %d.", len(args))
+		}
+
+		if args[0].Type != SetDT || args[1].Type != SetDT {
+			return FAIL, nil
+		}
+
+		intersection := setBuilder{}
+		a, b := args[0].Set(), args[1].Set()
+		for _, n := range a.Members() {
+			if b.Has(n) {
+				intersection.add(n)
+			}
+		}
+
+		return NewSet(intersection.set()), nil
+	}),
+	"difference": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"difference\". Expected 2, received %d.", len(args))
+		}
+
+		if args[0].Type != SetDT || args[1].Type != SetDT {
+			return FAIL, nil
+		}
+
+		difference := setBuilder{}
+		a, b := args[0].Set(), args[1].Set()
+		for _, n := range a.Members() {
+			if !b.Has(n) {
+				difference.add(n)
+			}
+		}
+
+		return NewSet(difference.set()), nil
+	}),
+	"add": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"add\". Expected 2, received %d.", len(args))
+		}
+
+		if args[0].Type != SetDT {
+			return FAIL, nil
+		}
+
+		return NewSet(args[0].Set().Add(args[1])), nil
+	}),
+	"remove": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"remove\". Expected 2, received %d.", len(args))
+		}
+
+		if args[0].Type != SetDT {
+			return FAIL, nil
+		}
+
+		return NewSet(args[0].Set().Remove(args[1])), nil
+	}),
 	// object utils
-	"keys": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"keys\". Expected 1, received %d.", len(args))
-			}
-
-			if args[0].Type != ObjectNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			keys := List{}
-			for k := range args[0].Val.(Object) {
-				keys = append(keys, k.toNode())
-			}
-
-			return &Node{Type: ListNT, Val: keys}, nil
-		},
-	},
-	"values": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of values for \"values\". Expected 1, received %d.", len(args))
-			}
-
-			if args[0].Type != ObjectNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			vals := List{}
-			for _, v := range args[0].Val.(Object) {
-				vals = append(vals, v)
-			}
-
-			return &Node{Type: ListNT, Val: vals}, nil
-		},
-	},
+	"keys": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"keys\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != ObjectDT {
+			return FAIL, nil
+		}
+
+		keys := []Value{}
+		for _, f := range args[0].Object().Fields() {
+			keys = append(keys, f.Key)
+		}
+
+		return NewList(listFrom(keys)), nil
+	}),
+	"values": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of values for \"values\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != ObjectDT {
+			return FAIL, nil
+		}
+
+		vals := []Value{}
+		for _, f := range args[0].Object().Fields() {
+			vals = append(vals, f.Val)
+		}
+
+		return NewList(listFrom(vals)), nil
+	}),
+	"pairs": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"pairs\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != ObjectDT {
+			return FAIL, nil
+		}
+
+		pairs := []Value{}
+		for _, f := range args[0].Object().Fields() {
+			pairs = append(pairs, NewList(ListOf(f.Key, f.Val)))
+		}
+
+		return NewList(listFrom(pairs)), nil
+	}),
 	// list utils
-	"flat": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"flat\". Expected 1, received %d.", len(args))
-			}
-
-			if args[0].Type != ListNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			flattened := List{}
-			for _, n := range args[0].Val.(List) {
-				if n.Type == ListNT {
-					flattened = append(flattened, n.Val.(List)...)
-				} else {
-					flattened = append(flattened, n)
-				}
-			}
-
-			return &Node{
-				Type: ListNT,
-				Val:  flattened,
-			}, nil
-		},
-	},
-	"find": {
-		Type: LambdaNT,
-		Func: func(env *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 2 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"find\". Expected 2, received %d.", len(args))
-			}
-
-			list := args[0]
-			if list.Type != ListNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			predicate := args[1]
-			if predicate.Type != LambdaNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			for _, n := range list.Val.(List) {
-				call := &Node{
-					Type: CallNT,
-					L:    predicate,
-					R: &Node{
-						Type: ArgNT,
-						L:    n,
-					},
-				}
-				val, err := Interpret(call, env)
-				if err != nil {
-					return nil, err
-				}
-
-				if isTruthy(val) {
-					return n, nil
-				}
-			}
-
-			return &Node{Type: FailNT}, nil
-		},
-	},
-	"findIndex": {
-		Type: LambdaNT,
-		Func: func(env *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 2 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"findIndex\". Expected 2, received %d.", len(args))
-			}
-
-			list := args[0]
-			if list.Type != ListNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			predicate := args[1]
-			if predicate.Type != LambdaNT {
-				return &Node{Type: FailNT}, nil
+	"flat": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"flat\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != ListDT {
+			return FAIL, nil
+		}
+
+		flattened := List{}
+		for _, n := range args[0].List().Items() {
+			if n.Type == ListDT {
+				flattened = flattened.Concat(n.List())
+			} else {
+				flattened = flattened.Append(n)
+			}
+		}
+
+		return NewList(flattened), nil
+	}),
+	"find": NewFunc(func(env *Environment, args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"find\". Expected 2, received %d.", len(args))
+		}
+
+		list := args[0]
+		if list.Type != ListDT {
+			return FAIL, nil
+		}
+
+		predicate := args[1]
+		if predicate.Type != LambdaDT {
+			return FAIL, nil
+		}
+
+		for _, n := range list.List().Items() {
+			val, err := callLambda(nil, predicate, env, n)
+			if err != nil {
+				return Value{}, err
 			}
 
-			for i, n := range list.Val.(List) {
-				call := &Node{
-					Type: CallNT,
-					L:    predicate,
-					R: &Node{
-						Type: ArgNT,
-						L:    n,
-					},
-				}
-				val, err := Interpret(call, env)
-				if err != nil {
-					return nil, err
-				}
-
-				if isTruthy(val) {
-					return &Node{
-						Type: IntNT,
-						Val:  int64(i),
-					}, nil
-				}
+			if isTruthy(val) {
+				return n, nil
 			}
+		}
 
-			return &Node{Type: FailNT}, nil
-		},
-	},
-	// "fold": {
-	// 	Type: LambdaNT,
-	// 	Func: func(env *Environment, args ...*Node) (*Node, error) {
-	// 		// list, startingVal, func
-	// 		if len(args) != 3 {
-	// 			return nil, fmt.Errorf("Wrong number of arguments for \"fold\". Expected 3, received %d.\n\"fold\" takes a list, a starting value, and a binary function that takes the accumulator and the current value and returns a value.", len(args))
-	// 		}
+		return FAIL, nil
+	}),
+	"findIndex": NewFunc(func(env *Environment, args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"findIndex\". Expected 2, received %d.", len(args))
+		}
 
-	// 		list := args[0]
-	// 		if list.Type != ListNT {
-	// 			return &Node{Type: FailNT}, nil
-	// 		}
-
-	// 		accumulator := args[1]
+		list := args[0]
+		if list.Type != ListDT {
+			return FAIL, nil
+		}
 
-	// 		fn := args[2]
-	// 		if fn.Type != LambdaNT {
-	// 			return &Node{Type: FailNT}, nil
-	// 		}
-
-	// 		for _, n := range list.Val.(List) {
-	// 			call := &Node{
-	// 				Type: CallNT,
-	// 				L:    fn,
-	// 				R: &Node{
-	// 					Type: ArgNT,
-	// 					L:    accumulator,
-	// 					R: &Node{
-	// 						Type: ArgNT,
-	// 						L:    n,
-	// 					},
-	// 				},
-	// 			}
-
-	// 			val, err := Interpret(call, env)
-	// 			if err != nil {
-	// 				return nil, err
-	// 			}
-
-	// 			accumulator = val
-	// 		}
-
-	// 		return accumulator, nil
-	// 	},
-	// },
-	"append": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 2 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"append\". Expected 2, received %d.", len(args))
-			}
+		predicate := args[1]
+		if predicate.Type != LambdaDT {
+			return FAIL, nil
+		}
 
-			if args[0].Type != ListNT {
-				return &Node{Type: FailNT}, nil
-			}
-
-			return &Node{
-				Type: ListNT,
-				Val:  append(args[0].Val.(List), args[1]),
-			}, nil
-		},
-	},
-	"reverse": {
-		Type: LambdaNT,
-		Func: func(_ *Environment, args ...*Node) (*Node, error) {
-			if len(args) != 1 {
-				return nil, fmt.Errorf("Wrong number of arguments for \"reverse\". Expected 1, received %d.", len(args))
-			}
+		for i, n := range list.List().Items() {
+			val, err := callLambda(nil, predicate, env, n)
+			if err != nil {
+				return Value{}, err
+			}
+
+			if isTruthy(val) {
+				return NewInt(int64(i)), nil
+			}
+		}
+
+		return FAIL, nil
+	}),
+	"fold": NewFunc(func(env *Environment, args ...Value) (Value, error) {
+		// list, startingVal, func
+		if len(args) != 3 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"fold\". Expected 3, received %d.\n\"fold\" takes a list, a starting value, and a binary function that takes the accumulator and the current value and returns a value.", len(args))
+		}
+
+		list := args[0]
+		if list.Type != ListDT && list.Type != SetDT && list.Type != ObjectDT {
+			return FAIL, nil
+		}
+
+		fn := args[2]
+		if fn.Type != LambdaDT {
+			return FAIL, nil
+		}
+
+		return fold(iterateCollection(list), args[1], nil, fn, 0, env)
+	}),
+	"reduce": NewFunc(func(env *Environment, args ...Value) (Value, error) {
+		// list, func
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"reduce\". Expected 2, received %d.\n\"reduce\" takes a list and a binary function that takes the accumulator and the current value and returns a value.", len(args))
+		}
+
+		list := args[0]
+		if list.Type != ListDT && list.Type != SetDT && list.Type != ObjectDT {
+			return FAIL, nil
+		}
+
+		fn := args[1]
+		if fn.Type != LambdaDT {
+			return FAIL, nil
+		}
+
+		next := iterateCollection(list)
+		first := next()
+		if first.IsZero() {
+			return FAIL, nil
+		}
+		return fold(next, first, nil, fn, 1, env)
+	}),
+	"append": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"append\". Expected 2, received %d.", len(args))
+		}
+
+		if args[0].Type != ListDT {
+			return FAIL, nil
+		}
+
+		return NewList(args[0].List().Append(args[1])), nil
+	}),
+	"reverse": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"reverse\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != ListDT {
+			return FAIL, nil
+		}
+
+		rev := args[0].List().Items()
+		for i, j := 0, len(rev)-1; i < j; i, j = i+1, j-1 {
+			rev[i], rev[j] = rev[j], rev[i]
+		}
+
+		return NewList(listFrom(rev)), nil
+	}),
+	// tasks and channels
+	"Channel": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) > 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"Channel\". Expected 0 or 1, received %d.", len(args))
+		}
+
+		capacity := 0
+		if len(args) == 1 {
+			if args[0].Type != IntDT || args[0].Int() < 0 {
+				return FAIL, nil
+			}
+			capacity = int(args[0].Int())
+		}
+
+		return newChannel(capacity), nil
+	}),
+	"send": NewFunc(func(env *Environment, args ...Value) (Value, error) {
+		if len(args) != 2 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"send\". Expected 2, received %d.", len(args))
+		}
+
+		if args[0].Type != ChannelDT {
+			return FAIL, nil
+		}
+
+		ok, err := args[0].channel().send(args[1], env.limits())
+		if err != nil {
+			return Value{}, err
+		}
+		if !ok {
+			return FAIL, nil
+		}
+
+		return SUCCESS, nil
+	}),
+	"receive": NewFunc(func(env *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"receive\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != ChannelDT {
+			return FAIL, nil
+		}
+
+		val, ok, err := args[0].channel().receive(env.limits())
+		if err != nil {
+			return Value{}, err
+		}
+		if !ok {
+			return FAIL, nil
+		}
+
+		return val, nil
+	}),
+	"close": NewFunc(func(_ *Environment, args ...Value) (Value, error) {
+		if len(args) != 1 {
+			return Value{}, fmt.Errorf("Wrong number of arguments for \"close\". Expected 1, received %d.", len(args))
+		}
+
+		if args[0].Type != ChannelDT || !args[0].channel().close() {
+			return FAIL, nil
+		}
+
+		return SUCCESS, nil
+	}),
+}
 
-			if args[0].Type != ListNT {
-				return &Node{Type: FailNT}, nil
-			}
+// graphemes splits a string into user-perceived characters: a base character along with any
+// combining marks, emoji modifiers and joined emoji that follow it. This covers the common
+// cases of Unicode's extended grapheme clusters rather than the whole specification.
+func graphemes(s string) []string {
+	res := []string{}
+	start, prev, regional := 0, rune(-1), 0
+	for i, r := range s {
+		if i > start && !continuesGrapheme(prev, r, regional) {
+			res = append(res, s[start:i])
+			start, regional = i, 0
+		}
+		if isRegionalIndicator(r) {
+			regional++
+		}
+		prev = r
+	}
+	if start < len(s) {
+		res = append(res, s[start:])
+	}
+	return res
+}
 
-			list := args[0].Val.(List)
-			rev := make(List, len(list))
-			for i, n := range list {
-				rev[len(list)-i-1] = n
-			}
+const zeroWidthJoiner = '\u200d'
+
+// continuesGrapheme checks whether r belongs to the same grapheme as the character before it.
+// regional is the number of regional indicators (flag halves) in the grapheme so far.
+func continuesGrapheme(prev, r rune, regional int) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return true
+	case prev == zeroWidthJoiner:
+		return true
+	case isRegionalIndicator(prev) && isRegionalIndicator(r):
+		return regional%2 == 1
+	}
+	return r == zeroWidthJoiner ||
+		unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+		(r >= 0xfe00 && r <= 0xfe0f) || // variation selectors
+		(r >= 0x1f3fb && r <= 0x1f3ff) || // skin tone modifiers
+		(r >= 0xe0020 && r <= 0xe007f) // tags
+}
 
-			return &Node{
-				Type: ListNT,
-				Val:  rev,
-			}, nil
-		},
-	},
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
 }
 
-func castFloat(n *Node) (float64, error) {
-	if n.Type == FloatNT {
-		return n.Val.(float64), nil
+func castFloat(n Value) (float64, error) {
+	if n.Type == FloatDT {
+		return n.Float(), nil
 	}
-	if n.Type == IntNT {
-		return float64(n.Val.(int64)), nil
+	if n.Type == IntDT {
+		return float64(n.Int()), nil
 	}
 	return 0, fmt.Errorf("Cannot cast to Float")
 }
 
-func castInt(n *Node) (int64, error) {
-	if n.Type == IntNT {
-		return n.Val.(int64), nil
+func castInt(n Value) (int64, error) {
+	if n.Type == IntDT {
+		return n.Int(), nil
 	}
-	if n.Type == FloatNT {
-		return int64(n.Val.(float64)), nil
+	if n.Type == FloatDT {
+		return int64(n.Float()), nil
 	}
 	return 0, fmt.Errorf("Cannot cast to Int")
 }
@@ -7,6 +7,8 @@ type Token struct {
 	Type   TokenType
 	Line   int
 	Lexeme string
+	Column int // position of the token's first character in its line, starting at 1
+	Offset int // byte offset of the token's first character in the source
 }
 
 // TokenType ...
@@ -62,6 +64,9 @@ const (
 	// Literals
 	IdentifierTT
 	StringTT
+	TemplateStartTT // the text of an interpolated string up to its first ${
+	TemplateMidTT   // the text between two interpolations
+	TemplateEndTT   // the text after the last interpolation
 	IntTT
 	FloatTT
 	CharTT
@@ -91,7 +96,13 @@ const (
 	IndexTT
 	FindTT
 	FoldTT
+	FromTT
 	BindTT
+	SpawnTT
+	AwaitTT
+	PMapTT
+	PWhereTT
+	MatchTT
 
 	ImportTT
 	AsTT
@@ -102,71 +113,89 @@ const (
 )
 
 var tokenDescriptors map[TokenType]string = map[TokenType]string{
-	LeftParenTT:    "(",
-	RightParenTT:   ")",
-	LeftBraceTT:    "{",
-	RightBraceTT:   "}",
-	LeftBracketTT:  "[",
-	RightBracketTT: "]",
-	ColonTT:        ":",
-	CommaTT:        ",",
-	DotTT:          ".",
-	MinusTT:        "-",
-	PlusTT:         "+",
-	SemicolonTT:    ";",
-	NewLineTT:      "new line",
-	SlashTT:        "/",
-	StarTT:         "*",
-	ModuloTT:       "%",
-	ArrowTT:        "=>",
-	BangTT:         "!",
-	BangEqualTT:    "!=",
-	DotDotTT:       "..",
-	EqualTT:        "=",
-	EqualEqualTT:   "==",
-	GreaterTT:      ">",
-	GreaterEqualTT: ">=",
-	LessTT:         "<",
-	LessEqualTT:    "<=",
-	ColonEqualTT:   ":=",
-	MinusEqualTT:   "-=",
-	PlusEqualTT:    "+=",
-	SlashEqualTT:   "/=",
-	StarEqualTT:    "*=",
-	ModuloEqualTT:  "%=",
-	BarEqualTT:     "|=",
-	IdentifierTT:   "identifier",
-	StringTT:       "string literal",
-	IntTT:          "integer literal",
-	FloatTT:        "float literal",
-	AndTT:          "and",
-	ElseTT:         "else",
-	FalseTT:        "false",
-	ForTT:          "for",
-	IfTT:           "if",
-	NullTT:         "null",
-	OrTT:           "or",
-	ReturnTT:       "return",
-	TrueTT:         "true",
-	WhileTT:        "while",
-	CommentTT:      "comment",
-	EOFTT:          "EOF",
-	QuestionMarkTT: "?",
-	BarTT:          "|",
-	PipeTT:         "|>",
-	UnlessTT:       "unless",
-	UntilTT:        "until",
-	FailTT:         "fail",
-	SuccessTT:      "success",
-	MapTT:          "map",
-	WhereTT:        "where",
-	CharTT:         "char",
-	InTT:           "in",
-	VarTT:          "var",
-	IndexTT:        "index",
-	ImportTT:       "import",
-	AsTT:           "as",
-	DotDotDotTT:    "...",
+	LeftParenTT:     "(",
+	RightParenTT:    ")",
+	LeftBraceTT:     "{",
+	RightBraceTT:    "}",
+	LeftBracketTT:   "[",
+	RightBracketTT:  "]",
+	ColonTT:         ":",
+	CommaTT:         ",",
+	DotTT:           ".",
+	MinusTT:         "-",
+	PlusTT:          "+",
+	SemicolonTT:     ";",
+	NewLineTT:       "new line",
+	SlashTT:         "/",
+	StarTT:          "*",
+	ModuloTT:        "%",
+	ArrowTT:         "=>",
+	BangTT:          "!",
+	BangEqualTT:     "!=",
+	DotDotTT:        "..",
+	EqualTT:         "=",
+	EqualEqualTT:    "==",
+	GreaterTT:       ">",
+	GreaterEqualTT:  ">=",
+	LessTT:          "<",
+	LessEqualTT:     "<=",
+	ColonEqualTT:    ":=",
+	MinusEqualTT:    "-=",
+	PlusEqualTT:     "+=",
+	SlashEqualTT:    "/=",
+	StarEqualTT:     "*=",
+	ModuloEqualTT:   "%=",
+	BarEqualTT:      "|=",
+	IdentifierTT:    "identifier",
+	StringTT:        "string literal",
+	TemplateStartTT: "string literal",
+	TemplateMidTT:   "}",
+	TemplateEndTT:   "}",
+	IntTT:           "integer literal",
+	FloatTT:         "float literal",
+	AndTT:           "and",
+	ElseTT:          "else",
+	FalseTT:         "false",
+	ForTT:           "for",
+	IfTT:            "if",
+	NullTT:          "null",
+	OrTT:            "or",
+	ReturnTT:        "return",
+	TrueTT:          "true",
+	WhileTT:         "while",
+	CommentTT:       "comment",
+	EOFTT:           "EOF",
+	QuestionMarkTT:  "?",
+	BarTT:           "|",
+	PipeTT:          "|>",
+	UnlessTT:        "unless",
+	UntilTT:         "until",
+	FailTT:          "fail",
+	SuccessTT:       "success",
+	MapTT:           "map",
+	WhereTT:         "where",
+	CharTT:          "char",
+	InTT:            "in",
+	VarTT:           "var",
+	IndexTT:         "index",
+	ImportTT:        "import",
+	AsTT:            "as",
+	DotDotDotTT:     "...",
+	MatchTT:         "match",
+	HashTT:          "#",
+	CaratTT:         "^",
+	LeftArrowTT:     "<-",
+	BreakTT:         "break",
+	ContinueTT:      "continue",
+	UnderscoreTT:    "_",
+	FindTT:          "find",
+	FoldTT:          "fold",
+	FromTT:          "from",
+	BindTT:          "bind",
+	SpawnTT:         "spawn",
+	AwaitTT:         "await",
+	PMapTT:          "pmap",
+	PWhereTT:        "pwhere",
 }
 
 // ToString returns a string representation of a token in the form <Line#: Type "Lexeme">
@@ -0,0 +1,337 @@
+package interpreter
+
+import (
+	"sort"
+	"strings"
+)
+
+// Type is the static type of an expression, as inferred by Check
+type Type struct {
+	Kind     TypeKind
+	Elem     *Type            // element type of a List or Set
+	Items    []*Type          // item types of a Tuple, nil when its length is unknown
+	Fields   map[string]*Type // fields of an Object, nil when its shape is unknown
+	Params   []*Type          // parameter types of a Lambda
+	Return   *Type            // return type of a Lambda, nil when it depends on the arguments
+	Members  []*Type          // members of a Union
+	Variadic bool             // a Lambda accepting any number of arguments after its Params
+
+	// Lambdas defined in Rye are checked against the argument types at each call
+	lambda  *Node
+	scope   *typeScope
+	builtin *builtinSig
+}
+
+// TypeKind ...
+type TypeKind uint8
+
+// TypeKind values
+const (
+	AnyTK TypeKind = iota
+	IntTK
+	FloatTK
+	BoolTK
+	StringTK
+	NullTK
+	SuccessTK
+	FailTK
+	ListTK
+	SetTK
+	ObjectTK
+	TupleTK
+	LambdaTK
+	ModuleTK
+	TaskTK
+	ChannelTK
+	UnionTK
+)
+
+var typeKindNames map[TypeKind]string = map[TypeKind]string{
+	AnyTK:     "Any",
+	IntTK:     "Int",
+	FloatTK:   "Float",
+	BoolTK:    "Bool",
+	StringTK:  "String",
+	NullTK:    "Null",
+	SuccessTK: "Success",
+	FailTK:    "Fail",
+	ListTK:    "List",
+	SetTK:     "Set",
+	ObjectTK:  "Object",
+	TupleTK:   "Tuple",
+	LambdaTK:  "Lambda",
+	ModuleTK:  "Module",
+	TaskTK:    "Task",
+	ChannelTK: "Channel",
+	UnionTK:   "Union",
+}
+
+var (
+	anyType     = &Type{Kind: AnyTK}
+	intType     = &Type{Kind: IntTK}
+	floatType   = &Type{Kind: FloatTK}
+	boolType    = &Type{Kind: BoolTK}
+	stringType  = &Type{Kind: StringTK}
+	nullType    = &Type{Kind: NullTK}
+	successType = &Type{Kind: SuccessTK}
+	failType    = &Type{Kind: FailTK}
+	moduleType  = &Type{Kind: ModuleTK}
+	channelType = &Type{Kind: ChannelTK}
+	resultType  = unionOf(successType, failType)
+	numberType  = unionOf(intType, floatType)
+)
+
+func listOf(elem *Type) *Type {
+	return &Type{Kind: ListTK, Elem: elem}
+}
+
+func setOf(elem *Type) *Type {
+	return &Type{Kind: SetTK, Elem: elem}
+}
+
+func tupleOf(items ...*Type) *Type {
+	return &Type{Kind: TupleTK, Items: items}
+}
+
+func taskOf(res *Type) *Type {
+	return &Type{Kind: TaskTK, Elem: res}
+}
+
+func objectOf(fields map[string]*Type) *Type {
+	return &Type{Kind: ObjectTK, Fields: fields}
+}
+
+// maybe returns T?, the union of a type and fail
+func maybe(t *Type) *Type {
+	return unionOf(t, failType)
+}
+
+// unionOf flattens and deduplicates its arguments. Any absorbs every other type.
+func unionOf(ts ...*Type) *Type {
+	members := []*Type{}
+	seen := map[string]bool{}
+
+	var add func(t *Type) bool
+	add = func(t *Type) bool {
+		if t == nil {
+			return true
+		}
+		if t.Kind == AnyTK {
+			return false
+		}
+		if t.Kind == UnionTK {
+			for _, m := range t.Members {
+				if !add(m) {
+					return false
+				}
+			}
+			return true
+		}
+		if key := t.String(); !seen[key] {
+			seen[key] = true
+			members = append(members, t)
+		}
+		return true
+	}
+
+	for _, t := range ts {
+		if !add(t) {
+			return anyType
+		}
+	}
+
+	switch len(members) {
+	case 0:
+		return anyType
+	case 1:
+		return members[0]
+	}
+	return &Type{Kind: UnionTK, Members: members}
+}
+
+// members returns the types making up a union, or the type itself
+func (t *Type) members() []*Type {
+	if t.Kind == UnionTK {
+		return t.Members
+	}
+	return []*Type{t}
+}
+
+// without removes a kind from a union, e.g. Int? without fail is Int
+func (t *Type) without(k TypeKind) *Type {
+	if t.Kind == AnyTK {
+		return t
+	}
+	rest := []*Type{}
+	for _, m := range t.members() {
+		if m.Kind != k {
+			rest = append(rest, m)
+		}
+	}
+	if len(rest) == 0 {
+		return failType
+	}
+	return unionOf(rest...)
+}
+
+func (t *Type) mayBe(k TypeKind) bool {
+	for _, m := range t.members() {
+		if m.Kind == k || m.Kind == AnyTK {
+			return true
+		}
+	}
+	return false
+}
+
+// assignable reports whether a value of type t could be used where type to is expected
+func (t *Type) assignable(to *Type) bool {
+	if t.Kind == AnyTK || to.Kind == AnyTK {
+		return true
+	}
+	for _, a := range t.members() {
+		ok := false
+		for _, b := range to.members() {
+			if a.Kind == b.Kind || (a.Kind == IntTK && b.Kind == FloatTK) || b.Kind == AnyTK {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// compatible reports whether a value of type t might be used where type to is expected, i.e.
+// whether any of its members is assignable
+func (t *Type) compatible(to *Type) bool {
+	for _, m := range t.members() {
+		if m.assignable(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// elemType returns the type of the items produced by iterating over a collection
+func (t *Type) elemType() *Type {
+	switch t.Kind {
+	case ListTK, SetTK:
+		if t.Elem == nil {
+			return anyType
+		}
+		return t.Elem
+	case ObjectTK:
+		if t.Fields == nil {
+			return anyType
+		}
+		return stringType
+	case UnionTK:
+		elems := []*Type{}
+		for _, m := range t.Members {
+			if m.Kind == ListTK || m.Kind == SetTK || m.Kind == ObjectTK {
+				elems = append(elems, m.elemType())
+			}
+		}
+		return unionOf(elems...)
+	}
+	return anyType
+}
+
+// itemType returns the type of the i-th item destructured from a list or tuple
+func (t *Type) itemType(i int) *Type {
+	switch t.Kind {
+	case AnyTK:
+		return anyType
+	case ListTK:
+		return maybe(t.elemType())
+	case TupleTK:
+		if t.Items == nil {
+			return anyType
+		}
+		if i >= 0 && i < len(t.Items) {
+			return t.Items[i]
+		}
+	case UnionTK:
+		items := []*Type{}
+		for _, m := range t.Members {
+			items = append(items, m.itemType(i))
+		}
+		return unionOf(items...)
+	}
+	return failType
+}
+
+func (t *Type) String() string {
+	if t == nil {
+		return "Any"
+	}
+	switch t.Kind {
+	case ListTK, SetTK, TaskTK:
+		if t.Elem == nil {
+			return typeKindNames[t.Kind]
+		}
+		return typeKindNames[t.Kind] + "[" + t.Elem.String() + "]"
+	case ObjectTK:
+		if t.Fields == nil {
+			return "Object"
+		}
+		keys := []string{}
+		for k := range t.Fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		fields := []string{}
+		for _, k := range keys {
+			fields = append(fields, k+": "+t.Fields[k].String())
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	case TupleTK:
+		if t.Items == nil {
+			return "Tuple"
+		}
+		items := []string{}
+		for _, item := range t.Items {
+			items = append(items, item.String())
+		}
+		return "(" + strings.Join(items, ", ") + ")"
+	case LambdaTK:
+		if t.Params == nil && t.Return == nil && t.lambda == nil && t.builtin == nil {
+			return "Lambda"
+		}
+		params := []string{}
+		for _, p := range t.Params {
+			params = append(params, p.String())
+		}
+		if t.Variadic {
+			params = append(params, "...")
+		}
+		if t.Return == nil {
+			return "(" + strings.Join(params, ", ") + ") -> Any"
+		}
+		return "(" + strings.Join(params, ", ") + ") -> " + t.Return.String()
+	case UnionTK:
+		rest := []string{}
+		fails := false
+		for _, m := range t.Members {
+			if m.Kind == FailTK {
+				fails = true
+			} else {
+				rest = append(rest, m.String())
+			}
+		}
+		if !fails {
+			return strings.Join(rest, " | ")
+		}
+		if len(rest) == 1 {
+			if rest[0] == "Success" {
+				return "Result"
+			}
+			return rest[0] + "?"
+		}
+		return "(" + strings.Join(rest, " | ") + ")?"
+	default:
+		return typeKindNames[t.Kind]
+	}
+}
@@ -0,0 +1,454 @@
+package interpreter
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Value is a value of a running program, as opposed to the Nodes of its syntax. It's a tagged
+// union: numbers and bools are held in the Value itself, and strings, collections and functions by
+// reference, so a list of a million Ints is one slice of Values rather than a million allocations.
+// The zero Value is no value at all, e.g. the value of a loop that never ran.
+type Value struct {
+	Type DataType
+	bits uint64      // an Int, Float or Bool
+	ref  interface{} // a string, collection, function, module, task or channel
+}
+
+// DataType is the kind of a Value
+type DataType uint8
+
+const (
+	InvalidDT DataType = iota // the zero Value
+	NullDT
+	IntDT
+	FloatDT
+	BoolDT
+	StringDT
+	SuccessDT
+	FailDT
+	ListDT
+	SetDT
+	ObjectDT
+	TupleDT
+	LambdaDT
+	ModuleDT
+	TaskDT
+	ChannelDT
+
+	// the results of return, break and continue statements, which end a function or an iteration
+	// of a loop rather than being values of Rye
+	ReturnDT
+	BreakDT
+	ContinueDT
+
+	iterationDT // the state of a for loop run by the VM
+	tailCallDT  // a call in tail position, made by the caller of the function it ends
+)
+
+var dataTypeMap map[DataType]string = map[DataType]string{
+	InvalidDT:  "invalid",
+	NullDT:     "null",
+	IntDT:      "int",
+	FloatDT:    "float",
+	BoolDT:     "bool",
+	StringDT:   "string",
+	SuccessDT:  "success",
+	FailDT:     "fail",
+	ListDT:     "list",
+	SetDT:      "set",
+	ObjectDT:   "obj",
+	TupleDT:    "tuple",
+	LambdaDT:   "lambda",
+	ModuleDT:   "module",
+	TaskDT:     "task",
+	ChannelDT:  "channel",
+	ReturnDT:   "return",
+	BreakDT:    "break",
+	ContinueDT: "continue",
+}
+
+func (dt DataType) ToString() string {
+	return dataTypeMap[dt]
+}
+
+type Tuple []Value
+
+// Field is a field of an Object
+type Field struct {
+	Key, Val Value
+}
+
+// Func is a built-in function, written in Go
+type Func func(*Environment, ...Value) (Value, error)
+
+// function is a lambda: the expression it was created from, along with the scope it was created in,
+// which it's a closure of. Built-in functions have a Func instead.
+type function struct {
+	node    *Node // the lambda expression, with its parameters in L, body in R and return type in Val
+	scope   *Environment
+	builtin Func
+}
+
+// module is an imported module, whose scope holds the constants it exports
+type module struct {
+	name  string
+	scope *Environment
+}
+
+var FAIL = Value{Type: FailDT}
+var SUCCESS = Value{Type: SuccessDT}
+var NULL = Value{Type: NullDT}
+var TRUE = NewBool(true)
+var FALSE = NewBool(false)
+
+func NewInt(val int64) Value {
+	return Value{Type: IntDT, bits: uint64(val)}
+}
+
+func NewFloat(val float64) Value {
+	return Value{Type: FloatDT, bits: math.Float64bits(val)}
+}
+
+func NewBool(val bool) Value {
+	if val {
+		return Value{Type: BoolDT, bits: 1}
+	}
+	return Value{Type: BoolDT}
+}
+
+func NewString(val string) Value {
+	return Value{Type: StringDT, ref: val}
+}
+
+// NewList, NewSet and NewObject hold a collection through a pointer, so that assigning to an item
+// of it (which replaces it with an updated version) is seen through every Value holding it
+func NewList(val List) Value {
+	return Value{Type: ListDT, ref: &val}
+}
+
+func NewTuple(val Tuple) Value {
+	return Value{Type: TupleDT, ref: val}
+}
+
+func NewSet(val Set) Value {
+	return Value{Type: SetDT, ref: &val}
+}
+
+func NewObject(val Object) Value {
+	return Value{Type: ObjectDT, ref: &val}
+}
+
+// NewFunc makes a Go function into a built-in function of Rye
+func NewFunc(fn Func) Value {
+	return Value{Type: LambdaDT, ref: &function{builtin: fn}}
+}
+
+// newLambda creates a closure of the scope a lambda expression is evaluated in
+func newLambda(n *Node, scope *Environment) Value {
+	return Value{Type: LambdaDT, ref: &function{node: n, scope: scope}}
+}
+
+func newModule(name string, scope *Environment) Value {
+	return Value{Type: ModuleDT, ref: &module{name: name, scope: scope}}
+}
+
+// newReturn wraps the value of a return statement, until it reaches the function it returns from
+func newReturn(val Value) Value {
+	return Value{Type: ReturnDT, ref: val}
+}
+
+// IsZero reports whether a Value is the zero Value, which isn't a value of Rye
+func (v Value) IsZero() bool {
+	return v.Type == InvalidDT
+}
+
+func (v Value) Int() int64 {
+	return int64(v.bits)
+}
+
+func (v Value) Float() float64 {
+	return math.Float64frombits(v.bits)
+}
+
+func (v Value) Bool() bool {
+	return v.bits != 0
+}
+
+// Str gives the contents of a String
+func (v Value) Str() string {
+	return v.ref.(string)
+}
+
+func (v Value) List() List {
+	return *v.ref.(*List)
+}
+
+func (v Value) Tuple() Tuple {
+	return v.ref.(Tuple)
+}
+
+func (v Value) Set() Set {
+	return *v.ref.(*Set)
+}
+
+func (v Value) Object() Object {
+	return *v.ref.(*Object)
+}
+
+// setList replaces the list a Value holds, for every Value holding it
+func (v Value) setList(l List) {
+	*v.ref.(*List) = l
+}
+
+// setObject replaces the object a Value holds, for every Value holding it
+func (v Value) setObject(o Object) {
+	*v.ref.(*Object) = o
+}
+
+func (v Value) fn() *function {
+	return v.ref.(*function)
+}
+
+func (v Value) module() *module {
+	return v.ref.(*module)
+}
+
+func (v Value) task() *task {
+	return v.ref.(*task)
+}
+
+func (v Value) channel() *channel {
+	return v.ref.(*channel)
+}
+
+func (v Value) iteration() *iteration {
+	return v.ref.(*iteration)
+}
+
+func (v Value) tailCall() *tailCall {
+	return v.ref.(*tailCall)
+}
+
+// unwrap gives the value returned by a return statement
+func (v Value) unwrap() Value {
+	return v.ref.(Value)
+}
+
+// Kind gives the name of a value's type, as returned by typeof, or "" for values that aren't
+// values of Rye
+func (v Value) Kind() string {
+	switch v.Type {
+	case LambdaDT:
+		return "Lambda"
+	case ListDT:
+		return "List"
+	case SetDT:
+		return "Set"
+	case ObjectDT:
+		return "Object"
+	case TupleDT:
+		return "Tuple"
+	case SuccessDT, FailDT:
+		return "Result"
+	case FloatDT:
+		return "Float"
+	case IntDT:
+		return "Int"
+	case BoolDT:
+		return "Bool"
+	case StringDT:
+		return "String"
+	case NullDT:
+		return "Null"
+	case ModuleDT:
+		return "Module"
+	case TaskDT:
+		return "Task"
+	case ChannelDT:
+		return "Channel"
+	}
+	return ""
+}
+
+// key identifies a value as a Set member or Object key. Values that are equal have the same key:
+// numbers, bools and strings are held as they are, collections as an encoding of their items, and
+// functions, modules, tasks and channels by their identity.
+type key struct {
+	Type DataType
+	bits uint64
+	str  string
+}
+
+func keyOf(v Value) key {
+	switch v.Type {
+	case IntDT, BoolDT:
+		return key{Type: v.Type, bits: v.bits}
+	case FloatDT:
+		if v.Float() == 0 {
+			// -0 is 0
+			return key{Type: FloatDT}
+		}
+		return key{Type: FloatDT, bits: v.bits}
+	case StringDT:
+		return key{Type: StringDT, str: v.Str()}
+	case ListDT, TupleDT, SetDT, ObjectDT:
+		return key{Type: v.Type, str: encodeItems(v)}
+	case LambdaDT, ModuleDT, TaskDT, ChannelDT:
+		return key{Type: v.Type, bits: uint64(reflect.ValueOf(v.ref).Pointer())}
+	}
+	return key{Type: v.Type}
+}
+
+// encodeItems encodes the items of a collection for its key. The members of a set and the fields of
+// an object are sorted, so that it doesn't depend on the order they're stored in.
+func encodeItems(v Value) string {
+	var sb strings.Builder
+	switch v.Type {
+	case ListDT:
+		v.List().each(func(_ int, item Value) bool {
+			encode(&sb, item)
+			return true
+		})
+	case TupleDT:
+		for _, item := range v.Tuple() {
+			encode(&sb, item)
+		}
+	case SetDT:
+		members := []string{}
+		for _, m := range v.Set().Members() {
+			var msb strings.Builder
+			encode(&msb, m)
+			members = append(members, msb.String())
+		}
+		sort.Strings(members)
+		for _, m := range members {
+			sb.WriteString(m)
+		}
+	case ObjectDT:
+		fields := []string{}
+		for _, f := range v.Object().Fields() {
+			var fsb strings.Builder
+			encode(&fsb, f.Key)
+			encode(&fsb, f.Val)
+			fields = append(fields, fsb.String())
+		}
+		sort.Strings(fields)
+		for _, f := range fields {
+			sb.WriteString(f)
+		}
+	}
+	return sb.String()
+}
+
+// encode writes an item of a collection as its data type, the length of its contents, ":" and its
+// contents, so that collections with equal items have the same key
+func encode(sb *strings.Builder, v Value) {
+	k := keyOf(v)
+	s := k.str
+	if k.str == "" && k.bits != 0 {
+		s = strconv.FormatUint(k.bits, 16)
+	}
+	sb.WriteByte(byte(k.Type))
+	sb.WriteString(strconv.Itoa(len(s)))
+	sb.WriteByte(':')
+	sb.WriteString(s)
+}
+
+// ToString formats a value as it's printed inside of a collection, with strings in quotes
+func (v Value) ToString() string {
+	switch v.Type {
+	case InvalidDT:
+		return "NIL_PTR"
+	case IntDT:
+		return strconv.FormatInt(v.Int(), 10)
+	case FloatDT:
+		return fmt.Sprintf("%v", v.Float())
+	case BoolDT:
+		return strconv.FormatBool(v.Bool())
+	case StringDT:
+		return fmt.Sprintf("\"%v\"", v.Str())
+	case ListDT:
+		return "[" + joinValues(v.List().Items()) + "]"
+	case TupleDT:
+		return "(" + joinValues(v.Tuple()) + ")"
+	case ObjectDT:
+		fields := []string{}
+		for _, f := range v.Object().Fields() {
+			fields = append(fields, f.Key.ToString()+": "+f.Val.ToString())
+		}
+		return "{" + strings.Join(fields, ", ") + "}"
+	case SetDT:
+		return "{" + joinValues(v.Set().Members()) + "}"
+	case LambdaDT:
+		if f := v.fn(); f.node != nil {
+			return f.node.ToString()
+		}
+		return "<lambda>"
+	case ModuleDT:
+		return fmt.Sprintf("(module %s)", v.module().name)
+	case ReturnDT:
+		return fmt.Sprintf("(return %s)", v.unwrap().ToString())
+	case TaskDT, ChannelDT:
+		return fmt.Sprintf("<%s>", v.Type.ToString())
+	}
+	return v.Type.ToString()
+}
+
+func joinValues(vals []Value) string {
+	strs := make([]string, len(vals))
+	for i, val := range vals {
+		strs[i] = val.ToString()
+	}
+	return strings.Join(strs, ", ")
+}
+
+// Display formats a value as the REPL displays it
+func Display(v Value) string {
+	switch v.Type {
+	case InvalidDT, FloatDT, IntDT, BoolDT, StringDT, ListDT, ObjectDT, SetDT, TupleDT, NullDT, FailDT, SuccessDT:
+		return v.ToString()
+	case LambdaDT:
+		return "<lambda>"
+	case TaskDT, ChannelDT:
+		return v.ToString()
+	default:
+		return "success"
+	}
+}
+
+// literal gives the value of a literal
+func literal(n *Node) Value {
+	switch n.Type {
+	case IntNT:
+		return NewInt(n.Val.(int64))
+	case FloatNT:
+		return NewFloat(n.Val.(float64))
+	case BoolNT:
+		return NewBool(n.Val.(bool))
+	case StringNT:
+		return NewString(n.Val.(string))
+	case NullNT:
+		return NULL
+	case SuccessNT:
+		return SUCCESS
+	case BreakNT:
+		return Value{Type: BreakDT}
+	case ContinueNT:
+		return Value{Type: ContinueDT}
+	}
+	return FAIL
+}
+
+// nodeKey gives the key an object pattern or destructuring target takes a field by: the name of an
+// identifier, or the value of a literal
+func nodeKey(n *Node) Value {
+	if n.Type == IdentifierNT {
+		return NewString(n.Val.(string))
+	}
+	return literal(n)
+}
@@ -0,0 +1,423 @@
+package interpreter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// iteration is the state of a for loop, kept in a hidden local
+type iteration struct {
+	next  func() Value
+	index int
+}
+
+// Run runs a compiled program in an environment, like Interpret. Functions it calls are compiled
+// the first time they're called, and run by the VM too.
+func Run(ch *Chunk, env *Environment) (Value, error) {
+	frame := newFrame(env, env, ch.layout, ch.maxStack)
+	frame.vm = true
+	frame.owned = true
+	return execute(ch, frame)
+}
+
+// CallCompiled calls a function like Call, running it on the VM
+func CallCompiled(fn Value, args []Value, env *Environment, name string) (Value, error) {
+	scope := newScope(env)
+	scope.vm = true
+	return callLambda(calleeNamed(name), fn, scope, args...)
+}
+
+// callCompiled calls a Rye function with arguments on the VM, in a frame of the given depth. The
+// caller is the scope it's called from, and callee and line are where it's called, for error
+// messages. Any tail call the function ends with is returned rather than made.
+func callCompiled(f *function, args []Value, caller *Environment, callee *Node, line, depth int) (Value, error) {
+	ch := compiledFunction(f.node)
+	if err := checkArity(callee, len(ch.params), len(args)); err != nil {
+		return Value{}, err
+	}
+
+	scope := callFrame(f, ch.layout, caller, depth, ch.maxStack)
+	scope.vm = true
+	scope.owned = true
+	if err := scope.checkDepth(); err != nil {
+		return Value{}, err
+	}
+
+	for i, p := range ch.params {
+		val := args[i]
+		if t := annotation(p); t != nil {
+			var ok bool
+			if val, ok = conform(val, t); !ok {
+				return Value{}, runtimeErrorf(p.Line, "Type mismatch for argument \"%s\" of %s. Expected %s, received %s.", p.Val.(string), describeFunction(callee), t, typeName(val))
+			}
+		}
+		bindParam(scope, p, val)
+	}
+
+	res, err := execute(ch, scope)
+	if err != nil {
+		return res, pushFrame(err, frameName(callee), line)
+	}
+
+	if res.IsZero() {
+		// the body ended with a loop that never ran
+		res = FAIL
+	} else if res.Type == ReturnDT {
+		res = res.unwrap()
+	}
+	return res, nil
+}
+
+// callLambda calls a lambda with values as its arguments, for compound expressions like map.
+// The callee is nil for lambdas called by built-in functions.
+func callLambda(callee *Node, lambda Value, env *Environment, args ...Value) (Value, error) {
+	line := nodeLine(callee)
+	res, err := call(lambda, args, env, callee, line)
+	if err != nil {
+		return Value{}, locateLine(err, line)
+	}
+	return res, nil
+}
+
+// execute runs a chunk in the frame holding its locals, which has room for its stack after them
+func execute(ch *Chunk, env *Environment) (Value, error) {
+	s := env.limits()
+	code, consts, nodes := ch.code, ch.consts, ch.nodes
+	stack := env.slots[len(env.slots):len(env.slots)]
+
+	for pc := 0; pc < len(code); pc++ {
+		in := &code[pc]
+		if s != nil {
+			if err := s.step(); err != nil {
+				return Value{}, locateLine(err, int(in.line))
+			}
+		}
+
+		var err error
+		switch in.op {
+		case opConst:
+			stack = append(stack, consts[in.a])
+		case opNil:
+			stack = append(stack, Value{})
+		case opLambda:
+			// a closure of the frame it's created in. If it uses the frame's locals, other tasks
+			// may call it.
+			if layoutOf(nodes[in.a]).captures {
+				env.share()
+			}
+			stack = append(stack, newLambda(nodes[in.a], env))
+		case opPop:
+			stack = stack[:len(stack)-1]
+
+		// locals and names
+		case opGetLocal:
+			val := env.slot(int(in.a))
+			if val.IsZero() {
+				// declared in a branch that wasn't taken
+				val, err = resolveIdentifier(nodes[in.b], env)
+			}
+			stack = append(stack, val)
+		case opGetName:
+			var val Value
+			val, err = resolveIdentifier(nodes[in.a], env)
+			stack = append(stack, val)
+		case opDeclareLocal:
+			val := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			err = declareSlot(env, int(in.a), nodes[in.b], val)
+		case opDestructure:
+			val := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			target := nodes[in.a]
+			idents := targetIdents(target)
+			for i, item := range destructure(target, val) {
+				env.setSlot(idents[i].res.slot, item)
+			}
+		case opDeclareName:
+			val := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			// top-level declarations are made in the scope the program is run in
+			err = declareValue(nodes[in.a], val, env.Parent, in.b == 1)
+		case opSetLocal:
+			val := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			err = assignSlot(env, int(in.a), nodes[in.b], val)
+		case opSetName:
+			val := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			err = assignName(nodes[in.a], val, env)
+		case opLoadSlot:
+			stack = append(stack, env.slot(int(in.a)))
+		case opStoreSlot:
+			env.setSlot(int(in.a), stack[len(stack)-1])
+			stack = stack[:len(stack)-1]
+		case opClear:
+			env.clear(int(in.a), int(in.b))
+
+		// control flow
+		case opJump:
+			pc = int(in.a) - 1
+		case opJumpIfFalse:
+			cond := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if !isTruthy(cond) {
+				pc = int(in.a) - 1
+			}
+		case opLoop:
+			if err = s.cancelled(); err == nil {
+				pc = int(in.a) - 1
+			}
+		case opBreak:
+			stack = stack[:in.b]
+			pc = int(in.a) - 1
+		case opGuard:
+			if !passes(stack[len(stack)-1], int(in.b)) {
+				stack[len(stack)-1] = FAIL
+				pc = int(in.a) - 1
+			}
+		case opReturn:
+			res := stack[len(stack)-1]
+			if in.b == 1 {
+				res = newReturn(res)
+			}
+			return res, nil
+		case opEval:
+			// the interpreter may create closures of the frame, or spawn tasks in it
+			env.share()
+			var res Value
+			if in.b&evalTail != 0 {
+				res, err = interpretTail(nodes[in.a], env, true)
+			} else {
+				res, err = Interpret(nodes[in.a], env)
+			}
+			if err == nil && in.b&evalStmt != 0 && res.Type == ReturnDT {
+				return res.unwrap(), nil
+			}
+			stack = append(stack, res)
+
+		// operators
+		case opUnary:
+			top := len(stack) - 1
+			stack[top], err = unaryOp(NodeType(in.a), stack[top])
+		case opBinary:
+			top := len(stack) - 2
+			stack[top], err = binaryOp(NodeType(in.a), stack[top], stack[top+1])
+			stack = stack[:top+1]
+
+		// calls
+		case opCall:
+			argc := int(in.a)
+			base := len(stack) - argc
+			var res Value
+			res, err = call(stack[base-1], stack[base:], env, nodes[in.b].L, int(in.line))
+			stack = append(stack[:base-1], res)
+		case opTailCall:
+			// the frame is done, so the arguments can stay on its stack
+			base := len(stack) - int(in.a)
+			var res Value
+			if res, err = newTailCall(stack[base-1], stack[base:], env, nodes[in.b].L, int(in.line)); err == nil {
+				return res, nil
+			}
+		case opCompound:
+			top := len(stack) - 2
+			stack[top], err = compound(NodeType(in.a), stack[top], nodes[in.b], stack[top+1], env)
+			stack = stack[:top+1]
+		case opFold:
+			var acc Value
+			if in.a == 1 {
+				acc = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			top := len(stack) - 2
+			stack[top], err = foldItems(stack[top], acc, nodes[in.b], stack[top+1], env)
+			stack = stack[:top+1]
+
+		// collections
+		case opList:
+			stack = append(stack, NewList(List{}))
+		case opAppend:
+			top := len(stack) - 2
+			stack[top].setList(stack[top].List().Append(stack[top+1]))
+			stack = stack[:top+1]
+		case opSpreadList:
+			top := len(stack) - 2
+			stack[top].setList(spreadList(stack[top].List(), stack[top+1]))
+			stack = stack[:top+1]
+		case opSet:
+			stack = append(stack, NewSet(Set{}))
+		case opAddSet:
+			top := len(stack) - 2
+			stack[top] = NewSet(stack[top].Set().Add(stack[top+1]))
+			stack = stack[:len(stack)-1]
+		case opSpreadSet:
+			top := len(stack) - 2
+			stack[top] = NewSet(spreadSet(stack[top].Set(), stack[top+1]))
+			stack = stack[:len(stack)-1]
+		case opObject:
+			stack = append(stack, NewObject(Object{}))
+		case opAddField:
+			obj := stack[len(stack)-3]
+			obj.setObject(obj.Object().Put(stack[len(stack)-2], stack[len(stack)-1]))
+			stack = stack[:len(stack)-2]
+		case opSpreadObject:
+			obj := stack[len(stack)-2]
+			obj.setObject(spreadObject(obj.Object(), stack[len(stack)-1]))
+			stack = stack[:len(stack)-1]
+		case opTuple:
+			base := len(stack) - int(in.a)
+			tuple := make(Tuple, in.a)
+			copy(tuple, stack[base:])
+			stack = append(stack[:base], NewTuple(tuple))
+		case opTemplate:
+			base := len(stack) - int(in.a)
+			var sb strings.Builder
+			for _, val := range stack[base:] {
+				sb.WriteString(interpolate(val))
+			}
+			stack = append(stack[:base], NewString(sb.String()))
+		case opRange:
+			var start Value
+			end := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if in.a == 1 {
+				start = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			var res Value
+			res, err = makeRange(start, end, env)
+			stack = append(stack, res)
+
+		// access
+		case opIndex:
+			top := len(stack) - 2
+			stack[top], err = bracketAccess(stack[top], stack[top+1])
+			stack = stack[:top+1]
+		case opField:
+			top := len(stack) - 1
+			stack[top] = fieldAccess(stack[top], nodes[in.a])
+		case opSlice:
+			var start, end Value
+			if in.a&2 != 0 {
+				end = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			if in.a&1 != 0 {
+				start = stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+			}
+			top := len(stack) - 1
+			stack[top] = slice(stack[top], start, end)
+
+		// for loops
+		case opIter:
+			src := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if src.Type != ListDT && src.Type != ObjectDT && src.Type != SetDT {
+				stack = append(stack, FAIL)
+				pc = int(in.b) - 1
+				break
+			}
+			env.setSlot(int(in.a), Value{Type: iterationDT, ref: &iteration{next: iterateCollection(src), index: -1}})
+		case opNext:
+			it := env.slot(int(in.a)).iteration()
+			item := it.next()
+			if item.IsZero() {
+				pc = int(in.b) - 1
+				break
+			}
+			it.index++
+			stack = append(stack, item)
+		case opIterIndex:
+			it := env.slot(int(in.a)).iteration()
+			stack = append(stack, NewInt(int64(it.index)))
+
+		default:
+			err = fmt.Errorf("Unknown instruction")
+		}
+
+		if err != nil {
+			return Value{}, locateLine(err, int(in.line))
+		}
+		if s != nil && len(stack) > 0 {
+			if err := s.checkSize(stack[len(stack)-1]); err != nil {
+				return Value{}, locateLine(err, int(in.line))
+			}
+		}
+	}
+
+	return Value{}, nil
+}
+
+// declareSlot declares a local, checking it against its annotated type
+func declareSlot(env *Environment, slot int, ident *Node, val Value) error {
+	if t := env.layout.types[slot]; t != nil {
+		var err error
+		if val, err = conformVar(env.layout.names[slot], t, val, ident.Line); err != nil {
+			return err
+		}
+	}
+	env.setSlot(slot, val)
+	return nil
+}
+
+// assignSlot assigns a value to a local, or to the identifier by name if the local isn't declared
+func assignSlot(env *Environment, slot int, ident *Node, val Value) error {
+	if env.slot(slot).IsZero() {
+		return assignName(ident, val, env)
+	}
+	return env.assign(slot, ident, val)
+}
+
+// assignName assigns a value to an identifier, looked up by name
+func assignName(ident *Node, val Value, env *Environment) error {
+	assign, err := getAssignmentTarget(ident, env, false)
+	if err != nil {
+		return err
+	}
+	return assign(val)
+}
+
+// passes checks the value on the left of a compound expression, before its callee is evaluated
+func passes(val Value, guard int) bool {
+	switch guard {
+	case guardCollection:
+		return val.Type == ListDT || val.Type == SetDT
+	case guardFoldable:
+		return val.Type == ListDT || val.Type == SetDT || val.Type == ObjectDT
+	case guardSliceable:
+		return val.Type == ListDT || val.Type == StringDT
+	}
+	return val.Type != FailDT
+}
+
+// binaryOp applies a binary operator to two values
+func binaryOp(op NodeType, lhs, rhs Value) (Value, error) {
+	switch op {
+	case AddNT, SubtNT, DivNT, MultNT, ModuloNT:
+		return mathOp(op, lhs, rhs)
+	case PowerNT:
+		return power(lhs, rhs), nil
+	case LogicAndNT, LogicOrNT, FallbackNT:
+		return logicOp(op, lhs, rhs)
+	case InNT:
+		return contains(rhs, lhs), nil
+	}
+	return compare(op, lhs, rhs), nil
+}
+
+// compound applies an operator like map, which calls a lambda with the value on its left
+func compound(op NodeType, lhs Value, callee *Node, lambda Value, env *Environment) (Value, error) {
+	switch op {
+	case MapNT:
+		return mapItems(lhs, callee, lambda, env)
+	case WhereNT:
+		return whereItems(lhs, callee, lambda, env)
+	case PipeNT:
+		return pipeTo(lhs, callee, lambda, env)
+	case BindNT:
+		return bindTo(lhs, callee, lambda, env)
+	case FindNT:
+		return findItem(lhs, callee, lambda, env)
+	}
+	return Value{}, fmt.Errorf("Unknown operator")
+}
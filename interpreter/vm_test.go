@@ -0,0 +1,143 @@
+package interpreter
+
+import (
+	"testing"
+)
+
+func runVM(src string, t *testing.T) (Value, error) {
+	ast, err := scanAndParse(src)
+	if err != nil {
+		t.Fatalf(`Failed to parse "%s": %s`, src, err.Error())
+	}
+	return Run(Compile(ast), &Environment{
+		Parent: &Environment{Consts: StdLib},
+		Consts: map[string]Value{},
+		Vars:   map[string]Value{},
+	})
+}
+
+func TestVM(t *testing.T) {
+	tests := []struct {
+		input, result string
+	}{
+		// locals of a loop's body are declared again each iteration
+		{`
+			var seen := []
+			for i in ..2 {
+				x := i + 10
+				seen += [x]
+			}
+			seen`, `[10, 11]`},
+		// lambdas returned from a call keep its locals
+		{`
+			counter := () => {
+				var c := 0
+				() => {
+					c = c + 1
+					c
+				}
+			}
+			inc := counter()
+			inc()
+			inc()`, `2`},
+		// destructured parameters and declarations
+		{`
+			f := ([a, b], {c}) => {
+				(d, e) := (a + b, c)
+				d * e
+			}
+			f([1, 2], {c: 3})`, `9`},
+		// code the compiler doesn't handle itself falls back to the interpreter
+		{`
+			f := n => match n {
+				0 => "zero"
+				_ => [x * 2 for x <- ..n]
+			}
+			[f(0), f(3)]`, `["zero", [0, 2, 4]]`},
+		// frames whose locals are used by tasks and closures called by tasks are shared with them
+		{`
+			f := () => {
+				var n := 0
+				t := spawn {
+					for i in ..1000: n = n + 1
+					"done"
+				}
+				for i in ..1000: n = 0
+				await t
+			}
+			f()`, `"done"`},
+		{`
+			f := () => {
+				var n := 0
+				inc := () => {
+					n = n + 1
+				}
+				start := g => spawn {
+					for i in ..1000: g()
+					"done"
+				}
+				t := start(inc)
+				for i in ..1000: n = 0
+				await t
+			}
+			f()`, `"done"`},
+	}
+
+	for _, test := range tests {
+		res, err := runVM(test.input, t)
+		if err != nil {
+			t.Fatalf(`Failed to run "%s": %s`, test.input, err.Error())
+		}
+		if res.ToString() != test.result {
+			t.Fatalf(`Ran "%s" incorrectly. Expected %s, received %s`, test.input, test.result, res.ToString())
+		}
+	}
+
+	errTests := []struct {
+		input, err string
+	}{
+		{`
+			f := () => {
+				x := 1
+				x = 2
+			}
+			f()`, `Line 4: Cannot assign to constant variable "x"`},
+		{`
+			f := () => {
+				y := 1
+				y + z
+			}
+			f()`, `Line 4: "z" is undefined`},
+	}
+
+	for _, test := range errTests {
+		_, err := runVM(test.input, t)
+		if err == nil || err.Error() != test.err {
+			t.Fatalf(`Expected "%s" to raise %s, received %v`, test.input, test.err, err)
+		}
+	}
+}
+
+const benchSrc = `
+	fib := n => n if n < 2 else fib(n - 1) + fib(n - 2)
+	var hits := 0
+	for i in ..5000 {
+		x := i % 7
+		if x < 3: hits += 1
+	}
+	[fib(15), hits, #(1..5000 map _ * 2 where _ % 3 == 0)]
+`
+
+func BenchmarkInterpret(b *testing.B) {
+	ast, _ := scanAndParse(benchSrc)
+	for i := 0; i < b.N; i++ {
+		Interpret(ast, &Environment{Parent: &Environment{Consts: StdLib}})
+	}
+}
+
+func BenchmarkVM(b *testing.B) {
+	ast, _ := scanAndParse(benchSrc)
+	for i := 0; i < b.N; i++ {
+		Run(Compile(ast), &Environment{Parent: &Environment{Consts: StdLib}})
+	}
+}
@@ -2,24 +2,51 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
 
 	"github.com/jheredos/rye/interpreter"
+	"github.com/jheredos/rye/rye"
 )
 
 func main() {
-	if len(os.Args) > 2 {
+	// --vm runs code on the bytecode VM rather than the tree-walking interpreter, and
+	// --max-depth=N changes how deeply function calls may be nested
+	var opts []rye.Option
+	args := os.Args[:1]
+	for _, arg := range os.Args[1:] {
+		if arg == "--vm" {
+			opts = append(opts, rye.WithVM())
+		} else if strings.HasPrefix(arg, "--max-depth=") {
+			depth, err := strconv.Atoi(strings.TrimPrefix(arg, "--max-depth="))
+			if err != nil || depth < 1 {
+				fmt.Printf("Invalid maximum depth \"%s\"\n", strings.TrimPrefix(arg, "--max-depth="))
+				os.Exit(1)
+			}
+			opts = append(opts, rye.WithMaxDepth(depth))
+		} else {
+			args = append(args, arg)
+		}
+	}
+
+	if len(args) == 3 && args[1] == "check" {
+		checkFile(args[2])
+	} else if len(args) > 2 {
 		os.Exit(1)
-	} else if len(os.Args) == 2 {
-		runFile(os.Args[1])
+	} else if len(args) == 2 {
+		runFile(args[1], opts...)
 	} else {
-		runPrompt()
+		runPrompt(opts...)
 	}
 }
 
-func runFile(path string) {
+func runFile(path string, opts ...rye.Option) {
 	file, err := os.ReadFile(path) // read file
 	if err != nil {
 		panic(err)
@@ -34,75 +61,126 @@ func runFile(path string) {
 		return
 	}
 
-	// scan...
-	ts := interpreter.Scan(string(file))
-	// for _, t := range ts {
-	// 	fmt.Println(t.ToString())
-	// }
-
-	root, err := interpreter.Parse(ts)
+	rt, err := rye.New(opts...)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	_, err = rt.Eval(string(file))
+	if err != nil {
+		printError(err, string(file))
+		return
+	}
+}
 
-	// execute...
-	env := &interpreter.Environment{
-		Parent: &interpreter.Environment{
-			// env above the "top-level" for imports
-			Consts: interpreter.StdLib,
-		},
-		Consts: map[string]*interpreter.Node{},
-		Vars:   map[string]*interpreter.Node{},
+// checkFile reports type errors in a file without running it
+func checkFile(path string) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
 	}
-	_, err = interpreter.Interpret(root, env)
+
+	ts, err := interpreter.Scan(string(file))
 	if err != nil {
+		printSyntaxError(err, string(file))
+		os.Exit(1)
+	}
+	root, err := interpreter.Parse(ts)
+	if err != nil {
+		printSyntaxError(err, string(file))
+		os.Exit(1)
+	}
+	if err := interpreter.Resolve(root); err != nil {
+		printSyntaxError(err, string(file))
+		os.Exit(1)
+	}
+
+	errs := interpreter.Check(root)
+	for _, err := range errs {
 		fmt.Println(err)
-		return
+	}
+	if len(errs) > 0 {
+		os.Exit(1)
 	}
 }
 
-func runPrompt() {
+func runPrompt(opts ...rye.Option) {
 	reader := bufio.NewReader(os.Stdin)
-	env := &interpreter.Environment{
-		Parent: &interpreter.Environment{
-			// env above the "top-level" for imports
-			Consts: interpreter.StdLib,
-		},
-		Consts: map[string]*interpreter.Node{},
-		Vars:   map[string]*interpreter.Node{},
+	rt, err := rye.New(opts...)
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
 
 	for {
 		fmt.Print("> ")
 		inp, err := reader.ReadString('\n') // read line
-
-		// scan...
-		ts := interpreter.Scan(inp)
-		if err != nil {
-			fmt.Println(err)
-			continue
+		if err == io.EOF && inp == "" {
+			fmt.Println()
+			return
 		}
-		// for _, t := range ts {
-		// 	fmt.Println(t.ToString())
-		// }
 
-		// parse...
-		root, err := interpreter.Parse(ts)
+		res, err := evalInterruptible(rt, inp)
 		if err != nil {
-			fmt.Printf("Error: %s\n", err.Error())
+			printError(err, inp)
 			continue
 		}
-		if root == nil {
-			continue
+		if !res.IsZero() {
+			fmt.Println(res)
 		}
+	}
+}
 
-		// execute...
-		res, err := interpreter.Interpret(root, env)
-		if err != nil {
-			fmt.Println(err)
-			continue
+// evalInterruptible evaluates a line of the REPL. Ctrl-C stops it, rather than the REPL, until
+// it's done. Tasks it spawns may outlive it, so its context is only cancelled by Ctrl-C.
+func evalInterruptible(rt *rye.Runtime, inp string) (rye.Value, error) {
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-done:
 		}
-		fmt.Println(interpreter.Display(res))
+	}()
+
+	return rt.EvalContext(ctx, inp)
+}
+
+// printError shows syntax errors with the line of source they occurred on, and runtime errors with
+// their stack trace
+func printError(err error, src string) {
+	var re *interpreter.RuntimeError
+	if errors.As(err, &re) {
+		fmt.Println(re.Traceback())
+		return
+	}
+	printSyntaxError(err, src)
+}
+
+// printSyntaxError shows scanning, parsing and resolving errors with the line of source they
+// occurred on
+func printSyntaxError(err error, src string) {
+	var se interpreter.ScanErrors
+	var pe *interpreter.ParseError
+	var re interpreter.ResolveErrors
+	if errors.As(err, &se) {
+		fmt.Println(se.Render(src))
+		return
+	}
+	if errors.As(err, &re) {
+		fmt.Println(re.Render(src))
+		return
+	}
+	if errors.As(err, &pe) {
+		fmt.Println(pe.Render(src))
+		return
 	}
+	fmt.Printf("Error: %s\n", err.Error())
 }
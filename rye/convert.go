@@ -0,0 +1,235 @@
+package rye
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/jheredos/rye/interpreter"
+)
+
+// Value is a value produced by Rye code. Values can be passed back to Rye unchanged, or
+// converted to Go values with Export.
+type Value struct {
+	v interpreter.Value
+	r *Runtime // the Runtime the value came from
+}
+
+// Result is Rye's success or fail
+type Result bool
+
+// Result values
+const (
+	Success Result = true
+	Fail    Result = false
+)
+
+// Tuple is a Rye tuple, as opposed to a list
+type Tuple []interface{}
+
+// Func is a Go function that can be called from Rye, or a Rye function exported to Go
+type Func func(args ...Value) (Value, error)
+
+// IsZero reports whether a Value is the zero Value, e.g. the result of evaluating a comment
+func (v Value) IsZero() bool {
+	return v.v.IsZero()
+}
+
+// Kind gives the name of a value's type, as returned by typeof
+func (v Value) Kind() string {
+	return v.v.Kind()
+}
+
+// String formats a value as the REPL displays it
+func (v Value) String() string {
+	if v.v.IsZero() {
+		return ""
+	}
+	return interpreter.Display(v.v)
+}
+
+// Export converts a value to Go:
+//   - Int, Float, String and Bool give int64, float64, string and bool
+//   - null gives nil, and success and fail give Success and Fail
+//   - List and Set give []interface{}, and Tuple gives a Tuple
+//   - Object gives map[string]interface{}, with any keys that aren't strings as they're displayed
+//   - Lambda gives a Func, which calls it in the Runtime it came from
+//
+// Other values, such as tasks, can't be converted and give the Value itself.
+func (v Value) Export() interface{} {
+	val, r := v.v, v.r
+	switch val.Type {
+	case interpreter.InvalidDT, interpreter.NullDT:
+		return nil
+	case interpreter.IntDT:
+		return val.Int()
+	case interpreter.FloatDT:
+		return val.Float()
+	case interpreter.StringDT:
+		return val.Str()
+	case interpreter.BoolDT:
+		return val.Bool()
+	case interpreter.SuccessDT:
+		return Success
+	case interpreter.FailDT:
+		return Fail
+	case interpreter.ListDT:
+		res := []interface{}{}
+		for _, item := range val.List().Items() {
+			res = append(res, Value{item, r}.Export())
+		}
+		return res
+	case interpreter.TupleDT:
+		res := Tuple{}
+		for _, item := range val.Tuple() {
+			res = append(res, Value{item, r}.Export())
+		}
+		return res
+	case interpreter.SetDT:
+		res := []interface{}{}
+		for _, item := range val.Set().Members() {
+			res = append(res, Value{item, r}.Export())
+		}
+		return res
+	case interpreter.ObjectDT:
+		res := map[string]interface{}{}
+		for _, f := range val.Object().Fields() {
+			if f.Key.Type == interpreter.StringDT {
+				res[f.Key.Str()] = Value{f.Val, r}.Export()
+			} else {
+				res[interpreter.Display(f.Key)] = Value{f.Val, r}.Export()
+			}
+		}
+		return res
+	case interpreter.LambdaDT:
+		return Func(func(args ...Value) (Value, error) {
+			vals := make([]interface{}, len(args))
+			for i, arg := range args {
+				vals[i] = arg
+			}
+			return r.call(context.Background(), val, "", vals...)
+		})
+	default:
+		return v
+	}
+}
+
+// toValue converts a Go value to Rye, the reverse of Export. Slices and arrays become lists, maps
+// and structs become objects, and functions are wrapped as with RegisterFunc, apart from a Func,
+// whose errors are raised.
+func toValue(r *Runtime, val interface{}) (interpreter.Value, error) {
+	switch v := val.(type) {
+	case nil:
+		return interpreter.NULL, nil
+	case Value:
+		if v.v.IsZero() {
+			return interpreter.NULL, nil
+		}
+		return v.v, nil
+	case Result:
+		if v {
+			return interpreter.SUCCESS, nil
+		}
+		return interpreter.FAIL, nil
+	case Tuple:
+		tuple := interpreter.Tuple{}
+		for _, item := range v {
+			n, err := toValue(r, item)
+			if err != nil {
+				return interpreter.Value{}, err
+			}
+			tuple = append(tuple, n)
+		}
+		return interpreter.NewTuple(tuple), nil
+	case Func:
+		return interpreter.NewFunc(func(_ *interpreter.Environment, args ...interpreter.Value) (interpreter.Value, error) {
+			vals := make([]Value, len(args))
+			for i, arg := range args {
+				vals[i] = Value{arg, r}
+			}
+			res, err := v(vals...)
+			if err != nil {
+				return interpreter.Value{}, err
+			}
+			return toValue(r, res)
+		}), nil
+	case func(args ...Value) (Value, error):
+		return toValue(r, Func(v))
+	}
+
+	rv := reflect.ValueOf(val)
+	switch rv.Kind() {
+	case reflect.Bool:
+		return interpreter.NewBool(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return interpreter.NewInt(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return interpreter.Value{}, fmt.Errorf("Cannot convert %d to Rye. It's out of range for Int.", rv.Uint())
+		}
+		return interpreter.NewInt(int64(rv.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return interpreter.NewFloat(rv.Float()), nil
+	case reflect.String:
+		return interpreter.NewString(rv.String()), nil
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return interpreter.NULL, nil
+		}
+		return toValue(r, rv.Elem().Interface())
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return interpreter.NULL, nil
+		}
+		list := interpreter.List{}
+		for i := 0; i < rv.Len(); i++ {
+			n, err := toValue(r, rv.Index(i).Interface())
+			if err != nil {
+				return interpreter.Value{}, err
+			}
+			list = list.Append(n)
+		}
+		return interpreter.NewList(list), nil
+	case reflect.Map:
+		if rv.IsNil() {
+			return interpreter.NULL, nil
+		}
+		obj := interpreter.Object{}
+		for _, k := range rv.MapKeys() {
+			key, err := toValue(r, k.Interface())
+			if err != nil {
+				return interpreter.Value{}, err
+			}
+			switch key.Type {
+			case interpreter.NullDT, interpreter.LambdaDT:
+				return interpreter.Value{}, fmt.Errorf("Cannot use a value of type %T as an Object key", k.Interface())
+			}
+			item, err := toValue(r, rv.MapIndex(k).Interface())
+			if err != nil {
+				return interpreter.Value{}, err
+			}
+			obj = obj.Put(key, item)
+		}
+		return interpreter.NewObject(obj), nil
+	case reflect.Struct:
+		obj := interpreter.Object{}
+		rt := rv.Type()
+		for i := 0; i < rt.NumField(); i++ {
+			name, ok := fieldName(rt.Field(i))
+			if !ok {
+				continue
+			}
+			item, err := toValue(r, rv.Field(i).Interface())
+			if err != nil {
+				return interpreter.Value{}, err
+			}
+			obj = obj.Put(interpreter.NewString(name), item)
+		}
+		return interpreter.NewObject(obj), nil
+	case reflect.Func:
+		return wrapFunc(r, "", rv)
+	}
+
+	return interpreter.Value{}, fmt.Errorf("Cannot convert a value of type %T to Rye", val)
+}
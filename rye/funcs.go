@@ -0,0 +1,318 @@
+package rye
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/jheredos/rye/interpreter"
+)
+
+var (
+	valueType  = reflect.TypeOf(Value{})
+	resultType = reflect.TypeOf(Success)
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// RegisterFunc declares a Go function in the top-level scope, so that it can be called from Rye
+// like a built-in function. Its arguments are converted to the types of the function's
+// parameters, and calls with the wrong number or types of arguments raise an error.
+//
+// The function may return nothing, which gives success, or a single value. It may also return a
+// value along with a bool or an error, in which case false or a non-nil error gives fail. A
+// function returning just an error gives success or fail.
+func (r *Runtime) RegisterFunc(name string, fn interface{}) error {
+	v, err := wrapFunc(r, name, reflect.ValueOf(fn))
+	if err != nil {
+		return err
+	}
+
+	r.env.Define(name, v)
+	return nil
+}
+
+// wrapFunc makes a Go function into a Rye built-in function. Anonymous functions are named
+// "<go>" in error messages.
+func wrapFunc(r *Runtime, name string, fn reflect.Value) (interpreter.Value, error) {
+	if fn.Kind() != reflect.Func || fn.IsNil() {
+		return interpreter.Value{}, fmt.Errorf("Cannot register \"%s\". Expected a function, received %s.", name, describe(fn))
+	}
+
+	ft := fn.Type()
+	switch ft.NumOut() {
+	case 0, 1:
+	case 2:
+		if last := ft.Out(1); last.Kind() != reflect.Bool && last != errorType {
+			return interpreter.Value{}, fmt.Errorf("Cannot register \"%s\". The second value it returns must be a bool or an error.", name)
+		}
+	default:
+		return interpreter.Value{}, fmt.Errorf("Cannot register \"%s\". Functions may return at most 2 values.", name)
+	}
+
+	if name == "" {
+		name = "<go>"
+	}
+	params := ft.NumIn()
+	if ft.IsVariadic() {
+		params--
+	}
+
+	return interpreter.NewFunc(func(_ *interpreter.Environment, args ...interpreter.Value) (res interpreter.Value, err error) {
+		if len(args) < params || (!ft.IsVariadic() && len(args) > params) {
+			if ft.IsVariadic() {
+				return interpreter.Value{}, fmt.Errorf("Wrong number of arguments for \"%s\". Expected at least %d, received %d.", name, params, len(args))
+			}
+			return interpreter.Value{}, fmt.Errorf("Wrong number of arguments for \"%s\". Expected %d, received %d.", name, params, len(args))
+		}
+
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			var t reflect.Type
+			if i < params {
+				t = ft.In(i)
+			} else {
+				t = ft.In(params).Elem()
+			}
+
+			val, ok := fromValue(r, arg, t)
+			if !ok && arg.Type == interpreter.IntDT && kindOf(t) == "Int" {
+				return interpreter.Value{}, fmt.Errorf("Argument %d of function \"%s\" is out of range for %s.", i+1, name, t)
+			}
+			if !ok {
+				return interpreter.Value{}, fmt.Errorf("Type mismatch for argument %d of function \"%s\". Expected %s, received %s.", i+1, name, kindOf(t), Value{arg, r}.Kind())
+			}
+			in[i] = val
+		}
+
+		defer func() {
+			if p := recover(); p != nil {
+				res, err = interpreter.Value{}, fmt.Errorf("Function \"%s\" panicked: %v", name, p)
+			}
+		}()
+		out := fn.Call(in)
+
+		if len(out) == 2 {
+			// the second value is a bool, of any named bool type, or an error
+			switch last := out[1]; {
+			case last.Kind() == reflect.Bool:
+				if !last.Bool() {
+					return interpreter.FAIL, nil
+				}
+			case !last.IsNil():
+				return interpreter.FAIL, nil
+			}
+		}
+
+		switch {
+		case len(out) == 0:
+			return interpreter.SUCCESS, nil
+		case len(out) == 1 && ft.Out(0) == errorType:
+			if !out[0].IsNil() {
+				return interpreter.FAIL, nil
+			}
+			return interpreter.SUCCESS, nil
+		}
+		return toValue(r, out[0].Interface())
+	}), nil
+}
+
+// fromValue converts a Rye value to a Go type, reporting false if it can't be. Ints may be used
+// where floats are expected.
+func fromValue(r *Runtime, n interpreter.Value, t reflect.Type) (reflect.Value, bool) {
+	switch t {
+	case valueType:
+		return reflect.ValueOf(Value{n, r}), true
+	case resultType:
+		switch n.Type {
+		case interpreter.SuccessDT:
+			return reflect.ValueOf(Success), true
+		case interpreter.FailDT:
+			return reflect.ValueOf(Fail), true
+		}
+		return reflect.Value{}, false
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		val := Value{n, r}.Export()
+		if val == nil {
+			return reflect.Zero(t), true
+		}
+		if !reflect.TypeOf(val).Implements(t) {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(val).Convert(t), true
+	case reflect.Bool:
+		if n.Type != interpreter.BoolDT {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(n.Bool()).Convert(t), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n.Type != interpreter.IntDT {
+			return reflect.Value{}, false
+		}
+		val := reflect.New(t).Elem()
+		if val.OverflowInt(n.Int()) {
+			return reflect.Value{}, false
+		}
+		val.SetInt(n.Int())
+		return val, true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n.Type != interpreter.IntDT || n.Int() < 0 {
+			return reflect.Value{}, false
+		}
+		val := reflect.New(t).Elem()
+		if val.OverflowUint(uint64(n.Int())) {
+			return reflect.Value{}, false
+		}
+		val.SetUint(uint64(n.Int()))
+		return val, true
+	case reflect.Float32, reflect.Float64:
+		val := reflect.New(t).Elem()
+		switch n.Type {
+		case interpreter.FloatDT:
+			val.SetFloat(n.Float())
+		case interpreter.IntDT:
+			val.SetFloat(float64(n.Int()))
+		default:
+			return reflect.Value{}, false
+		}
+		return val, true
+	case reflect.String:
+		if n.Type != interpreter.StringDT {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(n.Str()).Convert(t), true
+	case reflect.Ptr:
+		if n.Type == interpreter.NullDT {
+			return reflect.Zero(t), true
+		}
+		elem, ok := fromValue(r, n, t.Elem())
+		if !ok {
+			return reflect.Value{}, false
+		}
+		ptr := reflect.New(t.Elem())
+		ptr.Elem().Set(elem)
+		return ptr, true
+	case reflect.Slice:
+		var items []interpreter.Value
+		switch n.Type {
+		case interpreter.ListDT:
+			items = n.List().Items()
+		case interpreter.TupleDT:
+			items = n.Tuple()
+		case interpreter.SetDT:
+			items = n.Set().Members()
+		default:
+			return reflect.Value{}, false
+		}
+		slice := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			val, ok := fromValue(r, item, t.Elem())
+			if !ok {
+				return reflect.Value{}, false
+			}
+			slice.Index(i).Set(val)
+		}
+		return slice, true
+	case reflect.Map:
+		if n.Type != interpreter.ObjectDT {
+			return reflect.Value{}, false
+		}
+		m := reflect.MakeMap(t)
+		for _, f := range n.Object().Fields() {
+			key, ok := fromValue(r, f.Key, t.Key())
+			if !ok || (key.Kind() == reflect.Interface && !key.IsNil() && !key.Elem().Type().Comparable()) {
+				// collections can't be the keys of maps in Go
+				return reflect.Value{}, false
+			}
+			val, ok := fromValue(r, f.Val, t.Elem())
+			if !ok {
+				return reflect.Value{}, false
+			}
+			m.SetMapIndex(key, val)
+		}
+		return m, true
+	case reflect.Struct:
+		if n.Type != interpreter.ObjectDT {
+			return reflect.Value{}, false
+		}
+		s := reflect.New(t).Elem()
+		obj := n.Object()
+		for i := 0; i < t.NumField(); i++ {
+			name, ok := fieldName(t.Field(i))
+			if !ok {
+				continue
+			}
+			// missing fields are left as zero values
+			item, exists := obj.Get(interpreter.NewString(name))
+			if !exists {
+				continue
+			}
+			val, ok := fromValue(r, item, t.Field(i).Type)
+			if !ok {
+				return reflect.Value{}, false
+			}
+			s.Field(i).Set(val)
+		}
+		return s, true
+	case reflect.Func:
+		if n.Type != interpreter.LambdaDT || t != reflect.TypeOf(Func(nil)) {
+			return reflect.Value{}, false
+		}
+		return reflect.ValueOf(Value{n, r}.Export()), true
+	}
+
+	return reflect.Value{}, false
+}
+
+// fieldName gives the name of an exported struct field in Rye, which may be set with a `rye` tag.
+// Fields tagged `rye:"-"` are skipped.
+func fieldName(field reflect.StructField) (string, bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	if tag, ok := field.Tag.Lookup("rye"); ok {
+		return tag, tag != "-"
+	}
+	return field.Name, true
+}
+
+// kindOf gives the name of the Rye type a Go type is converted from
+func kindOf(t reflect.Type) string {
+	switch t {
+	case valueType:
+		return "Any"
+	case resultType:
+		return "Result"
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return "Any"
+	case reflect.Bool:
+		return "Bool"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return "Int"
+	case reflect.Float32, reflect.Float64:
+		return "Float"
+	case reflect.String:
+		return "String"
+	case reflect.Ptr:
+		return kindOf(t.Elem()) + " | Null"
+	case reflect.Slice:
+		return "List[" + kindOf(t.Elem()) + "]"
+	case reflect.Map, reflect.Struct:
+		return "Object"
+	case reflect.Func:
+		return "Lambda"
+	}
+	return t.String()
+}
+
+func describe(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	return v.Type().String()
+}
@@ -0,0 +1,158 @@
+package rye
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+type found bool
+
+type order struct {
+	ID    int
+	Items []string `rye:"items"`
+	Total float64  `rye:"total"`
+}
+
+func TestRegisterFunc(t *testing.T) {
+	r := newRuntime(t)
+
+	funcs := map[string]interface{}{
+		"repeat": strings.Repeat,
+		"half":   func(x float64) float64 { return x / 2 },
+		"join":   func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+		"parse": func(s string) (int, error) {
+			n, err := strconv.Atoi(s)
+			return n, err
+		},
+		"lookup": func(m map[string]int, k string) (int, bool) {
+			v, ok := m[k]
+			return v, ok
+		},
+		"positive": func(n int) (int, found) { return n, n > 0 },
+		"check": func(n int) error {
+			if n < 0 {
+				return errors.New("negative")
+			}
+			return nil
+		},
+		"summary": func(o order) string {
+			return strconv.Itoa(o.ID) + ": " + strings.Join(o.Items, ", ")
+		},
+		"first": func(o *order) interface{} {
+			if o == nil || len(o.Items) == 0 {
+				return nil
+			}
+			return o.Items[0]
+		},
+		"noop": func() {},
+		"sum": func(xs []int) (total int) {
+			for _, x := range xs {
+				total += x
+			}
+			return
+		},
+		"typeOf": func(v Value) string { return v.Kind() },
+		"apply":  func(f Func, arg Value) (Value, error) { return f(arg) },
+		"boom":   func() int { panic("oh no") },
+		"order":  func(id int) order { return order{ID: id, Items: []string{"tea"}, Total: 2.5} },
+		"small":  func(n int8) int8 { return n },
+		"any":    func(v interface{}) interface{} { return v },
+	}
+	for name, fn := range funcs {
+		if err := r.RegisterFunc(name, fn); err != nil {
+			t.Fatalf(`Failed to register "%s": %s`, name, err.Error())
+		}
+	}
+
+	tests := []struct {
+		input, display string
+	}{
+		{`repeat("ab", 3)`, `"ababab"`},
+		{`half(3)`, `1.5`},
+		{`join("-")`, `""`},
+		{`join("-", "a", "b", "c")`, `"a-b-c"`},
+		{`parse("42") + 1`, `43`},
+		{`parse("x")`, `fail`},
+		{`parse("x") | 0`, `0`},
+		{`lookup({a: 1}, "a")`, `1`},
+		{`lookup({a: 1}, "b")`, `fail`},
+		{`[positive(1), positive(-1)]`, `[1, fail]`},
+		{`[check(1), check(-1)]`, `[success, fail]`},
+		{`summary({ID: 7, items: ["tea", "cake"]})`, `"7: tea, cake"`},
+		{`[first({items: ["x"]}), first(null)]`, `["x", null]`},
+		{`noop()`, `success`},
+		{`sum([1, 2, 3])`, `6`},
+		{`sum((1, 2))`, `3`},
+		{`[typeOf(1), typeOf([]), typeOf(fail)]`, `["Int", "List", "Result"]`},
+		{`apply(x => x * 2, 21)`, `42`},
+		{`order(1).total + order(2).ID`, `4.5`},
+		{`any({a: [1, (2, "b")]})`, `{"a": [1, (2, "b")]}`},
+		{`[1, 2] map half`, `[0.5, 1]`},
+	}
+
+	for _, test := range tests {
+		v, err := r.Eval(test.input)
+		if err != nil {
+			t.Fatalf(`Failed to evaluate "%s": %s`, test.input, err.Error())
+		}
+		if v.String() != test.display {
+			t.Fatalf(`Evaluated "%s" incorrectly. Expected %s, received %s`, test.input, test.display, v)
+		}
+	}
+
+	errs := []struct {
+		input, err string
+	}{
+		{`repeat("ab")`, `Line 1: Wrong number of arguments for "repeat". Expected 2, received 1.`},
+		{`noop(1)`, `Line 1: Wrong number of arguments for "noop". Expected 0, received 1.`},
+		{`join()`, `Line 1: Wrong number of arguments for "join". Expected at least 1, received 0.`},
+		{`half("a")`, `Line 1: Type mismatch for argument 1 of function "half". Expected Float, received String.`},
+		{`join(",", "a", 1)`, `Line 1: Type mismatch for argument 3 of function "join". Expected String, received Int.`},
+		{`sum([1, "a"])`, `Line 1: Type mismatch for argument 1 of function "sum". Expected List[Int], received List.`},
+		{`first(1)`, `Line 1: Type mismatch for argument 1 of function "first". Expected Object | Null, received Int.`},
+		{`small(1000)`, `Line 1: Argument 1 of function "small" is out of range for int8.`},
+		{`boom()`, `Line 1: Function "boom" panicked: oh no`},
+	}
+
+	for _, test := range errs {
+		_, err := r.Eval(test.input)
+		if err == nil || err.Error() != test.err {
+			t.Fatalf(`Expected "%s" to raise %s, received %v`, test.input, test.err, err)
+		}
+	}
+}
+
+func TestRegisterFuncErrors(t *testing.T) {
+	r := newRuntime(t)
+
+	tests := []struct {
+		fn  interface{}
+		err string
+	}{
+		{42, `Cannot register "f". Expected a function, received int.`},
+		{nil, `Cannot register "f". Expected a function, received nil.`},
+		{func() (int, string) { return 0, "" }, `Cannot register "f". The second value it returns must be a bool or an error.`},
+		{func() (int, int, error) { return 0, 0, nil }, `Cannot register "f". Functions may return at most 2 values.`},
+	}
+
+	for _, test := range tests {
+		err := r.RegisterFunc("f", test.fn)
+		if err == nil || err.Error() != test.err {
+			t.Fatalf(`Expected %s, received %v`, test.err, err)
+		}
+	}
+
+	// functions set as values are wrapped too
+	if err := r.Set("double", func(n int) int { return n * 2 }); err != nil {
+		t.Fatal(err)
+	}
+	v, err := r.Eval(`double(4)`)
+	if err != nil || v.String() != `8` {
+		t.Fatalf(`Expected 8, received %v (%v)`, v, err)
+	}
+	if _, err := r.Eval(`double()`); err == nil || err.Error() != `Line 1: Wrong number of arguments for "<go>". Expected 1, received 0.` {
+		t.Fatalf(`Expected an argument error, received %v`, err)
+	}
+}
@@ -0,0 +1,254 @@
+// Package rye embeds the Rye interpreter in Go programs, e.g. to use Rye as a rules or
+// configuration language. Values are converted between Go and Rye automatically.
+package rye
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/jheredos/rye/interpreter"
+)
+
+// Runtime runs Rye code in a top-level scope that persists between calls, like the REPL. A
+// Runtime may only be used by one goroutine at a time, although the Rye code it runs may spawn
+// tasks of its own.
+type Runtime struct {
+	env     *interpreter.Environment
+	sandbox *Sandbox
+	vm      bool  // whether code is compiled and run on the VM, rather than the tree-walker
+	running int32 // calls in progress, including calls made from Go functions
+}
+
+// Option configures a Runtime
+type Option func(*Runtime) error
+
+// WithGlobals declares constants in the top-level scope of a Runtime
+func WithGlobals(globals map[string]interface{}) Option {
+	return func(r *Runtime) error {
+		for name, val := range globals {
+			if err := r.Set(name, val); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Sandbox restricts what code run by a Runtime may do, e.g. when running rules written by users.
+// Limits that are exceeded raise an *interpreter.LimitError, except for Depth, which lowers the
+// maximum depth set with WithMaxDepth and so raises an *interpreter.StackOverflowError.
+type Sandbox struct {
+	interpreter.Limits
+	Builtins []string      // the built-in functions that may be used, or all of them if nil
+	Timeout  time.Duration // how long each call to Eval, RunFile or Call (and tasks it spawns) may run for, if not zero
+}
+
+// WithSandbox runs all code in a Runtime with restrictions
+func WithSandbox(s Sandbox) Option {
+	return func(r *Runtime) error {
+		if s.Builtins != nil {
+			builtins := r.env.Parent.Consts
+			allowed := make(map[string]interpreter.Value, len(s.Builtins))
+			for _, name := range s.Builtins {
+				fn, ok := builtins[name]
+				if !ok {
+					return fmt.Errorf("\"%s\" is not a built-in function", name)
+				}
+				allowed[name] = fn
+			}
+			r.env.Parent.Consts = allowed
+		}
+
+		r.sandbox = &s
+		return nil
+	}
+}
+
+// WithMaxDepth changes how deeply function calls may be nested in a Runtime, from
+// interpreter.DefaultMaxDepth. Calls nested deeper raise an *interpreter.StackOverflowError.
+func WithMaxDepth(depth int) Option {
+	return func(r *Runtime) error {
+		if depth < 1 {
+			return fmt.Errorf("Invalid maximum depth %d", depth)
+		}
+		r.env.SetMaxDepth(depth)
+		return nil
+	}
+}
+
+// WithVM runs code in a Runtime on the bytecode VM, which is faster than the tree-walking
+// interpreter for loops and function calls
+func WithVM() Option {
+	return func(r *Runtime) error {
+		r.vm = true
+		return nil
+	}
+}
+
+// New creates a Runtime with the built-in functions available
+func New(opts ...Option) (*Runtime, error) {
+	// each runtime has a copy of the built-in functions, since imported modules are declared
+	// alongside them
+	builtins := make(map[string]interpreter.Value, len(interpreter.StdLib))
+	for name, fn := range interpreter.StdLib {
+		builtins[name] = fn
+	}
+
+	r := &Runtime{
+		env: &interpreter.Environment{
+			Parent: &interpreter.Environment{Consts: builtins},
+			Consts: map[string]interpreter.Value{},
+			Vars:   map[string]interpreter.Value{},
+		},
+	}
+
+	for _, opt := range opts {
+		if err := opt(r); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Eval runs Rye source, returning the value of its last statement. Source with no statements
+// gives the zero Value. Syntax errors are returned as an interpreter.ScanErrors or
+// *interpreter.ParseError, misused variables as an interpreter.ResolveErrors, and errors raised
+// while running as an *interpreter.RuntimeError.
+func (r *Runtime) Eval(src string) (Value, error) {
+	return r.EvalContext(context.Background(), src)
+}
+
+// EvalContext is like Eval, but stops running once ctx is done, returning an error wrapping
+// ctx.Err()
+func (r *Runtime) EvalContext(ctx context.Context, src string) (Value, error) {
+	ts, err := interpreter.Scan(src)
+	if err != nil {
+		return Value{}, err
+	}
+
+	root, err := interpreter.Parse(ts)
+	if err != nil {
+		return Value{}, err
+	}
+	if root == nil {
+		return Value{}, nil
+	}
+	if err := interpreter.Resolve(root); err != nil {
+		return Value{}, err
+	}
+
+	defer r.start(ctx)()
+	res, err := r.run(root)
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{res, r}, nil
+}
+
+// start sandboxes a call to the Runtime, returning a function to call once it's done. Go functions
+// called from Rye may call the Runtime again, in which case they share the limits of the call they
+// were made from.
+func (r *Runtime) start(ctx context.Context) func() {
+	if atomic.AddInt32(&r.running, 1) > 1 {
+		return func() { atomic.AddInt32(&r.running, -1) }
+	}
+
+	var limits interpreter.Limits
+	if r.sandbox != nil {
+		limits = r.sandbox.Limits
+		if r.sandbox.Timeout > 0 {
+			// the timeout isn't cancelled once the call is done, so that tasks it spawned keep
+			// running (and may be awaited by later calls) until it times out
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, r.sandbox.Timeout)
+			go func() {
+				<-ctx.Done()
+				cancel()
+			}()
+		}
+	}
+	r.env.Sandbox(ctx, limits)
+
+	return func() { atomic.AddInt32(&r.running, -1) }
+}
+
+// RunFile runs a Rye file. Its imports are found relative to the working directory.
+func (r *Runtime) RunFile(path string) (Value, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return Value{}, err
+	}
+	return r.Eval(string(src))
+}
+
+// Set declares a constant in the top-level scope, converting a Go value to Rye. It replaces any
+// value already declared with the same name.
+func (r *Runtime) Set(name string, val interface{}) error {
+	v, err := toValue(r, val)
+	if err != nil {
+		return err
+	}
+
+	r.env.Define(name, v)
+	return nil
+}
+
+// Get looks up a name in the top-level scope, including the built-in functions
+func (r *Runtime) Get(name string) (Value, error) {
+	v, ok := r.env.Lookup(name)
+	if !ok {
+		return Value{}, fmt.Errorf("\"%s\" is undefined", name)
+	}
+	return Value{v, r}, nil
+}
+
+// Call calls a Rye function by name, converting its arguments from Go values
+func (r *Runtime) Call(fnName string, args ...interface{}) (Value, error) {
+	return r.CallContext(context.Background(), fnName, args...)
+}
+
+// CallContext is like Call, but stops running once ctx is done, returning an error wrapping
+// ctx.Err()
+func (r *Runtime) CallContext(ctx context.Context, fnName string, args ...interface{}) (Value, error) {
+	fn, err := r.Get(fnName)
+	if err != nil {
+		return Value{}, err
+	}
+	return r.call(ctx, fn.v, fnName, args...)
+}
+
+// run evaluates a node in the top-level scope, on the VM if the Runtime uses it
+func (r *Runtime) run(n *interpreter.Node) (interpreter.Value, error) {
+	if r.vm {
+		return interpreter.Run(interpreter.Compile(n), r.env)
+	}
+	return interpreter.Interpret(n, r.env)
+}
+
+// call calls a function in the top-level scope. The name it's called by is empty for lambdas.
+func (r *Runtime) call(ctx context.Context, fn interpreter.Value, name string, args ...interface{}) (Value, error) {
+	vals := make([]interpreter.Value, len(args))
+	for i, arg := range args {
+		v, err := toValue(r, arg)
+		if err != nil {
+			return Value{}, err
+		}
+		vals[i] = v
+	}
+
+	defer r.start(ctx)()
+	var res interpreter.Value
+	var err error
+	if r.vm {
+		res, err = interpreter.CallCompiled(fn, vals, r.env, name)
+	} else {
+		res, err = interpreter.Call(fn, vals, r.env, name)
+	}
+	if err != nil {
+		return Value{}, err
+	}
+	return Value{res, r}, nil
+}
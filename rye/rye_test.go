@@ -0,0 +1,368 @@
+package rye
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jheredos/rye/interpreter"
+)
+
+func newRuntime(t *testing.T, opts ...Option) *Runtime {
+	r, err := New(opts...)
+	if err != nil {
+		t.Fatalf("Failed to create a Runtime: %s", err.Error())
+	}
+	return r
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input, display, kind string
+		export               interface{}
+	}{
+		{`1 + 2`, `3`, `Int`, int64(3)},
+		{`1 / 4`, `0.25`, `Float`, 0.25},
+		{`"a" + "b"`, `"ab"`, `String`, "ab"},
+		{`1 < 2`, `true`, `Bool`, true},
+		{`null`, `null`, `Null`, nil},
+		{`[1, "a", [true]]`, `[1, "a", [true]]`, `List`, []interface{}{int64(1), "a", []interface{}{true}}},
+		{`(1, "a")`, `(1, "a")`, `Tuple`, Tuple{int64(1), "a"}},
+		{`{b: {c: null}}`, `{"b": {"c": null}}`, `Object`, map[string]interface{}{"b": map[string]interface{}{"c": nil}}},
+		{"o := {}\no[(1, 2)] = 3\no", `{(1, 2): 3}`, `Object`, map[string]interface{}{"(1, 2)": int64(3)}},
+		{`{1}`, `{1}`, `Set`, []interface{}{int64(1)}},
+		{`[1][5]`, `fail`, `Result`, Fail},
+		{`print?`, `success`, `Result`, Success},
+	}
+
+	for _, test := range tests {
+		r := newRuntime(t)
+		v, err := r.Eval(test.input)
+		if err != nil {
+			t.Fatalf(`Failed to evaluate "%s": %s`, test.input, err.Error())
+		}
+		if v.String() != test.display || v.Kind() != test.kind {
+			t.Fatalf(`Evaluated "%s" incorrectly. Expected %s (%s), received %s (%s)`, test.input, test.display, test.kind, v, v.Kind())
+		}
+		if !reflect.DeepEqual(v.Export(), test.export) {
+			t.Fatalf(`Exported "%s" incorrectly. Expected %#v, received %#v`, test.input, test.export, v.Export())
+		}
+	}
+}
+
+func TestEvalPersists(t *testing.T) {
+	r := newRuntime(t)
+	if _, err := r.Eval(`double := _ * 2`); err != nil {
+		t.Fatal(err)
+	}
+	v, err := r.Eval(`double(21)`)
+	if err != nil || v.Export() != int64(42) {
+		t.Fatalf(`Expected 42, received %v (%v)`, v, err)
+	}
+}
+
+func TestWithVM(t *testing.T) {
+	r := newRuntime(t, WithVM())
+	if _, err := r.Eval(`
+		var total := 0
+		for i in ..11 { total += i }
+		discount := (order) => {
+			if order.total > 100: return order.total * 0.1
+			return 0
+		}
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := r.Eval(`total`)
+	if err != nil || v.Export() != int64(55) {
+		t.Fatalf(`Expected 55, received %v (%v)`, v, err)
+	}
+	v, err = r.Call("discount", map[string]interface{}{"total": 250})
+	if err != nil || v.Export() != 25.0 {
+		t.Fatalf(`Expected 25, received %v (%v)`, v, err)
+	}
+
+	_, err = r.Eval("f := n => n + nope\nf(1)")
+	var re *interpreter.RuntimeError
+	if !errors.As(err, &re) || re.Line != 1 || len(re.Stack) != 1 {
+		t.Fatalf(`Expected a RuntimeError on line 1, received %v`, err)
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	r := newRuntime(t)
+
+	_, err := r.Eval(`x := [1,`)
+	var pe *interpreter.ParseError
+	if !errors.As(err, &pe) {
+		t.Fatalf(`Expected a ParseError, received %v`, err)
+	}
+
+	_, err = r.Eval("\n\nundefinedThing + 1")
+	var re *interpreter.RuntimeError
+	if !errors.As(err, &re) || re.Line != 3 {
+		t.Fatalf(`Expected a RuntimeError on line 3, received %v`, err)
+	}
+}
+
+type point struct {
+	X, Y   int
+	Label  string `rye:"label"`
+	hidden bool
+}
+
+func TestSetAndGet(t *testing.T) {
+	r := newRuntime(t, WithGlobals(map[string]interface{}{
+		"limit": 10,
+	}))
+
+	if err := r.Set("p", point{X: 1, Y: 2, Label: "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set("tags", map[string][]string{"x": {"a", "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set("ratio", float32(0.5)); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := r.Eval(`[p.X + p.Y, p.label, p.hidden, #tags.x, ratio * limit]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != `[3, "a", fail, 2, 5]` {
+		t.Fatalf(`Received %s`, v)
+	}
+
+	// Rye values are passed back unchanged, and replace existing declarations
+	if _, err := r.Eval(`var counter := 1`); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set("counter", v); err != nil {
+		t.Fatal(err)
+	}
+	got, err := r.Get("counter")
+	if err != nil || got.String() != v.String() {
+		t.Fatalf(`Expected %s, received %s (%v)`, v, got, err)
+	}
+
+	if _, err := r.Get("missing"); err == nil || err.Error() != `"missing" is undefined` {
+		t.Fatalf(`Expected an undefined error, received %v`, err)
+	}
+	if err := r.Set("ch", make(chan int)); err == nil || err.Error() != `Cannot convert a value of type chan int to Rye` {
+		t.Fatalf(`Expected a conversion error, received %v`, err)
+	}
+	if err := r.Set("big", uint64(1<<63)); err == nil || err.Error() != `Cannot convert 9223372036854775808 to Rye. It's out of range for Int.` {
+		t.Fatalf(`Expected an out of range error, received %v`, err)
+	}
+	if err := r.Set("m", map[interface{}]int{true: 1, nil: 2}); err == nil {
+		t.Fatalf(`Expected an error for a null Object key`)
+	}
+}
+
+func TestCall(t *testing.T) {
+	r := newRuntime(t)
+	if _, err := r.Eval(`
+		discount := (order) => {
+			if order.total > 100: return order.total * 0.1
+			return 0
+		}
+	`); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := r.Call("discount", map[string]interface{}{"total": 250})
+	if err != nil || v.Export() != 25.0 {
+		t.Fatalf(`Expected 25, received %v (%v)`, v, err)
+	}
+
+	// built-in functions can be called too
+	v, err = r.Call("uppercase", "abc")
+	if err != nil || v.Export() != "ABC" {
+		t.Fatalf(`Expected "ABC", received %v (%v)`, v, err)
+	}
+
+	_, err = r.Call("discount")
+	if err == nil || err.Error() != `Too few arguments provided to function "discount". Expected 1, received 0.` {
+		t.Fatalf(`Expected an argument error, received %v`, err)
+	}
+
+	if _, err = r.Call("nope", 1); err == nil {
+		t.Fatalf(`Expected an error calling an undefined function`)
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	r := newRuntime(t)
+
+	// Go functions called from Rye
+	err := r.Set("lookup", Func(func(args ...Value) (Value, error) {
+		if len(args) != 1 || args[0].Kind() != "String" {
+			return Value{}, errors.New("lookup expects a String")
+		}
+		return r.Eval(`{name: "Ada", age: 36}`)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := r.Eval(`lookup("ada").age + 1`)
+	if err != nil || v.Export() != int64(37) {
+		t.Fatalf(`Expected 37, received %v (%v)`, v, err)
+	}
+
+	_, err = r.Eval(`lookup(1)`)
+	if err == nil || err.Error() != `Line 1: lookup expects a String` {
+		t.Fatalf(`Expected an error from lookup, received %v`, err)
+	}
+
+	// Rye functions called from Go
+	v, err = r.Eval(`[1, 2, 3] map n => m => n * m`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fns := v.Export().([]interface{})
+	res, err := fns[2].(Func)(Value{}, Value{})
+	if err == nil {
+		t.Fatalf(`Expected an argument error, received %v`, res)
+	}
+	arg, _ := r.Eval(`5`)
+	res, err = fns[2].(Func)(arg)
+	if err != nil || res.Export() != int64(15) {
+		t.Fatalf(`Expected 15, received %v (%v)`, res, err)
+	}
+}
+
+func TestRunFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rules.ry")
+	if err := os.WriteFile(path, []byte("allowed := user => user.age >= minAge\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r := newRuntime(t, WithGlobals(map[string]interface{}{"minAge": 18}))
+	if _, err := r.RunFile(path); err != nil {
+		t.Fatal(err)
+	}
+	v, err := r.Call("allowed", map[string]int{"age": 17})
+	if err != nil || v.Export() != false {
+		t.Fatalf(`Expected false, received %v (%v)`, v, err)
+	}
+
+	// runtimes don't share declarations
+	other := newRuntime(t)
+	if _, err := other.Get("allowed"); err == nil {
+		t.Fatalf(`Expected "allowed" to be undefined in another Runtime`)
+	}
+}
+
+func TestWithMaxDepth(t *testing.T) {
+	// runtimes with different maximum depths can run at the same time
+	src := `f := n => 0 if n == 0 else 1 + f(n - 1)
+	f(200)`
+	shallow := newRuntime(t, WithMaxDepth(100))
+	deep := newRuntime(t, WithVM())
+
+	errs := make(chan error)
+	go func() {
+		_, err := shallow.Eval(src)
+		errs <- err
+	}()
+	if v, err := deep.Eval(src); err != nil || v.Export() != int64(200) {
+		t.Fatalf(`Expected 200, received %v (%v)`, v, err)
+	}
+
+	var so *interpreter.StackOverflowError
+	if err := <-errs; !errors.As(err, &so) || so.Depth != 100 {
+		t.Fatalf(`Expected a StackOverflowError, received %v`, err)
+	}
+
+	// a sandbox's depth limit doesn't raise the maximum depth
+	limited := newRuntime(t, WithMaxDepth(100), WithSandbox(Sandbox{Limits: interpreter.Limits{Depth: 1000}}))
+	if _, err := limited.Eval(src); !errors.As(err, &so) || so.Depth != 100 {
+		t.Fatalf(`Expected a StackOverflowError, received %v`, err)
+	}
+
+	if _, err := New(WithMaxDepth(0)); err == nil || err.Error() != "Invalid maximum depth 0" {
+		t.Fatalf(`Expected an invalid maximum depth, received %v`, err)
+	}
+}
+
+func TestSandbox(t *testing.T) {
+	r := newRuntime(t, WithSandbox(Sandbox{
+		Limits:   interpreter.Limits{Steps: 10000, Depth: 20},
+		Builtins: []string{"uppercase", "sum"},
+		Timeout:  50 * time.Millisecond,
+	}))
+
+	v, err := r.Eval(`uppercase("ok") + String(sum([1, 2]))`)
+	if err == nil || err.Error() != `Line 1: "String" is undefined` {
+		t.Fatalf(`Expected "String" to be undefined, received %v (%v)`, v, err)
+	}
+	if _, err = r.Eval(`readFile("/etc/passwd")`); err == nil || err.Error() != `Line 1: "readFile" is undefined` {
+		t.Fatalf(`Expected "readFile" to be undefined, received %v`, err)
+	}
+
+	// the step limit applies to each call separately
+	src := `f := n => 0 if n == 0 else 1 + f(n - 1)`
+	if _, err = r.Eval(src); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		if v, err = r.Call("f", 10); err != nil || v.Export() != int64(10) {
+			t.Fatalf(`Expected 10, received %v (%v)`, v, err)
+		}
+	}
+
+	// the depth limit lowers the maximum depth
+	var so *interpreter.StackOverflowError
+	if _, err = r.Call("f", 100); !errors.As(err, &so) || so.Depth != 20 {
+		t.Fatalf(`Expected a StackOverflowError, received %v`, err)
+	}
+
+	// a timeout alone stops loops that never end
+	unlimited := newRuntime(t, WithSandbox(Sandbox{Timeout: 20 * time.Millisecond}))
+	if _, err = unlimited.Eval(`var i := 0
+	while true { i = i + 1 }`); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf(`Expected a timeout, received %v`, err)
+	}
+
+	// tasks outlive the call that spawned them, until it times out
+	tasks := newRuntime(t, WithSandbox(Sandbox{Timeout: 10 * time.Second}))
+	if _, err = tasks.Eval(`t := spawn {
+		var i := 0
+		while i < 100000 { i = i + 1 }
+		i
+	}`); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = tasks.Eval(`await t`); err != nil || v.Export() != int64(100000) {
+		t.Fatalf(`Expected 100000, received %v (%v)`, v, err)
+	}
+
+	tasks = newRuntime(t, WithSandbox(Sandbox{Timeout: 20 * time.Millisecond}))
+	if _, err = tasks.Eval(`t := spawn {
+		var i := 0
+		while true { i = i + 1 }
+	}`); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tasks.Eval(`await t`); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf(`Expected the task to time out, received %v`, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = newRuntime(t).EvalContext(ctx, `1..10 map _ * 2`); !errors.Is(err, context.Canceled) {
+		t.Fatalf(`Expected the evaluation to be cancelled, received %v`, err)
+	}
+
+	if _, err = New(WithSandbox(Sandbox{Builtins: []string{"nope"}})); err == nil || err.Error() != `"nope" is not a built-in function` {
+		t.Fatalf(`Expected an unknown built-in error, received %v`, err)
+	}
+}